@@ -12,10 +12,12 @@ import (
 
 // 重放工具：读取执行日志并按筛选条件重放设备命令。
 func main() {
-	// CLI 参数：日志路径、按 task/trace 过滤、重放条数限制。
+	// CLI 参数：日志路径、按 task/trace/域/频道过滤、重放条数限制。
 	logPath := flag.String("log", "data/execution.log", "execution log file (jsonl)")
 	taskID := flag.String("task", "", "replay only this task_id (optional)")
 	traceID := flag.String("trace", "", "replay only this trace_id (optional)")
+	domainID := flag.String("domain", "", "replay only this domain_id (optional)")
+	channelID := flag.String("channel", "", "replay only this channel_id (optional)")
 	limit := flag.Int("limit", 0, "max records to replay (0 = all)")
 	flag.Parse()
 
@@ -34,24 +36,34 @@ func main() {
 
 	count := 0
 	for _, e := range entries {
-		// 按 task/trace 过滤。
+		// 按 task/trace/域/频道过滤。
 		if *taskID != "" && e.TaskID != *taskID {
 			continue
 		}
 		if *traceID != "" && e.TraceID != *traceID {
 			continue
 		}
+		if *domainID != "" && e.DomainID != *domainID {
+			continue
+		}
+		if *channelID != "" && e.ChannelID != *channelID {
+			continue
+		}
 		// limit>0 时限制重放条数。
 		if *limit > 0 && count >= *limit {
 			break
 		}
 
 		cmd := model.DeviceCommand{
-			DeviceID: e.DeviceID,
-			Command:  e.Command,
-			Params:   e.Params,
-			TaskID:   e.TaskID,
-			TraceID:  e.TraceID,
+			DeviceID:  e.DeviceID,
+			Command:   e.Command,
+			Params:    e.Params,
+			TaskID:    e.TaskID,
+			TraceID:   e.TraceID,
+			Principal: e.Principal,
+			DomainID:  e.DomainID,
+			ChannelID: e.ChannelID,
+			Target:    e.Target,
 		}
 
 		fmt.Printf("[REPLAY] trace=%s task=%s device=%s command=%s params=%v\n",
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"agri-control-service/internal/api"
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/fertigation"
 	"agri-control-service/internal/logstore"
 	"agri-control-service/internal/registry"
+	"agri-control-service/internal/resolver"
+	"agri-control-service/internal/schedule"
 	"agri-control-service/internal/service"
+	"agri-control-service/internal/sim"
+	"agri-control-service/internal/state"
 )
 
 // 程序入口：加载任务配置、初始化日志存储与控制服务，并启动 HTTP 接口。
@@ -16,6 +28,20 @@ func main() {
 	// 支持通过参数指定任务注册表文件和并发 worker 数量。
 	registryPath := flag.String("registry", "configs/scenarios.yaml", "registry config file (yaml/json)")
 	workers := flag.Int("workers", 4, "number of concurrent worker goroutines")
+	sitePath := flag.String("site", "configs/site.yaml", "farm time zone and coordinates (yaml/json)")
+	recipesPath := flag.String("recipes", "configs/recipes.yaml", "fertigation recipes and stock tanks (yaml/json)")
+	devicesPath := flag.String("devices", "../data/device_registry.json", "device registry (domain → channel → partition → executors)")
+	logPath := flag.String("log", "data/execution.log", "execution log file (jsonl, hash-chained)")
+	keyPath := flag.String("signing-key", "", "ed25519 key for signed log checkpoints, outside the log directory (created if missing; default <user config dir>/agri-control-service/logsign.key)")
+	checkpointEvery := flag.Int("checkpoint-every", 100, "write a signed checkpoint every N log records")
+	checkpointInterval := flag.Duration("checkpoint-interval", 5*time.Minute, "also write a signed checkpoint at this interval")
+	driver := flag.String("driver", "print", "device driver: print (log only) | sim (digital twin)")
+	simPath := flag.String("sim", "configs/sim.yaml", "digital twin config, used with -driver sim (yaml/json)")
+	stateDir := flag.String("state-dir", "", "shared state directory for active/passive HA (empty = single instance, state in memory)")
+	instanceID := flag.String("instance-id", "", "instance id used in the leader lease (default hostname-pid)")
+	leaseTTL := flag.Duration("lease-ttl", 10*time.Second, "leader lease ttl; the standby takes over within about this long")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated IPs/CIDRs of gateways whose identity headers (X-Principal, X-Request-Source) are trusted; empty = none")
+	addr := flag.String("addr", ":8280", "http listen address")
 	flag.Parse()
 
 	// 优先加载外部任务场景配置，失败则回退到内置默认配置。
@@ -25,19 +51,163 @@ func main() {
 		log.Printf("registry: loaded from %s", *registryPath)
 	}
 
+	// 加载农场时区与分区坐标，失败则使用本地时区（日出日落表达式不可用）。
+	siteLoaded := false
+	if err := schedule.LoadFromFile(*sitePath); err != nil {
+		log.Printf("schedule: load %s failed, fallback to local time zone, sunrise/sunset disabled: %v", *sitePath, err)
+	} else {
+		siteLoaded = true
+		log.Printf("schedule: loaded site from %s (tz=%s)", *sitePath, schedule.Location())
+	}
+
+	// 加载水肥配方，失败则 fertilization 任务不支持 recipe 参数。
+	if err := fertigation.LoadFromFile(*recipesPath); err != nil {
+		log.Printf("fertigation: load %s failed, recipes disabled: %v", *recipesPath, err)
+	} else {
+		log.Printf("fertigation: loaded recipes from %s", *recipesPath)
+	}
+
+	// 加载分区 → 执行器注册表，失败则 target 原样作为设备 ID 下发。
+	if err := resolver.LoadFromFile(*devicesPath); err != nil {
+		log.Printf("resolver: load %s failed, target used as device id: %v", *devicesPath, err)
+	} else {
+		log.Printf("resolver: loaded device registry from %s", *devicesPath)
+	}
+	// 注册表中记录了坐标的分区优先使用注册表坐标计算日出日落
+	schedule.SetCoordSource(func(target string) (schedule.Coord, bool) {
+		lat, lng, ok := resolver.Coords(target)
+		return schedule.Coord{Lat: lat, Lng: lng}, ok
+	})
+	// 坐标是手工配置：加载了 site 配置时，每个分区都必须能取得坐标，否则日出日落调度到运行时才失败
+	if siteLoaded {
+		var missing []string
+		for _, l := range resolver.All() {
+			scope := l.DomainID + "/" + l.ChannelID + "/"
+			if !schedule.HasCoords(scope+l.Partition.PartitionID) && !schedule.HasCoords(scope+l.Partition.PartitionName) {
+				missing = append(missing, scope+l.Partition.PartitionID)
+			}
+		}
+		if len(missing) > 0 {
+			log.Fatalf("schedule: no coordinates for partitions %v; set lat/lng in %s or the device registry", missing, *sitePath)
+		}
+	}
+
+	// 高可用模式：任务状态写入共享目录，leader 租约决定哪个实例执行任务。
+	var st *state.Store
+	if *stateDir != "" {
+		var err error
+		if st, err = state.Open(*stateDir); err != nil {
+			log.Fatalf("ha: %v", err)
+		}
+		if *instanceID == "" {
+			host, _ := os.Hostname()
+			*instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+	}
+
 	// 初始化执行日志存储；失败时仅禁用落盘，不影响主流程。
-	store, err := logstore.NewLogStore("data/execution.log")
+	store, err := logstore.NewLogStore(*logPath)
 	if err != nil {
 		log.Printf("execution log disabled: %v", err)
 	}
 
-	// 组装控制服务和 HTTP 处理器。
-	ctrl := service.NewControlService(store, *workers)
+	// 组装控制服务：高可用模式下当选 leader 后才启动 worker。
+	var ctrl *service.ControlService
+	if st != nil {
+		ctrl = service.NewHAControlService(store, *workers, st)
+	} else {
+		ctrl = service.NewControlService(store, *workers)
+	}
+
+	if store != nil {
+		if *keyPath == "" {
+			if p, err := logstore.DefaultKeyPath(); err == nil {
+				*keyPath = p
+			} else {
+				log.Printf("execution log checkpoints: %v", err)
+			}
+		}
+		if err := logstore.CheckKeyLocation(*keyPath, *logPath); err != nil {
+			log.Fatalf("execution log checkpoints: %v", err)
+		}
+		if signer, err := logstore.LoadOrCreateSigner(*keyPath); err != nil {
+			log.Printf("execution log checkpoints disabled: %v", err)
+		} else {
+			// 哈希链 + 定期签名检查点，可用 cmd/verify 校验是否被篡改或截断
+			store.EnableCheckpoints(signer, *checkpointEvery)
+			go func() {
+				for range time.Tick(*checkpointInterval) {
+					if !ctrl.IsLeader() {
+						continue // standby 不写日志，检查点由 leader 负责
+					}
+					if err := store.Checkpoint(); err != nil {
+						log.Printf("execution log checkpoint failed: %v", err)
+					}
+				}
+			}()
+			log.Printf("execution log: %s (checkpoints signed with %s)", *logPath, *keyPath)
+		}
+	}
+
+	// 组装 HTTP 处理器。
 	handler := api.NewHandler(ctrl)
+	if err := handler.SetTrustedProxies(*trustedProxies); err != nil {
+		log.Fatalf("api: %v", err)
+	}
+
+	// 仿真驱动：倍速时钟 + 数字孪生，离线端到端测试场景/策略/LLM 规划
+	if *driver == "sim" {
+		cfg, err := sim.LoadConfig(*simPath)
+		if err != nil {
+			log.Fatalf("sim: %v", err)
+		}
+		start := time.Time{}
+		if cfg.Start != "" {
+			if start, err = time.Parse(time.RFC3339, cfg.Start); err != nil {
+				log.Fatalf("sim: invalid start: %v", err)
+			}
+		}
+		clock.Set(start, cfg.Speed)
+		twin := sim.New(cfg)
+		twin.Start()
+		ctrl.SetDriver(twin)
+		http.HandleFunc("/control/sim", api.SimStateHandler(twin))
+		log.Printf("sim: digital twin driver enabled (speed=%gx, start=%s)", cfg.Speed, clock.Now().Format(time.RFC3339))
+	}
 
 	// 注册 API 路由。
 	http.HandleFunc("/control/task", handler.HandleTask)
+	http.HandleFunc("/control/task/status", handler.HandleTaskStatus)
+	http.HandleFunc("/control/plan", handler.HandlePlan)
+	http.HandleFunc("/control/schemas", handler.HandleSchemas)
+	http.HandleFunc("/control/override", handler.HandleOverride)
+	http.HandleFunc("/control/rotation", handler.HandleRotation)
+	http.HandleFunc("/control/recipes", handler.HandleRecipes)
+	http.HandleFunc("/control/recipes/calc", handler.HandleRecipeCalc)
+
+	if st != nil {
+		http.HandleFunc("/control/ha", api.HAStatusHandler(ctrl, st, *instanceID))
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		elector := &state.Elector{Store: st, ID: *instanceID, TTL: *leaseTTL}
+		go func() {
+			elector.Run(ctx, func(lease state.Lease) {
+				// 多个实例共用同一执行日志时，从文件末尾恢复哈希链头后再开始写入
+				if store != nil {
+					if err := store.Resync(); err != nil {
+						log.Printf("execution log resync failed: %v", err)
+					}
+				}
+				ctrl.Promote(lease)
+			}, func() {
+				log.Fatalf("ha: %s lost leadership, exiting so that it restarts as standby", *instanceID)
+			})
+			log.Printf("ha: %s stopped, lease released", *instanceID)
+			os.Exit(0)
+		}()
+		log.Printf("ha: instance %s standing by (state dir %s, lease ttl %s)", *instanceID, *stateDir, *leaseTTL)
+	}
 
-	log.Println("Agri Control Service running on :8280")
-	log.Fatal(http.ListenAndServe(":8280", nil))
+	log.Printf("Agri Control Service running on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
 }
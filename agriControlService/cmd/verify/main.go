@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"agri-control-service/internal/logstore"
+)
+
+// 校验工具：检查执行日志的哈希链与签名检查点，发现篡改或截断时以非零状态退出。
+func main() {
+	logPath := flag.String("log", "data/execution.log", "execution log file (jsonl)")
+	cpPath := flag.String("checkpoints", "", "checkpoint file (default: <log>.checkpoints)")
+	pubPath := flag.String("pubkey", "", "ed25519 public key (hex) (default <user config dir>/agri-control-service/logsign.key.pub)")
+	noSig := flag.Bool("no-signature", false, "skip checkpoint signature check")
+	asJSON := flag.Bool("json", false, "print report as json")
+	flag.Parse()
+
+	if *cpPath == "" {
+		*cpPath = logstore.CheckpointPath(*logPath)
+	}
+	if *pubPath == "" && !*noSig {
+		p, err := logstore.DefaultKeyPath()
+		if err != nil {
+			log.Fatalf("locate public key: %v", err)
+		}
+		*pubPath = p + ".pub"
+	}
+	var pub ed25519.PublicKey
+	if !*noSig {
+		p, err := logstore.LoadPublicKey(*pubPath)
+		if err != nil {
+			log.Fatalf("load public key: %v", err)
+		}
+		pub = p
+	}
+
+	rep, err := logstore.Verify(*logPath, *cpPath, pub)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+
+	if *asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(rep)
+	} else {
+		fmt.Printf("records=%d legacy=%d last_seq=%d checkpoints=%d\n", rep.Records, rep.Legacy, rep.LastSeq, rep.Checkpoints)
+		for _, p := range rep.Problems {
+			fmt.Println("PROBLEM:", p)
+		}
+		if rep.OK() {
+			fmt.Println("OK: hash chain and checkpoints intact")
+		}
+	}
+	if !rep.OK() {
+		os.Exit(1)
+	}
+}
@@ -3,6 +3,6 @@ module agri-control-service
 go 1.21
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/google/uuid v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,16 +2,25 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
+	"agri-control-service/internal/fertigation"
 	"agri-control-service/internal/model"
+	"agri-control-service/internal/override"
+	"agri-control-service/internal/registry"
+	"agri-control-service/internal/schema"
 	"agri-control-service/internal/service"
+	"agri-control-service/internal/sim"
+	"agri-control-service/internal/state"
 
 	"github.com/google/uuid"
 )
 
 type Handler struct {
-	ctrl *service.ControlService
+	ctrl    *service.ControlService
+	trusted trustedProxies
 }
 
 // NewHandler 绑定控制服务，用于对外提供 HTTP 接口。
@@ -19,6 +28,17 @@ func NewHandler(ctrl *service.ControlService) *Handler {
 	return &Handler{ctrl: ctrl}
 }
 
+// SetTrustedProxies 设置可信反向代理（逗号分隔的 IP / CIDR）；只有来自这些地址的请求才采用
+// X-Principal、X-Request-Source 身份请求头，提交主体与操作员来源只能由可信代理认证后注入。
+func (h *Handler) SetTrustedProxies(spec string) error {
+	t, err := parseTrustedProxies(spec)
+	if err != nil {
+		return err
+	}
+	h.trusted = t
+	return nil
+}
+
 // HandleTask 接收 POST /control/task，解析任务、补全标识并入队。
 func (h *Handler) HandleTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -33,8 +53,27 @@ func (h *Handler) HandleTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ensureIDs(&task)
+	task.Principal = h.principal(r)
+	task.Source = h.taskSource(r, task.Source)
 
 	if err := h.ctrl.HandleTask(&task); err != nil {
+		if errors.Is(err, service.ErrNotLeader) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrOverridden) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidTask) {
+			resp := map[string]interface{}{"error": err.Error()}
+			var verr *schema.ValidationError
+			if errors.As(err, &verr) {
+				resp["fields"] = verr.Fields
+			}
+			writeJSON(w, http.StatusBadRequest, resp)
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
@@ -44,6 +83,265 @@ func (h *Handler) HandleTask(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("task executed"))
 }
 
+// HandlePlan 接收 POST /control/plan，返回任务的执行计划预览（策略结论、设备命令、时间线），无任何副作用。
+func (h *Handler) HandlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var task model.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	task.Source = h.taskSource(r, task.Source)
+
+	writeJSON(w, http.StatusOK, h.ctrl.PreviewPlan(task))
+}
+
+// HandleTaskStatus 接收 GET /control/task/status?task_id=...，返回任务状态与各设备执行结果。
+func (h *Handler) HandleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("task_id is required"))
+		return
+	}
+	outcome, ok := h.ctrl.TaskStatus(taskID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, outcome)
+}
+
+// HandleSchemas 接收 GET /control/schemas[?task_type=...]，返回各任务类型的参数 schema，供客户端生成表单。
+func (h *Handler) HandleSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if taskType := r.URL.Query().Get("task_type"); taskType != "" {
+		sch, ok := registry.TaskParamSchemas[taskType]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, sch)
+		return
+	}
+	writeJSON(w, http.StatusOK, registry.TaskParamSchemas)
+}
+
+// HandleRecipes 接收 GET /control/recipes，返回水源、母液罐与各作物分生育期的配方。
+func (h *Handler) HandleRecipes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := fertigation.Current()
+	if cfg == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+// recipeCalcRequest 是 POST /control/recipes/calc 的请求体。
+type recipeCalcRequest struct {
+	Recipe      string  `json:"recipe"`
+	Stage       string  `json:"stage"`
+	TankVolumeL float64 `json:"tank_volume_l"`
+	DurationMin float64 `json:"duration_min"`
+}
+
+// HandleRecipeCalc 接收 POST /control/recipes/calc，把配方、灌溉水量与时长换算为注肥泵运行时间与注肥阀时序，无副作用。
+func (h *Handler) HandleRecipeCalc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req recipeCalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	d, err := fertigation.Calculate(req.Recipe, req.Stage, req.TankVolumeL, req.DurationMin)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, fertigation.ErrUnknownRecipe) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// HandleOverride 管理分区人工接管：
+//   - GET    /control/override[?domain_id=&channel_id=&target=]：列出全部或查询单个目标
+//   - POST   /control/override：创建/修改（model.OverrideRequest）
+//   - DELETE /control/override?domain_id=&channel_id=&target=：提前解除，被挂起的任务随即恢复
+func (h *Handler) HandleOverride(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ref := model.TargetRef{DomainID: q.Get("domain_id"), ChannelID: q.Get("channel_id"), Target: q.Get("target")}
+
+	switch r.Method {
+	case http.MethodGet:
+		if ref.Target == "" {
+			writeJSON(w, http.StatusOK, h.ctrl.Overrides())
+			return
+		}
+		o, ok, err := h.ctrl.Override(ref)
+		if err != nil {
+			writeOverrideError(w, err)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodPost:
+		var req model.OverrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		o, err := h.ctrl.SetOverride(req)
+		if err != nil {
+			writeOverrideError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodDelete:
+		o, ok, err := h.ctrl.ReleaseOverride(ref)
+		if err != nil {
+			writeOverrideError(w, err)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRotation 管理分区轮灌：
+//   - POST /control/rotation：按水泵容量与时间窗生成轮灌时间表（model.RotationRequest），dry_run 为 false 时提交为关联任务
+//   - GET  /control/rotation?group_id=...：查询已提交计划及各段任务状态
+func (h *Handler) HandleRotation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		groupID := r.URL.Query().Get("group_id")
+		if groupID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("group_id is required"))
+			return
+		}
+		plan, ok := h.ctrl.Rotation(groupID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	case http.MethodPost:
+		var req model.RotationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		plan, err := h.ctrl.PlanRotation(req, h.principal(r))
+		if err != nil {
+			status := http.StatusInternalServerError
+			resp := map[string]interface{}{"error": err.Error()}
+			switch {
+			case errors.Is(err, service.ErrNotLeader):
+				status = http.StatusServiceUnavailable
+			case errors.Is(err, service.ErrOverridden):
+				status = http.StatusConflict
+			case errors.Is(err, service.ErrInvalidTask):
+				status = http.StatusBadRequest
+				var verr *schema.ValidationError
+				if errors.As(err, &verr) {
+					resp["fields"] = verr.Fields
+				}
+			}
+			writeJSON(w, status, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeOverrideError 请求不合法（目标未知、时间非法等）返回 400，非 leader 返回 503，其余返回 500。
+func writeOverrideError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, override.ErrInvalidOverride) || errors.Is(err, service.ErrInvalidTask):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrNotLeader):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]interface{}{"error": err.Error()})
+}
+
+// SimStateHandler 返回 GET /control/sim 处理器：数字孪生的分区含水率、水泵与设备开关状态。
+func SimStateHandler(twin *sim.Twin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, twin.Snapshot())
+	}
+}
+
+// HAStatusHandler 返回 GET /control/ha 处理器：本实例标识、是否为 leader 以及共享存储中的当前租约。
+func HAStatusHandler(ctrl *service.ControlService, st *state.Store, instance string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		resp := map[string]interface{}{"instance": instance, "leader": ctrl.IsLeader(), "state_dir": st.Dir()}
+		if lease, err := st.CurrentLease(); err != nil {
+			resp["error"] = err.Error()
+		} else {
+			resp["lease"] = lease
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// writeJSON 以 JSON 形式写出响应。
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+// principal 返回提交任务的主体，写入哈希链执行日志：只有来自可信代理的请求才采用 X-Principal 请求头（由网关认证后注入），
+// 其余请求（含请求体中的 principal）都可伪造，记为 anonymous@<来源地址>。
+func (h *Handler) principal(r *http.Request) string {
+	if h.fromTrustedProxy(r) {
+		if p := strings.TrimSpace(r.Header.Get("X-Principal")); p != "" {
+			return p
+		}
+	}
+	return "anonymous@" + remoteHost(r)
+}
+
 // ensureIDs 确保任务有 task_id/trace_id，便于链路追踪。
 func ensureIDs(task *model.Task) {
 	if task.TaskID == "" {
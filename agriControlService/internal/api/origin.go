@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"agri-control-service/internal/override"
+)
+
+// trustedProxies 是可信反向代理（网关）的地址范围：只有来自这些地址的请求才采用身份相关的请求头。
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies 解析逗号分隔的 IP / CIDR 列表；空串表示不信任任何代理。
+func parseTrustedProxies(spec string) (trustedProxies, error) {
+	var out trustedProxies
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+		} else if ip, err := netip.ParseAddr(s); err == nil {
+			out = append(out, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+		} else {
+			return nil, fmt.Errorf("invalid trusted proxy %q", s)
+		}
+	}
+	return out, nil
+}
+
+// contains 判断地址（IP）是否属于可信代理。
+func (t trustedProxies) contains(addr string) bool {
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range t {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost 返回连接对端的 IP（去掉端口）。
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromTrustedProxy 判断请求是否来自可信代理。
+func (h *Handler) fromTrustedProxy(r *http.Request) bool {
+	return h.trusted.contains(remoteHost(r))
+}
+
+// taskSource 返回任务来源：可信代理注入的 X-Request-Source 优先；
+// 请求体中的 source 可由调用方任意填写，声称操作员来源（不受人工接管约束）却不经可信代理时降级为 http。
+func (h *Handler) taskSource(r *http.Request, claimed string) string {
+	if h.fromTrustedProxy(r) {
+		if src := strings.TrimSpace(r.Header.Get("X-Request-Source")); src != "" {
+			return src
+		}
+		return claimed
+	}
+	if override.IsOperator(claimed) {
+		log.Printf("untrusted caller %s claimed source %q, recorded as http", remoteHost(r), claimed)
+		return "http"
+	}
+	return claimed
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/service"
+)
+
+// submit 以指定来源地址提交任务，返回任务状态。
+func submit(t *testing.T, h *Handler, id, remote string, header map[string]string) string {
+	t.Helper()
+	body := `{"task_id":"` + id + `","task_type":"irrigation","target":"A区","source":"operator","params":{"duration_min":10}}`
+	req := httptest.NewRequest(http.MethodPost, "/control/task", strings.NewReader(body))
+	req.RemoteAddr = remote
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.HandleTask(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("提交 %s status=%d body=%s", id, rec.Code, rec.Body.String())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		o, ok := h.ctrl.TaskStatus(id)
+		if ok && o.Status != model.TaskQueued {
+			return o.Status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("任务 %s 状态 = %q", id, o.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOperatorSourceRequiresTrustedProxy(t *testing.T) {
+	h := NewHandler(service.NewControlService(nil, 1))
+	if err := h.SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ctrl.SetOverride(model.OverrideRequest{Target: "A区", Duration: "1h", Operator: "张工"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 请求体自称 operator，但不经可信代理：仍被接管挂起
+	if st := submit(t, h, "forged", "192.0.2.7:4000", nil); st != model.TaskHeld {
+		t.Fatalf("伪造操作员来源的任务状态 = %q，期望 held", st)
+	}
+	// 非可信地址带 X-Request-Source 头同样无效
+	if st := submit(t, h, "forged-header", "192.0.2.7:4000", map[string]string{"X-Request-Source": "operator"}); st != model.TaskHeld {
+		t.Fatalf("非可信地址注入来源头的任务状态 = %q，期望 held", st)
+	}
+	// 可信代理认证后注入的操作员来源不受接管约束
+	if st := submit(t, h, "operator", "10.1.2.3:4000", map[string]string{"X-Request-Source": "operator"}); st == model.TaskHeld {
+		t.Fatal("可信代理注入的操作员来源不应被挂起")
+	}
+	if o, _ := h.ctrl.TaskStatus("forged"); o.Override == nil {
+		t.Fatalf("挂起状态未记录接管: %+v", o)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tp, err := parseTrustedProxies(" 127.0.0.1, 10.0.0.0/8 ,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{"127.0.0.1": true, "10.9.8.7": true, "::1": true, "::ffff:10.0.0.1": true, "192.0.2.1": false, "": false} {
+		if got := tp.contains(addr); got != want {
+			t.Fatalf("contains(%q) = %v，期望 %v", addr, got, want)
+		}
+	}
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("非法 CIDR 应返回错误")
+	}
+}
+
+func TestPrincipalRequiresTrustedProxy(t *testing.T) {
+	h := NewHandler(service.NewControlService(nil, 1))
+	if err := h.SetTrustedProxies("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/control/task", nil)
+	req.Header.Set("X-Principal", "alice")
+
+	req.RemoteAddr = "192.0.2.7:4000"
+	if p := h.principal(req); p != "anonymous@192.0.2.7" {
+		t.Fatalf("非可信地址的主体 = %q，期望按来源地址记录", p)
+	}
+	req.RemoteAddr = "127.0.0.1:4000"
+	if p := h.principal(req); p != "alice" {
+		t.Fatalf("可信代理注入的主体 = %q，期望 alice", p)
+	}
+	req.Header.Del("X-Principal")
+	if p := h.principal(req); p != "anonymous@127.0.0.1" {
+		t.Fatalf("可信代理未注入主体 = %q", p)
+	}
+}
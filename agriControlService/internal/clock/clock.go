@@ -0,0 +1,64 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// clock 包：控制服务统一的时间源。
+// 默认即真实时间；仿真/场景测试时可设置起始时间与倍速（如 60 倍：真实 1 秒 = 仿真 1 分钟），
+// 调度表达式解析、schedule_at 定时、wait 动作与人工接管到期都基于该时钟，长流程可在几分钟内跑完。
+
+var (
+	mu        sync.RWMutex
+	speed     = 1.0
+	realStart time.Time // 设置倍速时的真实时间
+	simStart  time.Time // 设置倍速时的仿真时间；零值表示未启用（直接使用真实时间）
+)
+
+// Set 设置仿真起始时间与倍速；start 为零值时从当前时间开始，speed<=0 视为 1。
+func Set(start time.Time, factor float64) {
+	if factor <= 0 {
+		factor = 1
+	}
+	if start.IsZero() {
+		start = Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	speed = factor
+	realStart = time.Now()
+	simStart = start
+}
+
+// Speed 返回当前倍速。
+func Speed() float64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	return speed
+}
+
+// Now 返回当前（仿真）时间。
+func Now() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	if simStart.IsZero() {
+		return time.Now()
+	}
+	return simStart.Add(time.Duration(float64(time.Since(realStart)) * speed))
+}
+
+// Until 返回距离 t 的仿真时长。
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
+
+// Real 把仿真时长换算为真实时长。
+func Real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / Speed())
+}
+
+// AfterFunc 在经过仿真时长 d 后调用 f。
+func AfterFunc(d time.Duration, f func()) *time.Timer {
+	return time.AfterFunc(Real(d), f)
+}
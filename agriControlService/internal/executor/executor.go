@@ -8,25 +8,47 @@ import (
 	"agri-control-service/internal/model"
 )
 
+// Driver 把设备命令真正下发到设备层（真实执行器、仿真孪生等）。
+type Driver interface {
+	Send(cmd model.DeviceCommand) error
+}
+
+// printDriver 未接入设备时的默认实现：命令只打印不下发。
+type printDriver struct{}
+
+func (printDriver) Send(model.DeviceCommand) error { return nil }
+
 // Executor 负责把规划好的 DeviceCommand 下发到设备层；支持可选日志落盘。
 type Executor struct {
-	store *logstore.LogStore
+	store  *logstore.LogStore
+	driver Driver
 }
 
 func NewExecutor(store *logstore.LogStore) *Executor {
-	return &Executor{store: store}
+	return &Executor{store: store, driver: printDriver{}}
 }
 
-// Execute 在当前实现中仅打印命令并写日志，预留对接真实设备。
+// SetDriver 替换设备驱动（如 -driver sim 时使用数字孪生）。
+func (e *Executor) SetDriver(d Driver) {
+	if d == nil {
+		d = printDriver{}
+	}
+	e.driver = d
+}
+
+// Execute 打印命令、经驱动下发并写日志；驱动返回的错误记入日志并返回给调用方。
 func (e *Executor) Execute(cmd model.DeviceCommand) error {
 	start := time.Now()
-	fmt.Printf("[EXECUTE] trace=%s task=%s device=%s command=%s params=%v\n",
-		cmd.TraceID, cmd.TaskID, cmd.DeviceID, cmd.Command, cmd.Params)
+	fmt.Printf("[EXECUTE] trace=%s task=%s scope=%s device=%s command=%s params=%v\n",
+		cmd.TraceID, cmd.TaskID, cmd.Scope(), cmd.DeviceID, cmd.Command, cmd.Params)
 
 	status := "ok"
 	var errMsg string
-
-	// TODO: 在此对接真实设备调用，并设置 status/errMsg
+	err := e.driver.Send(cmd)
+	if err != nil {
+		status = "failed"
+		errMsg = err.Error()
+	}
 
 	elapsed := time.Since(start).Milliseconds()
 
@@ -34,16 +56,21 @@ func (e *Executor) Execute(cmd model.DeviceCommand) error {
 		_ = e.store.Append(logstore.LogEntry{
 			TaskID:    cmd.TaskID,
 			TraceID:   cmd.TraceID,
+			Principal: cmd.Principal,
+			DomainID:  cmd.DomainID,
+			ChannelID: cmd.ChannelID,
+			Target:    cmd.Target,
 			DeviceID:  cmd.DeviceID,
 			Command:   cmd.Command,
 			Params:    cmd.Params,
 			Status:    status,
 			Error:     errMsg,
 			ElapsedMs: elapsed,
+			Dose:      cmd.Dose,
 		})
 	}
 
-	return nil
+	return err
 }
 
 // WaitDuration 从参数中解析常见的延迟字段（毫秒/秒/分钟）。
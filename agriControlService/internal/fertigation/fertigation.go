@@ -0,0 +1,289 @@
+package fertigation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"agri-control-service/internal/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fertigation 包：水肥一体化配方管理与投加量计算。
+// 配方按生育期给出目标 EC/pH 与各母液罐的配比；计算器结合水源 EC/pH、母液罐浓度与注肥泵流量，
+// 把“配方 + 灌溉水量 + 灌溉时长”换算为各注肥泵运行时间与注肥阀时序：
+//   - 酸罐：按 (水源 pH - 目标 pH) / ph_drop_per_ml_l 求 mL/L，其带入的 EC 先从目标增量中扣除
+//   - 营养罐：剩余 EC 增量按配比分摊，除以各罐 ec_per_ml_l 得到 mL/L
+//   - 时序：先清水 pre_flush_min，再在注肥窗口内按占空比间歇注肥，最后清水冲洗 post_flush_min
+
+// Tank 是一个母液罐及其注肥泵。
+type Tank struct {
+	Kind          string  `json:"kind" yaml:"kind"`                                             // nutrient | acid
+	ECPerMlL      float64 `json:"ec_per_ml_l" yaml:"ec_per_ml_l"`                               // 每 mL/L 带来的 EC 增量（mS/cm）
+	PHDropPerMlL  float64 `json:"ph_drop_per_ml_l,omitempty" yaml:"ph_drop_per_ml_l,omitempty"` // 仅酸罐：每 mL/L 降低的 pH
+	PumpFlowLph   float64 `json:"pump_flow_lph" yaml:"pump_flow_lph"`                           // 注肥泵流量（L/h）
+	Concentration string  `json:"concentration,omitempty" yaml:"concentration,omitempty"`       // 说明性文字，如 100x
+}
+
+// Stage 是某生育期的目标值与营养罐配比。
+type Stage struct {
+	EC     float64            `json:"ec" yaml:"ec"`
+	PH     float64            `json:"ph" yaml:"ph"`
+	Ratios map[string]float64 `json:"ratios" yaml:"ratios"` // 营养罐 → 配比（按 EC 贡献分摊）
+}
+
+// Recipe 是一种作物的配方，按生育期区分。
+type Recipe struct {
+	Description string           `json:"description,omitempty" yaml:"description,omitempty"`
+	Stages      map[string]Stage `json:"stages" yaml:"stages"`
+}
+
+// Water 描述水源与清水冲洗时长。
+type Water struct {
+	BaseEC       float64 `json:"base_ec" yaml:"base_ec"`
+	BasePH       float64 `json:"base_ph" yaml:"base_ph"`
+	PreFlushMin  float64 `json:"pre_flush_min" yaml:"pre_flush_min"`
+	PostFlushMin float64 `json:"post_flush_min" yaml:"post_flush_min"`
+}
+
+// Config 是 recipes.yaml 的顶层结构。
+type Config struct {
+	Water   Water             `json:"water" yaml:"water"`
+	Tanks   map[string]Tank   `json:"tanks" yaml:"tanks"`
+	Recipes map[string]Recipe `json:"recipes" yaml:"recipes"`
+}
+
+// 母液罐类型。
+const (
+	KindNutrient = "nutrient"
+	KindAcid     = "acid"
+)
+
+// ErrUnknownRecipe 配方或生育期不存在。
+var ErrUnknownRecipe = errors.New("unknown recipe")
+
+// ErrInfeasible 输入不合法或注肥泵能力不足等导致无法给出方案。
+var ErrInfeasible = errors.New("dosing infeasible")
+
+var (
+	mu      sync.RWMutex
+	current *Config // nil 表示未加载配方
+)
+
+// LoadFromFile 从 YAML/JSON 加载配方与母液罐配置，校验通过则替换运行时配置。
+func LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read recipes: %w", err)
+	}
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("unmarshal yaml recipes: %w", err)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("unmarshal json recipes: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported recipes file type: %s", path)
+	}
+	if err := validate(&cfg); err != nil {
+		return err
+	}
+	mu.Lock()
+	current = &cfg
+	mu.Unlock()
+	return nil
+}
+
+// validate 检查配比引用的罐存在且为营养罐、各罐参数为正。
+func validate(cfg *Config) error {
+	if len(cfg.Recipes) == 0 {
+		return errors.New("recipes config has no recipes")
+	}
+	for name, t := range cfg.Tanks {
+		if t.PumpFlowLph <= 0 {
+			return fmt.Errorf("tank %s: pump_flow_lph must be > 0", name)
+		}
+		switch t.Kind {
+		case KindNutrient:
+			if t.ECPerMlL <= 0 {
+				return fmt.Errorf("tank %s: ec_per_ml_l must be > 0", name)
+			}
+		case KindAcid:
+			if t.PHDropPerMlL <= 0 {
+				return fmt.Errorf("tank %s: ph_drop_per_ml_l must be > 0", name)
+			}
+		default:
+			return fmt.Errorf("tank %s: unknown kind %q", name, t.Kind)
+		}
+	}
+	for rn, r := range cfg.Recipes {
+		for sn, st := range r.Stages {
+			if st.EC <= cfg.Water.BaseEC {
+				return fmt.Errorf("recipe %s/%s: target ec %.2f must be above water base_ec %.2f", rn, sn, st.EC, cfg.Water.BaseEC)
+			}
+			for tank, ratio := range st.Ratios {
+				t, ok := cfg.Tanks[tank]
+				if !ok || t.Kind != KindNutrient {
+					return fmt.Errorf("recipe %s/%s: %s is not a nutrient tank", rn, sn, tank)
+				}
+				if ratio < 0 {
+					return fmt.Errorf("recipe %s/%s: ratio of %s must be >= 0", rn, sn, tank)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Loaded 表示是否已加载配方。
+func Loaded() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// Current 返回当前配置（未加载时为 nil），供 GET /control/recipes 展示。
+func Current() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Calculate 按配方与生育期，把灌溉水量 waterL（升）与灌溉时长 durationMin（分钟）换算为注肥方案。
+func Calculate(recipe, stage string, waterL, durationMin float64) (model.Dosing, error) {
+	mu.RLock()
+	cfg := current
+	mu.RUnlock()
+	if cfg == nil {
+		return model.Dosing{}, fmt.Errorf("%w: recipes not loaded", ErrUnknownRecipe)
+	}
+	r, ok := cfg.Recipes[recipe]
+	if !ok {
+		return model.Dosing{}, fmt.Errorf("%w: %s", ErrUnknownRecipe, recipe)
+	}
+	st, ok := r.Stages[stage]
+	if !ok {
+		return model.Dosing{}, fmt.Errorf("%w: %s has no stage %s", ErrUnknownRecipe, recipe, stage)
+	}
+	if waterL <= 0 || durationMin <= 0 {
+		return model.Dosing{}, fmt.Errorf("%w: water volume and duration must be > 0", ErrInfeasible)
+	}
+
+	d := model.Dosing{
+		Recipe:         recipe,
+		Stage:          stage,
+		TargetEC:       st.EC,
+		TargetPH:       st.PH,
+		WaterL:         waterL,
+		WaterFlowLph:   round(waterL/(durationMin/60), 1),
+		DurationSec:    round(durationMin*60, 1),
+		InjectStartSec: round(cfg.Water.PreFlushMin*60, 1),
+		InjectEndSec:   round((durationMin-cfg.Water.PostFlushMin)*60, 1),
+	}
+	window := d.InjectEndSec - d.InjectStartSec
+	if window <= 0 {
+		return model.Dosing{}, fmt.Errorf("%w: duration %.0f min leaves no injection window after %.0f+%.0f min flush",
+			ErrInfeasible, durationMin, cfg.Water.PreFlushMin, cfg.Water.PostFlushMin)
+	}
+
+	doses := map[string]float64{} // 罐 → mL/L
+	deltaEC := st.EC - cfg.Water.BaseEC
+	if deltaEC <= 0 {
+		return model.Dosing{}, fmt.Errorf("%w: target ec %.2f is not above water ec %.2f, nutrients would not be dosed",
+			ErrInfeasible, st.EC, cfg.Water.BaseEC)
+	}
+
+	// 酸罐调 pH，带入的 EC 从营养罐的增量中扣除
+	if drop := cfg.Water.BasePH - st.PH; drop > 0 {
+		for _, name := range sortedKeys(cfg.Tanks) {
+			if t := cfg.Tanks[name]; t.Kind == KindAcid {
+				doses[name] = drop / t.PHDropPerMlL
+				deltaEC -= doses[name] * t.ECPerMlL
+				break
+			}
+		}
+	}
+
+	var total float64
+	for _, ratio := range st.Ratios {
+		total += ratio
+	}
+	if deltaEC <= 0 && total > 0 {
+		return model.Dosing{}, fmt.Errorf("%w: acid for ph %.1f already raises ec above target %.2f, nutrients would not be dosed",
+			ErrInfeasible, st.PH, st.EC)
+	}
+	if total > 0 {
+		for tank, ratio := range st.Ratios {
+			if ratio > 0 {
+				doses[tank] = deltaEC * ratio / total / cfg.Tanks[tank].ECPerMlL
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(doses) {
+		t := cfg.Tanks[name]
+		volume := doses[name] * waterL / 1000
+		runtime := volume / t.PumpFlowLph * 3600
+		if runtime > window {
+			return model.Dosing{}, fmt.Errorf("%w: pump %s needs %.0fs but injection window is %.0fs",
+				ErrInfeasible, name, runtime, window)
+		}
+		d.Pumps = append(d.Pumps, model.PumpDose{
+			Tank:       name,
+			MlPerL:     round(doses[name], 3),
+			VolumeL:    round(volume, 3),
+			FlowLph:    t.PumpFlowLph,
+			RuntimeSec: round(runtime, 1),
+			Duty:       round(runtime/window, 3),
+		})
+	}
+	return d, nil
+}
+
+// Actual 按注肥窗口实际经过的时间（elapsedSec，相对 open_fertilizer）折算各泵实际投加量。
+// 各泵在窗口内按占空比均匀运行，提前关闭时投加量按比例减少。
+func Actual(d model.Dosing, elapsedSec float64) model.DoseRecord {
+	rec := model.DoseRecord{
+		Recipe:   d.Recipe,
+		Stage:    d.Stage,
+		TargetEC: d.TargetEC,
+		TargetPH: d.TargetPH,
+		PlannedL: map[string]float64{},
+		ActualL:  map[string]float64{},
+	}
+	window := d.InjectEndSec - d.InjectStartSec
+	injected := math.Min(math.Max(elapsedSec-d.InjectStartSec, 0), window)
+	rec.InjectedSec = round(injected, 1)
+	for _, p := range d.Pumps {
+		rec.PlannedL[p.Tank] = p.VolumeL
+		if window > 0 {
+			rec.ActualL[p.Tank] = round(p.VolumeL*injected/window, 3)
+		}
+	}
+	return rec
+}
+
+func round(v float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package fertigation
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadShipped(t *testing.T) {
+	t.Helper()
+	if err := LoadFromFile(filepath.Join("..", "..", "configs", "recipes.yaml")); err != nil {
+		t.Fatalf("加载 configs/recipes.yaml 失败: %v", err)
+	}
+}
+
+func TestCalculateShippedRecipe(t *testing.T) {
+	loadShipped(t)
+	d, err := Calculate("tomato", "fruiting", 5000, 40)
+	if err != nil {
+		t.Fatalf("Calculate: %v", err)
+	}
+	if len(d.Pumps) == 0 {
+		t.Fatal("未给出任何注肥泵方案")
+	}
+}
+
+// 目标 EC 不高于水源 EC 时不能静默返回只有酸罐（或空）的方案。
+func TestCalculateRejectsTargetAtOrBelowBaseEC(t *testing.T) {
+	loadShipped(t)
+	saved := Current()
+	t.Cleanup(func() {
+		mu.Lock()
+		current = saved
+		mu.Unlock()
+	})
+
+	cases := []struct {
+		name     string
+		ec, ph   float64
+		wantText string
+	}{
+		{"低于水源", 0.2, 6.0, "not above water ec"},
+		{"等于水源", 0.3, 6.0, "not above water ec"},
+		{"调酸后超过目标", 0.35, 5.0, "acid"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := *saved
+			cfg.Recipes = map[string]Recipe{"r": {Stages: map[string]Stage{
+				"s": {EC: tc.ec, PH: tc.ph, Ratios: map[string]float64{"A": 1, "B": 1}},
+			}}}
+			mu.Lock()
+			current = &cfg
+			mu.Unlock()
+
+			_, err := Calculate("r", "s", 5000, 40)
+			if !errors.Is(err, ErrInfeasible) || !strings.Contains(err.Error(), tc.wantText) {
+				t.Fatalf("err = %v, 期望 ErrInfeasible 且包含 %q", err, tc.wantText)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsTargetAtOrBelowBaseEC(t *testing.T) {
+	loadShipped(t)
+	cfg := *Current()
+	cfg.Recipes = map[string]Recipe{"r": {Stages: map[string]Stage{"s": {EC: 0.3, PH: 6}}}}
+	if err := validate(&cfg); err == nil || !strings.Contains(err.Error(), "base_ec") {
+		t.Fatalf("validate = %v, 期望拒绝目标 EC 不高于 base_ec 的生育期", err)
+	}
+}
@@ -0,0 +1,318 @@
+package logstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chain.go：执行日志的哈希链与签名检查点（防篡改）。
+// - 每条记录带 seq 与上一条记录的 hash，hash = sha256(prev_hash + 记录 JSON（hash 字段置空）)；
+//   修改、插入或删除中间记录都会使后续链断开。
+// - 定期把最新 (seq, hash) 用 ed25519 私钥签名，写入旁路文件 <log>.checkpoints；
+//   截断日志尾部或整体重算哈希链都会与已签名的检查点不符。
+// - 检查点之间同样成链：每个检查点签入上一个检查点的摘要（prev），删除中间的检查点会使后续检查点断开。
+// - 校验只需公钥（<key>.pub），审计方无需接触私钥；私钥不应与日志放在同一目录（见 DefaultKeyPath）。
+
+// Checkpoint 是对某一时刻日志链头的签名。
+type Checkpoint struct {
+	Seq   int64  `json:"seq"`
+	Hash  string `json:"hash"`
+	Ts    string `json:"ts"`
+	Prev  string `json:"prev,omitempty"` // 上一个检查点的摘要；第一个检查点为空
+	KeyID string `json:"key_id"`
+	Sig   string `json:"sig"`
+}
+
+// signedPayload 返回检查点的签名内容；prev 为空时与启用检查点链之前的格式一致。
+func (c Checkpoint) signedPayload() []byte {
+	if c.Prev == "" {
+		return []byte(fmt.Sprintf("%d|%s|%s", c.Seq, c.Hash, c.Ts))
+	}
+	return []byte(fmt.Sprintf("%d|%s|%s|%s", c.Seq, c.Hash, c.Ts, c.Prev))
+}
+
+// digest 返回检查点（含签名）的摘要，由下一个检查点签入 prev。
+func (c Checkpoint) digest() string {
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckpointPath 返回日志对应的检查点文件路径。
+func CheckpointPath(logPath string) string {
+	return logPath + ".checkpoints"
+}
+
+// DefaultKeyPath 返回默认私钥路径 <用户配置目录>/agri-control-service/logsign.key。
+// 私钥放在日志目录之外：能改写日志目录的人不应同时拿到私钥重签检查点。
+func DefaultKeyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locate config dir: %w", err)
+	}
+	return filepath.Join(dir, "agri-control-service", "logsign.key"), nil
+}
+
+// CheckKeyLocation 拒绝放在日志所在目录（或其子目录）中的私钥。
+func CheckKeyLocation(keyPath, logPath string) error {
+	key, err := filepath.Abs(keyPath)
+	if err != nil {
+		return err
+	}
+	logDir, err := filepath.Abs(filepath.Dir(logPath))
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(logDir, key); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("signing key %s must not be stored in the log directory %s", keyPath, logDir)
+	}
+	return nil
+}
+
+// Signer 持有检查点签名私钥。
+type Signer struct {
+	priv  ed25519.PrivateKey
+	keyID string
+}
+
+// LoadOrCreateSigner 从密钥文件（hex 编码的 ed25519 seed）加载签名私钥；文件不存在时生成新密钥，
+// 并把公钥写到 <path>.pub 供 verify 使用。私钥文件权限 0600，不应纳入版本库。
+func LoadOrCreateSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("generate signing key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("create key dir: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("write signing key: %w", err)
+		}
+		data = []byte(hex.EncodeToString(seed))
+	} else if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key in %s", path)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := priv.Public().(ed25519.PublicKey)
+	if err := os.WriteFile(path+".pub", []byte(hex.EncodeToString(pub)+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("write public key: %w", err)
+	}
+	return &Signer{priv: priv, keyID: keyID(pub)}, nil
+}
+
+// sign 对 seq/hash 生成签名检查点，prev 为上一个检查点的摘要。
+func (s *Signer) sign(seq int64, hash, prev string) Checkpoint {
+	c := Checkpoint{Seq: seq, Hash: hash, Ts: time.Now().UTC().Format(time.RFC3339Nano), Prev: prev, KeyID: s.keyID}
+	c.Sig = hex.EncodeToString(ed25519.Sign(s.priv, c.signedPayload()))
+	return c
+}
+
+// LoadPublicKey 读取 hex 编码的 ed25519 公钥文件。
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	pub, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key in %s", path)
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// keyID 取公钥 sha256 前 8 字节，便于轮换密钥后区分检查点。
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// hashEntry 计算记录哈希：sha256(prev_hash + 规范化记录 JSON（hash 字段置空）)。
+func hashEntry(e LogEntry) (string, error) {
+	e.Hash = ""
+	b, err := canonicalJSON(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON 编码后再按通用结构解码重编码，使对象键有序、数字原样保留，
+// 保证写入时与校验时（从文件解码后）得到相同的字节序列。
+func canonicalJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+// VerifyReport 是日志校验结果。
+type VerifyReport struct {
+	Records     int      `json:"records"`     // 日志总行数
+	Legacy      int      `json:"legacy"`      // 启用哈希链之前的历史记录（无 seq/hash），不参与校验
+	LastSeq     int64    `json:"last_seq"`    // 链上最后一条记录的序号
+	Checkpoints int      `json:"checkpoints"` // 检查点数量
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// OK 表示未发现篡改或截断。
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify 校验日志哈希链与检查点签名；pub 为 nil 时只校验哈希链。
+// 可检测：记录被修改、插入或删除（链断开），日志尾部被截断或整体重算（与检查点不符），
+// 检查点被伪造（签名无效）或中间的检查点被删除（检查点链断开）。
+func Verify(logPath, checkpointPath string, pub ed25519.PublicKey) (VerifyReport, error) {
+	var rep VerifyReport
+	problem := func(format string, args ...interface{}) {
+		rep.Problems = append(rep.Problems, fmt.Sprintf(format, args...))
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return rep, fmt.Errorf("open log file: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+
+	hashes := map[int64]string{} // seq -> hash
+	var prev string
+	chained := false
+	line := 0
+	for scanner.Scan() {
+		line++
+		rep.Records++
+		var e LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			problem("line %d: unparsable record: %v", line, err)
+			continue
+		}
+		if e.Seq == 0 && e.Hash == "" {
+			if chained {
+				problem("line %d: unchained record after chain start", line)
+			} else {
+				rep.Legacy++
+			}
+			continue
+		}
+		if !chained {
+			chained = true
+			if e.Seq != 1 {
+				problem("line %d: chain starts at seq %d, earlier records missing", line, e.Seq)
+			}
+		} else if e.Seq != rep.LastSeq+1 {
+			problem("line %d: seq %d follows %d (records inserted or deleted)", line, e.Seq, rep.LastSeq)
+		}
+		if e.PrevHash != prev {
+			problem("line %d (seq %d): prev_hash mismatch", line, e.Seq)
+		}
+		want, err := hashEntry(e)
+		if err != nil {
+			problem("line %d (seq %d): hash: %v", line, e.Seq, err)
+		} else if want != e.Hash {
+			problem("line %d (seq %d): record modified (hash mismatch)", line, e.Seq)
+		}
+		hashes[e.Seq] = e.Hash
+		prev = e.Hash
+		rep.LastSeq = e.Seq
+	}
+	if err := scanner.Err(); err != nil {
+		return rep, fmt.Errorf("scan log: %w", err)
+	}
+
+	cps, err := readCheckpoints(checkpointPath)
+	if err != nil {
+		return rep, err
+	}
+	rep.Checkpoints = len(cps)
+	if len(cps) == 0 && rep.LastSeq > 0 {
+		problem("no checkpoints found in %s", checkpointPath)
+	}
+	var lastCp int64
+	var prevDigest string
+	cpChained := false
+	for i, c := range cps {
+		if pub != nil {
+			sig, err := hex.DecodeString(c.Sig)
+			if err != nil || c.KeyID != keyID(pub) || !ed25519.Verify(pub, c.signedPayload(), sig) {
+				problem("checkpoint %d (seq %d): invalid signature", i+1, c.Seq)
+				continue
+			}
+		}
+		// 启用检查点链之前写入的检查点没有 prev，仅允许出现在链开始之前
+		switch {
+		case c.Prev != "" && c.Prev != prevDigest:
+			problem("checkpoint %d (seq %d): prev mismatch (checkpoints deleted or reordered)", i+1, c.Seq)
+		case c.Prev == "" && cpChained:
+			problem("checkpoint %d (seq %d): unchained checkpoint after chain start", i+1, c.Seq)
+		}
+		if c.Prev != "" {
+			cpChained = true
+		}
+		prevDigest = c.digest()
+		if c.Seq < lastCp {
+			problem("checkpoint %d: seq %d goes backwards", i+1, c.Seq)
+		}
+		lastCp = c.Seq
+		h, ok := hashes[c.Seq]
+		switch {
+		case c.Seq > rep.LastSeq:
+			problem("checkpoint %d: seq %d beyond end of log (last seq %d): log truncated", i+1, c.Seq, rep.LastSeq)
+		case !ok:
+			problem("checkpoint %d: seq %d missing from log", i+1, c.Seq)
+		case h != c.Hash:
+			problem("checkpoint %d: seq %d hash differs from signed checkpoint (chain rewritten)", i+1, c.Seq)
+		}
+	}
+	return rep, nil
+}
+
+// readCheckpoints 读取检查点文件；文件不存在视为没有检查点。
+func readCheckpoints(path string) ([]Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoints: %w", err)
+	}
+	var out []Checkpoint
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var c Checkpoint
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("checkpoint line %d: %w", i+1, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package logstore
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSignedStore 在临时目录中创建日志，私钥放在另一个临时目录。
+func newSignedStore(t *testing.T) (*LogStore, *Signer, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "execution.log")
+	signer, err := LoadOrCreateSigner(filepath.Join(t.TempDir(), "logsign.key"))
+	if err != nil {
+		t.Fatalf("创建私钥失败: %v", err)
+	}
+	store, err := NewLogStore(logPath)
+	if err != nil {
+		t.Fatalf("创建日志失败: %v", err)
+	}
+	t.Cleanup(func() { store.file.Close() })
+	store.EnableCheckpoints(signer, 2)
+	return store, signer, logPath
+}
+
+func appendN(t *testing.T, s *LogStore, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := s.Append(LogEntry{TaskID: "t1", DeviceID: "v1", Command: "open", Status: "ok"}); err != nil {
+			t.Fatalf("追加日志失败: %v", err)
+		}
+	}
+}
+
+func TestCheckpointsAreChained(t *testing.T) {
+	store, signer, logPath := newSignedStore(t)
+	appendN(t, store, 6)
+
+	cps, err := readCheckpoints(CheckpointPath(logPath))
+	if err != nil || len(cps) != 3 {
+		t.Fatalf("检查点数量 = %d (err %v)，期望 3", len(cps), err)
+	}
+	if cps[0].Prev != "" || cps[1].Prev != cps[0].digest() || cps[2].Prev != cps[1].digest() {
+		t.Fatalf("检查点未成链: %+v", cps)
+	}
+	pub := signer.priv.Public().(ed25519.PublicKey)
+	rep, err := Verify(logPath, CheckpointPath(logPath), pub)
+	if err != nil || !rep.OK() {
+		t.Fatalf("未篡改的日志校验失败: %+v (err %v)", rep, err)
+	}
+
+	// 重新打开后继续写入，新检查点接在已有检查点之后
+	reopened, err := NewLogStore(logPath)
+	if err != nil {
+		t.Fatalf("重新打开日志失败: %v", err)
+	}
+	defer reopened.file.Close()
+	reopened.EnableCheckpoints(signer, 2)
+	appendN(t, reopened, 2)
+	if rep, _ := Verify(logPath, CheckpointPath(logPath), pub); !rep.OK() || rep.Checkpoints != 4 {
+		t.Fatalf("重新打开后校验失败: %+v", rep)
+	}
+}
+
+func TestVerifyDetectsDeletedCheckpoint(t *testing.T) {
+	store, signer, logPath := newSignedStore(t)
+	appendN(t, store, 6)
+
+	cpPath := CheckpointPath(logPath)
+	data, err := os.ReadFile(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	kept := []string{lines[0], lines[2]}
+	if err := os.WriteFile(cpPath, []byte(strings.Join(kept, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := Verify(logPath, cpPath, signer.priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OK() || !strings.Contains(strings.Join(rep.Problems, "\n"), "prev mismatch") {
+		t.Fatalf("删除中间检查点未被发现: %+v", rep)
+	}
+}
+
+func TestCheckKeyLocation(t *testing.T) {
+	cases := []struct {
+		key, log string
+		ok       bool
+	}{
+		{"data/logsign.key", "data/execution.log", false},
+		{"data/keys/logsign.key", "data/execution.log", false},
+		{"/etc/agri-control/logsign.key", "data/execution.log", true},
+		{"data-keys/logsign.key", "data/execution.log", true},
+	}
+	for _, tc := range cases {
+		if err := CheckKeyLocation(tc.key, tc.log); (err == nil) != tc.ok {
+			t.Errorf("CheckKeyLocation(%q, %q) = %v, 期望通过=%v", tc.key, tc.log, err, tc.ok)
+		}
+	}
+}
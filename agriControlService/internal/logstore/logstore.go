@@ -8,30 +8,49 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"agri-control-service/internal/model"
 )
 
 // LogEntry 表示一次动作执行的记录，按 JSONL 持久化。
+// Seq/PrevHash/Hash 构成哈希链（见 chain.go），由 Append 填写。
 type LogEntry struct {
+	Seq       int64                  `json:"seq,omitempty"`
 	Timestamp string                 `json:"ts"`
 	TaskID    string                 `json:"task_id"`
 	TraceID   string                 `json:"trace_id"`
+	Principal string                 `json:"principal,omitempty"` // 提交任务的主体
+	DomainID  string                 `json:"domain_id,omitempty"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	Target    string                 `json:"target,omitempty"`
 	DeviceID  string                 `json:"device_id"`
 	Command   string                 `json:"command"`
 	Params    map[string]interface{} `json:"params"`
 	Status    string                 `json:"status"`
 	Error     string                 `json:"error,omitempty"`
 	ElapsedMs int64                  `json:"elapsed_ms"`
+	Dose      *model.DoseRecord      `json:"dose,omitempty"` // 注肥实际投加量
+	PrevHash  string                 `json:"prev_hash,omitempty"`
+	Hash      string                 `json:"hash,omitempty"`
 }
 
-// LogStore 负责将执行日志追加到 JSONL 文件。
+// LogStore 负责将执行日志追加到 JSONL 文件，并维护哈希链与签名检查点。
 type LogStore struct {
 	path string
 	file *os.File
 	enc  *json.Encoder
 	mu   sync.Mutex
+
+	seq      int64  // 链上最后一条记录的序号
+	lastHash string // 链上最后一条记录的哈希
+
+	signer          *Signer
+	checkpointEvery int64  // 每追加多少条记录写一次检查点
+	checkpointSeq   int64  // 最近一次检查点覆盖到的序号
+	checkpointHash  string // 最近一次检查点的摘要，下一个检查点签入 prev
 }
 
-// NewLogStore 确保日志目录存在，并绑定到指定路径。
+// NewLogStore 确保日志目录存在，并绑定到指定路径；已有日志时从末尾恢复哈希链头。
 func NewLogStore(path string) (*LogStore, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return nil, fmt.Errorf("create log dir: %w", err)
@@ -40,10 +59,53 @@ func NewLogStore(path string) (*LogStore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open log file: %w", err)
 	}
-	return &LogStore{path: path, file: f, enc: json.NewEncoder(f)}, nil
+	s := &LogStore{path: path, file: f, enc: json.NewEncoder(f)}
+	if err := s.recoverHead(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resync 重新从文件末尾恢复哈希链头与检查点位置；多个实例共用同一日志文件时，新 leader 开始写入前调用。
+func (s *LogStore) Resync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recoverHead()
+}
+
+// recoverHead 读取已有日志与检查点，恢复链上最后一条记录的序号、哈希以及最近一次检查点的序号与摘要。
+func (s *LogStore) recoverHead() error {
+	entries, err := s.ReadAll()
+	if err != nil {
+		return fmt.Errorf("recover hash chain: %w", err)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Hash != "" {
+			s.seq, s.lastHash = entries[i].Seq, entries[i].Hash
+			break
+		}
+	}
+	cps, err := readCheckpoints(CheckpointPath(s.path))
+	if err != nil {
+		return err
+	}
+	if len(cps) > 0 {
+		last := cps[len(cps)-1]
+		s.checkpointSeq, s.checkpointHash = last.Seq, last.digest()
+	}
+	return nil
 }
 
-// Append 追加一条日志；出现错误会返回给调用方自行处理。
+// EnableCheckpoints 设置签名私钥，每追加 every 条记录自动写一次签名检查点（every<=0 时仅手动调用 Checkpoint）。
+func (s *LogStore) EnableCheckpoints(signer *Signer, every int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signer = signer
+	s.checkpointEvery = int64(every)
+}
+
+// Append 追加一条日志（填写 seq/prev_hash/hash）；出现错误会返回给调用方自行处理。
 func (s *LogStore) Append(entry LogEntry) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -51,8 +113,52 @@ func (s *LogStore) Append(entry LogEntry) error {
 	if entry.Timestamp == "" {
 		entry.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
 	}
+	entry.Seq = s.seq + 1
+	entry.PrevHash = s.lastHash
+	hash, err := hashEntry(entry)
+	if err != nil {
+		return fmt.Errorf("hash log entry: %w", err)
+	}
+	entry.Hash = hash
+
+	if err := s.enc.Encode(entry); err != nil {
+		return err
+	}
+	s.seq, s.lastHash = entry.Seq, entry.Hash
 
-	return s.enc.Encode(entry)
+	if s.checkpointEvery > 0 && s.seq-s.checkpointSeq >= s.checkpointEvery {
+		return s.checkpointLocked()
+	}
+	return nil
+}
+
+// Checkpoint 为当前链头写一条签名检查点；自上次检查点后没有新记录或未配置私钥时不做任何事。
+// 服务应定期调用，缩短尾部截断无法被发现的窗口。
+func (s *LogStore) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpointLocked()
+}
+
+func (s *LogStore) checkpointLocked() error {
+	if s.signer == nil || s.seq == 0 || s.seq == s.checkpointSeq {
+		return nil
+	}
+	c := s.signer.sign(s.seq, s.lastHash, s.checkpointHash)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(CheckpointPath(s.path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open checkpoint file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	s.checkpointSeq, s.checkpointHash = s.seq, c.digest()
+	return nil
 }
 
 // ReadAll 读取全部日志，便于重放或排查。
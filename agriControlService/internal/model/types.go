@@ -1,14 +1,60 @@
 package model
 
+import "strings"
+
 // 任务与执行相关的数据结构定义。
 type Task struct {
-	TaskID     string                 `json:"task_id,omitempty" yaml:"task_id,omitempty"`
-	TraceID    string                 `json:"trace_id,omitempty" yaml:"trace_id,omitempty"`
-	ScheduleAt string                 `json:"schedule_at,omitempty" yaml:"schedule_at,omitempty"`
-	TaskType   string                 `json:"task_type" yaml:"task_type"`
-	Target     string                 `json:"target" yaml:"target"`
-	Params     map[string]interface{} `json:"params" yaml:"params"`
-	Source     string                 `json:"source" yaml:"source"`
+	TaskID     string `json:"task_id,omitempty" yaml:"task_id,omitempty"`
+	TraceID    string `json:"trace_id,omitempty" yaml:"trace_id,omitempty"`
+	ScheduleAt string `json:"schedule_at,omitempty" yaml:"schedule_at,omitempty"`
+	// ScheduleExpr 保留原始调度表达式（如 sunrise+30m），ScheduleAt 入队时被解析为 RFC3339 绝对时间。
+	ScheduleExpr string `json:"schedule_expr,omitempty" yaml:"schedule_expr,omitempty"`
+	TaskType     string `json:"task_type" yaml:"task_type"`
+	// DomainID/ChannelID 限定 target 的作用域（分区名称仅在单个 Magistrala 频道内唯一）；
+	// 也可直接写完全限定的 target："<domain_id>/<channel_id>/<partition>"。
+	DomainID  string                 `json:"domain_id,omitempty" yaml:"domain_id,omitempty"`
+	ChannelID string                 `json:"channel_id,omitempty" yaml:"channel_id,omitempty"`
+	Target    string                 `json:"target" yaml:"target"`
+	Params    map[string]interface{} `json:"params" yaml:"params"`
+	Source    string                 `json:"source" yaml:"source"`
+	// Principal 是提交任务的主体（API 层只采用可信代理注入的 X-Principal 请求头），随命令写入防篡改执行日志。
+	Principal string `json:"principal,omitempty" yaml:"principal,omitempty"`
+	// GroupID 关联同一批提交的任务（如一次轮灌计划拆出的各段）。
+	GroupID string `json:"group_id,omitempty" yaml:"group_id,omitempty"`
+}
+
+// TargetRef 是带域/频道作用域的目标引用。
+type TargetRef struct {
+	DomainID  string `json:"domain_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Target    string `json:"target"`
+}
+
+// Ref 返回任务的作用域目标；target 为完全限定写法时拆分出域与频道。
+func (t Task) Ref() TargetRef {
+	ref := TargetRef{DomainID: t.DomainID, ChannelID: t.ChannelID, Target: t.Target}
+	if parts := strings.Split(t.Target, "/"); len(parts) == 3 {
+		if ref.DomainID == "" {
+			ref.DomainID = parts[0]
+		}
+		if ref.ChannelID == "" {
+			ref.ChannelID = parts[1]
+		}
+		ref.Target = parts[2]
+	}
+	return ref
+}
+
+// Key 返回作用域键 "<domain>/<channel>/<target>"，未知部分记为 "*"；锁、日志等按该键隔离。
+func (r TargetRef) Key() string {
+	d, c := r.DomainID, r.ChannelID
+	if d == "" {
+		d = "*"
+	}
+	if c == "" {
+		c = "*"
+	}
+	return d + "/" + c + "/" + r.Target
 }
 
 // Action 描述 planner 规划出的单个动作。
@@ -20,9 +66,243 @@ type Action struct {
 
 // DeviceCommand 是 executor 可直接下发的设备指令。
 type DeviceCommand struct {
-	DeviceID string                 `json:"device_id"`
-	Command  string                 `json:"command"`
-	Params   map[string]interface{} `json:"params"`
-	TaskID   string                 `json:"task_id"`
-	TraceID  string                 `json:"trace_id"`
+	DeviceID  string                 `json:"device_id"`
+	Command   string                 `json:"command"`
+	Params    map[string]interface{} `json:"params"`
+	TaskID    string                 `json:"task_id"`
+	TraceID   string                 `json:"trace_id"`
+	Principal string                 `json:"principal,omitempty"`
+	DomainID  string                 `json:"domain_id,omitempty"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	Target    string                 `json:"target,omitempty"`
+	Dose      *DoseRecord            `json:"dose,omitempty"`  // 注肥结束命令附带实际投加量，写入执行日志
+	Epoch     int64                  `json:"epoch,omitempty"` // 高可用模式下发命令的 leader 租约 epoch（fencing token）
+}
+
+// Scope 返回命令所属的作用域目标键，用于日志输出。
+func (c DeviceCommand) Scope() string {
+	return TargetRef{DomainID: c.DomainID, ChannelID: c.ChannelID, Target: c.Target}.Key()
+}
+
+// Step 是一个动作及其按分区展开后的设备命令（wait 等系统动作没有命令）。
+type Step struct {
+	Action   Action          `json:"action"`
+	Commands []DeviceCommand `json:"commands,omitempty"`
+}
+
+// 任务状态取值。
+const (
+	TaskQueued    = "queued"
+	TaskScheduled = "scheduled"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskWaiting   = "waiting"  // 同一作用域目标已有任务在执行，排队等待
+	TaskHeld      = "held"     // 目标处于人工接管（hold 模式），接管结束后继续执行
+	TaskRejected  = "rejected" // 目标处于人工接管（reject 模式），任务被拒绝
+	TaskPartial   = "partial"  // 部分设备执行失败
+	TaskFailed    = "failed"
+)
+
+// DeviceResult 记录单个设备命令的执行结果。
+type DeviceResult struct {
+	DeviceID string `json:"device_id"`
+	Command  string `json:"command"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Ts       string `json:"ts"`
+}
+
+// TaskOutcome 汇总任务执行状态与各设备结果。
+type TaskOutcome struct {
+	TaskID    string         `json:"task_id"`
+	TraceID   string         `json:"trace_id"`
+	TaskType  string         `json:"task_type"`
+	GroupID   string         `json:"group_id,omitempty"`
+	Principal string         `json:"principal,omitempty"`
+	DomainID  string         `json:"domain_id,omitempty"`
+	ChannelID string         `json:"channel_id,omitempty"`
+	Target    string         `json:"target"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Results   []DeviceResult `json:"results,omitempty"`
+	Override  *Override      `json:"override,omitempty"` // 挂起/拒绝该任务的人工接管
+	UpdatedAt string         `json:"updated_at"`
+}
+
+// Done 表示任务已到终态（succeeded / partial / failed / rejected）。
+func (o TaskOutcome) Done() bool {
+	switch o.Status {
+	case TaskSucceeded, TaskPartial, TaskFailed, TaskRejected:
+		return true
+	}
+	return false
+}
+
+// Override 是操作员对某作用域分区的人工接管锁：持有期间非操作员来源的任务被挂起（hold）或拒绝（reject）。
+type Override struct {
+	Key       string `json:"key"` // 作用域目标键 domain/channel/partitionId
+	DomainID  string `json:"domain_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Target    string `json:"target"`
+	Mode      string `json:"mode"`
+	Operator  string `json:"operator,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// OverrideRequest 是创建/修改人工接管的请求体；duration 与 expires_at 二选一。
+type OverrideRequest struct {
+	DomainID  string `json:"domain_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Target    string `json:"target"`
+	Mode      string `json:"mode,omitempty"`     // hold（默认）或 reject
+	Duration  string `json:"duration,omitempty"` // Go duration，如 2h、90m
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Operator  string `json:"operator,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// RotationZoneRequest 是轮灌请求中的一个分区及其请求时长。
+type RotationZoneRequest struct {
+	Target      string  `json:"target"`
+	DurationMin float64 `json:"duration_min"`
+}
+
+// RotationRequest 是 POST /control/rotation 的请求体：在时间窗内按水泵容量轮流灌溉多个分区。
+// window_start/window_end 支持与 schedule_at 相同的表达式（RFC3339、sunrise-30m、05:00 等），
+// window_start 为空表示立即开始，window_end 相对 window_start 解析。
+type RotationRequest struct {
+	DomainID    string                `json:"domain_id,omitempty"`
+	ChannelID   string                `json:"channel_id,omitempty"`
+	Zones       []RotationZoneRequest `json:"zones"`
+	Capacity    int                   `json:"capacity"` // 水泵同时可带动的阀门数
+	WindowStart string                `json:"window_start,omitempty"`
+	WindowEnd   string                `json:"window_end"`
+	MaxRunMin   float64               `json:"max_run_min,omitempty"` // 单段最长运行（分钟），默认取灌溉策略上限
+	DryRun      bool                  `json:"dry_run,omitempty"`     // 只返回时间表，不提交任务
+	Reason      string                `json:"reason,omitempty"`
+}
+
+// RotationZone 汇总单个分区在轮灌计划中的分配情况。
+type RotationZone struct {
+	Key          string  `json:"key"`
+	DomainID     string  `json:"domain_id,omitempty"`
+	ChannelID    string  `json:"channel_id,omitempty"`
+	Target       string  `json:"target"`
+	Valves       int     `json:"valves"`
+	RequestedMin float64 `json:"requested_min"`
+	AllocatedMin float64 `json:"allocated_min"`
+	Runs         int     `json:"runs"`
+}
+
+// RotationSlot 是轮灌时间表中的一段：分区在 start_at 打开阀门、end_at 关闭，对应一个 irrigation 任务。
+type RotationSlot struct {
+	TaskID      string  `json:"task_id"`
+	Key         string  `json:"key"`
+	Target      string  `json:"target"`
+	Valves      int     `json:"valves"`
+	Run         int     `json:"run"`
+	StartAt     string  `json:"start_at"`
+	EndAt       string  `json:"end_at"`
+	DurationMin float64 `json:"duration_min"`
+	Status      string  `json:"status,omitempty"` // 已提交计划的任务当前状态
+}
+
+// RotationPlan 是轮灌计划：各分区分配与按时间排序的开/关时间表；提交后各段任务共享 group_id。
+type RotationPlan struct {
+	GroupID     string         `json:"group_id"`
+	Capacity    int            `json:"capacity"`
+	MaxRunMin   float64        `json:"max_run_min"`
+	WindowStart string         `json:"window_start"`
+	WindowEnd   string         `json:"window_end"`
+	FinishAt    string         `json:"finish_at"`
+	Scale       float64        `json:"scale"` // 时长压缩比例，1 表示按请求时长
+	Zones       []RotationZone `json:"zones"`
+	Slots       []RotationSlot `json:"slots"`
+	Submitted   bool           `json:"submitted"`
+	Principal   string         `json:"principal,omitempty"`
+}
+
+// PumpDose 是单个母液罐（或酸罐）注肥泵的投加方案。
+type PumpDose struct {
+	Tank       string  `json:"tank"`
+	MlPerL     float64 `json:"ml_per_l"`    // 每升灌溉水投加的母液量（mL/L）
+	VolumeL    float64 `json:"volume_l"`    // 本次投加母液总量（L）
+	FlowLph    float64 `json:"flow_lph"`    // 注肥泵流量（L/h）
+	RuntimeSec float64 `json:"runtime_sec"` // 注肥泵累计运行时间
+	Duty       float64 `json:"duty"`        // 注肥窗口内的占空比，泵按比例间歇运行保证浓度均匀
+}
+
+// Dosing 是配方计算结果，作为 fertilization 任务的 dosing 参数下发给注肥设备。
+// 时间均相对 open_fertilizer 下发时刻：先清水灌溉 inject_start_sec，注肥到 inject_end_sec，再清水冲洗管路到 duration_sec。
+type Dosing struct {
+	Recipe         string     `json:"recipe"`
+	Stage          string     `json:"stage"`
+	TargetEC       float64    `json:"target_ec"` // mS/cm
+	TargetPH       float64    `json:"target_ph"`
+	WaterL         float64    `json:"water_l"` // 本次灌溉水量（L）
+	WaterFlowLph   float64    `json:"water_flow_lph"`
+	DurationSec    float64    `json:"duration_sec"`
+	InjectStartSec float64    `json:"inject_start_sec"`
+	InjectEndSec   float64    `json:"inject_end_sec"`
+	Pumps          []PumpDose `json:"pumps"`
+}
+
+// DoseRecord 是写入执行日志的实际投加量：按注肥窗口实际经过的时间折算各泵投加量。
+type DoseRecord struct {
+	Recipe      string             `json:"recipe"`
+	Stage       string             `json:"stage"`
+	TargetEC    float64            `json:"target_ec"`
+	TargetPH    float64            `json:"target_ph"`
+	InjectedSec float64            `json:"injected_sec"`
+	PlannedL    map[string]float64 `json:"planned_l"`
+	ActualL     map[string]float64 `json:"actual_l"`
+}
+
+// Adjustment 记录策略对任务参数的一次修正。
+type Adjustment struct {
+	Field  string      `json:"field"`
+	From   interface{} `json:"from"`
+	To     interface{} `json:"to"`
+	Reason string      `json:"reason"`
+}
+
+// PolicyVerdict 是策略评估结论：是否放行、拒绝原因及参数修正。
+type PolicyVerdict struct {
+	Allowed     bool         `json:"allowed"`
+	Reason      string       `json:"reason,omitempty"`
+	Adjustments []Adjustment `json:"adjustments,omitempty"`
+}
+
+// TimelineEntry 是计划预览中的一个时间点。
+type TimelineEntry struct {
+	Step        int     `json:"step"`
+	ActionType  string  `json:"action_type"`
+	DeviceType  string  `json:"device_type"`
+	At          string  `json:"at"`
+	OffsetSec   float64 `json:"offset_sec"`
+	DurationSec float64 `json:"duration_sec,omitempty"` // 仅 wait 动作
+	Devices     int     `json:"devices,omitempty"`
+}
+
+// PlanPreview 是任务的执行计划预览，不产生任何副作用。
+type PlanPreview struct {
+	Task       Task            `json:"task"`
+	Executable bool            `json:"executable"`
+	Error      string          `json:"error,omitempty"`
+	Fields     []FieldError    `json:"fields,omitempty"` // 参数 schema 校验错误
+	Verdict    PolicyVerdict   `json:"verdict"`
+	Steps      []Step          `json:"steps,omitempty"`
+	Timeline   []TimelineEntry `json:"timeline,omitempty"`
+	StartAt    string          `json:"start_at"`
+	FinishAt   string          `json:"finish_at,omitempty"`
+	Override   *Override       `json:"override,omitempty"` // 目标当前的人工接管（对该任务来源生效时）
+}
+
+// FieldError 是字段级校验错误（如参数 schema 校验失败）。
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,134 @@
+package override
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+)
+
+// override 包：分区人工接管锁。
+// 农艺人员在田间作业时由操作员锁定分区，持有期间自动化来源（llm、定时、MQTT 等）的任务被挂起或拒绝；
+// 锁到期自动解除，解除时通过回调通知控制服务恢复被挂起的任务。
+
+// 接管模式。
+const (
+	ModeHold   = "hold"   // 挂起：任务保持 held，接管结束后继续执行
+	ModeReject = "reject" // 拒绝：任务直接失败
+)
+
+// operatorSources 不受人工接管约束的任务来源。
+var operatorSources = map[string]bool{"operator": true, "human": true}
+
+// IsOperator 判断任务来源是否为操作员；来源须由服务端认定（HTTP 接口只信任可信代理注入的来源），不能直接取请求体。
+func IsOperator(source string) bool {
+	return operatorSources[source]
+}
+
+// ErrInvalidOverride 接管请求不合法（模式未知、到期时间已过等）。
+var ErrInvalidOverride = errors.New("invalid override")
+
+type entry struct {
+	o       model.Override
+	expires time.Time
+	timer   *time.Timer
+}
+
+// Manager 管理全部人工接管；键为作用域目标键。
+type Manager struct {
+	mu        sync.Mutex
+	items     map[string]*entry
+	onRelease func(model.Override)
+}
+
+// NewManager 创建接管管理器；onRelease 在接管到期或被删除后调用（不持有内部锁）。
+func NewManager(onRelease func(model.Override)) *Manager {
+	return &Manager{items: make(map[string]*entry), onRelease: onRelease}
+}
+
+// Set 创建或修改接管；同一键已存在时保留创建时间并按新的模式、到期时间重新计时。
+func (m *Manager) Set(o model.Override, expires time.Time) (model.Override, error) {
+	if o.Mode == "" {
+		o.Mode = ModeHold
+	}
+	if o.Mode != ModeHold && o.Mode != ModeReject {
+		return model.Override{}, fmt.Errorf("%w: unknown mode %q", ErrInvalidOverride, o.Mode)
+	}
+	now := clock.Now()
+	if !expires.After(now) {
+		return model.Override{}, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidOverride)
+	}
+
+	ts := now.UTC().Format(time.RFC3339)
+	o.CreatedAt, o.UpdatedAt = ts, ts
+	o.ExpiresAt = expires.UTC().Format(time.RFC3339)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.items[o.Key]; ok {
+		old.timer.Stop()
+		o.CreatedAt = old.o.CreatedAt
+	}
+	e := &entry{o: o, expires: expires}
+	e.timer = clock.AfterFunc(clock.Until(expires), func() { m.expire(o.Key, e) })
+	m.items[o.Key] = e
+	return o, nil
+}
+
+// Get 返回键对应的生效中接管。
+func (m *Manager) Get(key string) (model.Override, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[key]
+	if !ok || !clock.Now().Before(e.expires) {
+		return model.Override{}, false
+	}
+	return e.o, true
+}
+
+// List 返回全部生效中接管，按键排序。
+func (m *Manager) List() []model.Override {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]model.Override, 0, len(m.items))
+	for _, e := range m.items {
+		out = append(out, e.o)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Release 提前解除接管。
+func (m *Manager) Release(key string) (model.Override, bool) {
+	m.mu.Lock()
+	e, ok := m.items[key]
+	if !ok {
+		m.mu.Unlock()
+		return model.Override{}, false
+	}
+	e.timer.Stop()
+	delete(m.items, key)
+	m.mu.Unlock()
+	if m.onRelease != nil {
+		m.onRelease(e.o)
+	}
+	return e.o, true
+}
+
+// expire 到期回调；接管已被修改（entry 已替换）时忽略。
+func (m *Manager) expire(key string, e *entry) {
+	m.mu.Lock()
+	if m.items[key] != e {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.items, key)
+	m.mu.Unlock()
+	if m.onRelease != nil {
+		m.onRelease(e.o)
+	}
+}
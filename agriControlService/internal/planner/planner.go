@@ -11,11 +11,15 @@ import (
 // 主要职责是“计划怎么做”，不关心设备执行细节。
 func PlanActions(task model.Task) ([]model.Action, error) {
 	// 按 task_type 从注册表查找对应的动作链
-	actions, ok := registry.TaskActionRegistry[task.TaskType]
+	registered, ok := registry.TaskActionRegistry[task.TaskType]
 	if !ok {
 		return nil, errors.New("unknown task type")
 	}
 
+	// 拷贝动作链后再注入参数，避免并发任务改写注册表中的共享切片
+	actions := make([]model.Action, len(registered))
+	copy(actions, registered)
+
 	// 将 Task 的动态参数透传到每个 Action，便于后续执行使用
 	for i := range actions {
 		actions[i].Params = task.Params
@@ -1,18 +1,57 @@
 package policy
 
-import "agri-control-service/internal/model"
+import (
+	"errors"
+
+	"agri-control-service/internal/model"
+)
 
 // policy 包：放置任务级策略校验/修正逻辑，用于在执行前约束或调整参数。
 
-// ValidateTask: 对任务参数做策略检查/修正。
-func ValidateTask(task model.Task) error {
-	// 示例：灌溉时长限制，若超过 60 分钟则截断到 60。
+// MaxIrrigationMin 单次灌溉时长上限（分钟），轮灌规划也按此拆分单段运行。
+const MaxIrrigationMin = 60
+
+// irrigationCaps 灌溉时长字段及其上限。
+var irrigationCaps = []struct {
+	field string
+	limit float64
+}{{"duration_min", MaxIrrigationMin}, {"duration_sec", MaxIrrigationMin * 60}}
+
+// Evaluate 对任务做策略评估，返回修正后的参数副本与结论；不修改入参，可用于计划预览。
+func Evaluate(task model.Task) (map[string]interface{}, model.PolicyVerdict) {
+	params := make(map[string]interface{}, len(task.Params))
+	for k, v := range task.Params {
+		params[k] = v
+	}
+	verdict := model.PolicyVerdict{Allowed: true}
+
+	// 灌溉时长限制：超过 60 分钟则截断到 60（duration_sec 同样按 3600 秒截断）。
+	// 参数 schema 的上限与此一致，经 /control/task 提交的任务不会触发截断；这里兜底未配置 schema 的情况。
 	if task.TaskType == "irrigation" {
-		if v, ok := task.Params["duration_min"].(float64); ok {
-			if v > 60 {
-				task.Params["duration_min"] = 60
+		for _, c := range irrigationCaps {
+			field, limit := c.field, c.limit
+			if v, ok := params[field].(float64); ok && v > limit {
+				params[field] = limit
+				verdict.Adjustments = append(verdict.Adjustments, model.Adjustment{
+					Field:  field,
+					From:   v,
+					To:     limit,
+					Reason: "irrigation duration capped",
+				})
 			}
 		}
 	}
+	return params, verdict
+}
+
+// ValidateTask: 对任务参数做策略检查/修正，修正结果直接写回 task.Params。
+func ValidateTask(task model.Task) error {
+	params, verdict := Evaluate(task)
+	if !verdict.Allowed {
+		return errors.New(verdict.Reason)
+	}
+	for k, v := range params {
+		task.Params[k] = v
+	}
 	return nil
 }
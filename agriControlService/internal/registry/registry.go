@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"agri-control-service/internal/model"
+	"agri-control-service/internal/policy"
+	"agri-control-service/internal/schema"
 
 	"gopkg.in/yaml.v3"
 )
@@ -27,9 +30,31 @@ var defaultTaskActionRegistry = map[string][]model.Action{
 	},
 }
 
-// registryConfig: 配置文件结构，仅关心 actions 段。
+// TaskParamSchemas: TaskType -> 参数 schema（运行时表）；未配置 schema 的任务类型不做参数校验。
+var TaskParamSchemas = defaultTaskParamSchemas()
+
+// defaultTaskParamSchemas: 内置兜底 schema，与 defaultTaskActionRegistry 对应。
+func defaultTaskParamSchemas() map[string]*schema.Schema {
+	no := false
+	minDuration, maxDuration, maxDurationSec := 1.0, float64(policy.MaxIrrigationMin), float64(policy.MaxIrrigationMin*60)
+	return map[string]*schema.Schema{
+		"irrigation": {
+			Type:                 "object",
+			Required:             []string{"duration_min"},
+			AdditionalProperties: &no,
+			Properties: map[string]*schema.Schema{
+				"duration_min": {Type: "number", Minimum: &minDuration, Maximum: &maxDuration, Description: "灌溉时长（分钟）"},
+				"duration_sec": {Type: "number", Minimum: &minDuration, Maximum: &maxDurationSec, Description: "时长（秒），优先于 duration_min"},
+				"reason":       {Type: "string", Description: "决策原因"},
+			},
+		},
+	}
+}
+
+// registryConfig: 配置文件结构，关心 actions 与 schemas 段。
 type registryConfig struct {
 	Actions map[string][]model.Action `json:"actions" yaml:"actions"`
+	Schemas map[string]*schema.Schema `json:"schemas" yaml:"schemas"`
 }
 
 // LoadFromFile 尝试从 YAML/JSON 配置加载 Task → Action 映射，成功则替换运行时表，失败保留默认表。
@@ -57,11 +82,55 @@ func LoadFromFile(path string) error {
 		return errors.New("registry config has no actions")
 	}
 
+	if err := checkSchemas(cfg.Schemas); err != nil {
+		return err
+	}
+
 	// 采用深拷贝后的配置作为新的运行时表，避免外部修改影响。
 	TaskActionRegistry = cloneRegistry(cfg.Actions)
+	if len(cfg.Schemas) > 0 {
+		TaskParamSchemas = cfg.Schemas
+	}
 	return nil
 }
 
+// checkSchemas 检查各 schema 自洽（见 schema.Check），并要求 irrigation 时长上限不超过策略上限，
+// 避免 schema 放行、策略再静默截断。
+func checkSchemas(schemas map[string]*schema.Schema) error {
+	for _, name := range sortedNames(schemas) {
+		if err := schemas[name].Check(); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	irr, ok := schemas["irrigation"]
+	if !ok || irr == nil {
+		return nil
+	}
+	for _, c := range []struct {
+		field string
+		limit float64
+	}{{"duration_min", policy.MaxIrrigationMin}, {"duration_sec", policy.MaxIrrigationMin * 60}} {
+		field, limit := c.field, c.limit
+		prop := irr.Properties[field]
+		if prop == nil {
+			continue
+		}
+		if prop.Maximum == nil || *prop.Maximum > limit {
+			return fmt.Errorf("schema irrigation.%s: maximum must be set and <= %v (policy cap)", field, limit)
+		}
+	}
+	return nil
+}
+
+func sortedNames(m map[string]*schema.Schema) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // cloneRegistry: 对映射和动作切片做浅层值拷贝，避免共享底层切片。
 func cloneRegistry(src map[string][]model.Action) map[string][]model.Action {
 	dst := make(map[string][]model.Action, len(src))
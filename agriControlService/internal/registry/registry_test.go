@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 仓库自带的 scenarios.yaml 必须能通过加载时的 schema 自洽检查。
+func TestShippedScenariosLoad(t *testing.T) {
+	if err := LoadFromFile(filepath.Join("..", "..", "configs", "scenarios.yaml")); err != nil {
+		t.Fatalf("加载 configs/scenarios.yaml 失败: %v", err)
+	}
+}
+
+func TestLoadRejectsInconsistentSchemas(t *testing.T) {
+	cases := []struct {
+		name, schema, want string
+	}{
+		{"required 带 default", `
+    type: object
+    required: [duration_min]
+    properties:
+      duration_min: {type: number, minimum: 1, maximum: 60, default: 30}`, "must not have a default"},
+		{"超过策略上限", `
+    type: object
+    required: [duration_min]
+    properties:
+      duration_min: {type: number, minimum: 1, maximum: 240}`, "policy cap"},
+		{"minimum 大于 maximum", `
+    type: object
+    properties:
+      duration_min: {type: number, minimum: 90, maximum: 60}`, "minimum 90 > maximum 60"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenarios.yaml")
+			cfg := "actions:\n  irrigation:\n    - {action_type: open_valve, device_type: irrigation}\nschemas:\n  irrigation:" + tc.schema + "\n"
+			if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadFromFile(path)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err=%v，期望包含 %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"agri-control-service/internal/model"
+)
+
+// resolver 包：把任务的抽象目标（分区 ID / 分区名称，如 "A区"）展开为具体执行器（Magistrala clientId）。
+// 数据来源为共享的 data/device_registry.json：domain → channel → partition → executors。
+
+// defaultExecutorType 未标注类型的执行器视为灌溉阀门（当前执行层仅接入了灌溉节点）。
+const defaultExecutorType = "irrigation"
+
+// Executor 表示分区内的一个执行器；注册表中既可写成字符串 clientId，也可写成带类型的对象。
+type Executor struct {
+	ClientID   string `json:"clientId"`
+	DeviceType string `json:"deviceType,omitempty"`
+}
+
+// UnmarshalJSON 兼容 "clientId" 与 {"clientId":"...","deviceType":"..."} 两种写法。
+func (e *Executor) UnmarshalJSON(b []byte) error {
+	var id string
+	if err := json.Unmarshal(b, &id); err == nil {
+		*e = Executor{ClientID: id}
+		return nil
+	}
+	type plain Executor
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*e = Executor(p)
+	return nil
+}
+
+// Type 返回执行器类型，未标注时使用默认类型。
+func (e Executor) Type() string {
+	if e.DeviceType == "" {
+		return defaultExecutorType
+	}
+	return e.DeviceType
+}
+
+// Partition 表示一个分区及其传感器、执行器。
+type Partition struct {
+	PartitionID   string     `json:"partitionId"`
+	PartitionName string     `json:"partitionName"`
+	Sensors       []string   `json:"sensors"`
+	Executors     []Executor `json:"executors"`
+	// Lat/Lng 分区坐标（手工配置，控制服务不从传感平台拉取），可省略；用于日出日落调度
+	Lat *float64 `json:"lat,omitempty"`
+	Lng *float64 `json:"lng,omitempty"`
+}
+
+// Channel 对应 Magistrala 频道。
+type Channel struct {
+	ChannelID  string      `json:"channelId"`
+	Partitions []Partition `json:"partitions"`
+}
+
+// Domain 对应 Magistrala 域。
+type Domain struct {
+	DomainID string    `json:"domainId"`
+	Channels []Channel `json:"channels"`
+}
+
+// deviceRegistry 是 device_registry.json 的顶层结构。
+type deviceRegistry struct {
+	Domains []Domain `json:"domains"`
+}
+
+var (
+	mu      sync.RWMutex
+	current *deviceRegistry // nil 表示未加载：目标原样作为设备 ID（兼容旧行为）
+)
+
+// ErrUnknownTarget 目标在注册表中找不到对应分区。
+var ErrUnknownTarget = errors.New("unknown target")
+
+// ErrAmbiguousTarget 未限定域/频道时，多个频道中存在同名分区。
+var ErrAmbiguousTarget = errors.New("ambiguous target, specify domain_id/channel_id")
+
+// ErrNoExecutors 分区内没有匹配设备类型的执行器。
+var ErrNoExecutors = errors.New("no executors for device type")
+
+// LoadFromFile 读取设备注册表，成功则替换运行时表。
+func LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read device registry: %w", err)
+	}
+	var reg deviceRegistry
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return fmt.Errorf("unmarshal device registry: %w", err)
+	}
+	if len(reg.Domains) == 0 {
+		return errors.New("device registry has no domains")
+	}
+	mu.Lock()
+	current = &reg
+	mu.Unlock()
+	return nil
+}
+
+// Loaded 表示是否已加载设备注册表。
+func Loaded() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// Location 是分区在注册表中的完整位置。
+type Location struct {
+	DomainID  string
+	ChannelID string
+	Partition Partition
+}
+
+// Ref 返回规范化的作用域目标（target 使用分区 ID）。
+func (l Location) Ref() model.TargetRef {
+	return model.TargetRef{DomainID: l.DomainID, ChannelID: l.ChannelID, Target: l.Partition.PartitionID}
+}
+
+// Locate 在 ref 限定的域/频道内按分区 ID 或名称查找分区；未限定时在全部域中查找，命中多个则报歧义。
+func Locate(ref model.TargetRef) (Location, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return Location{}, fmt.Errorf("%w: device registry not loaded", ErrUnknownTarget)
+	}
+	var found []Location
+	for _, d := range current.Domains {
+		if ref.DomainID != "" && d.DomainID != ref.DomainID {
+			continue
+		}
+		for _, c := range d.Channels {
+			if ref.ChannelID != "" && c.ChannelID != ref.ChannelID {
+				continue
+			}
+			for _, p := range c.Partitions {
+				if p.PartitionID == ref.Target || p.PartitionName == ref.Target {
+					found = append(found, Location{DomainID: d.DomainID, ChannelID: c.ChannelID, Partition: p})
+				}
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Location{}, fmt.Errorf("%w: %s", ErrUnknownTarget, ref.Key())
+	case 1:
+		return found[0], nil
+	default:
+		return Location{}, fmt.Errorf("%w: %s matches %d partitions", ErrAmbiguousTarget, ref.Target, len(found))
+	}
+}
+
+// All 返回注册表中全部分区的位置；未加载时返回 nil。
+func All() []Location {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return nil
+	}
+	var out []Location
+	for _, d := range current.Domains {
+		for _, c := range d.Channels {
+			for _, p := range c.Partitions {
+				out = append(out, Location{DomainID: d.DomainID, ChannelID: c.ChannelID, Partition: p})
+			}
+		}
+	}
+	return out
+}
+
+// Coords 返回作用域键（"domain/channel/partition"，"*" 表示未限定）或分区名对应分区的坐标；
+// 注册表未加载、分区无法唯一定位或未记录坐标时 ok=false。
+func Coords(key string) (lat, lng float64, ok bool) {
+	ref := model.TargetRef{Target: key}
+	if parts := strings.Split(key, "/"); len(parts) == 3 {
+		ref = model.TargetRef{DomainID: strings.Trim(parts[0], "*"), ChannelID: strings.Trim(parts[1], "*"), Target: parts[2]}
+	}
+	loc, err := Locate(ref)
+	if err != nil || loc.Partition.Lat == nil || loc.Partition.Lng == nil {
+		return 0, 0, false
+	}
+	return *loc.Partition.Lat, *loc.Partition.Lng, true
+}
+
+// ResolveDevices 返回作用域目标分区内类型为 deviceType 的执行器 clientId 列表。
+// 注册表未加载时目标原样返回，保持旧版“target 即设备 ID”的行为。
+func ResolveDevices(ref model.TargetRef, deviceType string) ([]string, error) {
+	if !Loaded() {
+		return []string{ref.Target}, nil
+	}
+	loc, err := Locate(ref)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range loc.Partition.Executors {
+		if e.ClientID != "" && e.Type() == deviceType {
+			ids = append(ids, e.ClientID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: %s in %s", ErrNoExecutors, deviceType, loc.Ref().Key())
+	}
+	return ids, nil
+}
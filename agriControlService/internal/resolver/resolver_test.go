@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"agri-control-service/internal/model"
+)
+
+// 两个频道各有一个名为 "A区" 的分区，用于验证作用域限定。
+const testRegistry = `{
+  "domains": [{
+    "domainId": "farm-1",
+    "channels": [
+      {"channelId": "ch-north", "partitions": [{
+        "partitionId": "north-A", "partitionName": "A区",
+        "executors": ["valve-1", {"clientId": "valve-2", "deviceType": "irrigation"}, {"clientId": "fan-1", "deviceType": "ventilation"}]
+      }]},
+      {"channelId": "ch-south", "partitions": [{
+        "partitionId": "south-A", "partitionName": "A区",
+        "executors": ["valve-9"]
+      }]}
+    ]
+  }]
+}`
+
+func loadTestRegistry(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "device_registry.json")
+	if err := os.WriteFile(path, []byte(testRegistry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+	})
+}
+
+func TestResolveDevicesFansOutByType(t *testing.T) {
+	if ids, err := ResolveDevices(model.TargetRef{Target: "valve-x"}, "irrigation"); err != nil || !reflect.DeepEqual(ids, []string{"valve-x"}) {
+		t.Fatalf("未加载注册表时 = %v, %v，期望目标原样返回", ids, err)
+	}
+	loadTestRegistry(t)
+
+	ref := model.TargetRef{ChannelID: "ch-north", Target: "A区"}
+	ids, err := ResolveDevices(ref, "irrigation")
+	if err != nil || !reflect.DeepEqual(ids, []string{"valve-1", "valve-2"}) {
+		t.Fatalf("irrigation 执行器 = %v, %v", ids, err)
+	}
+	if ids, err := ResolveDevices(ref, "ventilation"); err != nil || !reflect.DeepEqual(ids, []string{"fan-1"}) {
+		t.Fatalf("ventilation 执行器 = %v, %v", ids, err)
+	}
+	if _, err := ResolveDevices(ref, "fertigation"); !errors.Is(err, ErrNoExecutors) {
+		t.Fatalf("无匹配类型 err = %v，期望 ErrNoExecutors", err)
+	}
+}
+
+func TestLocateScopesByDomainAndChannel(t *testing.T) {
+	loadTestRegistry(t)
+
+	if _, err := Locate(model.TargetRef{Target: "A区"}); !errors.Is(err, ErrAmbiguousTarget) {
+		t.Fatalf("未限定作用域 err = %v，期望 ErrAmbiguousTarget", err)
+	}
+	loc, err := Locate(model.TargetRef{DomainID: "farm-1", ChannelID: "ch-south", Target: "A区"})
+	if err != nil || loc.Partition.PartitionID != "south-A" {
+		t.Fatalf("限定频道 = %+v, %v", loc, err)
+	}
+	if got := loc.Ref().Key(); got != "farm-1/ch-south/south-A" {
+		t.Fatalf("作用域键 = %s", got)
+	}
+	// 分区 ID 全局唯一，无需限定
+	if loc, err := Locate(model.TargetRef{Target: "north-A"}); err != nil || loc.ChannelID != "ch-north" {
+		t.Fatalf("按分区 ID 定位 = %+v, %v", loc, err)
+	}
+	if _, err := Locate(model.TargetRef{DomainID: "farm-2", Target: "A区"}); !errors.Is(err, ErrUnknownTarget) {
+		t.Fatalf("其他域 err = %v，期望 ErrUnknownTarget", err)
+	}
+	if _, _, ok := Coords("farm-1/ch-north/north-A"); ok {
+		t.Fatal("未记录坐标的分区不应返回坐标")
+	}
+}
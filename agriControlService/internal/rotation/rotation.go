@@ -0,0 +1,237 @@
+package rotation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// rotation 包：共享水源的分区轮灌规划。
+// 水泵同时只能带动有限个阀门，按容量把各分区请求的灌溉时长排成开/关时间表：
+// - 任一时刻打开的阀门数不超过容量（分区占用的阀门数 = 分区内灌溉执行器数量）
+// - 单段运行不超过 MaxRunMin，超出部分拆成多轮，与其他分区轮换
+// - 分配时长与每段时长都是整分钟（灌溉任务的 duration_min 至少 1）；拆分后不足 1 分钟的零头并入当前段
+// - 每次有阀门空出时优先安排已灌比例最低的分区；排在前面但容量不够的分区不会被小分区无限插队
+// - 整个时间表落在时间窗内；总需求超出时间窗时按同一比例压缩各分区时长（公平缩减）
+// 本包只做纯计算，不涉及任务提交与设备执行。
+
+// ErrInvalidRequest 请求参数不合法（容量、时间窗、分区阀门数等）。
+var ErrInvalidRequest = errors.New("invalid rotation request")
+
+// ErrWindowTooShort 即使每个分区只灌 1 分钟也排不进时间窗。
+var ErrWindowTooShort = errors.New("rotation does not fit in window")
+
+// Zone 是参与轮灌的一个分区。
+type Zone struct {
+	Key     string  // 作用域键，仅用于标识与输出
+	Valves  int     // 同时占用的阀门数
+	Minutes float64 // 请求的灌溉时长（分钟）
+}
+
+// Request 是轮灌规划的输入。
+type Request struct {
+	Zones     []Zone
+	Capacity  int       // 水泵同时可带动的阀门数
+	Start     time.Time // 时间窗开始
+	End       time.Time // 时间窗结束
+	MaxRunMin float64   // 单段最长运行时间（分钟），<=0 表示不拆分
+}
+
+// Slot 是时间表中的一段运行：某分区在 [Start, End) 内打开阀门。
+type Slot struct {
+	Zone    int // Request.Zones 下标
+	Run     int // 该分区的第几段（从 1 开始）
+	Start   time.Time
+	End     time.Time
+	Minutes float64
+}
+
+// Plan 是轮灌规划结果。
+type Plan struct {
+	Slots     []Slot    // 按开始时间排序
+	Allocated []float64 // 各分区实际分配的时长（分钟），与 Request.Zones 一一对应
+	Scale     float64   // 时长压缩比例，1 表示未压缩
+	Finish    time.Time // 最后一段结束时间
+}
+
+// scaleSteps 二分搜索压缩比例的迭代次数，精度远小于 1 分钟的取整误差。
+const scaleSteps = 30
+
+// Build 生成轮灌时间表；需求能放进时间窗时按原时长排，否则二分查找最大的公平压缩比例。
+func Build(req Request) (Plan, error) {
+	if err := validate(req); err != nil {
+		return Plan{}, err
+	}
+	window := req.End.Sub(req.Start)
+
+	full := allocate(req.Zones, 1)
+	if p := schedule(req, full); p.Finish.Sub(req.Start) <= window {
+		p.Scale = 1
+		return p, nil
+	}
+
+	// 各分区至少灌 1 分钟，仍放不下则无解
+	minimal := allocate(req.Zones, 0)
+	best := schedule(req, minimal)
+	if best.Finish.Sub(req.Start) > window {
+		return Plan{}, fmt.Errorf("%w: needs %s, window is %s", ErrWindowTooShort,
+			best.Finish.Sub(req.Start).Round(time.Minute), window.Round(time.Minute))
+	}
+	best.Scale = 0
+
+	lo, hi := 0.0, 1.0
+	for i := 0; i < scaleSteps; i++ {
+		mid := (lo + hi) / 2
+		p := schedule(req, allocate(req.Zones, mid))
+		if p.Finish.Sub(req.Start) <= window {
+			lo = mid
+			best = p
+			best.Scale = mid
+		} else {
+			hi = mid
+		}
+	}
+	return best, nil
+}
+
+// validate 检查容量、时间窗与各分区参数。
+func validate(req Request) error {
+	if len(req.Zones) == 0 {
+		return fmt.Errorf("%w: no zones", ErrInvalidRequest)
+	}
+	if req.Capacity <= 0 {
+		return fmt.Errorf("%w: capacity must be > 0", ErrInvalidRequest)
+	}
+	if !req.End.After(req.Start) {
+		return fmt.Errorf("%w: window end must be after start", ErrInvalidRequest)
+	}
+	for _, z := range req.Zones {
+		if z.Minutes <= 0 {
+			return fmt.Errorf("%w: %s duration must be > 0", ErrInvalidRequest, z.Key)
+		}
+		if z.Valves <= 0 {
+			return fmt.Errorf("%w: %s has no valves", ErrInvalidRequest, z.Key)
+		}
+		if z.Valves > req.Capacity {
+			return fmt.Errorf("%w: %s needs %d valves, capacity is %d", ErrInvalidRequest, z.Key, z.Valves, req.Capacity)
+		}
+	}
+	return nil
+}
+
+// allocate 按比例压缩各分区时长，取整到分钟且至少 1 分钟；未压缩时四舍五入到整分钟。
+func allocate(zones []Zone, scale float64) []float64 {
+	out := make([]float64, len(zones))
+	for i, z := range zones {
+		if scale >= 1 {
+			out[i] = math.Max(1, math.Round(z.Minutes))
+			continue
+		}
+		out[i] = math.Max(1, math.Floor(z.Minutes*scale))
+	}
+	return out
+}
+
+// runLength 返回本段运行时长：不超过单段上限（取整到分钟，至少 1 分钟）；
+// 剩余不足 1 分钟的零头并入本段，即使因此略超上限，避免拆出无法下发的短段。
+func runLength(remaining, maxRun float64) float64 {
+	if maxRun <= 0 {
+		return remaining
+	}
+	limit := math.Max(1, math.Floor(maxRun))
+	if remaining-limit < 1 {
+		return remaining
+	}
+	return limit
+}
+
+// running 是模拟过程中正在运行的一段。
+type running struct {
+	zone int
+	end  time.Time
+}
+
+// schedule 按事件推进模拟轮灌：每当有阀门空出，按已灌比例从低到高启动分区。
+// 排在最前但容量不够的分区会预留最早可启动时刻，其后的分区只有在该时刻前结束才允许插空（EASY backfilling），
+// 保证大分区不会被小分区一直插队。
+func schedule(req Request, alloc []float64) Plan {
+	n := len(req.Zones)
+	remaining := append([]float64(nil), alloc...)
+	delivered := make([]float64, n)
+	runs := make([]int, n)
+	active := map[int]bool{}
+	var inflight []running
+	free := req.Capacity
+	now := req.Start
+	plan := Plan{Allocated: alloc, Finish: req.Start}
+
+	for {
+		// 候选：仍有剩余且未在运行，按已灌比例升序，比例相同按请求顺序
+		var candidates []int
+		for i := 0; i < n; i++ {
+			if remaining[i] > 0 && !active[i] {
+				candidates = append(candidates, i)
+			}
+		}
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return delivered[candidates[a]]/alloc[candidates[a]] < delivered[candidates[b]]/alloc[candidates[b]]
+		})
+
+		var reserve time.Time // 被阻塞分区的预留启动时刻，零值表示无预留
+		for _, i := range candidates {
+			run := runLength(remaining[i], req.MaxRunMin)
+			end := now.Add(time.Duration(run * float64(time.Minute)))
+			if req.Zones[i].Valves > free {
+				if reserve.IsZero() {
+					reserve = earliestStart(inflight, req.Zones, free, req.Zones[i].Valves)
+				}
+				continue
+			}
+			if !reserve.IsZero() && end.After(reserve) {
+				continue
+			}
+			runs[i]++
+			plan.Slots = append(plan.Slots, Slot{Zone: i, Run: runs[i], Start: now, End: end, Minutes: run})
+			remaining[i] -= run
+			delivered[i] += run
+			free -= req.Zones[i].Valves
+			active[i] = true
+			inflight = append(inflight, running{zone: i, end: end})
+			if end.After(plan.Finish) {
+				plan.Finish = end
+			}
+		}
+
+		if len(inflight) == 0 {
+			return plan
+		}
+		// 推进到最早结束的一段，释放同时结束的所有段
+		sort.Slice(inflight, func(a, b int) bool { return inflight[a].end.Before(inflight[b].end) })
+		now = inflight[0].end
+		kept := inflight[:0]
+		for _, r := range inflight {
+			if r.end.After(now) {
+				kept = append(kept, r)
+				continue
+			}
+			free += req.Zones[r.zone].Valves
+			active[r.zone] = false
+		}
+		inflight = kept
+	}
+}
+
+// earliestStart 返回空出 need 个阀门的最早时刻（按运行段结束时间依次释放）。
+func earliestStart(inflight []running, zones []Zone, free, need int) time.Time {
+	ends := append([]running(nil), inflight...)
+	sort.Slice(ends, func(a, b int) bool { return ends[a].end.Before(ends[b].end) })
+	for _, r := range ends {
+		free += zones[r.zone].Valves
+		if free >= need {
+			return r.end
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,163 @@
+package rotation
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var windowStart = time.Date(2025, 6, 1, 5, 0, 0, 0, time.UTC)
+
+// checkCapacity 断言任一时刻打开的阀门数不超过容量，且全部运行段落在时间窗内。
+func checkCapacity(t *testing.T, req Request, p Plan) {
+	t.Helper()
+	for _, s := range p.Slots {
+		if s.Start.Before(req.Start) || s.End.After(req.End) {
+			t.Fatalf("运行段 %+v 超出时间窗", s)
+		}
+		open := 0
+		for _, o := range p.Slots {
+			if !o.Start.After(s.Start) && o.End.After(s.Start) {
+				open += req.Zones[o.Zone].Valves
+			}
+		}
+		if open > req.Capacity {
+			t.Fatalf("%s 打开阀门 %d 个，超过容量 %d", s.Start.Format(time.Kitchen), open, req.Capacity)
+		}
+	}
+}
+
+func TestBuildRespectsCapacityAndSplitsRuns(t *testing.T) {
+	req := Request{
+		Zones: []Zone{
+			{Key: "A", Valves: 2, Minutes: 60},
+			{Key: "B", Valves: 1, Minutes: 30},
+			{Key: "C", Valves: 1, Minutes: 30},
+		},
+		Capacity:  2,
+		Start:     windowStart,
+		End:       windowStart.Add(4 * time.Hour),
+		MaxRunMin: 30,
+	}
+	p, err := Build(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Scale != 1 {
+		t.Fatalf("时间窗足够时 scale = %v，期望 1", p.Scale)
+	}
+	checkCapacity(t, req, p)
+
+	total := make([]float64, len(req.Zones))
+	runs := make([]int, len(req.Zones))
+	for _, s := range p.Slots {
+		if s.Minutes > req.MaxRunMin {
+			t.Fatalf("运行段 %+v 超过单段上限", s)
+		}
+		total[s.Zone] += s.Minutes
+		runs[s.Zone]++
+	}
+	for i, z := range req.Zones {
+		if total[i] != z.Minutes {
+			t.Fatalf("%s 累计 %v 分钟，期望 %v", z.Key, total[i], z.Minutes)
+		}
+	}
+	if runs[0] != 2 {
+		t.Fatalf("A 区 60 分钟应拆成 2 段，实际 %d 段", runs[0])
+	}
+}
+
+func TestBuildCompressesFairly(t *testing.T) {
+	req := Request{
+		Zones:    []Zone{{Key: "A", Valves: 1, Minutes: 60}, {Key: "B", Valves: 1, Minutes: 120}},
+		Capacity: 1,
+		Start:    windowStart,
+		End:      windowStart.Add(90 * time.Minute),
+	}
+	p, err := Build(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Scale <= 0 || p.Scale >= 1 {
+		t.Fatalf("scale = %v，期望在 (0,1) 内", p.Scale)
+	}
+	checkCapacity(t, req, p)
+	// 按同一比例缩减：B 的分配约为 A 的两倍（取整误差 1 分钟内）
+	if a, b := p.Allocated[0], p.Allocated[1]; b < 2*a-1 || b > 2*a+1 {
+		t.Fatalf("分配 A=%v B=%v，期望按比例缩减", a, b)
+	}
+
+	req.End = windowStart.Add(time.Minute)
+	if _, err := Build(req); !errors.Is(err, ErrWindowTooShort) {
+		t.Fatalf("时间窗过短 err = %v，期望 ErrWindowTooShort", err)
+	}
+}
+
+func TestBuildRejectsInvalidRequest(t *testing.T) {
+	base := Request{
+		Zones:    []Zone{{Key: "A", Valves: 1, Minutes: 30}},
+		Capacity: 1,
+		Start:    windowStart,
+		End:      windowStart.Add(time.Hour),
+	}
+	cases := map[string]func(r *Request){
+		"容量为 0":   func(r *Request) { r.Capacity = 0 },
+		"窗口倒置":    func(r *Request) { r.End = r.Start.Add(-time.Minute) },
+		"阀门数超过容量": func(r *Request) { r.Zones = []Zone{{Key: "A", Valves: 2, Minutes: 30}} },
+		"时长为 0":   func(r *Request) { r.Zones = []Zone{{Key: "A", Valves: 1}} },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := base
+			mutate(&req)
+			if _, err := Build(req); !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("err = %v，期望 ErrInvalidRequest", err)
+			}
+		})
+	}
+}
+
+// 非整分钟的请求与单段上限：每段都是至少 1 分钟的整分钟，零头不会单独成段。
+func TestBuildWholeMinuteRuns(t *testing.T) {
+	req := Request{
+		Zones:     []Zone{{Key: "A", Valves: 1, Minutes: 60.5}, {Key: "B", Valves: 1, Minutes: 20.2}},
+		Capacity:  1,
+		Start:     windowStart,
+		End:       windowStart.Add(4 * time.Hour),
+		MaxRunMin: 30,
+	}
+	p, err := Build(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCapacity(t, req, p)
+	total := make([]float64, len(req.Zones))
+	for _, s := range p.Slots {
+		if s.Minutes < 1 || s.Minutes != math.Trunc(s.Minutes) {
+			t.Fatalf("运行段 %+v 不是至少 1 分钟的整分钟", s)
+		}
+		total[s.Zone] += s.Minutes
+	}
+	if total[0] != 61 || total[1] != 20 || p.Allocated[0] != 61 || p.Allocated[1] != 20 {
+		t.Fatalf("累计 %v，分配 %v，期望取整为 [61 20]", total, p.Allocated)
+	}
+
+	// 单段上限非整数且剩余不足 1 分钟：零头并入当前段
+	req = Request{
+		Zones:     []Zone{{Key: "A", Valves: 1, Minutes: 31}},
+		Capacity:  1,
+		Start:     windowStart,
+		End:       windowStart.Add(time.Hour),
+		MaxRunMin: 30.5,
+	}
+	if p, err = Build(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Slots) != 2 || p.Slots[0].Minutes != 30 || p.Slots[1].Minutes != 1 {
+		t.Fatalf("运行段 = %+v，期望 30 + 1", p.Slots)
+	}
+	if got := runLength(30.5, 30); got != 30.5 {
+		t.Fatalf("剩余 30.5 分钟、上限 30 的本段时长 = %v，期望并入零头", got)
+	}
+}
@@ -0,0 +1,214 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schedule 包：把任务的 schedule_at 调度表达式解析为绝对时间。
+// 支持的表达式：
+//   - RFC3339 绝对时间：2026-05-01T06:30:00+08:00
+//   - 相对时间：in 45m / in 1h30m（相对当前时间）
+//   - 农场本地时间：06:30、06:30:00（下一次出现）、2026-05-01 06:30（按农场时区解释）
+//   - 日出日落：sunrise、sunset、sunrise+30m、sunset-1h（下一次出现，按目标分区坐标计算）
+
+// Coord 表示经纬度坐标（度）。
+type Coord struct {
+	Lat float64 `json:"lat" yaml:"lat"`
+	Lng float64 `json:"lng" yaml:"lng"`
+}
+
+// Site 描述农场时区与坐标；坐标均为手工配置，设备注册表中分区的 lat/lng 优先（见 SetCoordSource）。
+type Site struct {
+	Timezone   string           `json:"timezone" yaml:"timezone"`
+	Lat        float64          `json:"lat" yaml:"lat"`
+	Lng        float64          `json:"lng" yaml:"lng"`
+	Partitions map[string]Coord `json:"partitions" yaml:"partitions"`
+
+	location *time.Location
+}
+
+// site 是运行时使用的农场配置；未加载时使用本地时区且无坐标（日出日落表达式不可用）。
+var site = &Site{Timezone: "Local", location: time.Local}
+
+// coordSource 是分区坐标的另一来源（设备注册表中手工记录的坐标），优先于 site 配置中的坐标。
+var coordSource func(target string) (Coord, bool)
+
+// SetCoordSource 设置分区坐标的动态来源；返回 false 时回退到 site 配置。
+func SetCoordSource(fn func(target string) (Coord, bool)) {
+	coordSource = fn
+}
+
+// LoadFromFile 从 YAML/JSON 加载农场时区与坐标配置，成功则替换运行时配置。
+func LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read site config: %w", err)
+	}
+
+	var cfg Site
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("unmarshal yaml site: %w", err)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("unmarshal json site: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported site file type: %s", path)
+	}
+
+	if cfg.Timezone == "" {
+		cfg.Timezone = "Local"
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("load timezone %q: %w", cfg.Timezone, err)
+	}
+	cfg.location = loc
+	site = &cfg
+	return nil
+}
+
+// Location 返回农场时区。
+func Location() *time.Location {
+	return site.location
+}
+
+// ErrNoCoordinates 目标分区与农场均未配置坐标时返回，日出日落表达式无法计算。
+var ErrNoCoordinates = errors.New("no coordinates configured for target")
+
+var (
+	sunExpr      = regexp.MustCompile(`^(sunrise|sunset)\s*(?:([+-])\s*(\S+))?$`)
+	inExpr       = regexp.MustCompile(`^in\s+(\S+)$`)
+	clockLayouts = []string{"15:04", "15:04:05"}
+	localLayouts = []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02T15:04:05"}
+)
+
+// IsAbsolute 判断表达式是否已是 RFC3339 绝对时间。
+func IsAbsolute(expr string) bool {
+	_, err := time.Parse(time.RFC3339, strings.TrimSpace(expr))
+	return err == nil
+}
+
+// Resolve 将调度表达式解析为绝对时间；target（分区名或作用域键）用于查找日出日落计算所需的分区坐标。
+func Resolve(expr, target string, now time.Time) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, expr); err == nil {
+		return t, nil
+	}
+
+	loc := site.location
+	local := now.In(loc)
+	lower := strings.ToLower(expr)
+
+	if lower == "now" {
+		return now, nil
+	}
+
+	if m := inExpr.FindStringSubmatch(lower); m != nil {
+		d, err := time.ParseDuration(m[1])
+		if err != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("invalid relative schedule %q", expr)
+		}
+		return now.Add(d), nil
+	}
+
+	if m := sunExpr.FindStringSubmatch(lower); m != nil {
+		var offset time.Duration
+		if m[2] != "" {
+			d, err := time.ParseDuration(m[3])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid sun offset %q: %w", expr, err)
+			}
+			offset = d
+			if m[2] == "-" {
+				offset = -d
+			}
+		}
+		return nextSunEvent(m[1], offset, target, local)
+	}
+
+	for _, layout := range clockLayouts {
+		if c, err := time.ParseInLocation(layout, expr, loc); err == nil {
+			t := time.Date(local.Year(), local.Month(), local.Day(), c.Hour(), c.Minute(), c.Second(), 0, loc)
+			if !t.After(local) {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+	}
+
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, expr, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported schedule expression %q", expr)
+}
+
+// nextSunEvent 计算下一次 (日出|日落)+offset 的时间；今天已过则顺延到次日，极昼/极夜最多向后查找一年。
+func nextSunEvent(event string, offset time.Duration, target string, local time.Time) (time.Time, error) {
+	c, ok := coordsFor(target)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrNoCoordinates, target)
+	}
+	day := local
+	for i := 0; i < 366; i++ {
+		rise, set, ok := SunTimes(day, c.Lat, c.Lng)
+		if ok {
+			t := rise
+			if event == "sunset" {
+				t = set
+			}
+			t = t.Add(offset)
+			if t.After(local) {
+				return t, nil
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, fmt.Errorf("no %s within a year at %s", event, target)
+}
+
+// HasCoords 判断目标（分区名或作用域键）能否取得坐标，用于启动时检查日出日落调度的坐标覆盖。
+func HasCoords(target string) bool {
+	_, ok := coordsFor(target)
+	return ok
+}
+
+// coordsFor 依次使用动态来源的分区坐标、site 配置的分区坐标，最后回退到农场默认坐标。
+// target 可为作用域键 "domain/channel/partition"：先按完整键查找，再按分区名查找。
+func coordsFor(target string) (Coord, bool) {
+	if coordSource != nil {
+		if c, ok := coordSource(target); ok {
+			return c, true
+		}
+	}
+	if c, ok := site.Partitions[target]; ok {
+		return c, true
+	}
+	if i := strings.LastIndex(target, "/"); i >= 0 {
+		if c, ok := site.Partitions[target[i+1:]]; ok {
+			return c, true
+		}
+	}
+	if site.Lat != 0 || site.Lng != 0 {
+		return Coord{Lat: site.Lat, Lng: site.Lng}, true
+	}
+	return Coord{}, false
+}
@@ -0,0 +1,58 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+// sun.go：纯 Go 计算日出/日落时间（NOAA 简化算法，即 Wikipedia "Sunrise equation"）。
+// 精度约 1~2 分钟，足够用于灌溉类调度；不依赖任何外部服务。
+
+const (
+	julianUnixEpoch = 2440587.5 // 1970-01-01T00:00:00Z 对应的儒略日
+	julian2000      = 2451545.0 // J2000.0
+	degToRad        = math.Pi / 180
+)
+
+// SunTimes 计算 day 所在日期（按 day 的时区取年月日）在 lat/lng 处的日出与日落时间。
+// 极昼/极夜时 ok=false。返回时间与 day 处于同一时区。
+func SunTimes(day time.Time, lat, lng float64) (sunrise, sunset time.Time, ok bool) {
+	loc := day.Location()
+	y, m, d := day.Date()
+	// 取当天 UTC 正午对应的儒略日，计算自 J2000 起的整日数
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	jd := float64(noon.Unix())/86400 + julianUnixEpoch
+	n := math.Round(jd - julian2000)
+
+	// 平太阳时、平近点角、中心差、黄经
+	jStar := n - lng/360
+	mAnomaly := math.Mod(357.5291+0.98560028*jStar, 360)
+	mRad := mAnomaly * degToRad
+	center := 1.9148*math.Sin(mRad) + 0.0200*math.Sin(2*mRad) + 0.0003*math.Sin(3*mRad)
+	lambda := math.Mod(mAnomaly+center+180+102.9372, 360)
+	lRad := lambda * degToRad
+
+	// 太阳过中天时刻与赤纬
+	jTransit := julian2000 + jStar + 0.0053*math.Sin(mRad) - 0.0069*math.Sin(2*lRad)
+	sinDecl := math.Sin(lRad) * math.Sin(23.4397*degToRad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+
+	// 时角：-0.833° 包含大气折射与太阳视半径修正
+	phi := lat * degToRad
+	cosOmega := (math.Sin(-0.833*degToRad) - math.Sin(phi)*sinDecl) / (math.Cos(phi) * cosDecl)
+	if cosOmega < -1 || cosOmega > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	omega := math.Acos(cosOmega) / degToRad
+
+	sunrise = julianToTime(jTransit - omega/360).In(loc)
+	sunset = julianToTime(jTransit + omega/360).In(loc)
+	return sunrise, sunset, true
+}
+
+// julianToTime 将儒略日转换为 time.Time（UTC）。
+func julianToTime(jd float64) time.Time {
+	sec := (jd - julianUnixEpoch) * 86400
+	whole := math.Floor(sec)
+	return time.Unix(int64(whole), int64((sec-whole)*1e9)).UTC()
+}
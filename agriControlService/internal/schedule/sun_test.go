@@ -0,0 +1,114 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// 参考值取自 NOAA Solar Calculator（当地民用时间，精确到分钟）；算法精度约 1~2 分钟。
+const sunTolerance = 3 * time.Minute
+
+func mustLoc(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("时区数据不可用: %v", err)
+	}
+	return loc
+}
+
+func TestSunTimesKnownLocations(t *testing.T) {
+	cases := []struct {
+		name            string
+		tz              string
+		lat, lng        float64
+		date            string
+		sunrise, sunset string
+	}{
+		{"北京夏至", "Asia/Shanghai", 39.9042, 116.4074, "2024-06-21", "04:46", "19:46"},
+		{"北京冬至", "Asia/Shanghai", 39.9042, 116.4074, "2024-12-21", "07:33", "16:53"},
+		{"纽约夏至", "America/New_York", 40.7128, -74.0060, "2024-06-20", "05:25", "20:31"},
+		{"纽约冬至", "America/New_York", 40.7128, -74.0060, "2024-12-21", "07:17", "16:32"},
+		{"悉尼夏至", "Australia/Sydney", -33.8688, 151.2093, "2024-12-21", "05:41", "20:05"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			loc := mustLoc(t, tc.tz)
+			day, _ := time.ParseInLocation("2006-01-02", tc.date, loc)
+			rise, set, ok := SunTimes(day, tc.lat, tc.lng)
+			if !ok {
+				t.Fatal("期望有日出日落")
+			}
+			for _, c := range []struct {
+				label string
+				got   time.Time
+				want  string
+			}{{"日出", rise, tc.sunrise}, {"日落", set, tc.sunset}} {
+				want, _ := time.ParseInLocation("2006-01-02 15:04", tc.date+" "+c.want, loc)
+				if d := c.got.Sub(want); d > sunTolerance || d < -sunTolerance {
+					t.Errorf("%s=%s，期望 %s（误差 %s）", c.label, c.got.Format("15:04:05"), c.want, d)
+				}
+				if c.got.Location() != loc {
+					t.Errorf("%s 时区=%s，期望 %s", c.label, c.got.Location(), loc)
+				}
+			}
+		})
+	}
+}
+
+func TestSunTimesPolar(t *testing.T) {
+	// 特罗姆瑟：夏至极昼、冬至极夜
+	for _, date := range []string{"2024-06-21", "2024-12-21"} {
+		day, _ := time.Parse("2006-01-02", date)
+		if _, _, ok := SunTimes(day, 69.6492, 18.9553); ok {
+			t.Errorf("%s 期望极昼/极夜 ok=false", date)
+		}
+	}
+}
+
+func TestResolveSunExpressions(t *testing.T) {
+	loc := mustLoc(t, "Asia/Shanghai")
+	prevSite, prevSource := site, coordSource
+	t.Cleanup(func() { site, coordSource = prevSite, prevSource })
+	site = &Site{Timezone: "Asia/Shanghai", location: loc, Partitions: map[string]Coord{"field-A": {Lat: 39.9042, Lng: 116.4074}}}
+	coordSource = nil
+
+	at := func(s string) time.Time {
+		v, _ := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		return v
+	}
+	near := func(t *testing.T, got time.Time, want string) {
+		t.Helper()
+		if d := got.Sub(at(want)); d > sunTolerance || d < -sunTolerance {
+			t.Fatalf("得到 %s，期望约 %s", got.In(loc).Format("2006-01-02 15:04"), want)
+		}
+	}
+
+	// 当天日出前：取当天；作用域键按分区名回退查找
+	got, err := Resolve("sunrise+30m", "dom/ch/field-A", at("2024-06-21 03:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	near(t, got, "2024-06-21 05:16")
+	// 当天日落已过：顺延到次日
+	if got, err = Resolve("sunset-1h", "field-A", at("2024-06-21 19:00")); err != nil {
+		t.Fatal(err)
+	}
+	near(t, got, "2024-06-22 18:46")
+
+	// 动态坐标来源优先于 site 配置
+	coordSource = func(string) (Coord, bool) { return Coord{Lat: 40.7128, Lng: -74.0060}, true }
+	if got, err = Resolve("sunrise", "field-A", at("2024-06-20 00:00")); err != nil {
+		t.Fatal(err)
+	}
+	near(t, got, "2024-06-20 17:25") // 纽约 05:25 EDT = 北京时间 17:25
+
+	coordSource = nil
+	if _, err = Resolve("sunrise", "field-X", at("2024-06-21 03:00")); !errors.Is(err, ErrNoCoordinates) {
+		t.Fatalf("无坐标时 err=%v，期望 ErrNoCoordinates", err)
+	}
+	if HasCoords("dom/ch/field-X") || !HasCoords("dom/ch/field-A") {
+		t.Fatal("HasCoords 与坐标配置不一致")
+	}
+}
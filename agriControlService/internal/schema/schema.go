@@ -0,0 +1,286 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"agri-control-service/internal/model"
+)
+
+// schema 包：任务参数的 JSON-Schema 校验（子集实现）。
+// 支持关键字：type、properties、required、additionalProperties、minimum、maximum、
+// enum、minLength、maxLength、pattern、items、default、description。
+// 不支持 $ref / anyOf / oneOf 等组合关键字，够用即可，保持零依赖。
+
+// Schema 描述一个 JSON 值的约束；YAML 与 JSON 使用相同的字段名。
+type Schema struct {
+	Type                 string             `json:"type,omitempty" yaml:"type,omitempty"`
+	Description          string             `json:"description,omitempty" yaml:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty" yaml:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty" yaml:"additionalProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty" yaml:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty" yaml:"items,omitempty"`
+	Default              interface{}        `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// ValidationError 汇总字段级校验错误。
+type ValidationError struct {
+	Fields []model.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return "params invalid: " + strings.Join(parts, "; ")
+}
+
+// ApplyDefaults 为缺失的可选顶层属性填充 default 值，返回被填充的字段名；required 字段从不填充。
+func (s *Schema) ApplyDefaults(params map[string]interface{}) []string {
+	if s == nil || params == nil {
+		return nil
+	}
+	var filled []string
+	for _, name := range sortedKeys(s.Properties) {
+		prop := s.Properties[name]
+		if s.isRequired(name) {
+			continue
+		}
+		if _, ok := params[name]; !ok && prop != nil && prop.Default != nil {
+			def := prop.Default
+			if n, isInt := def.(int); isInt {
+				def = float64(n) // 与 JSON 解码结果保持一致
+			}
+			params[name] = def
+			filled = append(filled, name)
+		}
+	}
+	return filled
+}
+
+// Check 检查 schema 自身是否自洽：required 字段不能带 default（否则 required 形同虚设），
+// minimum 不能大于 maximum，default 须满足自身约束。返回的错误以属性路径开头。
+func (s *Schema) Check() error {
+	var problems []string
+	s.check("", &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("schema inconsistent: %s", strings.Join(problems, "; "))
+}
+
+func (s *Schema) check(path string, problems *[]string) {
+	if s == nil {
+		return
+	}
+	field := path
+	if field == "" {
+		field = "$"
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		*problems = append(*problems, fmt.Sprintf("%s: minimum %v > maximum %v", field, *s.Minimum, *s.Maximum))
+	}
+	if s.Default != nil {
+		def := s.Default
+		if n, isInt := def.(int); isInt {
+			def = float64(n)
+		}
+		if err := s.Validate(def); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: default %v invalid: %v", field, s.Default, err))
+		}
+	}
+	for _, name := range sortedKeys(s.Properties) {
+		prop := s.Properties[name]
+		if prop != nil && prop.Default != nil && s.isRequired(name) {
+			*problems = append(*problems, fmt.Sprintf("%s: required field must not have a default", join(path, name)))
+		}
+		prop.check(join(path, name), problems)
+	}
+	s.Items.check(path+"[]", problems)
+}
+
+func (s *Schema) isRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 校验 value 是否满足 schema，返回 *ValidationError 或 nil。
+func (s *Schema) Validate(value interface{}) error {
+	if s == nil {
+		return nil
+	}
+	var errs []model.FieldError
+	s.validate("", value, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: errs}
+}
+
+func (s *Schema) validate(path string, value interface{}, errs *[]model.FieldError) {
+	add := func(format string, args ...interface{}) {
+		field := path
+		if field == "" {
+			field = "$"
+		}
+		*errs = append(*errs, model.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !matchesType(s.Type, value) {
+		add("expected %s, got %s", s.Type, typeName(value))
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		add("must be one of %v", s.Enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, model.FieldError{Field: join(path, name), Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, model.FieldError{Field: join(path, name), Message: "unknown field"})
+				}
+				continue
+			}
+			if prop != nil {
+				prop.validate(join(path, name), v[name], errs)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			add("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			add("must be <= %v", *s.Maximum)
+		}
+	case int:
+		s.validate(path, float64(v), errs)
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			add("length must be >= %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			add("length must be <= %d", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				add("invalid pattern in schema: %v", err)
+			} else if !re.MatchString(v) {
+				add("must match %s", s.Pattern)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	}
+}
+
+// matchesType 判断值是否符合 JSON-Schema 类型（JSON 解码后的数字统一为 float64）。
+func matchesType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		switch value.(type) {
+		case float64, int:
+			return true
+		}
+		return false
+	case "integer":
+		switch x := value.(type) {
+		case int:
+			return true
+		case float64:
+			return x == math.Trunc(x)
+		}
+		return false
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func typeName(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case int:
+		return "integer"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
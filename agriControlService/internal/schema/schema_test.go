@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"agri-control-service/internal/model"
+)
+
+const irrigationSchema = `{
+  "type": "object",
+  "required": ["duration_min"],
+  "additionalProperties": false,
+  "properties": {
+    "duration_min": {"type": "number", "minimum": 1, "maximum": 120},
+    "mode": {"type": "string", "enum": ["drip", "spray"], "default": "drip"},
+    "zones": {"type": "array", "items": {"type": "string", "pattern": "^[A-Z]$"}}
+  }
+}`
+
+func mustSchema(t *testing.T, raw string) *Schema {
+	t.Helper()
+	var s Schema
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestValidateReportsFieldErrors(t *testing.T) {
+	s := mustSchema(t, irrigationSchema)
+	cases := []struct {
+		name   string
+		params string
+		want   []model.FieldError
+	}{
+		{"合法", `{"duration_min": 30, "zones": ["A", "B"]}`, nil},
+		{"缺少必填", `{"mode": "drip"}`, []model.FieldError{{Field: "duration_min", Message: "is required"}}},
+		{"超出上限", `{"duration_min": 500}`, []model.FieldError{{Field: "duration_min", Message: "must be <= 120"}}},
+		{"类型错误", `{"duration_min": "30"}`, []model.FieldError{{Field: "duration_min", Message: "expected number, got string"}}},
+		{"未知字段", `{"duration_min": 30, "flow": 2}`, []model.FieldError{{Field: "flow", Message: "unknown field"}}},
+		{"枚举与数组元素", `{"duration_min": 30, "mode": "flood", "zones": ["A", "b"]}`, []model.FieldError{
+			{Field: "mode", Message: "must be one of [drip spray]"},
+			{Field: "zones[1]", Message: "must match ^[A-Z]$"},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var params map[string]interface{}
+			if err := json.Unmarshal([]byte(tc.params), &params); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Validate(params)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("err = %v，期望通过", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) || !reflect.DeepEqual(ve.Fields, tc.want) {
+				t.Fatalf("err = %v，期望 %+v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsSkipsRequired(t *testing.T) {
+	s := mustSchema(t, irrigationSchema)
+	s.Properties["duration_min"].Default = 30.0 // Check 会拒绝这样的 schema，这里只验证不会填充
+	params := map[string]interface{}{}
+	if filled := s.ApplyDefaults(params); !reflect.DeepEqual(filled, []string{"mode"}) {
+		t.Fatalf("填充字段 = %v，期望只有 mode", filled)
+	}
+	if _, ok := params["duration_min"]; ok {
+		t.Fatal("required 字段不应被填充默认值")
+	}
+	if err := s.Check(); err == nil {
+		t.Fatal("required 字段带 default 时 Check 应报错")
+	}
+}
@@ -3,55 +3,130 @@ package service
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"agri-control-service/internal/clock"
 	"agri-control-service/internal/executor"
 	"agri-control-service/internal/logstore"
 	"agri-control-service/internal/model"
+	"agri-control-service/internal/override"
 	"agri-control-service/internal/planner"
 	"agri-control-service/internal/policy"
+	"agri-control-service/internal/registry"
+	"agri-control-service/internal/resolver"
+	"agri-control-service/internal/schedule"
+	"agri-control-service/internal/schema"
+	"agri-control-service/internal/state"
 )
 
 // ControlService 负责控制主流程：入队、调度、策略校验、规划动作、串行执行。
 // - 队列削峰：HandleTask 将任务放入内存队列，worker 异步处理
-// - 调度：支持 schedule_at 定时启动，以及 wait 动作的非阻塞延时
-// - 规划：调用 planner 依据 TaskType 生成动作序列
+// - 调度：支持 schedule_at 定时启动（RFC3339/日出日落/本地时间表达式），以及 wait 动作的非阻塞延时
+// - 规划：调用 planner 依据 TaskType 生成动作序列，并经 resolver 把分区展开为执行器
 // - 策略：调用 policy 在执行前做参数校验/修正
-// - 执行：调用 executor 下发设备命令，附带日志
+// - 执行：调用 executor 下发设备命令，附带日志；各设备结果汇总到任务状态
 type ControlService struct {
 	executor *executor.Executor // 执行设备命令的执行器
 	queue    chan *model.Task   // 任务队列，负责削峰和异步处理
+
+	mu        sync.RWMutex
+	outcomes  map[string]*model.TaskOutcome  // task_id -> 执行状态（内存）
+	finished  []finishedOutcome              // 已到终态的任务（按完成先后），用于淘汰 outcomes
+	rotations map[string]*model.RotationPlan // group_id -> 已提交的轮灌计划
+
+	lockMu sync.Mutex
+	locks  map[string]*targetLock   // 作用域目标键 -> 执行锁，同一目标的动作链串行执行
+	held   map[string][]*model.Task // 作用域目标键 -> 被人工接管挂起的任务
+
+	overrides *override.Manager // 分区人工接管
+
+	workers int          // worker 数量；高可用模式下当选 leader 后才启动
+	leader  atomic.Bool  // 是否在执行任务（单实例模式始终为 true）
+	state   *state.Store // 共享状态存储，nil 表示单实例模式（状态仅在内存）
+
+	leaseHolder string // 当选时的租约持有者与 epoch，Promote 时写入，用于 fencing
+	leaseEpoch  int64
 }
 
 const defaultWorkers = 4
 
-// NewControlService 构造控制服务，初始化执行器与队列，并启动指定数量的 worker。
+// ErrInvalidTask 表示任务本身不合法（如调度表达式无法解析），API 层据此返回 400。
+var ErrInvalidTask = errors.New("invalid task")
+
+// NewControlService 构造控制服务，初始化执行器与队列，并启动指定数量的 worker（单实例模式）。
 func NewControlService(store *logstore.LogStore, workers int) *ControlService {
+	s := newControlService(store, workers)
+	s.leader.Store(true)
+	s.startWorkers(s.workers)
+	return s
+}
+
+// newControlService 初始化执行器、队列与各内存表，不启动 worker。
+func newControlService(store *logstore.LogStore, workers int) *ControlService {
 	if workers <= 0 {
 		workers = defaultWorkers
 	}
 	s := &ControlService{
-		executor: executor.NewExecutor(store),
-		queue:    make(chan *model.Task, workers*4), // 简单按 worker 数量放大队列容量
+		executor:  executor.NewExecutor(store),
+		queue:     make(chan *model.Task, workers*4), // 简单按 worker 数量放大队列容量
+		outcomes:  make(map[string]*model.TaskOutcome),
+		rotations: make(map[string]*model.RotationPlan),
+		locks:     make(map[string]*targetLock),
+		held:      make(map[string][]*model.Task),
+		workers:   workers,
 	}
-	s.startWorkers(workers)
+	s.overrides = override.NewManager(s.onOverrideReleased)
 	return s
 }
 
-// HandleTask 校验/补全标识并尝试入队，队列满时返回错误。
+// SetDriver 设置设备驱动（默认仅打印）；应在接收任务前调用。
+func (s *ControlService) SetDriver(d executor.Driver) {
+	s.executor.SetDriver(d)
+}
+
+// HandleTask 校验/补全标识、解析调度表达式并尝试入队，队列满时返回错误。
 func (s *ControlService) HandleTask(task *model.Task) error {
-	ensureIdentifiers(task)
+	if err := s.admit(task); err != nil {
+		return err
+	}
 
+	// 先记录 queued 再入队，避免覆盖 worker 已写入的 scheduled/running 状态
+	s.setStatus(task, model.TaskQueued, "")
 	select {
 	case s.queue <- task:
 		return nil
 	default:
+		s.setStatus(task, model.TaskFailed, "task queue is full")
 		return fmt.Errorf("task queue is full")
 	}
 }
 
+// admit 是入队前的公共校验：确认本实例是 leader，补全标识、规范化目标、校验参数、解析调度表达式并检查人工接管。
+func (s *ControlService) admit(task *model.Task) error {
+	if err := s.requireLeader(); err != nil {
+		return err
+	}
+	ensureIdentifiers(task)
+	if err := normalizeTarget(task); err != nil {
+		return err
+	}
+	if err := validateParams(task); err != nil {
+		return err
+	}
+	if err := applyRecipe(task); err != nil {
+		return err
+	}
+	if err := resolveSchedule(task, clock.Now()); err != nil {
+		return err
+	}
+	return s.checkOverrideOnSubmit(task)
+}
+
 // startWorkers 启动 n 个后台 worker，从队列中取任务执行。
 func (s *ControlService) startWorkers(n int) {
 	for i := 0; i < n; i++ {
@@ -67,76 +142,274 @@ func (s *ControlService) worker() {
 }
 
 // processTask 处理调度时间：若 schedule_at 在未来则设定定时器到点再执行。
+// schedule_at 已在入队时解析为 RFC3339，这里只需比较当前时间。
 func (s *ControlService) processTask(task *model.Task) {
 	if task.ScheduleAt != "" {
 		t, err := time.Parse(time.RFC3339, task.ScheduleAt)
 		if err != nil {
 			log.Printf("[trace=%s task=%s] invalid schedule_at: %v", task.TraceID, task.TaskID, err)
-		} else {
-			now := time.Now()
-			if t.After(now) {
-				d := time.Until(t)
-				time.AfterFunc(d, func() {
-					s.processPlannedTask(task)
-				})
-				return
-			}
+			s.setStatus(task, model.TaskFailed, fmt.Sprintf("invalid schedule_at %q: %v", task.ScheduleAt, err))
+			return
+		}
+		if d := clock.Until(t); d > 0 {
+			s.setStatus(task, model.TaskScheduled, "")
+			clock.AfterFunc(d, func() {
+				s.processPlannedTask(task)
+			})
+			return
 		}
 	}
 	s.processPlannedTask(task)
 }
 
-// processPlannedTask 在通过策略校验后生成动作并启动执行。
+// validateParams 按任务类型的参数 schema 填充默认值并做字段级校验；未配置 schema 的类型不校验。
+func validateParams(task *model.Task) error {
+	sch, ok := registry.TaskParamSchemas[task.TaskType]
+	if !ok {
+		return nil
+	}
+	if task.Params == nil {
+		task.Params = map[string]interface{}{}
+	}
+	if filled := sch.ApplyDefaults(task.Params); len(filled) > 0 {
+		log.Printf("[trace=%s task=%s] params defaulted: %v", task.TraceID, task.TaskID, filled)
+	}
+	if err := sch.Validate(task.Params); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTask, err)
+	}
+	return nil
+}
+
+// resolveSchedule 将 schedule_at 中的表达式解析为 RFC3339 绝对时间，原始表达式保存在 schedule_expr。
+func resolveSchedule(task *model.Task, now time.Time) error {
+	if task.ScheduleAt == "" || schedule.IsAbsolute(task.ScheduleAt) {
+		return nil
+	}
+	t, err := schedule.Resolve(task.ScheduleAt, task.Ref().Key(), now)
+	if err != nil {
+		return fmt.Errorf("%w: schedule_at: %v", ErrInvalidTask, err)
+	}
+	task.ScheduleExpr = task.ScheduleAt
+	task.ScheduleAt = t.Format(time.RFC3339)
+	log.Printf("[trace=%s task=%s] schedule %q resolved to %s", task.TraceID, task.TaskID, task.ScheduleExpr, task.ScheduleAt)
+	return nil
+}
+
+// processPlannedTask 在通过策略校验后生成动作、展开设备并启动执行。
 func (s *ControlService) processPlannedTask(task *model.Task) {
-	if err := policy.ValidateTask(*task); err != nil {
-		log.Printf("[trace=%s task=%s] policy reject: %v", task.TraceID, task.TaskID, err)
+	verdict, steps, err := planTask(task)
+	if err != nil {
+		log.Printf("[trace=%s task=%s] %v", task.TraceID, task.TaskID, err)
+		s.setStatus(task, model.TaskFailed, err.Error())
+		return
+	}
+	for _, adj := range verdict.Adjustments {
+		log.Printf("[trace=%s task=%s] policy adjust %s: %v -> %v (%s)", task.TraceID, task.TaskID, adj.Field, adj.From, adj.To, adj.Reason)
+	}
+
+	// 同一作用域目标已有动作链在执行时排队，避免两条链交错开关同一批设备
+	if !s.acquireTarget(task, steps, 0) {
+		log.Printf("[trace=%s task=%s] target %s busy, waiting", task.TraceID, task.TaskID, targetKey(task))
+		s.setStatus(task, model.TaskWaiting, "")
+		return
+	}
+	s.startSteps(task, steps)
+}
+
+// startSteps 检查人工接管后标记任务开始执行并运行动作链（调用方已持有目标锁）。
+func (s *ControlService) startSteps(task *model.Task, steps []model.Step) {
+	if s.applyOverride(task) {
+		s.releaseTarget(task)
 		return
 	}
+	s.setStatus(task, model.TaskRunning, "")
+	s.runSteps(task, steps, 0)
+}
+
+// planTask 依次执行策略评估、动作规划与设备展开；策略修正后的参数写回 task.Params。
+// 执行与计划预览共用该流程，保证预览结果与实际执行一致。
+func planTask(task *model.Task) (model.PolicyVerdict, []model.Step, error) {
+	params, verdict := policy.Evaluate(*task)
+	if !verdict.Allowed {
+		return verdict, nil, fmt.Errorf("policy reject: %s", verdict.Reason)
+	}
+	task.Params = params
 
 	actions, err := planner.PlanActions(*task)
 	if err != nil {
-		log.Printf("[trace=%s task=%s] plan failed: %v", task.TraceID, task.TaskID, err)
-		return
+		return verdict, nil, fmt.Errorf("plan failed: %w", err)
+	}
+
+	steps, err := buildSteps(task, actions)
+	if err != nil {
+		return verdict, nil, fmt.Errorf("resolve failed: %w", err)
+	}
+	return verdict, steps, nil
+}
+
+// PreviewPlan 返回任务的执行计划预览：动作链、各设备命令、策略结论与预计时间线，不入队也不下发任何命令。
+func (s *ControlService) PreviewPlan(task model.Task) model.PlanPreview {
+	// 深拷贝参数，避免预览过程修改调用方数据
+	params := make(map[string]interface{}, len(task.Params))
+	for k, v := range task.Params {
+		params[k] = v
+	}
+	task.Params = params
+	ensureIdentifiers(&task)
+
+	now := clock.Now()
+	preview := model.PlanPreview{StartAt: now.UTC().Format(time.RFC3339)}
+	if err := normalizeTarget(&task); err != nil {
+		preview.Task = task
+		preview.Error = err.Error()
+		return preview
+	}
+	if err := validateParams(&task); err != nil {
+		preview.Task = task
+		preview.Error = err.Error()
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			preview.Fields = verr.Fields
+		}
+		return preview
+	}
+	if err := applyRecipe(&task); err != nil {
+		preview.Task = task
+		preview.Error = err.Error()
+		return preview
+	}
+	if err := resolveSchedule(&task, now); err != nil {
+		preview.Task = task
+		preview.Error = err.Error()
+		return preview
+	}
+	start := now
+	if task.ScheduleAt != "" {
+		if t, err := time.Parse(time.RFC3339, task.ScheduleAt); err == nil && t.After(now) {
+			start = t
+		}
+	}
+	preview.StartAt = start.UTC().Format(time.RFC3339)
+
+	verdict, steps, err := planTask(&task)
+	preview.Task = task
+	preview.Verdict = verdict
+	preview.Steps = steps
+	if err != nil {
+		preview.Error = err.Error()
+		return preview
+	}
+	// 人工接管：reject 模式不可执行；hold 模式推迟到接管结束后开始
+	if o, ok := s.activeOverride(&task); ok {
+		preview.Override = &o
+		if o.Mode == override.ModeReject {
+			preview.Error = fmt.Sprintf("%s: %s until %s", ErrOverridden, o.Key, o.ExpiresAt)
+			return preview
+		}
+		if t, err := time.Parse(time.RFC3339, o.ExpiresAt); err == nil && t.After(start) {
+			start = t
+			preview.StartAt = start.UTC().Format(time.RFC3339)
+		}
+	}
+	preview.Executable = true
+
+	// 时间线：设备动作视为瞬时完成，wait 动作推进时间
+	cursor := start
+	for i, step := range steps {
+		entry := model.TimelineEntry{
+			Step:       i,
+			ActionType: step.Action.ActionType,
+			DeviceType: step.Action.DeviceType,
+			At:         cursor.UTC().Format(time.RFC3339),
+			OffsetSec:  cursor.Sub(start).Seconds(),
+			Devices:    len(step.Commands),
+		}
+		if step.Action.ActionType == "wait" {
+			d := executor.WaitDuration(step.Action.Params)
+			entry.DurationSec = d.Seconds()
+			cursor = cursor.Add(d)
+		}
+		preview.Timeline = append(preview.Timeline, entry)
 	}
+	preview.FinishAt = cursor.UTC().Format(time.RFC3339)
+	return preview
+}
 
-	s.runActions(task, actions, 0)
+// buildSteps 把每个非 wait 动作按 device_type 展开为分区内各执行器的设备命令。
+func buildSteps(task *model.Task, actions []model.Action) ([]model.Step, error) {
+	steps := make([]model.Step, 0, len(actions))
+	for _, action := range actions {
+		step := model.Step{Action: action}
+		if action.ActionType != "wait" {
+			devices, err := resolver.ResolveDevices(task.Ref(), action.DeviceType)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range devices {
+				step.Commands = append(step.Commands, model.DeviceCommand{
+					DeviceID:  id,
+					Command:   action.ActionType,
+					Params:    action.Params,
+					TaskID:    task.TaskID,
+					TraceID:   task.TraceID,
+					Principal: task.Principal,
+					DomainID:  task.DomainID,
+					ChannelID: task.ChannelID,
+					Target:    task.Target,
+				})
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
 }
 
-// runActions 顺序执行动作；wait 动作用定时器延迟，不阻塞 worker。
-func (s *ControlService) runActions(task *model.Task, actions []model.Action, idx int) {
-	if idx >= len(actions) {
+// runSteps 顺序执行动作；wait 动作用定时器延迟，不阻塞 worker。
+// 同一动作下发到分区内全部设备：全部失败则中止动作链，部分失败则继续并把任务标记为 partial。
+func (s *ControlService) runSteps(task *model.Task, steps []model.Step, idx int) {
+	if s.fenced(task) {
+		return
+	}
+	if idx >= len(steps) {
+		s.finish(task)
 		return
 	}
-	action := actions[idx]
+	step := steps[idx]
 
 	// wait 动作：用定时器延后执行后续动作，当前 worker 立即返回
-	if action.ActionType == "wait" {
-		d := executor.WaitDuration(action.Params)
+	if step.Action.ActionType == "wait" {
+		d := executor.WaitDuration(step.Action.Params)
 		if d > 0 {
-			time.AfterFunc(d, func() {
-				s.runActions(task, actions, idx+1)
+			s.persist(task, steps, idx+1, clock.Now().Add(d))
+			clock.AfterFunc(d, func() {
+				s.runSteps(task, steps, idx+1)
 			})
 			return
 		}
 		// 无有效等待时间则跳过
-		s.runActions(task, actions, idx+1)
+		s.runSteps(task, steps, idx+1)
 		return
 	}
 
-	// 非 wait 动作：立即执行设备命令
-	cmd := model.DeviceCommand{
-		DeviceID: task.Target,
-		Command:  action.ActionType,
-		Params:   action.Params,
-		TaskID:   task.TaskID,
-		TraceID:  task.TraceID,
+	// 非 wait 动作：立即向分区内每个设备下发命令（注肥结束命令附带实际投加量）
+	s.persist(task, steps, idx, time.Time{})
+	failed := 0
+	for _, cmd := range s.withDose(task, step) {
+		cmd.Epoch = s.leaseEpoch
+		err := s.executor.Execute(cmd)
+		if err != nil {
+			failed++
+			log.Printf("[trace=%s task=%s] execute failed device=%s: %v", task.TraceID, task.TaskID, cmd.DeviceID, err)
+		}
+		s.recordResult(task, cmd, err)
 	}
-	if err := s.executor.Execute(cmd); err != nil {
-		log.Printf("[trace=%s task=%s] execute failed: %v", task.TraceID, task.TaskID, err)
+	if failed > 0 && failed == len(step.Commands) {
+		s.setStatus(task, model.TaskFailed, fmt.Sprintf("%s failed on all %d devices", step.Action.ActionType, failed))
+		s.releaseTarget(task)
 		return
 	}
 
-	s.runActions(task, actions, idx+1)
+	s.runSteps(task, steps, idx+1)
 }
 
 // ensureIdentifiers 保证任务/链路标识存在，便于追踪与日志关联。
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+)
+
+// recordingDriver 记录下发的设备命令。
+type recordingDriver struct {
+	mu   sync.Mutex
+	cmds []model.DeviceCommand
+}
+
+func (d *recordingDriver) Send(cmd model.DeviceCommand) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cmds = append(d.cmds, cmd)
+	return nil
+}
+
+func (d *recordingDriver) sent() []model.DeviceCommand {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]model.DeviceCommand(nil), d.cmds...)
+}
+
+// newTestService 创建单实例控制服务并接入 recordingDriver。
+func newTestService(t *testing.T) (*ControlService, *recordingDriver) {
+	t.Helper()
+	s := NewControlService(nil, 1)
+	d := &recordingDriver{}
+	s.SetDriver(d)
+	return s, d
+}
+
+func TestOutcomesEvictedByAgeAndCount(t *testing.T) {
+	s := newControlService(nil, 1)
+	done := func(id string) {
+		s.setStatus(&model.Task{TaskID: id}, model.TaskSucceeded, "")
+	}
+
+	done("old")
+	s.setStatus(&model.Task{TaskID: "running"}, model.TaskRunning, "")
+	s.mu.Lock()
+	s.finished[0].at = clock.Now().Add(-outcomeRetention - time.Minute)
+	s.mu.Unlock()
+	done("new")
+	if _, ok := s.TaskStatus("old"); ok {
+		t.Fatal("超过保留时长的终态任务应被淘汰")
+	}
+	if _, ok := s.TaskStatus("running"); !ok {
+		t.Fatal("未到终态的任务不应被淘汰")
+	}
+
+	for i := 0; i < maxFinishedOutcomes; i++ {
+		done(fmt.Sprintf("t%d", i))
+	}
+	if _, ok := s.TaskStatus("new"); ok {
+		t.Fatal("超出数量上限时应淘汰最早完成的任务")
+	}
+	if _, ok := s.TaskStatus("t0"); !ok {
+		t.Fatal("数量上限内的任务不应被淘汰")
+	}
+	s.mu.RLock()
+	n := len(s.finished)
+	s.mu.RUnlock()
+	if n != maxFinishedOutcomes {
+		t.Fatalf("终态队列长度 = %d，期望 %d", n, maxFinishedOutcomes)
+	}
+}
+
+func TestPreviewPlanHasNoSideEffects(t *testing.T) {
+	s, d := newTestService(t)
+	params := map[string]interface{}{"duration_min": 10.0}
+	p := s.PreviewPlan(model.Task{TaskType: "irrigation", Target: "A区", Params: params})
+	if !p.Executable || p.Error != "" {
+		t.Fatalf("预览 = %+v", p)
+	}
+	if len(p.Timeline) != 3 || p.Timeline[1].ActionType != "wait" || p.Timeline[1].DurationSec != 600 {
+		t.Fatalf("时间线 = %+v", p.Timeline)
+	}
+	start, _ := time.Parse(time.RFC3339, p.StartAt)
+	finish, _ := time.Parse(time.RFC3339, p.FinishAt)
+	if finish.Sub(start) != 10*time.Minute {
+		t.Fatalf("预计 %s → %s，期望持续 10 分钟", p.StartAt, p.FinishAt)
+	}
+	if _, ok := s.TaskStatus(p.Task.TaskID); ok || len(d.sent()) != 0 {
+		t.Fatal("预览不应入队或下发命令")
+	}
+	if len(params) != 1 {
+		t.Fatalf("预览修改了调用方参数: %v", params)
+	}
+
+	p = s.PreviewPlan(model.Task{TaskType: "irrigation", Target: "A区", Params: map[string]interface{}{"duration_min": 0.0}})
+	if p.Executable || len(p.Fields) != 1 || p.Fields[0].Field != "duration_min" {
+		t.Fatalf("参数越界的预览 = %+v", p)
+	}
+}
+
+func TestInvalidScheduleAtFailsTask(t *testing.T) {
+	s, d := newTestService(t)
+	task := &model.Task{TaskID: "bad-schedule", TaskType: "irrigation", Target: "A区", ScheduleAt: "tomorrow morning"}
+	s.processTask(task)
+	o, ok := s.TaskStatus(task.TaskID)
+	if !ok || o.Status != model.TaskFailed || !strings.Contains(o.Error, "schedule_at") {
+		t.Fatalf("非法 schedule_at 的任务状态 = %+v", o)
+	}
+	if len(d.sent()) != 0 {
+		t.Fatal("非法 schedule_at 的任务不应下发命令")
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/executor"
+	"agri-control-service/internal/fertigation"
+	"agri-control-service/internal/model"
+)
+
+// fertigation.go：fertilization 任务与配方计算的衔接。
+// 任务带 recipe 时，入队前把配方换算为 dosing 参数（注肥泵运行时间与注肥阀时序），随 open_fertilizer 下发；
+// 注肥结束命令按实际经过的时间折算投加量，写入执行日志。
+
+// fertilizerDevice 注肥设备类型，与 scenarios.yaml 中 fertilization 动作的 device_type 一致。
+const fertilizerDevice = "fertilizer"
+
+// applyRecipe 为带 recipe 的 fertilization 任务计算 dosing 参数（覆盖调用方传入的 dosing）。
+func applyRecipe(task *model.Task) error {
+	if task.TaskType != "fertilization" {
+		return nil
+	}
+	recipe, _ := task.Params["recipe"].(string)
+	if recipe == "" {
+		return nil
+	}
+	stage, _ := task.Params["stage"].(string)
+	water, _ := task.Params["tank_volume_l"].(float64)
+	if stage == "" || water <= 0 {
+		return fmt.Errorf("%w: recipe requires stage and tank_volume_l", ErrInvalidTask)
+	}
+	d, err := fertigation.Calculate(recipe, stage, water, executor.WaitDuration(task.Params).Minutes())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	task.Params["dosing"] = d
+	return nil
+}
+
+// withDose 为注肥结束命令附带实际投加量：以同一批注肥设备第一次成功下发命令的时间作为注肥开始。
+// 开始命令本身（尚无结果）原样返回。
+func (s *ControlService) withDose(task *model.Task, step model.Step) []model.DeviceCommand {
+	d, ok := dosingParam(task.Params)
+	if !ok || step.Action.DeviceType != fertilizerDevice || len(step.Commands) == 0 {
+		return step.Commands
+	}
+	devices := map[string]bool{}
+	for _, c := range step.Commands {
+		devices[c.DeviceID] = true
+	}
+	var opened time.Time
+	s.mu.RLock()
+	if o, ok := s.outcomes[task.TaskID]; ok {
+		for _, r := range o.Results {
+			if r.Status != "ok" || !devices[r.DeviceID] {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339Nano, r.Ts); err == nil {
+				opened = t
+				break
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if opened.IsZero() {
+		return step.Commands
+	}
+
+	rec := fertigation.Actual(d, clock.Now().Sub(opened).Seconds())
+	cmds := make([]model.DeviceCommand, len(step.Commands))
+	for i, c := range step.Commands {
+		c.Dose = &rec
+		cmds[i] = c
+	}
+	return cmds
+}
+
+// dosingParam 取出配方计算的 dosing 参数；高可用接管后参数经过 JSON 往返，需重新解码。
+func dosingParam(params map[string]interface{}) (model.Dosing, bool) {
+	if recipe, _ := params["recipe"].(string); recipe == "" {
+		return model.Dosing{}, false
+	}
+	switch v := params["dosing"].(type) {
+	case model.Dosing:
+		return v, true
+	case map[string]interface{}:
+		var d model.Dosing
+		b, err := json.Marshal(v)
+		if err != nil || json.Unmarshal(b, &d) != nil {
+			return model.Dosing{}, false
+		}
+		return d, true
+	}
+	return model.Dosing{}, false
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"agri-control-service/internal/model"
+)
+
+// 高可用接管后任务参数经过 JSON 往返，dosing 变为 map，仍须能取回同样的方案。
+func TestDosingParamSurvivesJSONRoundTrip(t *testing.T) {
+	want := model.Dosing{
+		Recipe: "tomato", Stage: "fruiting", TargetEC: 2.8, TargetPH: 5.8,
+		WaterL: 5000, DurationSec: 2400, InjectStartSec: 180, InjectEndSec: 2100,
+		Pumps: []model.PumpDose{{Tank: "A", MlPerL: 9.6, VolumeL: 48, FlowLph: 120, RuntimeSec: 1440, Duty: 0.75}},
+	}
+	params := map[string]interface{}{"recipe": "tomato", "dosing": want}
+	if got, ok := dosingParam(params); !ok || !reflect.DeepEqual(got, want) {
+		t.Fatalf("内存中的 dosing = %+v, %v", got, ok)
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := dosingParam(decoded)
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Fatalf("JSON 往返后的 dosing = %+v, %v; 期望 %+v", got, ok, want)
+	}
+}
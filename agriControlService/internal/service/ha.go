@@ -0,0 +1,234 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/logstore"
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/state"
+)
+
+// ha.go：主备高可用。
+// 高可用模式下任务状态（含已展开的动作链与执行进度）写入共享状态存储；只有 leader 启动 worker、持有定时器，
+// standby 当选后从存储中恢复未完成的任务：未开始的重新规划（定时任务重新设定时器），
+// 执行到一半的从下一步继续（wait 中的按原定恢复时间继续）。
+// 设备命令至少下发一次：leader 在下发某一步时宕机，接管后会重发该步（开/关阀命令可重复执行）。
+// 租约基于各实例的真实时钟，时钟偏差或进程暂停可能让旧 leader 误以为仍持有租约；
+// 动作链每一步执行前用当选时的 epoch 校验租约（fencing），epoch 同时随设备命令下发，供设备层拒绝旧 leader 的命令。
+
+// ErrNotLeader 当前实例不是 leader，API 层据此返回 503。
+var ErrNotLeader = errors.New("not leader")
+
+// NewHAControlService 构造高可用模式的控制服务：当选 leader（Promote）前不启动 worker，也不接受任务。
+func NewHAControlService(store *logstore.LogStore, workers int, st *state.Store) *ControlService {
+	s := newControlService(store, workers)
+	s.state = st
+	return s
+}
+
+// IsLeader 表示当前实例是否在执行任务（单实例模式下始终为 true）。
+func (s *ControlService) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// requireLeader 非 leader 时返回 ErrNotLeader，附带当前租约持有者便于调用方重试。
+func (s *ControlService) requireLeader() error {
+	if s.IsLeader() {
+		return nil
+	}
+	if s.state != nil {
+		if l, err := s.state.CurrentLease(); err == nil && l.Holder != "" {
+			return fmt.Errorf("%w (leader: %s)", ErrNotLeader, l.Holder)
+		}
+	}
+	return ErrNotLeader
+}
+
+// Promote 当选 leader 时以新租约调用：恢复人工接管与轮灌计划、启动 worker 并接管存储中未完成的任务。
+func (s *ControlService) Promote(lease state.Lease) {
+	if s.leader.Swap(true) {
+		return
+	}
+	s.leaseHolder, s.leaseEpoch = lease.Holder, lease.Epoch
+	s.restoreOverrides()
+	s.restoreRotations()
+	s.startWorkers(s.workers)
+	s.resumeTasks()
+}
+
+// fenced 高可用模式下确认租约仍是本实例当选时的 epoch；已被接管或过期时放弃 leader 身份并返回 true，
+// 调用方应停止动作链且不再写入状态（新 leader 会从存储中接管该任务）。
+// 读取租约失败时继续执行：存储短暂不可用由 Elector 按 ttl 处理。
+func (s *ControlService) fenced(task *model.Task) bool {
+	if s.state == nil {
+		return false
+	}
+	err := s.state.CheckFence(s.leaseHolder, s.leaseEpoch)
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, state.ErrFenced):
+		if s.leader.Swap(false) {
+			log.Printf("ha: %v, stop executing", err)
+		}
+		log.Printf("[trace=%s task=%s] ha: chain stopped by fencing, left to the new leader", task.TraceID, task.TaskID)
+		return true
+	default:
+		log.Printf("[trace=%s task=%s] ha: check lease failed: %v", task.TraceID, task.TaskID, err)
+		return false
+	}
+}
+
+// restoreOverrides 恢复尚未到期的人工接管。
+func (s *ControlService) restoreOverrides() {
+	list, err := s.state.LoadOverrides()
+	if err != nil {
+		log.Printf("ha: load overrides failed: %v", err)
+	}
+	for _, o := range list {
+		expires, err := time.Parse(time.RFC3339, o.ExpiresAt)
+		if err != nil || !expires.After(clock.Now()) {
+			continue
+		}
+		if _, err := s.overrides.Set(o, expires); err != nil {
+			log.Printf("ha: restore override %s failed: %v", o.Key, err)
+		}
+	}
+}
+
+// restoreRotations 恢复尚未结束（或结束不超过 outcomeRetention）的轮灌计划；更早的计划仍可从存储查询。
+func (s *ControlService) restoreRotations() {
+	plans, err := s.state.LoadRotations()
+	if err != nil {
+		log.Printf("ha: load rotations failed: %v", err)
+	}
+	cutoff := clock.Now().Add(-outcomeRetention)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range plans {
+		if t, err := time.Parse(time.RFC3339, plans[i].FinishAt); err == nil && t.Before(cutoff) {
+			continue
+		}
+		s.rotations[plans[i].GroupID] = &plans[i]
+	}
+}
+
+// resumeTasks 按最后更新时间顺序接管未完成的任务；执行到一半的动作链优先，保证其先拿到目标锁。
+func (s *ControlService) resumeTasks() {
+	recs, err := s.state.LoadTasks()
+	if err != nil {
+		log.Printf("ha: load tasks: %v", err)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		ri, rj := recs[i].Next > 0, recs[j].Next > 0
+		if ri != rj {
+			return ri
+		}
+		return recs[i].Outcome.UpdatedAt < recs[j].Outcome.UpdatedAt
+	})
+
+	resumed := 0
+	for _, rec := range recs {
+		out := rec.Outcome
+		s.mu.Lock()
+		s.outcomes[out.TaskID] = &out
+		s.mu.Unlock()
+		if rec.Done() {
+			continue
+		}
+		task := rec.Task
+		resumed++
+		if rec.Next <= 0 || rec.Next > len(rec.Steps) {
+			log.Printf("[trace=%s task=%s] ha: resume from %s, replanning", task.TraceID, task.TaskID, out.Status)
+			go s.processTask(&task)
+			continue
+		}
+		log.Printf("[trace=%s task=%s] ha: resume chain at step %d/%d", task.TraceID, task.TaskID, rec.Next, len(rec.Steps))
+		if !s.acquireTarget(&task, rec.Steps, rec.Next) {
+			continue
+		}
+		s.resumeAt(&task, rec.Steps, rec.Next, rec.ResumeAt)
+	}
+	log.Printf("ha: took over %d unfinished task(s)", resumed)
+}
+
+// resumeAt 从第 next 步继续动作链；处于 wait 中的按原定恢复时间继续。
+func (s *ControlService) resumeAt(task *model.Task, steps []model.Step, next int, at string) {
+	if t, err := time.Parse(time.RFC3339Nano, at); err == nil {
+		if d := clock.Until(t); d > 0 {
+			clock.AfterFunc(d, func() { s.runSteps(task, steps, next) })
+			return
+		}
+	}
+	go s.runSteps(task, steps, next)
+}
+
+// persist 把任务及执行进度写入共享状态存储（单实例模式下不做任何事）。
+// next/steps 为 0/nil 表示动作链尚未开始；resumeAt 为 wait 结束时间。
+func (s *ControlService) persist(task *model.Task, steps []model.Step, next int, resumeAt time.Time) {
+	if s.state == nil {
+		return
+	}
+	rec := state.Record{Task: *task, Steps: steps, Next: next}
+	if !resumeAt.IsZero() {
+		rec.ResumeAt = resumeAt.Format(time.RFC3339Nano)
+	}
+	s.mu.RLock()
+	if o, ok := s.outcomes[task.TaskID]; ok {
+		rec.Outcome = *o
+		rec.Outcome.Results = append([]model.DeviceResult(nil), o.Results...)
+	}
+	s.mu.RUnlock()
+	if err := s.state.SaveTask(rec); err != nil {
+		log.Printf("[trace=%s task=%s] ha: persist failed: %v", task.TraceID, task.TaskID, err)
+	}
+}
+
+// persistRotation 写入已提交的轮灌计划。
+func (s *ControlService) persistRotation(plan model.RotationPlan) {
+	if s.state == nil {
+		return
+	}
+	if err := s.state.SaveRotation(plan); err != nil {
+		log.Printf("[trace=%s] ha: persist rotation failed: %v", plan.GroupID, err)
+	}
+}
+
+// storedRotation standby 上查询轮灌计划（或已从内存淘汰的计划）时从共享存储读取。
+func (s *ControlService) storedRotation(groupID string) (model.RotationPlan, bool) {
+	if s.state == nil {
+		return model.RotationPlan{}, false
+	}
+	plan, ok, err := s.state.LoadRotation(groupID)
+	if err != nil || !ok {
+		return model.RotationPlan{}, false
+	}
+	return plan, true
+}
+
+// persistOverrides 写入当前生效中的人工接管。
+func (s *ControlService) persistOverrides() {
+	if s.state == nil {
+		return
+	}
+	if err := s.state.SaveOverrides(s.overrides.List()); err != nil {
+		log.Printf("ha: persist overrides failed: %v", err)
+	}
+}
+
+// storedStatus standby 上查询任务状态时从共享存储读取。
+func (s *ControlService) storedStatus(taskID string) (model.TaskOutcome, bool) {
+	if s.state == nil {
+		return model.TaskOutcome{}, false
+	}
+	rec, ok, err := s.state.LoadTask(taskID)
+	if err != nil || !ok {
+		return model.TaskOutcome{}, false
+	}
+	return rec.Outcome, true
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/state"
+)
+
+// newHAInstance 在共享状态目录上创建一个 standby 实例。
+func newHAInstance(t *testing.T, st *state.Store) *ControlService {
+	t.Helper()
+	return NewHAControlService(nil, 1, st)
+}
+
+// elect 以 holder 身份获取租约并 Promote。
+func elect(t *testing.T, s *ControlService, st *state.Store, holder string, ttl time.Duration) state.Lease {
+	t.Helper()
+	ok, lease, err := st.TryAcquire(holder, ttl)
+	if err != nil || !ok {
+		t.Fatalf("%s 获取租约: ok=%v err=%v", holder, ok, err)
+	}
+	s.Promote(lease)
+	return lease
+}
+
+func TestFailoverResumesTasksAndRotations(t *testing.T) {
+	// 接管后的任务由后台 goroutine 写入状态目录，不用 t.TempDir（其清理要求目录已无写入）
+	dir, err := os.MkdirTemp("", "acs-ha-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	st, err := state.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ttl = 50 * time.Millisecond
+	a, b := newHAInstance(t, st), newHAInstance(t, st)
+	elect(t, a, st, "a", ttl)
+
+	// standby 不接受写请求
+	if err := b.HandleTask(&model.Task{TaskType: "irrigation", Target: "A区", Params: map[string]interface{}{"duration_min": 10.0}}); err == nil {
+		t.Fatal("standby 不应接受任务")
+	}
+
+	task := &model.Task{
+		TaskType:   "irrigation",
+		Target:     "A区",
+		ScheduleAt: clock.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		Params:     map[string]interface{}{"duration_min": 10.0},
+	}
+	if err := a.HandleTask(task); err != nil {
+		t.Fatalf("leader 提交任务: %v", err)
+	}
+	plan, err := a.PlanRotation(model.RotationRequest{
+		Zones:       []model.RotationZoneRequest{{Target: "B区", DurationMin: 20}, {Target: "C区", DurationMin: 20}},
+		Capacity:    1,
+		WindowStart: clock.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339),
+		WindowEnd:   clock.Now().Add(4 * time.Hour).UTC().Format(time.RFC3339),
+	}, "tester")
+	if err != nil {
+		t.Fatalf("leader 提交轮灌: %v", err)
+	}
+	waitStatus(t, a, task.TaskID, model.TaskScheduled)
+
+	// standby 从共享存储查询任务与轮灌计划
+	if o, ok := b.TaskStatus(task.TaskID); !ok || o.Status != model.TaskScheduled {
+		t.Fatalf("standby 查询任务: %+v, %v", o, ok)
+	}
+	if p, ok := b.Rotation(plan.GroupID); !ok || len(p.Slots) != len(plan.Slots) {
+		t.Fatalf("standby 查询轮灌计划: %+v, %v", p, ok)
+	}
+
+	// a 停止续约，租约过期后 b 接管
+	time.Sleep(ttl + 10*time.Millisecond)
+	lease := elect(t, b, st, "b", ttl)
+	if lease.Epoch != 2 {
+		t.Fatalf("接管后 epoch = %d, 期望 2", lease.Epoch)
+	}
+	b.mu.RLock()
+	_, restored := b.rotations[plan.GroupID]
+	b.mu.RUnlock()
+	if !restored {
+		t.Fatal("新 leader 未恢复轮灌计划")
+	}
+	waitStatus(t, b, task.TaskID, model.TaskScheduled)
+	for _, slot := range plan.Slots {
+		waitStatus(t, b, slot.TaskID, model.TaskScheduled)
+	}
+
+	// 旧 leader 醒来后继续动作链会被 fencing 拦下，并放弃 leader 身份
+	if !a.fenced(task) {
+		t.Fatal("旧 epoch 的 leader 应被 fence")
+	}
+	if a.IsLeader() {
+		t.Fatal("被 fence 后旧 leader 不应仍为 leader")
+	}
+	if b.fenced(task) {
+		t.Fatal("新 leader 不应被 fence")
+	}
+}
+
+// waitStatus 等待 worker 把任务推进到指定状态。
+func waitStatus(t *testing.T, s *ControlService, taskID, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		o, ok := s.TaskStatus(taskID)
+		if ok && o.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("任务 %s 状态 = %q, 期望 %q", taskID, o.Status, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/override"
+	"agri-control-service/internal/schedule"
+)
+
+// overrides.go：人工接管与任务执行的衔接。
+// 接管在任务开始执行时检查（含定时到点、排队获得目标锁时）；已在执行中的动作链不受影响，
+// 避免中途打断导致阀门停留在打开状态。
+
+// ErrOverridden 目标处于 reject 模式的人工接管，API 层据此返回 409。
+var ErrOverridden = errors.New("target under manual override")
+
+// activeOverride 返回对该任务生效的人工接管；操作员来源的任务不受约束。
+func (s *ControlService) activeOverride(task *model.Task) (model.Override, bool) {
+	if override.IsOperator(task.Source) {
+		return model.Override{}, false
+	}
+	return s.overrides.Get(targetKey(task))
+}
+
+// checkOverrideOnSubmit 入队时遇到 reject 模式的接管直接拒绝；定时任务到点时接管可能已结束，留到执行时再检查。
+func (s *ControlService) checkOverrideOnSubmit(task *model.Task) error {
+	o, ok := s.activeOverride(task)
+	if !ok || o.Mode != override.ModeReject {
+		return nil
+	}
+	if task.ScheduleAt != "" {
+		if t, err := time.Parse(time.RFC3339, task.ScheduleAt); err == nil && t.After(clock.Now()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s locked by %s until %s", ErrOverridden, o.Key, operatorName(o), o.ExpiresAt)
+}
+
+// applyOverride 在任务开始执行前检查人工接管；任务被挂起或拒绝时返回 true。
+// 检查与挂起在 lockMu 下完成，与 onOverrideReleased 取出挂起队列互斥：
+// 接管在检查之后解除时，释放回调必然在任务入队之后取队列，任务不会滞留在 held 中。
+func (s *ControlService) applyOverride(task *model.Task) bool {
+	s.lockMu.Lock()
+	o, ok := s.activeOverride(task)
+	if !ok {
+		s.lockMu.Unlock()
+		return false
+	}
+	if o.Mode == override.ModeReject {
+		s.lockMu.Unlock()
+		log.Printf("[trace=%s task=%s] rejected by manual override on %s", task.TraceID, task.TaskID, o.Key)
+		s.setOverrideStatus(task, model.TaskRejected, &o,
+			fmt.Sprintf("manual override by %s until %s", operatorName(o), o.ExpiresAt))
+		return true
+	}
+	log.Printf("[trace=%s task=%s] held by manual override on %s until %s", task.TraceID, task.TaskID, o.Key, o.ExpiresAt)
+	// held 状态须在入队前写入：释放回调恢复执行后写入的 running 不会被覆盖
+	s.setOverrideStatus(task, model.TaskHeld, &o, "")
+	s.held[o.Key] = append(s.held[o.Key], task)
+	s.lockMu.Unlock()
+	return true
+}
+
+// onOverrideReleased 接管到期或被解除后，按挂起顺序重新调度该目标上被挂起的任务。
+func (s *ControlService) onOverrideReleased(o model.Override) {
+	s.lockMu.Lock()
+	tasks := s.held[o.Key]
+	delete(s.held, o.Key)
+	s.lockMu.Unlock()
+
+	s.persistOverrides()
+	log.Printf("[override] %s released, resuming %d held task(s)", o.Key, len(tasks))
+	for _, task := range tasks {
+		go s.processPlannedTask(task)
+	}
+}
+
+// SetOverride 创建或修改人工接管；expires_at 可为 RFC3339 或调度表达式（如 18:00、sunset）。
+func (s *ControlService) SetOverride(req model.OverrideRequest) (model.Override, error) {
+	if err := s.requireLeader(); err != nil {
+		return model.Override{}, err
+	}
+	task := model.Task{DomainID: req.DomainID, ChannelID: req.ChannelID, Target: req.Target}
+	if strings.TrimSpace(task.Target) == "" {
+		return model.Override{}, fmt.Errorf("%w: target is required", override.ErrInvalidOverride)
+	}
+	if err := normalizeTarget(&task); err != nil {
+		return model.Override{}, err
+	}
+	key := targetKey(&task)
+
+	now := clock.Now()
+	var expires time.Time
+	switch {
+	case req.ExpiresAt != "":
+		t, err := schedule.Resolve(req.ExpiresAt, key, now)
+		if err != nil {
+			return model.Override{}, fmt.Errorf("%w: expires_at: %v", override.ErrInvalidOverride, err)
+		}
+		expires = t
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return model.Override{}, fmt.Errorf("%w: invalid duration %q", override.ErrInvalidOverride, req.Duration)
+		}
+		expires = now.Add(d)
+	default:
+		return model.Override{}, fmt.Errorf("%w: duration or expires_at is required", override.ErrInvalidOverride)
+	}
+
+	o, err := s.overrides.Set(model.Override{
+		Key:       key,
+		DomainID:  task.DomainID,
+		ChannelID: task.ChannelID,
+		Target:    task.Target,
+		Mode:      req.Mode,
+		Operator:  req.Operator,
+		Reason:    req.Reason,
+	}, expires)
+	if err != nil {
+		return model.Override{}, err
+	}
+	s.persistOverrides()
+	log.Printf("[override] %s set mode=%s operator=%s until %s", o.Key, o.Mode, o.Operator, o.ExpiresAt)
+	return o, nil
+}
+
+// Override 返回目标上的生效中接管。
+func (s *ControlService) Override(ref model.TargetRef) (model.Override, bool, error) {
+	key, err := overrideKey(ref)
+	if err != nil {
+		return model.Override{}, false, err
+	}
+	o, ok := s.overrides.Get(key)
+	return o, ok, nil
+}
+
+// Overrides 返回全部生效中接管。
+func (s *ControlService) Overrides() []model.Override {
+	return s.overrides.List()
+}
+
+// ReleaseOverride 提前解除目标上的接管，被挂起的任务随即恢复。
+func (s *ControlService) ReleaseOverride(ref model.TargetRef) (model.Override, bool, error) {
+	if err := s.requireLeader(); err != nil {
+		return model.Override{}, false, err
+	}
+	key, err := overrideKey(ref)
+	if err != nil {
+		return model.Override{}, false, err
+	}
+	o, ok := s.overrides.Release(key)
+	if ok {
+		log.Printf("[override] %s released by request", key)
+	}
+	return o, ok, nil
+}
+
+// overrideKey 把请求中的目标规范化为接管键。
+func overrideKey(ref model.TargetRef) (string, error) {
+	task := model.Task{DomainID: ref.DomainID, ChannelID: ref.ChannelID, Target: ref.Target}
+	if strings.TrimSpace(task.Target) == "" {
+		return "", fmt.Errorf("%w: target is required", override.ErrInvalidOverride)
+	}
+	if err := normalizeTarget(&task); err != nil {
+		return "", err
+	}
+	return targetKey(&task), nil
+}
+
+func operatorName(o model.Override) string {
+	if o.Operator == "" {
+		return "operator"
+	}
+	return o.Operator
+}
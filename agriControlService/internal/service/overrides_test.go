@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/override"
+)
+
+func TestOverrideHoldsUntilRelease(t *testing.T) {
+	s, d := newTestService(t)
+	ref := model.TargetRef{Target: "A区"}
+	if _, err := s.SetOverride(model.OverrideRequest{Target: ref.Target, Duration: "1h", Operator: "张工"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 预览：hold 模式推迟到接管结束
+	p := s.PreviewPlan(model.Task{TaskType: "irrigation", Target: "A区", Source: "llm", Params: map[string]interface{}{"duration_min": 10.0}})
+	if !p.Executable || p.Override == nil || p.StartAt != p.Override.ExpiresAt {
+		t.Fatalf("接管期间的预览 = %+v", p)
+	}
+
+	task := &model.Task{TaskType: "irrigation", Target: "A区", Source: "llm", Params: map[string]interface{}{"duration_min": 10.0}}
+	if err := s.HandleTask(task); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, task.TaskID, model.TaskHeld)
+	if o, _ := s.TaskStatus(task.TaskID); o.Override == nil || o.Override.Operator != "张工" {
+		t.Fatalf("挂起状态未记录接管: %+v", o)
+	}
+	if len(d.sent()) != 0 {
+		t.Fatal("挂起期间不应下发命令")
+	}
+
+	// 操作员来源不受接管约束
+	op := &model.Task{TaskType: "irrigation", Target: "A区", Source: "operator", Params: map[string]interface{}{"duration_min": 10.0}}
+	if err := s.HandleTask(op); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, op.TaskID, model.TaskRunning)
+
+	if _, ok, err := s.ReleaseOverride(ref); err != nil || !ok {
+		t.Fatalf("解除接管: ok=%v err=%v", ok, err)
+	}
+	// 操作员任务仍持有目标锁，被挂起的任务恢复后排队等待
+	waitStatus(t, s, task.TaskID, model.TaskWaiting)
+	if _, ok, _ := s.Override(ref); ok {
+		t.Fatal("解除后不应再有生效中的接管")
+	}
+}
+
+func TestOverrideRejectMode(t *testing.T) {
+	s, d := newTestService(t)
+	if _, err := s.SetOverride(model.OverrideRequest{Target: "B区", Mode: override.ModeReject, Duration: "30m"}); err != nil {
+		t.Fatal(err)
+	}
+	task := &model.Task{TaskType: "irrigation", Target: "B区", Source: "schedule", Params: map[string]interface{}{"duration_min": 5.0}}
+	if err := s.HandleTask(task); !errors.Is(err, ErrOverridden) {
+		t.Fatalf("reject 模式提交 err = %v，期望 ErrOverridden", err)
+	}
+	if len(d.sent()) != 0 {
+		t.Fatal("被拒绝的任务不应下发命令")
+	}
+	if _, err := s.SetOverride(model.OverrideRequest{Target: "B区", Mode: "pause", Duration: "30m"}); !errors.Is(err, override.ErrInvalidOverride) {
+		t.Fatalf("未知模式 err = %v，期望 ErrInvalidOverride", err)
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/policy"
+	"agri-control-service/internal/resolver"
+	"agri-control-service/internal/rotation"
+	"agri-control-service/internal/schedule"
+)
+
+// rotation.go：共享水源的分区轮灌。
+// 由 rotation 包按水泵容量与时间窗排出开/关时间表，每段拆成一个定时 irrigation 任务，
+// 各段共享 group_id（同时作为 trace_id），可整体查询执行进度。
+
+// rotationSource 轮灌拆出的任务来源，不属于操作员来源，受人工接管约束。
+const rotationSource = "rotation"
+
+// PlanRotation 生成轮灌计划；dry_run 为 false 时把各段作为关联任务提交。
+// 任一段不合法（目标未知、参数校验失败、目标处于 reject 接管）时整个计划都不提交。
+func (s *ControlService) PlanRotation(req model.RotationRequest, principal string) (model.RotationPlan, error) {
+	if len(req.Zones) == 0 {
+		return model.RotationPlan{}, fmt.Errorf("%w: zones is required", ErrInvalidTask)
+	}
+
+	plan := model.RotationPlan{GroupID: generateID(), Capacity: req.Capacity, Principal: principal}
+	zones := make([]rotation.Zone, 0, len(req.Zones))
+	refs := make([]model.Task, 0, len(req.Zones))
+	seen := map[string]bool{}
+	for _, z := range req.Zones {
+		t := model.Task{DomainID: req.DomainID, ChannelID: req.ChannelID, Target: z.Target}
+		if err := normalizeTarget(&t); err != nil {
+			return model.RotationPlan{}, err
+		}
+		key := targetKey(&t)
+		if seen[key] {
+			return model.RotationPlan{}, fmt.Errorf("%w: duplicate zone %s", ErrInvalidTask, key)
+		}
+		seen[key] = true
+		valves, err := resolver.ResolveDevices(t.Ref(), "irrigation")
+		if err != nil {
+			return model.RotationPlan{}, fmt.Errorf("%w: %v", ErrInvalidTask, err)
+		}
+		zones = append(zones, rotation.Zone{Key: key, Valves: len(valves), Minutes: z.DurationMin})
+		refs = append(refs, t)
+	}
+
+	// 时间窗：开始时间已过则从当前开始；结束时间相对开始时间解析（如 05:00 → 07:00）
+	now := clock.Now()
+	start, err := schedule.Resolve(req.WindowStart, zones[0].Key, now)
+	if err != nil {
+		return model.RotationPlan{}, fmt.Errorf("%w: window_start: %v", ErrInvalidTask, err)
+	}
+	if start.Before(now) {
+		start = now
+	}
+	if req.WindowEnd == "" {
+		return model.RotationPlan{}, fmt.Errorf("%w: window_end is required", ErrInvalidTask)
+	}
+	end, err := schedule.Resolve(req.WindowEnd, zones[0].Key, start)
+	if err != nil {
+		return model.RotationPlan{}, fmt.Errorf("%w: window_end: %v", ErrInvalidTask, err)
+	}
+
+	// 单段运行不超过灌溉策略上限，否则执行时会被策略截断而打乱时间表
+	maxRun := req.MaxRunMin
+	if maxRun <= 0 || maxRun > policy.MaxIrrigationMin {
+		maxRun = policy.MaxIrrigationMin
+	}
+	plan.MaxRunMin = maxRun
+
+	built, err := rotation.Build(rotation.Request{Zones: zones, Capacity: req.Capacity, Start: start, End: end, MaxRunMin: maxRun})
+	if err != nil {
+		return model.RotationPlan{}, fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	plan.WindowStart = start.UTC().Format(time.RFC3339)
+	plan.WindowEnd = end.UTC().Format(time.RFC3339)
+	plan.FinishAt = built.Finish.UTC().Format(time.RFC3339)
+	plan.Scale = math.Round(built.Scale*1000) / 1000
+
+	for i, z := range zones {
+		plan.Zones = append(plan.Zones, model.RotationZone{
+			Key:          z.Key,
+			DomainID:     refs[i].DomainID,
+			ChannelID:    refs[i].ChannelID,
+			Target:       refs[i].Target,
+			Valves:       z.Valves,
+			RequestedMin: z.Minutes,
+			AllocatedMin: built.Allocated[i],
+		})
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "rotation " + plan.GroupID
+	}
+	tasks := make([]*model.Task, 0, len(built.Slots))
+	for _, slot := range built.Slots {
+		ref := refs[slot.Zone]
+		task := &model.Task{
+			TaskID:     generateID(),
+			TraceID:    plan.GroupID,
+			GroupID:    plan.GroupID,
+			ScheduleAt: slot.Start.Format(time.RFC3339),
+			TaskType:   "irrigation",
+			DomainID:   ref.DomainID,
+			ChannelID:  ref.ChannelID,
+			Target:     ref.Target,
+			Params:     map[string]interface{}{"duration_min": slot.Minutes, "reason": reason},
+			Source:     rotationSource,
+			Principal:  principal,
+		}
+		tasks = append(tasks, task)
+		plan.Zones[slot.Zone].Runs++
+		plan.Slots = append(plan.Slots, model.RotationSlot{
+			TaskID:      task.TaskID,
+			Key:         zones[slot.Zone].Key,
+			Target:      ref.Target,
+			Valves:      zones[slot.Zone].Valves,
+			Run:         slot.Run,
+			StartAt:     slot.Start.UTC().Format(time.RFC3339),
+			EndAt:       slot.End.UTC().Format(time.RFC3339),
+			DurationMin: slot.Minutes,
+		})
+	}
+	if req.DryRun {
+		return plan, nil
+	}
+
+	for _, task := range tasks {
+		if err := s.admit(task); err != nil {
+			return model.RotationPlan{}, err
+		}
+	}
+	plan.Submitted = true
+	stored := plan
+	s.mu.Lock()
+	s.rotations[plan.GroupID] = &stored
+	s.mu.Unlock()
+	s.persistRotation(plan)
+
+	// 计划内的任务必须全部入队，队列满时阻塞等待 worker 消费（定时任务出队后只设置定时器，很快腾出空间）
+	for _, task := range tasks {
+		s.setStatus(task, model.TaskQueued, "")
+		s.queue <- task
+	}
+	log.Printf("[trace=%s] rotation submitted: %d zones, %d runs, capacity=%d, finish=%s, scale=%.3f",
+		plan.GroupID, len(plan.Zones), len(plan.Slots), plan.Capacity, plan.FinishAt, plan.Scale)
+	return plan, nil
+}
+
+// Rotation 返回已提交的轮灌计划，各段附带对应任务的当前状态；内存中没有时（standby）从共享存储读取。
+func (s *ControlService) Rotation(groupID string) (model.RotationPlan, bool) {
+	var out model.RotationPlan
+	s.mu.RLock()
+	p, ok := s.rotations[groupID]
+	if ok {
+		out = *p
+		out.Zones = append([]model.RotationZone(nil), p.Zones...)
+		out.Slots = append([]model.RotationSlot(nil), p.Slots...)
+	}
+	s.mu.RUnlock()
+	if !ok {
+		if out, ok = s.storedRotation(groupID); !ok {
+			return model.RotationPlan{}, false
+		}
+	}
+	for i := range out.Slots {
+		if o, ok := s.TaskStatus(out.Slots[i].TaskID); ok {
+			out.Slots[i].Status = o.Status
+		}
+	}
+	return out, true
+}
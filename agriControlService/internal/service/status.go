@@ -0,0 +1,132 @@
+package service
+
+import (
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+)
+
+// status.go：任务执行状态（内存表）的维护与查询。
+// 已到终态的任务保留 outcomeRetention，且最多保留 maxFinishedOutcomes 个，超出后按完成先后淘汰；
+// 高可用模式下被淘汰的任务仍可从共享存储查询。
+
+const (
+	outcomeRetention    = 24 * time.Hour
+	maxFinishedOutcomes = 10000
+)
+
+// finishedOutcome 记录任务到达终态的时间。
+type finishedOutcome struct {
+	taskID string
+	at     time.Time
+}
+
+// TaskStatus 返回任务执行状态的快照；内存中没有时（standby 或已淘汰）释放锁后再读共享存储。
+func (s *ControlService) TaskStatus(taskID string) (model.TaskOutcome, bool) {
+	s.mu.RLock()
+	o, ok := s.outcomes[taskID]
+	if !ok {
+		s.mu.RUnlock()
+		return s.storedStatus(taskID)
+	}
+	out := *o
+	out.Results = append([]model.DeviceResult(nil), o.Results...)
+	s.mu.RUnlock()
+	return out, true
+}
+
+// setStatus 更新任务状态（不存在则创建）。
+func (s *ControlService) setStatus(task *model.Task, status, errMsg string) {
+	s.setOverrideStatus(task, status, nil, errMsg)
+}
+
+// setOverrideStatus 更新任务状态并记录导致挂起/拒绝的人工接管（ov 为 nil 时清除）。
+// 高可用模式下同时写入共享存储；running 状态的执行进度由 runSteps 逐步写入。
+func (s *ControlService) setOverrideStatus(task *model.Task, status string, ov *model.Override, errMsg string) {
+	s.mu.Lock()
+	o, ok := s.outcomes[task.TaskID]
+	if !ok {
+		o = &model.TaskOutcome{
+			TaskID:    task.TaskID,
+			TraceID:   task.TraceID,
+			TaskType:  task.TaskType,
+			GroupID:   task.GroupID,
+			Principal: task.Principal,
+			DomainID:  task.DomainID,
+			ChannelID: task.ChannelID,
+			Target:    task.Target,
+		}
+		s.outcomes[task.TaskID] = o
+	}
+	now := clock.Now()
+	o.Status = status
+	o.Error = errMsg
+	o.Override = ov
+	o.UpdatedAt = now.UTC().Format(time.RFC3339Nano)
+	if o.Done() {
+		s.finished = append(s.finished, finishedOutcome{taskID: task.TaskID, at: now})
+		s.evictOutcomesLocked(now)
+	}
+	s.mu.Unlock()
+
+	if status != model.TaskRunning {
+		s.persist(task, nil, 0, time.Time{})
+	}
+}
+
+// recordResult 追加单个设备命令的执行结果。
+func (s *ControlService) recordResult(task *model.Task, cmd model.DeviceCommand, err error) {
+	r := model.DeviceResult{
+		DeviceID: cmd.DeviceID,
+		Command:  cmd.Command,
+		Status:   "ok",
+		Ts:       clock.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.outcomes[task.TaskID]; ok {
+		o.Results = append(o.Results, r)
+		o.UpdatedAt = r.Ts
+	}
+}
+
+// finish 动作链结束后根据各设备结果得出最终状态。
+func (s *ControlService) finish(task *model.Task) {
+	status := model.TaskSucceeded
+	s.mu.RLock()
+	if o, ok := s.outcomes[task.TaskID]; ok {
+		for _, r := range o.Results {
+			if r.Status != "ok" {
+				status = model.TaskPartial
+				break
+			}
+		}
+	}
+	s.mu.RUnlock()
+	s.setStatus(task, status, "")
+	s.releaseTarget(task)
+}
+
+// evictOutcomesLocked 淘汰超出保留时长或数量上限的终态任务（调用方持有 s.mu）。
+// 淘汰前再次确认任务仍是终态：同一任务重复进入终态时队列中会有多条记录，只按最早一条淘汰一次。
+func (s *ControlService) evictOutcomesLocked(now time.Time) {
+	n := 0
+	for n < len(s.finished) {
+		f := s.finished[n]
+		if len(s.finished)-n <= maxFinishedOutcomes && now.Sub(f.at) < outcomeRetention {
+			break
+		}
+		if o, ok := s.outcomes[f.taskID]; ok && o.Done() {
+			delete(s.outcomes, f.taskID)
+		}
+		n++
+	}
+	if n > 0 {
+		s.finished = append(s.finished[:0:0], s.finished[n:]...)
+	}
+}
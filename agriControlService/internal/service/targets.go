@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/resolver"
+)
+
+// targets.go：作用域目标（domain/channel/partition）的规范化与执行锁。
+// 分区名称只在单个 Magistrala 频道内唯一，锁与日志都以作用域键区分，多个农场可共用一个控制服务。
+
+// targetLock 记录某作用域目标上正在执行的任务及排队任务。
+type targetLock struct {
+	running string
+	waiting []pendingRun
+}
+
+// pendingRun 是已完成规划、等待目标锁的动作链；next>0 表示接管后从中途继续的动作链。
+type pendingRun struct {
+	task  *model.Task
+	steps []model.Step
+	next  int
+}
+
+// normalizeTarget 拆分完全限定的 target，并在注册表中定位分区以补全 domain_id/channel_id。
+// 分区不存在或在多个频道中重名（未限定作用域）时返回 ErrInvalidTask。
+func normalizeTarget(task *model.Task) error {
+	ref := task.Ref()
+	task.DomainID, task.ChannelID, task.Target = ref.DomainID, ref.ChannelID, ref.Target
+	if !resolver.Loaded() {
+		return nil
+	}
+	loc, err := resolver.Locate(ref)
+	if err != nil {
+		return fmt.Errorf("%w: target: %v", ErrInvalidTask, err)
+	}
+	task.DomainID, task.ChannelID = loc.DomainID, loc.ChannelID
+	return nil
+}
+
+// targetKey 返回任务的作用域键；能在注册表中定位时使用分区 ID，使 "A区" 与 "field-A" 共用同一把锁。
+func targetKey(task *model.Task) string {
+	if resolver.Loaded() {
+		if loc, err := resolver.Locate(task.Ref()); err == nil {
+			return loc.Ref().Key()
+		}
+	}
+	return task.Ref().Key()
+}
+
+// acquireTarget 尝试获取目标锁；目标忙时把动作链（从第 next 步起）加入等待队列并返回 false。
+func (s *ControlService) acquireTarget(task *model.Task, steps []model.Step, next int) bool {
+	key := targetKey(task)
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	l, ok := s.locks[key]
+	if !ok {
+		s.locks[key] = &targetLock{running: task.TaskID}
+		return true
+	}
+	l.waiting = append(l.waiting, pendingRun{task: task, steps: steps, next: next})
+	return false
+}
+
+// releaseTarget 释放目标锁，并启动该目标上排队的下一条动作链。
+func (s *ControlService) releaseTarget(task *model.Task) {
+	key := targetKey(task)
+	s.lockMu.Lock()
+	l, ok := s.locks[key]
+	if !ok || l.running != task.TaskID {
+		s.lockMu.Unlock()
+		return
+	}
+	if len(l.waiting) == 0 {
+		delete(s.locks, key)
+		s.lockMu.Unlock()
+		return
+	}
+	next := l.waiting[0]
+	l.waiting = l.waiting[1:]
+	l.running = next.task.TaskID
+	s.lockMu.Unlock()
+
+	if next.next > 0 {
+		go s.runSteps(next.task, next.steps, next.next)
+		return
+	}
+	go s.startSteps(next.task, next.steps)
+}
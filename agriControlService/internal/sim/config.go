@@ -0,0 +1,154 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sim 包：数字孪生仿真驱动。
+// 模拟阀门、水泵与各分区土壤含水率（灌溉入流、蒸散、深层渗漏），并按 agriDataIntegration 相同的 SenML 形状
+// 发布模拟传感器读数；配合 clock 包的倍速时间，可离线端到端测试场景、策略与 LLM 规划。
+
+// Duration 支持在 YAML/JSON 中写 "5m" 形式的时长。
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	return d.parse(n.Value)
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	d.Duration = v
+	return nil
+}
+
+// Config 是仿真配置（configs/sim.yaml）。
+type Config struct {
+	Speed float64  `json:"speed" yaml:"speed"` // 时间倍速
+	Start string   `json:"start" yaml:"start"` // 仿真起始时间（RFC3339，空为当前时间）
+	Tick  Duration `json:"tick" yaml:"tick"`   // 仿真步长（仿真时间）
+
+	AirTempMean float64 `json:"air_temp_mean" yaml:"air_temp_mean"` // 日均气温 ℃
+	AirTempAmp  float64 `json:"air_temp_amp" yaml:"air_temp_amp"`   // 气温日较差的一半 ℃
+
+	Publish     PublishConfig              `json:"publish" yaml:"publish"`
+	Partitions  map[string]PartitionConfig `json:"partitions" yaml:"partitions"` // 键为 partitionId
+	Pumps       []PumpConfig               `json:"pumps" yaml:"pumps"`
+	FailDevices []string                   `json:"fail_devices" yaml:"fail_devices"` // 故障注入：这些设备的命令返回失败
+}
+
+// PublishConfig 描述模拟读数的发布方式。
+type PublishConfig struct {
+	Mode     string   `json:"mode" yaml:"mode"`         // http | file | none
+	URL      string   `json:"url" yaml:"url"`           // Magistrala HTTP 适配器地址，如 http://localhost:9011
+	Subtopic string   `json:"subtopic" yaml:"subtopic"` // 子主题
+	File     string   `json:"file" yaml:"file"`         // mode=file 时的 JSONL 输出文件
+	Every    Duration `json:"every" yaml:"every"`       // 发布间隔（仿真时间）
+}
+
+// PartitionConfig 描述一个分区的土壤与灌溉参数（含水率均为体积百分比）。
+type PartitionConfig struct {
+	AreaM2          float64        `json:"area_m2" yaml:"area_m2"`
+	RootDepthMM     float64        `json:"root_depth_mm" yaml:"root_depth_mm"`
+	Initial         float64        `json:"initial" yaml:"initial"`
+	FieldCapacity   float64        `json:"field_capacity" yaml:"field_capacity"`
+	WiltingPoint    float64        `json:"wilting_point" yaml:"wilting_point"`
+	Saturation      float64        `json:"saturation" yaml:"saturation"`
+	ETmmDay         float64        `json:"et_mm_day" yaml:"et_mm_day"`                 // 参考蒸散量 mm/天（按日变化分布到白天）
+	DrainagePerHour float64        `json:"drainage_per_hour" yaml:"drainage_per_hour"` // 每小时排出超过田间持水量部分的比例
+	ValveFlowLPH    float64        `json:"valve_flow_lph" yaml:"valve_flow_lph"`       // 单个阀门流量 L/h
+	MisterFlowLPH   float64        `json:"mister_flow_lph" yaml:"mister_flow_lph"`     // 单个喷雾器流量 L/h
+	Sensors         []SensorConfig `json:"sensors" yaml:"sensors"`
+}
+
+// SensorConfig 描述一个模拟传感器（对应 Magistrala client）。
+type SensorConfig struct {
+	Name     string `json:"name" yaml:"name"`         // SenML bn（英文 client 名称，如 sensor-soil_moisture_1-sim-A）
+	Secret   string `json:"secret" yaml:"secret"`     // client secret，用于 HTTP 适配器鉴权
+	Quantity string `json:"quantity" yaml:"quantity"` // soil_moisture | air_temperature
+}
+
+// PumpConfig 描述水泵：为若干分区供水，总流量受 capacity 限制，超出时各阀门按比例降流。
+type PumpConfig struct {
+	ID          string   `json:"id" yaml:"id"`
+	CapacityLPH float64  `json:"capacity_lph" yaml:"capacity_lph"`
+	Partitions  []string `json:"partitions" yaml:"partitions"`
+}
+
+// LoadConfig 从 YAML/JSON 读取仿真配置并补全默认值。
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read sim config: %w", err)
+	}
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		return nil, fmt.Errorf("unsupported sim file type: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal sim config: %w", err)
+	}
+	cfg.applyDefaults()
+	return &cfg, nil
+}
+
+func (c *Config) applyDefaults() {
+	if c.Speed <= 0 {
+		c.Speed = 1
+	}
+	if c.Tick.Duration <= 0 {
+		c.Tick.Duration = time.Minute
+	}
+	if c.Publish.Every.Duration <= 0 {
+		c.Publish.Every.Duration = 5 * time.Minute
+	}
+	if c.Publish.Subtopic == "" {
+		c.Publish.Subtopic = "sim"
+	}
+	for id, p := range c.Partitions {
+		if p.AreaM2 <= 0 {
+			p.AreaM2 = 1000
+		}
+		if p.RootDepthMM <= 0 {
+			p.RootDepthMM = 300
+		}
+		if p.FieldCapacity <= 0 {
+			p.FieldCapacity = 32
+		}
+		if p.Saturation <= p.FieldCapacity {
+			p.Saturation = p.FieldCapacity + 12
+		}
+		if p.Initial <= 0 {
+			p.Initial = p.FieldCapacity
+		}
+		if p.ValveFlowLPH <= 0 {
+			p.ValveFlowLPH = 3000
+		}
+		if p.MisterFlowLPH <= 0 {
+			p.MisterFlowLPH = 200
+		}
+		c.Partitions[id] = p
+	}
+}
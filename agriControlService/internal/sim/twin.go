@@ -0,0 +1,359 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+	"agri-control-service/internal/resolver"
+	"agri-control-service/internal/schedule"
+)
+
+// deviceInfo 是设备所在分区与类型。
+type deviceInfo struct {
+	partition  string
+	deviceType string
+}
+
+// partitionState 是分区的仿真状态。
+type partitionState struct {
+	cfg       PartitionConfig
+	domainID  string
+	channelID string
+	moisture  float64 // 体积含水率 %
+	appliedL  float64 // 累计入流水量 L
+	inflowLPH float64 // 最近一步的入流 L/h
+	etMM      float64 // 累计蒸散 mm
+	drainMM   float64 // 累计渗漏 mm
+}
+
+// Twin 是数字孪生：实现 executor.Driver 接收设备命令，并按仿真时钟推进土壤水分。
+type Twin struct {
+	cfg *Config
+	pub *publisher
+
+	mu           sync.Mutex
+	last         time.Time // 上一次推进到的仿真时间
+	parts        map[string]*partitionState
+	devices      map[string]deviceInfo // clientId -> 分区/类型
+	on           map[string]bool       // 设备开关状态
+	pumps        map[string]float64    // 水泵当前出水 L/h
+	fail         map[string]bool
+	sincePublish time.Duration
+	stop         chan struct{}
+}
+
+// New 根据配置与设备注册表构建孪生；注册表未加载时以分区 ID 作为设备 ID（与 resolver 旧行为一致）。
+func New(cfg *Config) *Twin {
+	t := &Twin{
+		cfg:     cfg,
+		pub:     newPublisher(cfg.Publish),
+		parts:   make(map[string]*partitionState),
+		devices: make(map[string]deviceInfo),
+		on:      make(map[string]bool),
+		pumps:   make(map[string]float64),
+		fail:    make(map[string]bool),
+		stop:    make(chan struct{}),
+	}
+	for id, pc := range cfg.Partitions {
+		t.parts[id] = &partitionState{cfg: pc, moisture: pc.Initial}
+	}
+	for _, loc := range resolver.All() {
+		ps, ok := t.parts[loc.Partition.PartitionID]
+		if !ok {
+			continue
+		}
+		ps.domainID, ps.channelID = loc.DomainID, loc.ChannelID
+		for _, e := range loc.Partition.Executors {
+			t.devices[e.ClientID] = deviceInfo{partition: loc.Partition.PartitionID, deviceType: e.Type()}
+		}
+	}
+	for _, id := range cfg.FailDevices {
+		t.fail[id] = true
+	}
+	return t
+}
+
+// Start 按仿真步长推进状态（真实间隔 = tick / 倍速），并按配置发布模拟读数。
+func (t *Twin) Start() {
+	t.mu.Lock()
+	t.last = clock.Now()
+	t.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(clock.Real(t.cfg.Tick.Duration))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.advance(clock.Now())
+			}
+		}
+	}()
+}
+
+// Stop 停止仿真推进。
+func (t *Twin) Stop() {
+	close(t.stop)
+}
+
+// Send 实现 executor.Driver：更新设备开关状态；故障注入列表中的设备返回错误。
+func (t *Twin) Send(cmd model.DeviceCommand) error {
+	if t.fail[cmd.DeviceID] {
+		return fmt.Errorf("simulated failure on device %s", cmd.DeviceID)
+	}
+	on, known := commandState(cmd.Command)
+	if !known {
+		return fmt.Errorf("sim: unsupported command %q", cmd.Command)
+	}
+
+	// 先把状态推进到当前时刻，再切换阀门，保证入流从命令时刻起算
+	t.advance(clock.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.devices[cmd.DeviceID]; !ok {
+		// 注册表外的设备：target 即分区 ID 时挂到该分区，类型按命令推断
+		if _, isPart := t.parts[cmd.DeviceID]; isPart {
+			t.devices[cmd.DeviceID] = deviceInfo{partition: cmd.DeviceID, deviceType: commandDeviceType(cmd.Command)}
+		}
+	}
+	t.on[cmd.DeviceID] = on
+	return nil
+}
+
+// commandState 把命令映射为设备开/关。
+func commandState(command string) (on bool, known bool) {
+	for _, p := range []string{"open_", "start_", "turn_on_", "deploy_"} {
+		if strings.HasPrefix(command, p) {
+			return true, true
+		}
+	}
+	for _, p := range []string{"close_", "stop_", "turn_off_", "retract_"} {
+		if strings.HasPrefix(command, p) {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// commandDeviceType 根据命令推断设备类型（仅用于注册表外的设备）。
+func commandDeviceType(command string) string {
+	switch {
+	case strings.HasSuffix(command, "_valve"):
+		return "irrigation"
+	case strings.HasSuffix(command, "_mister"):
+		return "mister"
+	}
+	return command
+}
+
+// advance 把仿真状态推进到 now。
+func (t *Twin) advance(now time.Time) {
+	t.mu.Lock()
+	dt := now.Sub(t.last)
+	if dt <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	t.step(dt, now)
+	t.last = now
+	t.sincePublish += dt
+	var batch []message
+	if t.sincePublish >= t.cfg.Publish.Every.Duration {
+		t.sincePublish = 0
+		batch = t.readingsLocked(now)
+	}
+	t.mu.Unlock()
+
+	if len(batch) > 0 {
+		t.pub.publish(now, batch)
+	}
+}
+
+// step 推进 dt：水泵限流 → 阀门入流 → 蒸散（按日变化与水分胁迫）→ 超过田间持水量部分渗漏。
+func (t *Twin) step(dt time.Duration, now time.Time) {
+	hours := dt.Hours()
+
+	// 各分区需水量（L/h）
+	demand := make(map[string]float64, len(t.parts))
+	for id, on := range t.on {
+		if !on {
+			continue
+		}
+		info, ok := t.devices[id]
+		if !ok {
+			continue
+		}
+		ps, ok := t.parts[info.partition]
+		if !ok {
+			continue
+		}
+		switch info.deviceType {
+		case "irrigation":
+			demand[info.partition] += ps.cfg.ValveFlowLPH
+		case "mister":
+			demand[info.partition] += ps.cfg.MisterFlowLPH
+		}
+	}
+
+	// 水泵容量不足时按比例降流
+	factor := make(map[string]float64, len(t.parts))
+	for _, p := range t.cfg.Pumps {
+		total := 0.0
+		for _, pid := range p.Partitions {
+			total += demand[pid]
+		}
+		f := 1.0
+		if p.CapacityLPH > 0 && total > p.CapacityLPH {
+			f = p.CapacityLPH / total
+		}
+		t.pumps[p.ID] = total * f
+		for _, pid := range p.Partitions {
+			if old, ok := factor[pid]; !ok || f < old {
+				factor[pid] = f
+			}
+		}
+	}
+
+	hour := float64(now.In(schedule.Location()).Hour()) + float64(now.In(schedule.Location()).Minute())/60
+	// 蒸散日变化：6~18 点按正弦分布，积分等于日蒸散量
+	diurnal := math.Max(0, math.Sin(math.Pi*(hour-6)/12)) * math.Pi / 24
+
+	for id, ps := range t.parts {
+		c := ps.cfg
+		f, ok := factor[id]
+		if !ok {
+			f = 1
+		}
+		ps.inflowLPH = demand[id] * f
+		inflowL := ps.inflowLPH * hours
+		ps.appliedL += inflowL
+		ps.moisture += inflowL / c.AreaM2 / c.RootDepthMM * 100
+
+		ks := 1.0
+		if c.FieldCapacity > c.WiltingPoint {
+			ks = clamp((ps.moisture-c.WiltingPoint)/(c.FieldCapacity-c.WiltingPoint), 0, 1)
+		}
+		etMM := c.ETmmDay * diurnal * hours * ks
+		ps.etMM += etMM
+		ps.moisture -= etMM / c.RootDepthMM * 100
+
+		if ps.moisture > c.FieldCapacity && c.DrainagePerHour > 0 {
+			drained := (ps.moisture - c.FieldCapacity) * (1 - math.Exp(-c.DrainagePerHour*hours))
+			ps.drainMM += drained * c.RootDepthMM / 100
+			ps.moisture -= drained
+		}
+		ps.moisture = clamp(ps.moisture, 0, c.Saturation)
+	}
+}
+
+// airTemperature 按日变化估算气温（15 点最高）。
+func (t *Twin) airTemperature(now time.Time) float64 {
+	local := now.In(schedule.Location())
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	return t.cfg.AirTempMean + t.cfg.AirTempAmp*math.Sin(2*math.Pi*(hour-9)/24)
+}
+
+// readingsLocked 生成各分区模拟传感器的读数。
+func (t *Twin) readingsLocked(now time.Time) []message {
+	var out []message
+	for _, id := range sortedKeys(t.parts) {
+		ps := t.parts[id]
+		for _, s := range ps.cfg.Sensors {
+			var v float64
+			var unit string
+			switch s.Quantity {
+			case "soil_moisture":
+				v, unit = ps.moisture, "percent"
+			case "air_temperature":
+				v, unit = t.airTemperature(now), "celsius"
+			default:
+				log.Printf("[sim] unsupported sensor quantity %q for %s", s.Quantity, s.Name)
+				continue
+			}
+			out = append(out, message{
+				domainID:  ps.domainID,
+				channelID: ps.channelID,
+				sensor:    s,
+				record:    senmlRecord(s.Name, unit, math.Round(v*100)/100),
+			})
+		}
+	}
+	return out
+}
+
+// PartitionSnapshot 是分区仿真状态快照。
+type PartitionSnapshot struct {
+	PartitionID string  `json:"partition_id"`
+	DomainID    string  `json:"domain_id,omitempty"`
+	ChannelID   string  `json:"channel_id,omitempty"`
+	Moisture    float64 `json:"moisture"`
+	InflowLPH   float64 `json:"inflow_lph"`
+	AppliedL    float64 `json:"applied_l"`
+	ETmm        float64 `json:"et_mm"`
+	DrainageMM  float64 `json:"drainage_mm"`
+}
+
+// Snapshot 是孪生整体状态快照，供 GET /control/sim 查看。
+type Snapshot struct {
+	Now        string              `json:"now"`
+	Speed      float64             `json:"speed"`
+	Partitions []PartitionSnapshot `json:"partitions"`
+	Pumps      map[string]float64  `json:"pumps"`   // 水泵出水 L/h
+	Devices    map[string]bool     `json:"devices"` // 设备开关状态
+}
+
+// Snapshot 推进到当前时刻并返回状态快照。
+func (t *Twin) Snapshot() Snapshot {
+	now := clock.Now()
+	t.advance(now)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snap := Snapshot{
+		Now:     now.Format(time.RFC3339),
+		Speed:   clock.Speed(),
+		Pumps:   make(map[string]float64, len(t.pumps)),
+		Devices: make(map[string]bool, len(t.on)),
+	}
+	for _, id := range sortedKeys(t.parts) {
+		ps := t.parts[id]
+		snap.Partitions = append(snap.Partitions, PartitionSnapshot{
+			PartitionID: id,
+			DomainID:    ps.domainID,
+			ChannelID:   ps.channelID,
+			Moisture:    math.Round(ps.moisture*100) / 100,
+			InflowLPH:   ps.inflowLPH,
+			AppliedL:    math.Round(ps.appliedL),
+			ETmm:        math.Round(ps.etMM*100) / 100,
+			DrainageMM:  math.Round(ps.drainMM*100) / 100,
+		})
+	}
+	for k, v := range t.pumps {
+		snap.Pumps[k] = v
+	}
+	for k, v := range t.on {
+		snap.Devices[k] = v
+	}
+	return snap
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
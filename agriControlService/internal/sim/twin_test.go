@@ -0,0 +1,86 @@
+package sim
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agri-control-service/internal/clock"
+	"agri-control-service/internal/model"
+)
+
+// 仓库自带的 sim.yaml 必须能加载。
+func TestShippedSimConfigLoads(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join("..", "..", "configs", "sim.yaml"))
+	if err != nil {
+		t.Fatalf("加载 configs/sim.yaml 失败: %v", err)
+	}
+	if len(cfg.Partitions) == 0 || cfg.Tick.Duration <= 0 {
+		t.Fatalf("配置 = %+v", cfg)
+	}
+}
+
+// 关闭蒸散与渗漏，只验证水泵限流与入流累计。
+func newTestTwin() *Twin {
+	cfg := &Config{
+		Publish: PublishConfig{Mode: "none"},
+		Partitions: map[string]PartitionConfig{
+			"field-A": {ValveFlowLPH: 3000, Initial: 20},
+			"field-B": {ValveFlowLPH: 1500, Initial: 20},
+		},
+		Pumps:       []PumpConfig{{ID: "pump-1", CapacityLPH: 3000, Partitions: []string{"field-A", "field-B"}}},
+		FailDevices: []string{"valve-broken"},
+	}
+	cfg.applyDefaults()
+	tw := New(cfg)
+	tw.last = clock.Now()
+	return tw
+}
+
+func TestTwinThrottlesSharedPump(t *testing.T) {
+	tw := newTestTwin()
+	for _, id := range []string{"field-A", "field-B"} {
+		if err := tw.Send(model.DeviceCommand{DeviceID: id, Command: "open_valve"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.advance(tw.last.Add(time.Hour))
+
+	snap := tw.Snapshot()
+	if got := snap.Pumps["pump-1"]; got != 3000 {
+		t.Fatalf("水泵出水 = %v L/h，期望限流到 3000", got)
+	}
+	// 需求 4500 L/h 超出容量，两个分区按 2/3 降流
+	want := map[string]float64{"field-A": 2000, "field-B": 1000}
+	for _, p := range snap.Partitions {
+		if p.InflowLPH != want[p.PartitionID] || p.AppliedL != want[p.PartitionID] {
+			t.Fatalf("%s 入流 = %v L/h，累计 %v L，期望 %v", p.PartitionID, p.InflowLPH, p.AppliedL, want[p.PartitionID])
+		}
+	}
+	// 默认面积 1000 m²、根区 300 mm：2000 L 提高含水率约 0.67%
+	if a := snap.Partitions[0]; math.Abs(a.Moisture-20.67) > 0.01 {
+		t.Fatalf("field-A 含水率 = %v，期望约 20.67", a.Moisture)
+	}
+
+	if err := tw.Send(model.DeviceCommand{DeviceID: "field-A", Command: "close_valve"}); err != nil {
+		t.Fatal(err)
+	}
+	tw.advance(tw.last.Add(time.Hour))
+	if got := tw.Snapshot().Pumps["pump-1"]; got != 1500 {
+		t.Fatalf("关闭 A 区后水泵出水 = %v L/h，期望 1500", got)
+	}
+}
+
+func TestTwinRejectsFailedAndUnknownCommands(t *testing.T) {
+	tw := newTestTwin()
+	if err := tw.Send(model.DeviceCommand{DeviceID: "valve-broken", Command: "open_valve"}); err == nil {
+		t.Fatal("故障注入的设备应返回错误")
+	}
+	if err := tw.Send(model.DeviceCommand{DeviceID: "field-A", Command: "calibrate"}); err == nil {
+		t.Fatal("未知命令应返回错误")
+	}
+	if on := tw.Snapshot().Devices["field-A"]; on {
+		t.Fatal("失败的命令不应改变设备状态")
+	}
+}
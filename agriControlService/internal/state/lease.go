@@ -0,0 +1,172 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lease.go：基于共享目录的 leader 租约。
+// 租约记录持有者与到期时间；leader 每 ttl/3 续约一次，standby 以同样频率尝试获取，租约过期即可接管。
+// 读改写租约时对 lease.lock 加排他 flock 互斥：锁随文件描述符释放，持锁进程崩溃时由内核自动释放，
+// 不按修改时间判断失效、也不删除锁文件，两个实例不会同时持锁。共享目录须支持文件锁（本地磁盘或 NFSv4）。
+// 租约使用真实时间（不受仿真倍速影响）。各实例的时钟偏差可能让旧 leader 以为租约仍有效，
+// 因此 epoch 同时作为防护令牌（fencing token）：leader 每次下发命令前用 CheckFence 确认租约仍是自己当选时的 epoch。
+
+// Lease 是 leader 租约。
+type Lease struct {
+	Holder    string    `json:"holder"`
+	Epoch     int64     `json:"epoch"` // 每次换主递增
+	ExpiresAt time.Time `json:"expires_at"`
+	RenewedAt time.Time `json:"renewed_at"`
+}
+
+// ErrLocked 另一实例正在读写租约。
+var ErrLocked = errors.New("lease lock busy")
+
+// ErrFenced 租约已被他人接管或已过期，持有旧 epoch 的实例不得再下发命令或写入状态。
+var ErrFenced = errors.New("lease fenced")
+
+// TryAcquire 在租约空闲、已过期或本就由 holder 持有时获取/续约，返回是否为 leader 与当前租约。
+func (s *Store) TryAcquire(holder string, ttl time.Duration) (bool, Lease, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return false, Lease{}, err
+	}
+	defer unlock()
+
+	var cur Lease
+	if _, err := readJSON(s.leasePath(), &cur); err != nil {
+		return false, Lease{}, err
+	}
+	now := time.Now()
+	if cur.Holder != "" && cur.Holder != holder && now.Before(cur.ExpiresAt) {
+		return false, cur, nil
+	}
+	next := Lease{Holder: holder, Epoch: cur.Epoch, ExpiresAt: now.Add(ttl), RenewedAt: now}
+	if cur.Holder != holder || !now.Before(cur.ExpiresAt) {
+		next.Epoch++
+	}
+	if err := writeJSON(s.leasePath(), next); err != nil {
+		return false, cur, err
+	}
+	return true, next, nil
+}
+
+// Resign 主动释放租约（正常退出时调用），standby 无需等待过期即可接管。
+func (s *Store) Resign(holder string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var cur Lease
+	if _, err := readJSON(s.leasePath(), &cur); err != nil || cur.Holder != holder {
+		return err
+	}
+	cur.ExpiresAt = time.Now()
+	return writeJSON(s.leasePath(), cur)
+}
+
+// CheckFence 确认租约仍由 holder 以 epoch 持有且未过期；否则返回 ErrFenced。
+// 租约文件通过 rename 原子替换，读取无需加锁。
+func (s *Store) CheckFence(holder string, epoch int64) error {
+	var cur Lease
+	if _, err := readJSON(s.leasePath(), &cur); err != nil {
+		return err
+	}
+	if cur.Holder != holder || cur.Epoch != epoch {
+		return fmt.Errorf("%w: lease held by %s at epoch %d, ours is %d", ErrFenced, cur.Holder, cur.Epoch, epoch)
+	}
+	if !time.Now().Before(cur.ExpiresAt) {
+		return fmt.Errorf("%w: lease epoch %d expired at %s", ErrFenced, epoch, cur.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CurrentLease 读取当前租约（不加锁，仅用于展示）。
+func (s *Store) CurrentLease() (Lease, error) {
+	var cur Lease
+	_, err := readJSON(s.leasePath(), &cur)
+	return cur, err
+}
+
+// lock 对 lease.lock 加非阻塞排他锁，已被占用时返回 ErrLocked；返回的 unlock 关闭文件即释放锁。
+// 锁文件始终保留：删除后等待者可能锁在已脱离目录的旧文件上，与新建锁文件的实例同时“持锁”。
+func (s *Store) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, "lease.lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+func (s *Store) leasePath() string {
+	return filepath.Join(s.dir, "lease.json")
+}
+
+// Elector 周期性竞选/续约 leader。
+type Elector struct {
+	Store  *Store
+	ID     string
+	TTL    time.Duration
+	leader bool
+}
+
+// Run 阻塞运行直到 ctx 结束：当选时以新租约调用 onElected，续约失败（租约被他人持有或存储不可用接近 ttl）时调用 onLost。
+// onLost 之后不会再次竞选：leader 的定时器与动作链都在内存中，无法安全地部分撤销，调用方应退出进程，由守护进程以 standby 身份重启。
+func (e *Elector) Run(ctx context.Context, onElected func(Lease), onLost func()) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+	var lastRenew time.Time
+	for {
+		ok, lease, err := e.Store.TryAcquire(e.ID, e.TTL)
+		switch {
+		case err != nil && e.leader:
+			// 存储暂时不可用或锁被占用：仍视为 leader，但须在租约到期前（留出一个续约周期）放弃，避免与新 leader 重叠
+			if !errors.Is(err, ErrLocked) {
+				log.Printf("ha: renew lease failed: %v", err)
+			}
+			if time.Since(lastRenew) >= e.TTL-e.TTL/3 {
+				e.leader = false
+				onLost()
+				return
+			}
+		case err != nil:
+			if !errors.Is(err, ErrLocked) {
+				log.Printf("ha: acquire lease failed: %v", err)
+			}
+		case ok && !e.leader:
+			e.leader = true
+			lastRenew = time.Now()
+			log.Printf("ha: %s elected leader (epoch %d)", e.ID, lease.Epoch)
+			onElected(lease)
+		case ok:
+			lastRenew = time.Now()
+		case e.leader:
+			log.Printf("ha: lease taken over by %s", lease.Holder)
+			e.leader = false
+			onLost()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.leader {
+				if err := e.Store.Resign(e.ID); err != nil {
+					log.Printf("ha: resign failed: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("打开状态目录失败: %v", err)
+	}
+	return st
+}
+
+func TestLeaseTakeoverAfterExpiry(t *testing.T) {
+	st := openStore(t)
+	const ttl = 50 * time.Millisecond
+
+	ok, a, err := st.TryAcquire("a", ttl)
+	if err != nil || !ok || a.Epoch != 1 {
+		t.Fatalf("a 首次获取租约: ok=%v lease=%+v err=%v", ok, a, err)
+	}
+	if ok, cur, _ := st.TryAcquire("b", ttl); ok || cur.Holder != "a" {
+		t.Fatalf("租约未过期时 b 不应接管: ok=%v lease=%+v", ok, cur)
+	}
+	// 续约不改变 epoch
+	if ok, renewed, _ := st.TryAcquire("a", ttl); !ok || renewed.Epoch != a.Epoch {
+		t.Fatalf("a 续约: ok=%v lease=%+v", ok, renewed)
+	}
+	if err := st.CheckFence("a", a.Epoch); err != nil {
+		t.Fatalf("持有中的租约不应被 fence: %v", err)
+	}
+
+	time.Sleep(ttl + 10*time.Millisecond)
+	if err := st.CheckFence("a", a.Epoch); !errors.Is(err, ErrFenced) {
+		t.Fatalf("过期租约应被 fence, err=%v", err)
+	}
+	ok, b, err := st.TryAcquire("b", ttl)
+	if err != nil || !ok || b.Holder != "b" || b.Epoch != a.Epoch+1 {
+		t.Fatalf("b 接管过期租约: ok=%v lease=%+v err=%v", ok, b, err)
+	}
+	// 旧 leader（时钟偏差或暂停后醒来）既不能续约，也通不过 fencing
+	if ok, _, _ := st.TryAcquire("a", ttl); ok {
+		t.Fatal("b 接管后 a 不应再获得租约")
+	}
+	if err := st.CheckFence("a", a.Epoch); !errors.Is(err, ErrFenced) {
+		t.Fatalf("旧 epoch 应被 fence, err=%v", err)
+	}
+	if err := st.CheckFence("b", b.Epoch); err != nil {
+		t.Fatalf("新 leader 不应被 fence: %v", err)
+	}
+}
+
+func TestLeaseResignAllowsImmediateTakeover(t *testing.T) {
+	st := openStore(t)
+	if ok, _, err := st.TryAcquire("a", time.Minute); !ok || err != nil {
+		t.Fatalf("a 获取租约: ok=%v err=%v", ok, err)
+	}
+	if err := st.Resign("a"); err != nil {
+		t.Fatalf("Resign: %v", err)
+	}
+	ok, b, err := st.TryAcquire("b", time.Minute)
+	if err != nil || !ok || b.Epoch != 2 {
+		t.Fatalf("a 释放后 b 应立即接管: ok=%v lease=%+v err=%v", ok, b, err)
+	}
+}
+
+// writeStaleLock 模拟崩溃实例遗留的锁文件（内容任意、修改时间很早）。
+func writeStaleLock(t *testing.T, st *Store) {
+	t.Helper()
+	lock := filepath.Join(st.Dir(), "lease.lock")
+	if err := os.WriteFile(lock, []byte("crashed-instance"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaseStaleLockFile(t *testing.T) {
+	st := openStore(t)
+	writeStaleLock(t, st)
+	// 遗留的锁文件无人持锁，立即可用
+	if ok, _, err := st.TryAcquire("a", time.Minute); !ok || err != nil {
+		t.Fatalf("崩溃遗留的锁文件不应阻塞获取: ok=%v err=%v", ok, err)
+	}
+	unlock, err := st.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := st.TryAcquire("b", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Fatalf("锁被持有时应返回 ErrLocked, err=%v", err)
+	}
+	unlock()
+	if _, err := os.Stat(filepath.Join(st.Dir(), "lease.lock")); err != nil {
+		t.Fatalf("释放锁不应删除锁文件: %v", err)
+	}
+}
+
+// 多个实例同时争抢遗留的锁文件：任一时刻至多一个持锁。
+func TestLeaseLockExclusiveUnderRace(t *testing.T) {
+	st := openStore(t)
+	writeStaleLock(t, st)
+
+	var holders, maxHolders, acquired atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				unlock, err := st.lock()
+				if errors.Is(err, ErrLocked) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				acquired.Add(1)
+				time.Sleep(100 * time.Microsecond)
+				holders.Add(-1)
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if maxHolders.Load() != 1 || acquired.Load() == 0 {
+		t.Fatalf("同时持锁数最大为 %d（获取 %d 次），期望恰为 1", maxHolders.Load(), acquired.Load())
+	}
+}
+
+// 多个 standby 同时接管过期租约：只有一个当选，epoch 只递增一次。
+func TestLeaseTakeoverRaceSingleLeader(t *testing.T) {
+	st := openStore(t)
+	const ttl = 20 * time.Millisecond
+	if ok, _, err := st.TryAcquire("old", ttl); !ok || err != nil {
+		t.Fatalf("old 获取租约: ok=%v err=%v", ok, err)
+	}
+	time.Sleep(ttl + 5*time.Millisecond)
+	writeStaleLock(t, st)
+
+	var winners sync.Map
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for {
+				ok, lease, err := st.TryAcquire(id, time.Minute)
+				if errors.Is(err, ErrLocked) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+				} else if ok {
+					winners.Store(id, lease.Epoch)
+				}
+				return
+			}
+		}(fmt.Sprintf("s%d", g))
+	}
+	wg.Wait()
+	n := 0
+	winners.Range(func(id, epoch any) bool {
+		n++
+		if epoch.(int64) != 2 {
+			t.Errorf("%v 当选 epoch=%v，期望 2", id, epoch)
+		}
+		return true
+	})
+	if n != 1 {
+		t.Fatalf("当选实例数 = %d，期望 1", n)
+	}
+}
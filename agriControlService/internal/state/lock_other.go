@@ -0,0 +1,13 @@
+//go:build !unix
+
+package state
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile 非 Unix 平台不支持共享目录的租约锁，高可用模式不可用。
+func lockFile(f *os.File) error {
+	return errors.New("lease lock: file locking is not supported on this platform")
+}
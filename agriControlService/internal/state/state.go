@@ -0,0 +1,201 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"agri-control-service/internal/model"
+)
+
+// state 包：多实例共享的任务状态存储与 leader 租约（高可用模式）。
+// 存储为共享目录（如 NFS 挂载）中的 JSON 文件，写入采用“临时文件 + rename”保证原子性：
+//   - tasks/<task_id>.json：任务、已展开的动作链、下一步下标与恢复时间、执行状态
+//   - overrides.json：生效中的人工接管
+//   - rotations/<group_id>.json：已提交的轮灌计划
+//   - lease.json：leader 租约（见 lease.go）
+//
+// leader 在每次状态变化时写入，standby 接管时读出未完成的任务继续执行。
+
+// Record 是一个任务的持久化状态。
+// Next 为 0 表示动作链尚未开始（接管后重新规划）；大于 0 表示执行到一半，从 Steps[Next] 继续，
+// ResumeAt 非空时表示处于 wait 中，到该时间后再继续。
+type Record struct {
+	Task     model.Task        `json:"task"`
+	Steps    []model.Step      `json:"steps,omitempty"`
+	Next     int               `json:"next"`
+	ResumeAt string            `json:"resume_at,omitempty"` // RFC3339Nano
+	Outcome  model.TaskOutcome `json:"outcome"`
+}
+
+// Done 表示任务已到终态，不需要接管。
+func (r Record) Done() bool {
+	return r.Outcome.Done()
+}
+
+// Store 是基于共享目录的状态存储。
+type Store struct {
+	dir string
+}
+
+// Open 打开（必要时创建）状态目录。
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"tasks", "rotations"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("create state dir: %w", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir 返回状态目录。
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// SaveTask 写入任务状态。
+func (s *Store) SaveTask(rec Record) error {
+	if !validID(rec.Task.TaskID) {
+		return fmt.Errorf("invalid task id %q", rec.Task.TaskID)
+	}
+	return writeJSON(s.taskPath(rec.Task.TaskID), rec)
+}
+
+// LoadTask 读取单个任务状态。
+func (s *Store) LoadTask(taskID string) (Record, bool, error) {
+	if !validID(taskID) {
+		return Record{}, false, nil
+	}
+	var rec Record
+	ok, err := readJSON(s.taskPath(taskID), &rec)
+	return rec, ok, err
+}
+
+// LoadTasks 读取全部任务状态；单个文件损坏时跳过并返回第一个错误。
+func (s *Store) LoadTasks() ([]Record, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "tasks", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	var firstErr error
+	for _, f := range files {
+		var rec Record
+		if _, err := readJSON(f, &rec); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, firstErr
+}
+
+// SaveOverrides 覆盖写入生效中的人工接管。
+func (s *Store) SaveOverrides(list []model.Override) error {
+	return writeJSON(filepath.Join(s.dir, "overrides.json"), list)
+}
+
+// LoadOverrides 读取人工接管；文件不存在时返回空。
+func (s *Store) LoadOverrides() ([]model.Override, error) {
+	var list []model.Override
+	_, err := readJSON(filepath.Join(s.dir, "overrides.json"), &list)
+	return list, err
+}
+
+// SaveRotation 写入已提交的轮灌计划。
+func (s *Store) SaveRotation(plan model.RotationPlan) error {
+	if !validID(plan.GroupID) {
+		return fmt.Errorf("invalid group id %q", plan.GroupID)
+	}
+	return writeJSON(s.rotationPath(plan.GroupID), plan)
+}
+
+// LoadRotation 读取单个轮灌计划。
+func (s *Store) LoadRotation(groupID string) (model.RotationPlan, bool, error) {
+	if !validID(groupID) {
+		return model.RotationPlan{}, false, nil
+	}
+	var plan model.RotationPlan
+	ok, err := readJSON(s.rotationPath(groupID), &plan)
+	return plan, ok, err
+}
+
+// LoadRotations 读取全部轮灌计划；单个文件损坏时跳过并返回第一个错误。
+func (s *Store) LoadRotations() ([]model.RotationPlan, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "rotations", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []model.RotationPlan
+	var firstErr error
+	for _, f := range files {
+		var plan model.RotationPlan
+		if _, err := readJSON(f, &plan); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out = append(out, plan)
+	}
+	return out, firstErr
+}
+
+func (s *Store) rotationPath(groupID string) string {
+	return filepath.Join(s.dir, "rotations", groupID+".json")
+}
+
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`)
+}
+
+func (s *Store) taskPath(taskID string) string {
+	return filepath.Join(s.dir, "tasks", taskID+".json")
+}
+
+// writeJSON 先写同目录临时文件再 rename，读方不会看到写了一半的文件。
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readJSON 读取并解码文件；文件不存在时返回 false 且无错误。
+func readJSON(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return true, nil
+}
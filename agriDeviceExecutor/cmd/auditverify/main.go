@@ -0,0 +1,59 @@
+package main
+
+import (
+	"agriDeviceExecutor/internal/data"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// auditverify 校验执行层审计日志的哈希链与签名检查点；发现篡改或截断时以非零状态退出。
+// 默认按顺序校验轮转后的全部分段（audit-*.log 与当前 audit.log），链须跨分段连续；
+// 指定 -checkpoints 时只校验 -log 这一个文件（链须从 seq 1 开始）。
+// 用法（在 agriDeviceExecutor 目录下）：go run ./cmd/auditverify
+func main() {
+	defLog, defPub := data.DefaultAuditPaths()
+	logPath := flag.String("log", defLog, "审计日志（jsonl）")
+	cpPath := flag.String("checkpoints", "", "检查点文件；指定时只校验 -log 单个文件")
+	pubPath := flag.String("pubkey", defPub, "ed25519 公钥（hex）；为空则只校验哈希链")
+	asJSON := flag.Bool("json", false, "以 JSON 输出校验结果")
+	flag.Parse()
+
+	var pub ed25519.PublicKey
+	if *pubPath != "" {
+		p, err := data.LoadAuditPublicKey(*pubPath)
+		if err != nil {
+			log.Fatalf("加载公钥失败: %v", err)
+		}
+		pub = p
+	}
+
+	var rep data.AuditVerifyReport
+	var err error
+	if *cpPath != "" {
+		rep, err = data.VerifyAudit(*logPath, *cpPath, pub)
+	} else {
+		rep, err = data.VerifyAuditSegments(*logPath, pub)
+	}
+	if err != nil {
+		log.Fatalf("校验失败: %v", err)
+	}
+	if *asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(rep)
+	} else {
+		fmt.Printf("segments=%d records=%d legacy=%d pruned=%d lastSeq=%d checkpoints=%d\n",
+			rep.Segments, rep.Records, rep.Legacy, rep.Pruned, rep.LastSeq, rep.Checkpoints)
+		for _, p := range rep.Problems {
+			fmt.Println("PROBLEM:", p)
+		}
+		if rep.OK() {
+			fmt.Println("OK: 哈希链与检查点完整")
+		}
+	}
+	if !rep.OK() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"agriDeviceExecutor/internal/config"
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// encryptsecret 用执行层加密密钥（EXECUTOR_SECRET_KEY / EXECUTOR_SECRET_KEY_FILE，见 internal/config/secrets.go）
+// 加密从标准输入读取的一行明文，输出 enc:v1: 值，手工填入 config.json 的 password / userToken 等字段。
+// 用法（在 agriDeviceExecutor 目录下）：echo -n '明文' | go run ./cmd/encryptsecret
+func main() {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("读取明文失败: %v", err)
+	}
+	plain := strings.TrimRight(line, "\r\n")
+	if plain == "" {
+		log.Fatal("明文为空")
+	}
+	enc, err := config.EncryptSecret(plain)
+	if err != nil {
+		log.Fatalf("加密失败: %v", err)
+	}
+	fmt.Println(enc)
+}
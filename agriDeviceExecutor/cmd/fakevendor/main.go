@@ -0,0 +1,31 @@
+package main
+
+import (
+	"agriDeviceExecutor/internal/fakevendor"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+// fakevendor 以独立进程运行第三方灌溉平台的模拟服务（示例设备见 fakevendor.SeedDemo），
+// 用于离线联调：把 config.json 的 agriPlatform.baseUrl 指向本服务，账号密码与 -user / -pwd 一致。
+// 用法（在 agriDeviceExecutor 目录下）：go run ./cmd/fakevendor -addr :9911
+func main() {
+	addr := flag.String("addr", ":9911", "监听地址")
+	user := flag.String("user", fakevendor.DefaultLoginName, "登录账号")
+	pwd := flag.String("pwd", fakevendor.DefaultLoginPwd, "登录密码")
+	ttl := flag.Duration("tokenTTL", 2*time.Hour, "token 有效期")
+	delay := flag.Duration("actuationDelay", 2*time.Second, "阀门动作延迟")
+	flag.Parse()
+
+	srv := fakevendor.New(fakevendor.Options{
+		LoginName:      *user,
+		LoginPwd:       *pwd,
+		TokenTTL:       *ttl,
+		ActuationDelay: *delay,
+	})
+	srv.SeedDemo()
+	log.Printf("[fakevendor] 模拟平台监听 %s（账号 %s）", *addr, *user)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
@@ -11,12 +11,29 @@ import (
 )
 
 func main() {
-	// 启动前先加载已有映射，避免重复注册
+	// 启动前先打开映射数据库（首次启动自动导入 JSON 映射），避免重复注册
 	if err := data.LoadMapping(""); err != nil {
-		log.Printf("[startup] 加载映射失败: %v", err)
-	} else {
-		log.Printf("[startup] 已加载执行映射（节点级）")
+		log.Fatalf("[startup] 加载映射失败: %v", err)
 	}
+	log.Printf("[startup] 已加载执行映射（节点级）")
+	// 从旧版 JSON 映射导入的 secret 曾以明文入库，须在 Magistrala 与映射中一并轮换
+	go service.RunSecretRotation()
+
+	// 审计日志定期写签名检查点（哈希链校验：go run ./cmd/auditverify）
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			if err := data.CheckpointAudit(); err != nil {
+				log.Printf("[audit] 写检查点失败: %v", err)
+			}
+		}
+	}()
+
+	// 后台阀门状态对账：回读实际状态，发现绕过本系统的操作（漂移）时写审计并推送 webhook。
+	// 首轮在一个周期后执行，会话未登录时由 SessionToken 自动登录。
+	go service.RunReconciler()
+	go service.RunCommandSubscriber()
+	// 阀门定时关闭：到期（含停机期间已到期）的阀门由执行层自动关闭，不依赖控制服务存活。
+	go service.RunDeadman()
 
 	mux := api.SetupMux()
 
@@ -38,7 +55,7 @@ func main() {
 		time.Sleep(500 * time.Millisecond)
 
 		// 1) 直接调用第三方登录逻辑（参考 global_service.go）
-		//    成功后会把 token 持久化到 config.json（agriPlatform.userToken）
+		//    成功后会把 token 加密保存到会话文件（config.TokenPath，默认 internal/data/vendor_token）
 		log.Println("[startup] 执行第三方平台登录...")
 		if _, err := service.UserLogin("", ""); err != nil {
 			log.Printf("[startup] 登录失败，跳过首次同步: %v", err)
@@ -46,8 +63,8 @@ func main() {
 		}
 		log.Println("[startup] 登录成功")
 
-		// 2) 读取严格版 token 与基础地址
-		token, err := config.GetUserToken()
+		// 2) 读取会话 token 与基础地址（之后的第三方请求由会话自动续期，token 失效时重新登录并重试）
+		token, err := service.SessionToken()
 		if err != nil {
 			log.Printf("[startup] 读取 token 失败，跳过首次同步: %v", err)
 			return
@@ -60,25 +77,25 @@ func main() {
 
 		// 3) 执行一次全量同步（发现设备与节点，并向 Magistrala 注册缺失的 client）
 		log.Println("[startup] 开始首次设备/节点同步...")
-		if err := service.SyncAll(token, baseURL); err != nil {
+		if rep, err := service.SyncAll(token, baseURL, false, data.Origin{Source: data.SourceStartup}); err != nil {
 			log.Printf("[startup] 首次同步失败: %v", err)
 		} else {
-			log.Printf("[startup] 首次同步完成")
+			log.Printf("[startup] 首次同步完成: 新增=%d 变化=%d 孤儿=%d", rep.Added, rep.Changed, rep.Orphaned)
 		}
 
 		// 4) 可选：周期增量同步（如每 5 分钟）
 		ticker := time.NewTicker(5 * time.Minute)
 		for range ticker.C {
-			// 每轮前读取最新 token（避免过期）
-			token, err = config.GetUserToken()
+			// 每轮前取会话 token（临近 expDate 时会先主动重新登录）
+			token, err = service.SessionToken()
 			if err != nil {
 				log.Printf("[sync] 读取 token 失败，跳过本轮: %v", err)
 				continue
 			}
-			if err := service.SyncAll(token, baseURL); err != nil {
+			if rep, err := service.SyncAll(token, baseURL, false, data.Origin{Source: data.SourceSync}); err != nil {
 				log.Printf("[sync] 周期同步失败: %v", err)
 			} else {
-				log.Printf("[sync] 周期同步完成")
+				log.Printf("[sync] 周期同步完成: 新增=%d 变化=%d 孤儿=%d", rep.Added, rep.Changed, rep.Orphaned)
 			}
 		}
 	}()
@@ -0,0 +1,858 @@
+package api_test
+
+import (
+	"agriDeviceExecutor/internal/api"
+	"agriDeviceExecutor/internal/data"
+	"agriDeviceExecutor/internal/fakevendor"
+	"agriDeviceExecutor/internal/service"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 端到端测试：执行层路由（api.SetupMux）对接 fakevendor 模拟平台，全部在临时目录中运行，
+// 不访问真实平台与 Magistrala（config.json、映射库、审计日志均为临时文件）。
+
+const (
+	devAddr  = "21131734"
+	valveA   = 10001
+	valveB   = 10002
+	clientA  = "e2e-client-a"
+	clientB  = "e2e-client-b"
+	clientR  = "e2e-client-relay"
+	relayDev = 40012345
+	e2eLogin = "e2e-user"
+	e2ePwd   = "e2e-pwd"
+	// e2eStateKey 模拟平台阀门节点的状态字段名（平台文档未定义，部署时按真实响应配置）
+	e2eStateKey = "valveState"
+)
+
+// e2eEnv 是单个测试独占的环境：临时工作目录（config.json、映射库、审计日志）、模拟平台与执行层服务。
+type e2eEnv struct {
+	vendor   *fakevendor.Server
+	executor *httptest.Server
+}
+
+// newEnv 为测试建立独立环境并切换到其临时目录；测试结束时关闭服务、映射库，并丢弃进程内的平台会话与审计链头。
+func newEnv(t *testing.T) *e2eEnv {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("EXECUTOR_SECRET_KEY", strings.Repeat("ab", 32))
+	t.Setenv("CONFIG_PATH", filepath.Join(dir, "internal/config/config.json"))
+
+	// 状态字段名与默认值不同，验证执行层只按 agriPlatform.nodeStateKey 读取
+	vendor := fakevendor.New(fakevendor.Options{LoginName: e2eLogin, LoginPwd: e2ePwd, StateKey: e2eStateKey})
+	vendor.AddDevice(devAddr, "测试控制器",
+		fakevendor.Node{NodeId: 1, NodeName: "土壤温湿度", FactorType: fakevendor.FactorSensor},
+		fakevendor.Node{NodeId: valveA, NodeName: "A区阀门", FactorType: fakevendor.FactorValve},
+		fakevendor.Node{NodeId: valveB, NodeName: "B区阀门", FactorType: fakevendor.FactorValve},
+	)
+	vendor.AddRelayDevice(relayDev, "测试环境监控", fakevendor.Relay{RelayNo: 1, RelayName: "风机"})
+	vendorSrv := httptest.NewServer(vendor)
+	t.Cleanup(vendorSrv.Close)
+
+	writeFile(t, filepath.Join(dir, "internal/config/config.json"), map[string]any{
+		"agriPlatform": map[string]any{
+			"baseUrl":           vendorSrv.URL,
+			"username":          e2eLogin,
+			"password":          e2ePwd,
+			"confirmTimeoutSec": 2,
+			// 平台文档未定义 token 失效 code，部署时按平台确认的值配置；此处与模拟平台一致
+			"authFailCodes": []int{fakevendor.DefaultAuthFailCode},
+			"nodeStateKey":  e2eStateKey,
+		},
+		"magistrala": map[string]any{
+			"domainId":            "e2e-domain",
+			"channelId":           "e2e-channel",
+			"disableStatePublish": true,
+		},
+		"relayPlatform": map[string]any{
+			"baseUrl":  vendorSrv.URL,
+			"username": e2eLogin,
+			"password": e2ePwd,
+		},
+		"reconcile": map[string]any{"intervalSec": -1},
+		// 测试客户端经回环地址访问，信任其 X-Request-Source 头
+		"audit": map[string]any{"trustedProxies": []string{"127.0.0.1", "::1"}},
+	})
+	// 映射库首次打开时导入该 JSON，无需经 Magistrala 注册 client；传感器节点 1 不建映射
+	now := time.Now().Unix()
+	writeFile(t, filepath.Join(dir, "internal/data/executor_mapping.json"), []map[string]any{
+		{"deviceAddr": devAddr, "nodeId": valveA, "clientId": clientA, "clientSecret": "secret-a", "status": "new", "updatedAt": now},
+		{"deviceAddr": devAddr, "nodeId": valveB, "clientId": clientB, "clientSecret": "secret-b", "status": "new", "updatedAt": now},
+		{"deviceAddr": data.RelayDeviceAddr(fmt.Sprint(relayDev)), "nodeId": 1, "actuator": data.ActuatorRelay,
+			"clientId": clientR, "clientSecret": "secret-r", "status": "new", "updatedAt": now},
+	})
+	service.ResetSessions()
+	data.ReloadAudit()
+	if err := data.LoadMapping(""); err != nil {
+		t.Fatalf("打开映射库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = data.CloseMapping()
+		service.ResetSessions()
+		data.ReloadAudit()
+	})
+
+	executor := httptest.NewServer(api.SetupMux())
+	t.Cleanup(executor.Close)
+	return &e2eEnv{vendor: vendor, executor: executor}
+}
+
+// fakeClock 是测试用的可推进时钟，经 service.SetClock 注入执行层。
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// useFakeClock 以当前时间为起点替换执行层时钟，测试结束时恢复。
+func useFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{now: time.Now()}
+	t.Cleanup(service.SetClock(c.Now))
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func writeFile(t *testing.T, path string, v any) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.MarshalIndent(v, "", "  ")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// result 是执行层统一响应。
+type result struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// call 请求执行层，返回 HTTP 状态码与解析后的响应。
+func (e *e2eEnv) call(t *testing.T, method, path string, body any) (int, result) {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, e.executor.URL+path, rd)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Request-Source", "e2e")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var res result
+	raw, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(raw, &res); err != nil {
+		t.Fatalf("%s %s: 响应不是 JSON: %s", method, path, raw)
+	}
+	return resp.StatusCode, res
+}
+
+// mustOK 断言响应为 200 / 1000，并把 data 解析到 out（可为 nil）。
+func (e *e2eEnv) mustOK(t *testing.T, method, path string, body, out any) {
+	t.Helper()
+	status, res := e.call(t, method, path, body)
+	if status != http.StatusOK || res.Code != 1000 {
+		t.Fatalf("%s %s: status=%d code=%d message=%s", method, path, status, res.Code, res.Message)
+	}
+	if out != nil {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			t.Fatalf("%s %s: 解析 data 失败: %v (%s)", method, path, err, res.Data)
+		}
+	}
+}
+
+type valveResult struct {
+	Confirm  string `json:"confirm"`
+	Observed string `json:"observed"`
+	Error    string `json:"error"`
+}
+
+func (e *e2eEnv) switchState(t *testing.T, nodeId int) int {
+	t.Helper()
+	n, ok := e.vendor.Node(devAddr, nodeId)
+	if !ok {
+		t.Fatalf("模拟平台缺少节点 %d", nodeId)
+	}
+	return n.SwitchState
+}
+
+func TestLoginAndGetUser(t *testing.T) {
+	e := newEnv(t)
+	var login map[string]any
+	e.mustOK(t, http.MethodPost, "/entrance/user/userLogin", nil, &login)
+	if login["token"] == nil || login["token"] == "" {
+		t.Fatalf("登录未返回 token: %v", login)
+	}
+	var user map[string]any
+	e.mustOK(t, http.MethodGet, "/entrance/user/getUser", nil, &user)
+	if user["loginName"] != e2eLogin {
+		t.Fatalf("loginName=%v", user["loginName"])
+	}
+}
+
+func TestDeviceAndNodeLists(t *testing.T) {
+	e := newEnv(t)
+	var devices []map[string]any
+	e.mustOK(t, http.MethodGet, "/entrance/device/getSysUserDevice", nil, &devices)
+	if len(devices) != 1 || devices[0]["deviceAddr"] != devAddr {
+		t.Fatalf("设备列表=%v", devices)
+	}
+	var nodes []map[string]any
+	e.mustOK(t, http.MethodGet, "/irrigation/node/getDeviceNodeList?devAddr="+devAddr, nil, &nodes)
+	if len(nodes) != 3 {
+		t.Fatalf("节点数=%d，期望 3", len(nodes))
+	}
+	var details []map[string]any
+	e.mustOK(t, http.MethodGet, "/irrigation/node/getDeviceIii?devAddr="+devAddr, nil, &details)
+	if len(details) != 1 {
+		t.Fatalf("设备详情=%v", details)
+	}
+	if list, _ := details[0]["irrigationNodeDOList"].([]any); len(list) != 3 {
+		t.Fatalf("设备详情节点数=%d，期望 3", len(list))
+	}
+}
+
+func TestValveControlConfirmed(t *testing.T) {
+	e := newEnv(t)
+	var res valveResult
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, &res)
+	if res.Confirm != data.ConfirmConfirmed || res.Observed != "on" {
+		t.Fatalf("open 结果=%+v", res)
+	}
+	if e.switchState(t, valveA) != 1 {
+		t.Fatal("模拟平台阀门未打开")
+	}
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, &res)
+	if res.Confirm != data.ConfirmConfirmed || e.switchState(t, valveA) != 0 {
+		t.Fatalf("close 结果=%+v state=%d", res, e.switchState(t, valveA))
+	}
+	m, _ := data.GetEntryByClientId(clientA)
+	if m.Confirm != data.ConfirmConfirmed {
+		t.Fatalf("映射 confirm=%s", m.Confirm)
+	}
+}
+
+func TestValveControlStuckFails(t *testing.T) {
+	e := newEnv(t)
+	if err := e.vendor.SetStuck(devAddr, valveB, true); err != nil {
+		t.Fatal(err)
+	}
+	defer e.vendor.SetStuck(devAddr, valveB, false)
+
+	status, res := e.call(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientB, "action": "open"})
+	if status != http.StatusInternalServerError {
+		t.Fatalf("卡死阀门 status=%d，期望 500", status)
+	}
+	var vr valveResult
+	_ = json.Unmarshal(res.Data, &vr)
+	if vr.Confirm != data.ConfirmFailed || vr.Observed != "off" {
+		t.Fatalf("卡死阀门结果=%+v", vr)
+	}
+}
+
+func TestValveControlUnknownClient(t *testing.T) {
+	e := newEnv(t)
+	status, _ := e.call(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": "no-such-client", "action": "open"})
+	if status != http.StatusBadRequest {
+		t.Fatalf("status=%d，期望 400", status)
+	}
+}
+
+func TestModeUpdate(t *testing.T) {
+	e := newEnv(t)
+	e.mustOK(t, http.MethodPost, "/executor/modeUpdate", map[string]string{"clientId": clientA, "mode": "2"}, nil)
+	if n, _ := e.vendor.Node(devAddr, valveA); n.Mode != "2" {
+		t.Fatalf("模拟平台 mode=%s，期望 2", n.Mode)
+	}
+	e.mustOK(t, http.MethodPost, "/executor/modeUpdate", map[string]string{"clientId": clientA, "mode": "1"}, nil)
+}
+
+func TestTokenExpiryRelogin(t *testing.T) {
+	e := newEnv(t)
+	e.mustOK(t, http.MethodGet, "/entrance/user/getUser", nil, nil) // 确保已有会话
+	before := e.vendor.Logins()
+	e.vendor.ExpireTokens()
+
+	var res valveResult
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, &res)
+	if res.Confirm != data.ConfirmConfirmed {
+		t.Fatalf("重新登录后控制结果=%+v", res)
+	}
+	if e.vendor.Logins() != before+1 {
+		t.Fatalf("登录次数 %d → %d，期望恰好重新登录一次", before, e.vendor.Logins())
+	}
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+}
+
+func TestBusinessCodeFault(t *testing.T) {
+	e := newEnv(t)
+	defer e.vendor.ClearFaults()
+	e.vendor.InjectFault(fakevendor.Fault{Endpoint: "manualControlValve", Code: 2001, Message: "设备离线"})
+
+	status, res := e.call(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"})
+	if status != http.StatusInternalServerError || !strings.Contains(res.Message, "设备离线") {
+		t.Fatalf("status=%d message=%s", status, res.Message)
+	}
+	if e.switchState(t, valveA) != 0 {
+		t.Fatal("业务错误时阀门状态不应变化")
+	}
+}
+
+func TestTransientFaultRetried(t *testing.T) {
+	e := newEnv(t)
+	defer e.vendor.ClearFaults()
+	before := e.vendor.Calls("getDeviceNodeList")
+	e.vendor.InjectFault(fakevendor.Fault{Endpoint: "getDeviceNodeList", HTTPStatus: http.StatusServiceUnavailable, Times: 1})
+
+	e.mustOK(t, http.MethodGet, "/irrigation/node/getDeviceNodeList?devAddr="+devAddr, nil, nil)
+	if got := e.vendor.Calls("getDeviceNodeList") - before; got != 2 {
+		t.Fatalf("调用次数=%d，期望 503 后重试一次", got)
+	}
+}
+
+func TestLatencyFault(t *testing.T) {
+	e := newEnv(t)
+	defer e.vendor.ClearFaults()
+	e.vendor.InjectFault(fakevendor.Fault{Endpoint: "getUser", Latency: 300 * time.Millisecond, Times: 1})
+
+	start := time.Now()
+	e.mustOK(t, http.MethodGet, "/entrance/user/getUser", nil, nil)
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("耗时 %s，期望不少于注入的延迟", d)
+	}
+}
+
+func TestHistoryAndRegulating(t *testing.T) {
+	e := newEnv(t)
+	end := time.Now()
+	start := end.Add(-2 * time.Hour)
+	q := fmt.Sprintf("/irrigation/node/getHistoryDataList?deviceAddr=%s&startTime=%s&endTime=%s&pages=1&limit=5&nodeId=1",
+		devAddr, urlTime(start), urlTime(end))
+	var hist struct {
+		Total int              `json:"total"`
+		Rows  []map[string]any `json:"rows"`
+	}
+	e.mustOK(t, http.MethodGet, q, nil, &hist)
+	if hist.Total < 11 || len(hist.Rows) != 5 {
+		t.Fatalf("history total=%d rows=%d", hist.Total, len(hist.Rows))
+	}
+
+	fid := fmt.Sprintf("%s_%d", devAddr, valveA)
+	var items []map[string]any
+	e.mustOK(t, http.MethodGet, "/irrigation/factor/getIrrigationFactorRegulating?factorId="+fid, nil, &items)
+	if len(items) != 2 {
+		t.Fatalf("遥调项=%v", items)
+	}
+	e.mustOK(t, http.MethodPost, "/irrigation/factor/replaceTbIrrigationFactorRegulating", map[string]any{
+		"listTbIrrigationFactorRegulating": []map[string]any{{"factorId": fid, "regularValue": 50, "regularText": "半开"}},
+	}, nil)
+	e.mustOK(t, http.MethodGet, "/irrigation/factor/getIrrigationFactorRegulating?factorId="+fid, nil, &items)
+	if len(items) != 1 || items[0]["regularText"] != "半开" {
+		t.Fatalf("替换后遥调项=%v", items)
+	}
+}
+
+func urlTime(t time.Time) string {
+	return strings.ReplaceAll(t.Format("2006-01-02 15:04:05"), " ", "%20")
+}
+
+func TestRefreshDryRun(t *testing.T) {
+	e := newEnv(t)
+	var rep struct {
+		DryRun bool `json:"dryRun"`
+		Added  int  `json:"added"`
+		Items  []struct {
+			DeviceAddr string `json:"deviceAddr"`
+			NodeId     int    `json:"nodeId"`
+			Class      string `json:"class"`
+		} `json:"items"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/nodes/refresh?dryRun=true", nil, &rep)
+	if !rep.DryRun || rep.Added != 1 {
+		t.Fatalf("dryRun 报告=%+v", rep)
+	}
+	for _, it := range rep.Items {
+		if it.DeviceAddr == devAddr && it.NodeId == 1 && it.Class != "added" {
+			t.Fatalf("传感器节点分类=%s，期望 added", it.Class)
+		}
+	}
+	if _, ok := data.GetEntry(devAddr, 1); ok {
+		t.Fatal("dryRun 不应建立映射")
+	}
+}
+
+func TestAuditRecordsControl(t *testing.T) {
+	e := newEnv(t)
+	e.mustOK(t, http.MethodPost, "/executor/modeUpdate", map[string]string{"clientId": clientB, "mode": "1"}, nil)
+
+	var page struct {
+		Total int                `json:"total"`
+		Items []data.AuditRecord `json:"items"`
+	}
+	e.mustOK(t, http.MethodGet, "/executor/audit?clientId="+clientB+"&action=modeUpdate&source=e2e", nil, &page)
+	if page.Total == 0 || !page.Items[0].Success || page.Items[0].Remote == "" {
+		t.Fatalf("审计查询=%+v", page)
+	}
+}
+
+type batchReport struct {
+	Aborted    bool `json:"aborted"`
+	Succeeded  int  `json:"succeeded"`
+	Failed     int  `json:"failed"`
+	Skipped    int  `json:"skipped"`
+	RolledBack int  `json:"rolledBack"`
+	Items      []struct {
+		ClientId   string `json:"clientId"`
+		Status     string `json:"status"`
+		Reason     string `json:"reason"`
+		RolledBack bool   `json:"rolledBack"`
+	} `json:"items"`
+}
+
+func TestBatchControlPartial(t *testing.T) {
+	e := newEnv(t)
+	var rep batchReport
+	status, res := e.call(t, http.MethodPost, "/executor/batchControl", map[string]any{"items": []map[string]string{
+		{"clientId": clientA, "action": "open"},
+		{"clientId": clientB, "action": "open"},
+		{"clientId": clientA, "action": "close"},
+		{"clientId": "no-such-client", "action": "open"},
+	}})
+	_ = json.Unmarshal(res.Data, &rep)
+	if status != http.StatusOK || res.Message != "partial" {
+		t.Fatalf("status=%d message=%s", status, res.Message)
+	}
+	if rep.Succeeded != 2 || rep.Skipped != 1 || rep.Failed != 1 || rep.Items[2].Reason != "duplicate" {
+		t.Fatalf("批量报告=%+v", rep)
+	}
+	if e.switchState(t, valveA) != 1 || e.switchState(t, valveB) != 1 {
+		t.Fatal("模拟平台阀门未全部打开")
+	}
+	e.mustOK(t, http.MethodPost, "/executor/batchControl", map[string]any{"items": []map[string]string{
+		{"clientId": clientA, "action": "close"},
+		{"clientId": clientB, "action": "close"},
+	}}, &rep)
+	if rep.Succeeded != 2 || e.switchState(t, valveA) != 0 || e.switchState(t, valveB) != 0 {
+		t.Fatalf("批量关闭报告=%+v", rep)
+	}
+}
+
+func TestBatchControlAllOrNothingRollback(t *testing.T) {
+	e := newEnv(t)
+	if err := e.vendor.SetStuck(devAddr, valveB, true); err != nil {
+		t.Fatal(err)
+	}
+	defer e.vendor.SetStuck(devAddr, valveB, false)
+
+	var rep batchReport
+	status, res := e.call(t, http.MethodPost, "/executor/batchControl", map[string]any{
+		"allOrNothing": true,
+		"items": []map[string]string{
+			{"clientId": clientA, "action": "open"},
+			{"clientId": clientB, "action": "open"},
+		},
+	})
+	_ = json.Unmarshal(res.Data, &rep)
+	if status != http.StatusInternalServerError || !rep.Aborted {
+		t.Fatalf("status=%d 报告=%+v", status, rep)
+	}
+	if !rep.Items[0].RolledBack || rep.RolledBack != 1 || e.switchState(t, valveA) != 0 {
+		t.Fatalf("未回滚已打开的阀门: %+v state=%d", rep, e.switchState(t, valveA))
+	}
+}
+
+func TestBatchControlAllOrNothingPrecheck(t *testing.T) {
+	e := newEnv(t)
+	before := e.vendor.Calls("manualControlValve")
+	var rep batchReport
+	status, res := e.call(t, http.MethodPost, "/executor/batchControl", map[string]any{
+		"allOrNothing": true,
+		"items": []map[string]string{
+			{"clientId": clientA, "action": "open"},
+			{"clientId": "no-such-client", "action": "open"},
+		},
+	})
+	_ = json.Unmarshal(res.Data, &rep)
+	if status != http.StatusBadRequest || rep.Aborted || rep.Items[0].Reason != "aborted" {
+		t.Fatalf("status=%d 报告=%+v", status, rep)
+	}
+	// 只有重复条目（不可执行但不是参数错误）时为 409
+	status, res = e.call(t, http.MethodPost, "/executor/batchControl", map[string]any{
+		"allOrNothing": true,
+		"items": []map[string]string{
+			{"clientId": clientA, "action": "open"},
+			{"clientId": clientA, "action": "close"},
+		},
+	})
+	_ = json.Unmarshal(res.Data, &rep)
+	if status != http.StatusConflict || rep.Items[1].Reason != "duplicate" {
+		t.Fatalf("status=%d 报告=%+v", status, rep)
+	}
+	if e.vendor.Calls("manualControlValve") != before {
+		t.Fatal("预检失败时不应下发任何命令")
+	}
+}
+
+func TestDeadmanClosesExpiredValve(t *testing.T) {
+	e := newEnv(t)
+	clock := useFakeClock(t)
+	var res struct {
+		Confirm      string `json:"confirm"`
+		OpenDeadline int64  `json:"openDeadline"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/valveControl",
+		map[string]any{"clientId": clientA, "action": "open", "maxOpenSeconds": 1}, &res)
+	if res.Confirm != data.ConfirmConfirmed || res.OpenDeadline == 0 || res.OpenDeadline > clock.Now().Unix()+1 {
+		t.Fatalf("open 结果=%+v", res)
+	}
+	if n, _ := service.DeadmanOnce(); n != 0 {
+		t.Fatal("未到期不应关阀")
+	}
+	clock.Advance(2 * time.Second)
+	if n, err := service.DeadmanOnce(); err != nil || n != 1 {
+		t.Fatalf("到期后关阀数=%d err=%v，期望 1", n, err)
+	}
+	if e.switchState(t, valveA) != 0 {
+		t.Fatal("到期后阀门未自动关闭")
+	}
+	if m, _ := data.GetEntryByClientId(clientA); m.OpenDeadline != 0 {
+		t.Fatalf("关阀后 openDeadline=%d，期望清除", m.OpenDeadline)
+	}
+	var page struct {
+		Total int `json:"total"`
+	}
+	e.mustOK(t, http.MethodGet, "/executor/audit?clientId="+clientA+"&action=valveControl&source=deadman", nil, &page)
+	if page.Total == 0 {
+		t.Fatal("自动关阀未写审计")
+	}
+}
+
+func TestDeadmanClosesOrphanedValve(t *testing.T) {
+	e := newEnv(t)
+	clock := useFakeClock(t)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl",
+		map[string]any{"clientId": clientA, "action": "open", "maxOpenSeconds": 1}, nil)
+	// 开启期间节点成为孤儿：普通控制被拒绝，到期关阀仍然执行
+	if err := data.UpdateEntry(devAddr, valveA, func(m *data.ExecutorMappingEntry) { m.OrphanedAt = clock.Now().Unix() }); err != nil {
+		t.Fatal(err)
+	}
+	e.expectInterlock(t, clientA, "close", "orphaned")
+	clock.Advance(2 * time.Second)
+	if n, err := service.DeadmanOnce(); err != nil || n != 1 {
+		t.Fatalf("到期后关阀数=%d err=%v，期望 1", n, err)
+	}
+	if e.switchState(t, valveA) != 0 {
+		t.Fatal("孤儿节点到期后未自动关闭")
+	}
+	if m, _ := data.GetEntryByClientId(clientA); m.OpenDeadline != 0 {
+		t.Fatalf("关阀后 openDeadline=%d，期望清除", m.OpenDeadline)
+	}
+}
+
+func TestExtendOpenDeadline(t *testing.T) {
+	e := newEnv(t)
+	clock := useFakeClock(t)
+	// 映射记录为 on（已过时）但没有期限：以平台实时状态为准
+	if err := data.UpdateEntryValue(devAddr, valveB, "on", nil); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := e.call(t, http.MethodPost, "/executor/extendOpen", map[string]any{"clientId": clientB, "maxOpenSeconds": 60})
+	if status != http.StatusConflict {
+		t.Fatalf("关闭的阀门延长期限 status=%d，期望 409", status)
+	}
+
+	e.mustOK(t, http.MethodPost, "/executor/valveControl",
+		map[string]any{"clientId": clientB, "action": "open", "maxOpenSeconds": 1}, nil)
+	var entry struct {
+		OpenDeadline int64 `json:"openDeadline"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/extendOpen", map[string]any{"clientId": clientB, "maxOpenSeconds": 600}, &entry)
+	if entry.OpenDeadline < clock.Now().Unix()+590 {
+		t.Fatalf("延长后 openDeadline=%d", entry.OpenDeadline)
+	}
+	clock.Advance(2 * time.Second)
+	if n, _ := service.DeadmanOnce(); n != 0 || e.switchState(t, valveB) != 1 {
+		t.Fatal("延长后不应自动关阀")
+	}
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientB, "action": "close"}, nil)
+	if m, _ := data.GetEntryByClientId(clientB); m.OpenDeadline != 0 {
+		t.Fatalf("关阀后 openDeadline=%d，期望清除", m.OpenDeadline)
+	}
+}
+
+// setSafety 在测试期间替换 config.json 的 safety 段，结束后恢复。
+func setSafety(t *testing.T, safety map[string]any) {
+	t.Helper()
+	path := os.Getenv("CONFIG_PATH")
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg map[string]any
+	if err := json.Unmarshal(orig, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg["safety"] = safety
+	writeFile(t, path, cfg)
+	t.Cleanup(func() {
+		// 保留测试期间登录写回的 token，只去掉 safety 段
+		b, _ := os.ReadFile(path)
+		var cur map[string]any
+		if json.Unmarshal(b, &cur) != nil {
+			_ = os.WriteFile(path, orig, 0o600)
+			return
+		}
+		delete(cur, "safety")
+		writeFile(t, path, cur)
+	})
+}
+
+// expectInterlock 断言开关阀被联锁拒绝（423），且未向平台下发命令。
+func (e *e2eEnv) expectInterlock(t *testing.T, clientId, action, rule string) {
+	t.Helper()
+	before := e.vendor.Calls("manualControlValve")
+	status, res := e.call(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientId, "action": action})
+	var il struct {
+		Rule string `json:"rule"`
+	}
+	_ = json.Unmarshal(res.Data, &il)
+	if status != http.StatusLocked || res.Code != 423 || il.Rule != rule {
+		t.Fatalf("%s %s: status=%d code=%d rule=%s message=%s，期望 423 %s", clientId, action, status, res.Code, il.Rule, res.Message, rule)
+	}
+	if e.vendor.Calls("manualControlValve") != before {
+		t.Fatal("联锁拒绝时不应下发命令")
+	}
+}
+
+func TestInterlockMaxOpenNodes(t *testing.T) {
+	e := newEnv(t)
+	for _, tc := range []struct {
+		rule   string
+		safety map[string]any
+	}{
+		{"maxOpenSite", map[string]any{"maxOpenNodes": 1}},
+		{"maxOpenDevice", map[string]any{"devices": map[string]any{devAddr: map[string]any{"maxOpenNodes": 1}}}},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			setSafety(t, tc.safety)
+			e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+			e.expectInterlock(t, clientB, "open", tc.rule)
+			// 重复开启已开启的节点不计入
+			e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+			e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+			e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientB, "action": "open"}, nil)
+			e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientB, "action": "close"}, nil)
+		})
+	}
+	var page struct {
+		Total int `json:"total"`
+	}
+	e.mustOK(t, http.MethodGet, "/executor/audit?clientId="+clientB+"&action=interlock", nil, &page)
+	if page.Total < 2 {
+		t.Fatalf("联锁拒绝审计条数=%d，期望至少 2", page.Total)
+	}
+}
+
+func TestInterlockForbiddenCombination(t *testing.T) {
+	e := newEnv(t)
+	setSafety(t, map[string]any{"forbidden": [][]string{{clientA, fmt.Sprintf("%s_%d", devAddr, valveB)}}})
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+	e.expectInterlock(t, clientB, "open", "forbiddenCombination")
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+
+	// 批量：预检时两者都关闭，执行第二条时第一条已开启
+	var rep batchReport
+	e.mustOK(t, http.MethodPost, "/executor/batchControl", map[string]any{"items": []map[string]string{
+		{"clientId": clientA, "action": "open"},
+		{"clientId": clientB, "action": "open"},
+	}}, &rep)
+	if rep.Succeeded != 1 || rep.Items[1].Reason != "interlock" || e.switchState(t, valveB) != 0 {
+		t.Fatalf("批量报告=%+v", rep)
+	}
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+}
+
+func TestInterlockMinToggle(t *testing.T) {
+	e := newEnv(t)
+	clock := useFakeClock(t)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+	setSafety(t, map[string]any{"minToggleSec": 60})
+	// 关阀从不受切换间隔限制
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+	if e.switchState(t, valveA) != 0 {
+		t.Fatal("切换间隔内关阀未执行")
+	}
+	e.expectInterlock(t, clientA, "open", "minToggleInterval")
+	clock.Advance(61 * time.Second)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+}
+
+// releaseEmergencyStopOnCleanup 测试结束时解除急停，测试中途失败也不会遗留急停状态。
+func (e *e2eEnv) releaseEmergencyStopOnCleanup(t *testing.T) {
+	t.Cleanup(func() {
+		if _, err := service.SetEmergencyStop(false, "e2e cleanup", "e2e", data.Origin{Source: "e2e"}); err != nil {
+			t.Errorf("解除急停失败: %v", err)
+		}
+	})
+}
+
+func TestEmergencyStop(t *testing.T) {
+	e := newEnv(t)
+	e.releaseEmergencyStopOnCleanup(t)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientB, "action": "open"}, nil)
+
+	var rep struct {
+		Closed int `json:"closed"`
+		Failed int `json:"failed"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/emergencyStop", map[string]any{"active": true, "reason": "管道爆裂", "operator": "e2e"}, &rep)
+	if rep.Closed != 2 || rep.Failed != 0 || e.switchState(t, valveA) != 0 || e.switchState(t, valveB) != 0 {
+		t.Fatalf("急停报告=%+v", rep)
+	}
+	var st data.EmergencyStop
+	e.mustOK(t, http.MethodGet, "/executor/emergencyStop", nil, &st)
+	if !st.Active || st.Reason != "管道爆裂" || st.Operator != "e2e" {
+		t.Fatalf("急停状态=%+v", st)
+	}
+	e.expectInterlock(t, clientA, "open", "emergencyStop")
+	if ack := service.ExecuteCommand(service.Command{ClientId: clientB, Action: "open"}); ack.Status != service.AckInterlock {
+		t.Fatalf("急停期间 MQTT 开阀回执=%+v", ack)
+	}
+	// 急停期间仍允许关阀
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+
+	e.mustOK(t, http.MethodPost, "/executor/emergencyStop", map[string]any{"active": false, "operator": "e2e"}, nil)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "open"}, nil)
+	e.mustOK(t, http.MethodPost, "/executor/valveControl", map[string]string{"clientId": clientA, "action": "close"}, nil)
+
+	var page struct {
+		Total int `json:"total"`
+	}
+	e.mustOK(t, http.MethodGet, "/executor/audit?action=emergencyStop", nil, &page)
+	if page.Total == 0 {
+		t.Fatal("急停未写审计")
+	}
+}
+
+func TestRelayActuatorControl(t *testing.T) {
+	e := newEnv(t)
+	var res struct {
+		Actuator string `json:"actuator"`
+		Confirm  string `json:"confirm"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/actuatorControl", map[string]string{"clientId": clientR, "state": "on"}, &res)
+	if r, _ := e.vendor.Relay(relayDev, 1); res.Actuator != data.ActuatorRelay || res.Confirm != data.ConfirmConfirmed || r.Status != 1 {
+		t.Fatalf("继电器闭合结果=%+v status=%d", res, r.Status)
+	}
+	var st service.ActuatorState
+	e.mustOK(t, http.MethodGet, "/executor/actuatorState?clientId="+clientR, nil, &st)
+	if st.State != "on" || st.Recorded != "on" {
+		t.Fatalf("继电器状态=%+v", st)
+	}
+	// MQTT 命令的 on/off 与 open/close 等价
+	if ack := service.ExecuteCommand(service.Command{ClientId: clientR, Action: "off"}); ack.Status != service.AckOK {
+		t.Fatalf("MQTT 断开继电器回执=%+v", ack)
+	}
+	if r, _ := e.vendor.Relay(relayDev, 1); r.Status != 0 {
+		t.Fatal("模拟平台继电器未断开")
+	}
+
+	if status, _ := e.call(t, http.MethodPost, "/executor/modeUpdate", map[string]string{"clientId": clientR, "mode": "2"}); status != http.StatusBadRequest {
+		t.Fatalf("继电器修改模式 status=%d，期望 400", status)
+	}
+	var page struct {
+		Total int `json:"total"`
+	}
+	e.mustOK(t, http.MethodGet, "/executor/audit?clientId="+clientR+"&action=relayControl", nil, &page)
+	if page.Total < 2 {
+		t.Fatalf("继电器控制审计条数=%d，期望至少 2", page.Total)
+	}
+	var relays []data.ExecutorMappingEntry
+	e.mustOK(t, http.MethodGet, "/executor/actuators?kind=relay", nil, &relays)
+	if len(relays) != 1 || relays[0].ClientId != clientR || relays[0].Status != "off" || relays[0].ClientSecret == "secret-r" {
+		t.Fatalf("继电器列表=%+v", relays)
+	}
+}
+
+// 全局开启上限与急停只约束阀门，继电器仅在显式给出 maxOpenSeconds 时定时断开。
+func TestRelayIgnoresValveCapAndEmergencyStop(t *testing.T) {
+	e := newEnv(t)
+	e.releaseEmergencyStopOnCleanup(t)
+	e.mustOK(t, http.MethodPost, "/executor/actuatorControl", map[string]string{"clientId": clientR, "state": "on"}, nil)
+	if m, _ := data.GetEntryByClientId(clientR); m.OpenDeadline != 0 {
+		t.Fatalf("未给 maxOpenSeconds 的继电器 openDeadline=%d，期望不设期限", m.OpenDeadline)
+	}
+
+	var rep struct {
+		Closed int `json:"closed"`
+	}
+	e.mustOK(t, http.MethodPost, "/executor/emergencyStop", map[string]any{"active": true, "reason": "管道爆裂", "operator": "e2e"}, &rep)
+	if r, _ := e.vendor.Relay(relayDev, 1); rep.Closed != 0 || r.Status != 1 {
+		t.Fatalf("急停不应断开继电器: 报告=%+v status=%d", rep, r.Status)
+	}
+	e.mustOK(t, http.MethodPost, "/executor/actuatorControl", map[string]string{"clientId": clientR, "state": "off"}, nil)
+	e.mustOK(t, http.MethodPost, "/executor/actuatorControl", map[string]any{"clientId": clientR, "state": "on", "maxOpenSeconds": 60}, nil)
+	if m, _ := data.GetEntryByClientId(clientR); m.OpenDeadline == 0 {
+		t.Fatal("显式给出 maxOpenSeconds 的继电器应设置期限")
+	}
+}
+
+// MQTT 命令的 maxOpenSeconds 与 HTTP 一致地设置自动关阀期限，非法取值直接拒绝、不下发。
+func TestCommandMaxOpenSeconds(t *testing.T) {
+	e := newEnv(t)
+	clock := useFakeClock(t)
+	cmds, err := service.ParseCommands([]byte(`{"id":"cmd-timed","clientId":"` + clientA + `","action":"open","maxOpenSeconds":600}`))
+	if err != nil || len(cmds) != 1 {
+		t.Fatalf("解析命令失败: %+v err=%v", cmds, err)
+	}
+	if ack := service.ExecuteCommand(cmds[0]); ack.Status != service.AckOK {
+		t.Fatalf("MQTT 定时开阀回执=%+v", ack)
+	}
+	if m, _ := data.GetEntryByClientId(clientA); m.OpenDeadline != clock.Now().Unix()+600 {
+		t.Fatalf("openDeadline=%d，期望 %d", m.OpenDeadline, clock.Now().Unix()+600)
+	}
+
+	before := e.vendor.Calls("manualControlValve")
+	for _, v := range []string{`-5`, `2.5`} {
+		cmds, _ := service.ParseCommands([]byte(`{"clientId":"` + clientB + `","action":"open","maxOpenSeconds":` + v + `}`))
+		if ack := service.ExecuteCommand(cmds[0]); ack.Status != service.AckRejected {
+			t.Fatalf("maxOpenSeconds=%s 回执=%+v，期望 rejected", v, ack)
+		}
+	}
+	if e.vendor.Calls("manualControlValve") != before {
+		t.Fatal("非法 maxOpenSeconds 不应下发命令")
+	}
+}
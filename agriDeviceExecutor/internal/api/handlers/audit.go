@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"agriDeviceExecutor/internal/data"
+	"agriDeviceExecutor/internal/models"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// auditPage 是审计查询的分页结果。
+type auditPage struct {
+	Total    int                `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+	Items    []data.AuditRecord `json:"items"`
+}
+
+// ExecutorAuditHandler GET /executor/audit
+// 查询审计日志（含已轮转的历史分段），按时间倒序：
+//   - 过滤：clientId、action（逗号分隔多个）、source、success=true|false、
+//     from / to（unix 秒、RFC3339、"2006-01-02 15:04:05" 或 "2006-01-02"；to 只给日期时含当天）；
+//   - 分页：page（从 1 开始）、pageSize（默认 50，最大 500），data 为 {total,page,pageSize,items}；
+//   - format=csv：导出全部匹配记录为 CSV（忽略分页）。
+func ExecutorAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	q, page, pageSize, err := parseAuditQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
+		return
+	}
+	csvExport := strings.EqualFold(r.URL.Query().Get("format"), "csv")
+	if !csvExport {
+		q.Offset, q.Limit = (page-1)*pageSize, pageSize
+	}
+	items, total, err := data.QueryAudit(q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
+		return
+	}
+	if csvExport {
+		writeAuditCSV(w, items)
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: auditPage{
+		Total: total, Page: page, PageSize: pageSize, Items: items,
+	}})
+}
+
+// parseAuditQuery 解析查询参数。
+func parseAuditQuery(r *http.Request) (q data.AuditQuery, page, pageSize int, err error) {
+	v := r.URL.Query()
+	q.ClientId = strings.TrimSpace(v.Get("clientId"))
+	q.Source = strings.TrimSpace(v.Get("source"))
+	for _, a := range strings.Split(v.Get("action"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			q.Actions = append(q.Actions, a)
+		}
+	}
+	if s := v.Get("success"); s != "" {
+		b, perr := strconv.ParseBool(s)
+		if perr != nil {
+			return q, 0, 0, fmt.Errorf("success invalid: %s", s)
+		}
+		q.Success = &b
+	}
+	if q.From, err = parseAuditTime(v.Get("from"), false); err != nil {
+		return q, 0, 0, fmt.Errorf("from invalid: %w", err)
+	}
+	if q.To, err = parseAuditTime(v.Get("to"), true); err != nil {
+		return q, 0, 0, fmt.Errorf("to invalid: %w", err)
+	}
+	page, pageSize = 1, defaultAuditPageSize
+	if s := v.Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return q, 0, 0, fmt.Errorf("page invalid: %s", s)
+		}
+	}
+	if s := v.Get("pageSize"); s != "" {
+		if pageSize, err = strconv.Atoi(s); err != nil || pageSize < 1 {
+			return q, 0, 0, fmt.Errorf("pageSize invalid: %s", s)
+		}
+		pageSize = min(pageSize, maxAuditPageSize)
+	}
+	return q, page, pageSize, nil
+}
+
+// parseAuditTime 解析时间参数为 unix 秒；空串返回 0（不过滤）。endOfDay 为 true 时只给日期的取当天最后一秒。
+func parseAuditTime(s string, endOfDay bool) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 { // 毫秒
+			n /= 1000
+		}
+		return n, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("无法解析时间 %q", s)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t.Unix(), nil
+}
+
+// writeAuditCSV 以 CSV 附件输出审计记录（带 UTF-8 BOM，便于 Excel 直接打开中文）。
+func writeAuditCSV(w http.ResponseWriter, items []data.AuditRecord) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="audit-%s.csv"`, time.Now().Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("\ufeff"))
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"seq", "time", "action", "source", "remote", "clientId", "deviceAddr", "nodeId", "success", "durationMs", "detail"})
+	for _, rec := range items {
+		_ = cw.Write([]string{
+			strconv.FormatInt(rec.Seq, 10),
+			time.Unix(rec.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			rec.Action,
+			rec.Source,
+			rec.Remote,
+			rec.ClientId,
+			rec.DeviceAddr,
+			strconv.Itoa(rec.NodeId),
+			strconv.FormatBool(rec.Success),
+			strconv.FormatInt(rec.DurationMs, 10),
+			rec.Detail,
+		})
+	}
+	cw.Flush()
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+	"agriDeviceExecutor/internal/service"
 	"encoding/json"
-	"errors"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 )
 
 // writeJSON 以给定的 HTTP 状态码将 payload 写为 JSON 响应。
@@ -66,18 +69,13 @@ func OnlyPost(h func(http.ResponseWriter, *http.Request)) func(http.ResponseWrit
 	}
 }
 
-// RequireAuth 中间件：严格获取本地 token + 规范化基础地址（去除尾部斜杠）。
-// 失败：401 未登录 / 500 其它错误；成功：调用下游并传递 token 与 baseURL。
+// RequireAuth 中间件：从平台会话获取有效 token（未登录或即将到期时自动登录）+ 规范化基础地址（去除尾部斜杠）。
+// 失败：401 登录失败 / 500 其它错误；成功：调用下游并传递 token 与 baseURL。
 func RequireAuth(h func(http.ResponseWriter, *http.Request, string, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 严格 token
-		token, err := config.GetUserToken()
+		token, err := service.SessionToken()
 		if err != nil {
-			if errors.Is(err, config.ErrTokenMissing) {
-				writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "message": "未登录"})
-				return
-			}
-			writeJSON(w, http.StatusInternalServerError, map[string]any{"code": 500, "message": err.Error()})
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "message": "未登录: " + err.Error()})
 			return
 		}
 		// 基础地址（严格版：若读取失败直接 500）
@@ -89,3 +87,34 @@ func RequireAuth(h func(http.ResponseWriter, *http.Request, string, string)) htt
 		h(w, r, token, baseURL)
 	}
 }
+
+// requestOrigin 提取审计用的请求来源，默认 Source 为 http、Remote 为连接的对端 IP。
+// 请求头可由客户端任意伪造，只有对端属于可信代理（audit.trustedProxies）时才采用：
+// - Source：X-Request-Source 头（如 llm、scheduler、console）；
+// - Remote：X-Forwarded-For 从右往左第一个不属于可信代理的地址（左侧的地址同样可被伪造）。
+func requestOrigin(r *http.Request) data.Origin {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	origin := data.Origin{Source: data.SourceHTTP, Remote: remote}
+	trusted := config.GetTrustedProxies()
+	if !trusted.Contains(remote) {
+		return origin
+	}
+	if src := strings.TrimSpace(r.Header.Get("X-Request-Source")); src != "" {
+		origin.Source = src[:min(len(src), 64)]
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		origin.Remote = hop
+		if !trusted.Contains(hop) {
+			break
+		}
+	}
+	return origin
+}
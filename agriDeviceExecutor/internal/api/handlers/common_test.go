@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequestOriginTrustsOnlyConfiguredProxies(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(cfg, []byte(`{"audit":{"trustedProxies":["10.0.0.0/8"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", cfg)
+
+	cases := []struct {
+		name, peer, xff string
+		wantSource      string
+		wantRemote      string
+	}{
+		{"直连客户端伪造请求头", "203.0.113.7:5000", "198.51.100.1", "http", "203.0.113.7"},
+		{"可信代理转发", "10.0.0.2:5000", "198.51.100.1", "llm", "198.51.100.1"},
+		{"客户端在代理链左侧伪造地址", "10.0.0.2:5000", "1.2.3.4, 198.51.100.1, 10.0.0.3", "llm", "198.51.100.1"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", "/executor/valveControl", nil)
+		r.RemoteAddr = tc.peer
+		r.Header.Set("X-Request-Source", "llm")
+		r.Header.Set("X-Forwarded-For", tc.xff)
+		if o := requestOrigin(r); o.Source != tc.wantSource || o.Remote != tc.wantRemote {
+			t.Errorf("%s: 得到 %+v，期望 source=%s remote=%s", tc.name, o, tc.wantSource, tc.wantRemote)
+		}
+	}
+}
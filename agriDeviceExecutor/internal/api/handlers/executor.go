@@ -4,38 +4,96 @@ import (
 	"agriDeviceExecutor/internal/data"
 	"agriDeviceExecutor/internal/models"
 	"agriDeviceExecutor/internal/service"
+	"errors"
 	"net/http"
-	//"time"
+	"strconv"
+	"time"
 )
 
 // 统一执行端点返回结构：models.ResultData
 // 成功：code=1000,message="ok"
 // 失败：根据错误类型选择 400 或 500。业务错误暂归类 500，可后续细化。
+// 控制类请求写审计时以 X-Request-Source 头（缺省 http）与客户端地址作为来源，见 requestOrigin。
 
 // ExecutorListNodesHandler GET /executor/nodes
-// 返回全部映射 entries。
+// 返回全部映射 entries（clientSecret 脱敏）。
 func ExecutorListNodesHandler(w http.ResponseWriter, r *http.Request) {
 	entries := data.GetAllEntries()
+	for i := range entries {
+		entries[i] = entries[i].Redacted()
+	}
 	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: entries})
 }
 
-// ExecutorRefreshHandler POST /executor/nodes/refresh
-// 触发全量同步。无请求体。
+// ExecutorRefreshHandler POST /executor/nodes/refresh[?dryRun=true]
+// 触发全量同步：逐节点分类为 added / unchanged / changed / orphaned，孤儿按 sync.orphanPolicy 处理。
+// dryRun=true 时只返回差异，不注册、不修改映射与 Magistrala。无请求体；data 为 service.SyncReport。
 func ExecutorRefreshHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
 		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
 		return
 	}
-	if err := service.SyncAll(token, baseURL); err != nil {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	rep, err := service.SyncAll(token, baseURL, dryRun, requestOrigin(r))
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
 		return
 	}
-	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok"})
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: rep})
+}
+
+// ExecutorRotateSecretHandler POST /executor/nodes/rotateSecret
+// Body: {"clientId":"..."}
+// 生成新的随机 secret，更新 Magistrala client 凭据并写入映射；data 为脱敏后的映射。
+func ExecutorRotateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	var body struct {
+		ClientId string `json:"clientId"`
+	}
+	if err := decodeJSON(r, &body); err != nil || body.ClientId == "" {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId invalid"})
+		return
+	}
+	entry, err := service.RotateClientSecret(body.ClientId, requestOrigin(r))
+	if err != nil {
+		if err.Error() == "clientId 未找到映射: "+body.ClientId {
+			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: entry})
+}
+
+// ExecutorReconcileHandler POST /executor/reconcile
+// 立即执行一轮阀门状态对账（平时由后台按 reconcile.intervalSec 周期执行），data 为本轮统计。
+func ExecutorReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	rep, err := service.ReconcileOnce()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error(), Data: rep})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: rep})
 }
 
 // ExecutorValveControlHandler POST /executor/valveControl
-// Body: {"clientId":"...","action":"open"|"close"}
+// Body: {"clientId":"...","action":"open"|"close","maxOpenSeconds":600}
+// maxOpenSeconds 可选（仅 open）：到期由执行层自动关阀，另受 safety.maxOpenSeconds 全局上限约束（见 service/deadman.go）。
+// 下发后回读节点状态确认，data 为 service.ValveResult：
+// confirmed → 200 / 1000 "ok"；unconfirmed（读不到状态）→ 200 / 1000 "unconfirmed"；
+// controlConfirmWait 内未确认 → 202 / 1000 "pending"，确认在后台继续，结果见 /executor/actuatorState 与映射的 confirm 字段；
+// failed（命令失败或回读状态与期望相反）→ 500；安全联锁拒绝（见 service/interlock.go）→ 423，data 为 {rule, detail}。
 func ExecutorValveControlHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
 	//startAll := time.Now()
 	// log.Printf("[debug][valve] enter handler method=%s uri=%s", r.Method, r.RequestURI)
@@ -48,8 +106,9 @@ func ExecutorValveControlHandler(w http.ResponseWriter, r *http.Request, token,
 	}
 
 	var body struct {
-		ClientId string `json:"clientId"`
-		Action   string `json:"action"`
+		ClientId       string `json:"clientId"`
+		Action         string `json:"action"`
+		MaxOpenSeconds int    `json:"maxOpenSeconds"`
 	}
 	if err := decodeJSON(r, &body); err != nil {
 		// log.Printf("[debug][valve] decodeJSON error=%v cost=%s", err, time.Since(startAll))
@@ -64,24 +123,234 @@ func ExecutorValveControlHandler(w http.ResponseWriter, r *http.Request, token,
 		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId/action invalid"})
 		return
 	}
+	if body.MaxOpenSeconds < 0 {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "maxOpenSeconds invalid"})
+		return
+	}
 
 	open := body.Action == "open"
 	// log.Printf("[debug][valve] calling ExecuteValveControl clientId=%s open=%v", body.ClientId, open)
-	if err := service.ExecuteValveControl(body.ClientId, open, token, baseURL); err != nil {
-		// log.Printf("[debug][valve] ExecuteValveControl error=%v cost=%s", err, time.Since(startAll))
-		if err.Error() == "clientId 未找到映射: "+body.ClientId {
+	res, err := service.StartValveControl(body.ClientId, open, time.Duration(body.MaxOpenSeconds)*time.Second, controlConfirmWait, token, baseURL, requestOrigin(r))
+	writeControlResult(w, body.ClientId, res, err)
+}
+
+// controlConfirmWait 控制接口等待回读确认的最长时间，超过后返回 pending，避免请求阻塞整个确认超时。
+const controlConfirmWait = 5 * time.Second
+
+// writeControlResult 按控制结果写响应（valveControl 与 actuatorControl 共用）。
+func writeControlResult(w http.ResponseWriter, clientId string, res service.ValveResult, err error) {
+	if err != nil {
+		if err.Error() == "clientId 未找到映射: "+clientId {
+			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
+			return
+		}
+		var il *service.InterlockError
+		if errors.As(err, &il) {
+			writeJSON(w, http.StatusLocked, models.ResultData{Code: 423, Message: err.Error(), Data: il})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error(), Data: res})
+		return
+	}
+	switch res.Confirm {
+	case data.ConfirmConfirmed:
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: res})
+	case data.ConfirmUnconfirmed:
+		// 平台已接受命令但无法回读状态：不视为失败，由调用方根据 data.confirm 决定是否复核
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "unconfirmed", Data: res})
+	case data.ConfirmPending:
+		writeJSON(w, http.StatusAccepted, models.ResultData{Code: 1000, Message: "pending", Data: res})
+	default:
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: res.Error, Data: res})
+	}
+}
+
+// ExecutorActuatorsHandler GET /executor/actuators[?kind=valve|relay]
+// 以统一模型列出执行器（阀门节点与继电器），data 为脱敏后的映射，actuator 标明类型、status 为 on / off。
+func ExecutorActuatorsHandler(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != data.ActuatorValve && kind != data.ActuatorRelay {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "kind invalid"})
+		return
+	}
+	out := []data.ExecutorMappingEntry{}
+	for _, e := range data.GetAllEntries() {
+		e.Actuator = e.Kind()
+		if kind == "" || e.Actuator == kind {
+			out = append(out, e.Redacted())
+		}
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: out})
+}
+
+// ExecutorActuatorStateHandler GET /executor/actuatorState?clientId=...
+// 经驱动回读执行器的实时状态，data 为 service.ActuatorState；读不到状态 → 502。
+func ExecutorActuatorStateHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	clientId := r.URL.Query().Get("clientId")
+	if clientId == "" {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId invalid"})
+		return
+	}
+	st, err := service.ReadActuatorState(clientId, token, baseURL)
+	if err != nil {
+		if err.Error() == "clientId 未找到映射: "+clientId {
 			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
 			return
 		}
+		writeJSON(w, http.StatusBadGateway, models.ResultData{Code: 502, Message: err.Error(), Data: st})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: st})
+}
+
+// ExecutorActuatorControlHandler POST /executor/actuatorControl
+// Body: {"clientId":"...","state":"on"|"off","maxOpenSeconds":600}
+// 统一的执行器开关：阀门节点与继电器同样处理（on = open，off = close），响应与 /executor/valveControl 一致。
+func ExecutorActuatorControlHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	var body struct {
+		ClientId       string `json:"clientId"`
+		State          string `json:"state"`
+		MaxOpenSeconds int    `json:"maxOpenSeconds"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "invalid json"})
+		return
+	}
+	if body.ClientId == "" || (body.State != "on" && body.State != "off") || body.MaxOpenSeconds < 0 {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId/state/maxOpenSeconds invalid"})
+		return
+	}
+	res, err := service.StartValveControl(body.ClientId, body.State == "on", time.Duration(body.MaxOpenSeconds)*time.Second, controlConfirmWait, token, baseURL, requestOrigin(r))
+	writeControlResult(w, body.ClientId, res, err)
+}
+
+// ExecutorExtendOpenHandler POST /executor/extendOpen
+// Body: {"clientId":"...","maxOpenSeconds":600}
+// 把正在开启的阀门的自动关阀期限改为 now+maxOpenSeconds（受全局上限约束），不重新下发命令；
+// 控制服务延长灌溉时调用。data 为脱敏后的映射（含 openDeadline）；阀门未开启返回 409（无期限时以回读状态为准）。
+func ExecutorExtendOpenHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+	var body struct {
+		ClientId       string `json:"clientId"`
+		MaxOpenSeconds int    `json:"maxOpenSeconds"`
+	}
+	if err := decodeJSON(r, &body); err != nil || body.ClientId == "" || body.MaxOpenSeconds <= 0 {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId/maxOpenSeconds invalid"})
+		return
+	}
+	entry, err := service.ExtendOpenDeadline(body.ClientId, time.Duration(body.MaxOpenSeconds)*time.Second, token, baseURL, requestOrigin(r))
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: entry})
+	case errors.Is(err, service.ErrNotOpen):
+		writeJSON(w, http.StatusConflict, models.ResultData{Code: 409, Message: err.Error()})
+	case err.Error() == "clientId 未找到映射: "+body.ClientId:
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
+	default:
 		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
+	}
+}
+
+// ExecutorBatchControlHandler POST /executor/batchControl
+// Body: {"items":[{"clientId":"...","action":"open"|"close","maxOpenSeconds":600}],"allOrNothing":false}
+// 同一设备的条目串行、不同设备并行（全局并发见 batch.concurrency），data 为 service.BatchReport，
+// 每个条目的 status 为 success / failed / skipped（reason：duplicate / interlock / aborted）：
+// 全部成功 → 200 / 1000 "ok"；部分未成功 → 200 / 1000 "partial"；allOrNothing 中止并回滚 → 500。
+// allOrNothing 预检未通过（未下发任何命令）：有 failed 条目（clientId 未映射、action 非法）→ 400，
+// 仅有 skipped 条目（重复、联锁）→ 409，data 均为报告。
+func ExecutorBatchControlHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
 		return
 	}
-	// log.Printf("[debug][valve] success clientId=%s totalCost=%s", body.ClientId, time.Since(startAll))
-	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok"})
+	var body struct {
+		Items        []service.BatchItem `json:"items"`
+		AllOrNothing bool                `json:"allOrNothing"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "invalid json"})
+		return
+	}
+	rep, err := service.ExecuteBatchControl(body.Items, body.AllOrNothing, token, baseURL, requestOrigin(r))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
+		return
+	}
+	switch {
+	case rep.Rejected && rep.Failed > 0:
+		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "批量控制预检未通过，未下发任何命令", Data: rep})
+	case rep.Rejected:
+		writeJSON(w, http.StatusConflict, models.ResultData{Code: 409, Message: "批量控制预检未通过，未下发任何命令", Data: rep})
+	case rep.Aborted:
+		writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: "批量控制中止，已打开的阀门已回滚", Data: rep})
+	case rep.Succeeded < rep.Total:
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "partial", Data: rep})
+	default:
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: rep})
+	}
+}
+
+// ExecutorEmergencyStopHandler GET/POST /executor/emergencyStop
+// GET：data 为当前急停状态 data.EmergencyStop。
+// POST Body: {"active":true,"reason":"...","operator":"..."}；active=true 激活急停：立即拒绝一切开阀（423），
+// 并关闭全部开启的阀门，data 为 service.EmergencyStopReport；有阀门未能关闭 → 500（急停仍然生效）。
+// active=false 解除急停，不恢复任何阀门。operator 缺省为请求方地址。
+func ExecutorEmergencyStopHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		st, err := data.GetEmergencyStop()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: st})
+	case http.MethodPost:
+		var body struct {
+			Active   *bool  `json:"active"`
+			Reason   string `json:"reason"`
+			Operator string `json:"operator"`
+		}
+		if err := decodeJSON(r, &body); err != nil || body.Active == nil {
+			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "active invalid"})
+			return
+		}
+		origin := requestOrigin(r)
+		if body.Operator == "" {
+			body.Operator = origin.Remote
+		}
+		rep, err := service.SetEmergencyStop(*body.Active, body.Reason, body.Operator, origin)
+		switch {
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: err.Error()})
+		case rep.Failed > 0 || rep.Error != "":
+			writeJSON(w, http.StatusInternalServerError, models.ResultData{Code: 500, Message: "急停已激活，部分阀门未能关闭", Data: rep})
+		default:
+			writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: rep})
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+	}
 }
 
 // ExecutorModeUpdateHandler POST /executor/modeUpdate
 // Body: {"clientId":"...","mode":"1"|"2"}
+// 工作模式仅阀门节点支持，对继电器返回 400。
 func ExecutorModeUpdateHandler(w http.ResponseWriter, r *http.Request, token, baseURL string) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -100,8 +369,8 @@ func ExecutorModeUpdateHandler(w http.ResponseWriter, r *http.Request, token, ba
 		writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "clientId/mode invalid"})
 		return
 	}
-	if err := service.ExecuteModeUpdate(body.ClientId, body.Mode, token, baseURL); err != nil {
-		if err.Error() == "clientId 未找到映射: "+body.ClientId {
+	if err := service.ExecuteModeUpdate(body.ClientId, body.Mode, token, baseURL, requestOrigin(r)); err != nil {
+		if err.Error() == "clientId 未找到映射: "+body.ClientId || errors.Is(err, service.ErrModeUnsupported) {
 			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: err.Error()})
 			return
 		}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"agriDeviceExecutor/internal/models"
+	"agriDeviceExecutor/internal/service"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ExecutorOverrideHandler GET|POST|DELETE /executor/override
+// 分区人工接管，透传到控制服务 /control/override：
+//   - GET    ?domain_id=&channel_id=&target=  查询（不带 target 列出全部）
+//   - POST   Body: {"target":"A区","duration":"2h","mode":"hold|reject","operator":"...","reason":"..."}
+//   - DELETE ?domain_id=&channel_id=&target=  提前解除
+//
+// 成功返回 code=1000，data 为控制服务返回的接管记录；失败时 code 为控制服务的 HTTP 状态码。
+func ExecutorOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, models.ResultData{Code: 405, Message: "method not allowed"})
+		return
+	}
+
+	var body []byte
+	if r.Method == http.MethodPost {
+		b, err := io.ReadAll(r.Body)
+		if err != nil || !json.Valid(b) {
+			writeJSON(w, http.StatusBadRequest, models.ResultData{Code: 400, Message: "invalid json"})
+			return
+		}
+		body = b
+	}
+
+	status, data, err := service.ProxyOverride(r.Method, r.URL.RawQuery, body, requestOrigin(r))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, models.ResultData{Code: 502, Message: err.Error()})
+		return
+	}
+	if status < 200 || status >= 300 {
+		msg := http.StatusText(status)
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			msg = e.Error
+		}
+		writeJSON(w, status, models.ResultData{Code: status, Message: msg})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ResultData{Code: 1000, Message: "ok", Data: data})
+}
@@ -8,7 +8,7 @@ import (
 // SetupMux 初始化 HTTP 路由。
 // 说明：
 // 1. 内部服务路径不再使用 /api/v2.0 前缀；仅第三方平台仍用其原始前缀（在 service 层构造）。
-// 2. 除登录外所有接口均经过 RequireAuth 中间件（严格读取本地 token 与基础地址）。
+// 2. 除登录与人工接管（透传到控制服务）外，所有接口均经过 RequireAuth 中间件（严格读取本地 token 与基础地址）。
 // 3. handlers.*Handler 只负责参数提取与调用 service，统一返回 JSON。
 func SetupMux() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -103,17 +103,72 @@ func SetupMux() *http.ServeMux {
 			handlers.ExecutorRefreshHandler(w, r, token, baseURL)
 		}))
 
-	// 阀门开关控制（POST: clientId + action=open|close）
+	// 轮换节点 client 的 Magistrala secret（POST: clientId）
+	mux.HandleFunc("/executor/nodes/rotateSecret",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorRotateSecretHandler(w, r)
+		}))
+
+	// 立即执行一轮阀门状态对账（后台另按 reconcile.intervalSec 周期执行）
+	mux.HandleFunc("/executor/reconcile",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorReconcileHandler(w, r)
+		}))
+
+	// 阀门开关控制（POST: clientId + action=open|close，可选 maxOpenSeconds 到期自动关阀）
 	mux.HandleFunc("/executor/valveControl",
 		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
 			handlers.ExecutorValveControlHandler(w, r, token, baseURL)
 		}))
 
+	// 延长阀门自动关闭期限（POST: clientId + maxOpenSeconds；不重新下发命令）
+	mux.HandleFunc("/executor/extendOpen",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorExtendOpenHandler(w, r, token, baseURL)
+		}))
+
+	// 批量阀门控制（POST: items=[{clientId,action}]，按设备串行、全局限并发，可选 allOrNothing 失败回滚）
+	mux.HandleFunc("/executor/batchControl",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorBatchControlHandler(w, r, token, baseURL)
+		}))
+
+	// 执行器列表（GET: 可选 kind=valve|relay；阀门节点与继电器统一为 on/off 模型）
+	mux.HandleFunc("/executor/actuators",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorActuatorsHandler(w, r)
+		}))
+
+	// 执行器实时状态（GET: clientId，经驱动回读）
+	mux.HandleFunc("/executor/actuatorState",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorActuatorStateHandler(w, r, token, baseURL)
+		}))
+
+	// 执行器开关（POST: clientId + state=on|off，可选 maxOpenSeconds；阀门节点与继电器通用）
+	mux.HandleFunc("/executor/actuatorControl",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorActuatorControlHandler(w, r, token, baseURL)
+		}))
+
 	// 阀门模式更新（POST: clientId + mode=1|2）
 	mux.HandleFunc("/executor/modeUpdate",
 		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
 			handlers.ExecutorModeUpdateHandler(w, r, token, baseURL)
 		}))
 
+	// 审计日志查询（GET: clientId/action/source/from/to/success 过滤，分页或 format=csv 导出）
+	mux.HandleFunc("/executor/audit",
+		handlers.RequireAuth(func(w http.ResponseWriter, r *http.Request, token, baseURL string) {
+			handlers.ExecutorAuditHandler(w, r)
+		}))
+
+	// 全局急停（GET 查询；POST active=true 关闭全部阀门并拒绝开阀，false 解除）。
+	// 不依赖平台 token 的有效性，会话失效时由服务层重新登录
+	mux.HandleFunc("/executor/emergencyStop", handlers.ExecutorEmergencyStopHandler)
+
+	// 分区人工接管（GET/POST/DELETE，透传到控制服务；不依赖第三方平台 token）
+	mux.HandleFunc("/executor/override", handlers.ExecutorOverrideHandler)
+
 	return mux
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// 审计日志轮转默认值：单文件 10MB 或覆盖 24 小时即轮转，保留最近 90 个历史分段。
+const (
+	defaultAuditMaxSizeMB   = 10
+	defaultAuditMaxAgeHours = 24
+	defaultAuditMaxFiles    = 90
+)
+
+// AuditRotation 是审计日志轮转参数；各字段为 0 表示不按该条件轮转或不清理。
+type AuditRotation struct {
+	MaxBytes int64
+	MaxAge   time.Duration
+	MaxFiles int
+}
+
+// GetAuditRotation 读取审计日志轮转参数；未配置时回退到默认值，配置为负数时关闭对应条件。
+func GetAuditRotation() AuditRotation {
+	sizeMB, ageHours, files := defaultAuditMaxSizeMB, defaultAuditMaxAgeHours, defaultAuditMaxFiles
+	if c, err := loadCredentials(); err == nil {
+		sizeMB = pickAuditLimit(c.Audit.MaxSizeMB, sizeMB)
+		ageHours = pickAuditLimit(c.Audit.MaxAgeHours, ageHours)
+		files = pickAuditLimit(c.Audit.MaxFiles, files)
+	}
+	return AuditRotation{
+		MaxBytes: int64(sizeMB) << 20,
+		MaxAge:   time.Duration(ageHours) * time.Hour,
+		MaxFiles: files,
+	}
+}
+
+// pickAuditLimit 0 取默认值，负数返回 0（关闭）。
+func pickAuditLimit(v, def int) int {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
+	}
+	return v
+}
+
+// TrustedProxies 是可信反向代理的地址范围。
+type TrustedProxies []netip.Prefix
+
+// Contains 判断地址（IP，可带端口）是否属于可信代理。
+func (t TrustedProxies) Contains(addr string) bool {
+	addr = strings.TrimSpace(addr)
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			return false
+		}
+		ip = ap.Addr()
+	}
+	ip = ip.Unmap()
+	for _, p := range t {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTrustedProxies 读取 audit.trustedProxies；未配置时为空（不信任任何代理头），无法解析的条目记日志后忽略。
+func GetTrustedProxies() TrustedProxies {
+	c, err := loadCredentials()
+	if err != nil {
+		return nil
+	}
+	var out TrustedProxies
+	for _, s := range c.Audit.TrustedProxies {
+		s = strings.TrimSpace(s)
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+		} else if ip, err := netip.ParseAddr(s); err == nil {
+			out = append(out, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+		} else {
+			log.Printf("[config] 忽略无法解析的 audit.trustedProxies 条目 %q", s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,16 @@
+package config
+
+// 批量控制默认并发：平台对同一账号的并发请求有限，且每个阀门控制含数秒回读确认。
+const (
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 32
+)
+
+// GetBatchConcurrency 读取批量控制的全局并发上限；未配置或非法时回退到默认值。
+func GetBatchConcurrency() int {
+	c, err := loadCredentials()
+	if err != nil || c.Batch.Concurrency <= 0 {
+		return defaultBatchConcurrency
+	}
+	return min(c.Batch.Concurrency, maxBatchConcurrency)
+}
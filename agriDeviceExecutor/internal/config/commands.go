@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// 命令总线默认值：Magistrala MQTT 适配器端口与子主题。
+const (
+	defaultMQTTPort       = "1883"
+	defaultCommandTopic   = "commands"
+	defaultCommandAckPath = "commands/ack"
+	minCommanderKeyLen    = 16
+)
+
+// CommanderKey 是允许下发命令的发布方：id 与 HMAC-SHA256 密钥（可为 enc:v1: 加密值）。
+type CommanderKey struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// CommandBusConfig 是命令总线（MQTT）的运行配置。
+type CommandBusConfig struct {
+	BrokerURL    string
+	Subtopic     string
+	AckSubtopic  string
+	ClientID     string
+	ClientSecret string            // 已解密
+	Commanders   map[string][]byte // commander id → HMAC 密钥（已解密）
+}
+
+// CommandBusEnabled 是否经 MQTT 接收控制命令（默认关闭）。
+func CommandBusEnabled() bool {
+	c, err := loadCredentials()
+	return err == nil && c.Commands.Enabled
+}
+
+// GetCommandBusConfig 读取命令总线配置，未配置的字段回退到默认值。
+// 专用连接凭据 clientId/clientSecret 与至少一个 commander 为必填，缺少时返回错误（命令总线不启动）。
+func GetCommandBusConfig() (CommandBusConfig, error) {
+	c, err := loadCredentials()
+	if err != nil {
+		return CommandBusConfig{}, err
+	}
+	cfg := CommandBusConfig{
+		BrokerURL:   strings.TrimSpace(c.Commands.BrokerURL),
+		Subtopic:    strings.Trim(c.Commands.Subtopic, " /"),
+		AckSubtopic: strings.Trim(c.Commands.AckSubtopic, " /"),
+		ClientID:    strings.TrimSpace(c.Commands.ClientID),
+	}
+	if cfg.Subtopic == "" {
+		cfg.Subtopic = defaultCommandTopic
+	}
+	if cfg.AckSubtopic == "" {
+		cfg.AckSubtopic = defaultCommandAckPath
+	}
+	if cfg.ClientSecret, err = DecryptSecret(strings.TrimSpace(c.Commands.ClientSecret)); err != nil {
+		return cfg, fmt.Errorf("解密 commands.clientSecret 失败: %w", err)
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return cfg, errors.New("缺少 commands.clientId / clientSecret（命令总线须使用专用 client 连接）")
+	}
+	cfg.Commanders = map[string][]byte{}
+	for _, k := range c.Commands.Commanders {
+		id := strings.TrimSpace(k.ID)
+		key, err := DecryptSecret(strings.TrimSpace(k.Key))
+		if err != nil {
+			return cfg, fmt.Errorf("解密 commander %s 的密钥失败: %w", id, err)
+		}
+		if id == "" || len(key) < minCommanderKeyLen {
+			return cfg, fmt.Errorf("commander %q 缺少 id 或密钥过短（至少 %d 字节）", id, minCommanderKeyLen)
+		}
+		cfg.Commanders[id] = []byte(key)
+	}
+	if len(cfg.Commanders) == 0 {
+		return cfg, errors.New("未配置 commands.commanders，无法校验命令来源")
+	}
+	if cfg.BrokerURL == "" {
+		base, err := GetMagistralaBaseURL()
+		if err != nil {
+			return cfg, err
+		}
+		u, err := url.Parse(base)
+		if err != nil || u.Hostname() == "" {
+			return cfg, fmt.Errorf("无法从 Magistrala baseUrl %q 推导 MQTT 地址，请配置 commands.brokerUrl", base)
+		}
+		cfg.BrokerURL = "tcp://" + u.Hostname() + ":" + defaultMQTTPort
+	}
+	return cfg, nil
+}
@@ -0,0 +1,19 @@
+package config
+
+import "strings"
+
+// 默认控制服务（agriControlService）地址。
+const defaultControlServiceURL = "http://localhost:8280"
+
+// GetControlServiceBaseURL 读取控制服务基础地址（去除尾部斜杠）；未配置时回退到本机默认端口。
+func GetControlServiceBaseURL() (string, error) {
+	c, err := loadCredentials()
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(c.ControlService.BaseURL)
+	if v == "" {
+		v = defaultControlServiceURL
+	}
+	return strings.TrimRight(v, "/"), nil
+}
@@ -5,32 +5,107 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // 本文件统一从 JSON 配置读取执行所需参数（模仿 agriDataIntegration/config.json 结构）。
 // - 路径：优先环境变量 CONFIG_PATH，其次 CREDENTIALS_PATH（兼容旧版本），否则 ./internal/config/config.json
 // - 结构：
 //   {
-//     "agriPlatform": {"baseUrl":"...","username":"...","password":"...","userToken":"..."},
-//     "magistrala":   {"baseUrl":"...","userToken":"...","domainId":"...","channelId":"..."}
+//     "agriPlatform": {"baseUrl":"...","username":"...","password":"enc:v1:...（也接受明文）","authFailCodes":[...],"nodeStateKey":"switchState"},
+//     "magistrala":   {"userToken":"enc:v1:...","domainId":"...","channelId":"...","messagePort":"9011","stateSubtopic":"executor","stateHeartbeatSec":600},
+//     "controlService": {"baseUrl":"http://localhost:8280"},
+//     "reconcile":    {"intervalSec":60,"webhookUrl":"..."},
+//     "sync":         {"orphanPolicy":"disable"},
+//     "commands":     {"enabled":true,"brokerUrl":"tcp://host:1883","subtopic":"commands","ackSubtopic":"commands/ack",
+//                      "clientId":"...","clientSecret":"enc:v1:...","commanders":[{"id":"llm-planner","key":"enc:v1:..."}]},
+//     "audit":        {"maxSizeMB":10,"maxAgeHours":24,"maxFiles":90,"trustedProxies":["127.0.0.1","10.0.0.0/8"]},
+//     "batch":        {"concurrency":4},
+//     "safety":       {"maxOpenSeconds":14400,"maxOpenNodes":4,"minToggleSec":30,
+//                      "devices":{"21131734":{"maxOpenNodes":2}},"forbidden":[["21131734_10001","21131734_10002"]]},
+//     "relayPlatform": {"baseUrl":"http://www.0531yun.com","username":"...","password":"enc:v1:...","deviceAddrs":["40012345"]}
 //   }
+// - 程序不改写配置文件：enc:v1: 加密值用 go run ./cmd/encryptsecret 生成后手工填入；
+//   登录得到的平台 token 加密保存在单独的会话文件（见 TokenPath），不写回 config.json。
 
 type AppConfig struct {
 	AgriPlatform struct {
 		BaseURL   string `json:"baseUrl"`
 		Username  string `json:"username"`
 		Password  string `json:"password"`
-		UserToken string `json:"userToken,omitempty"`
+		UserToken string `json:"userToken,omitempty"` // 旧版登录写入的 token，仅在会话文件不存在时读取
+
+		// AuthFailCodes 表示 token 失效的业务 code（收到后自动重新登录并重试一次）；平台文档未定义，为空时只认 HTTP 401/403
+		AuthFailCodes []int `json:"authFailCodes,omitempty"`
+		// ConfirmTimeoutSec 阀门控制后回读确认的超时（秒）；为 0 时使用内置默认值
+		ConfirmTimeoutSec int `json:"confirmTimeoutSec,omitempty"`
+		// NodeStateKey 节点对象中表示阀门开关状态的字段；平台文档未定义，为空时使用内置默认值（见 GetNodeStateKey）
+		NodeStateKey string `json:"nodeStateKey,omitempty"`
 	} `json:"agriPlatform"`
 	Magistrala struct {
 		BaseURL   string `json:"baseUrl,omitempty"`
-		UserToken string `json:"userToken,omitempty"`
+		UserToken string `json:"userToken,omitempty"` // 可为 enc:v1: 加密值；为空时使用共享配置 data/magistrala.json 的 userToken
 		DomainID  string `json:"domainId"`
 		ChannelID string `json:"channelId"`
+		// MessagePort HTTP 适配器端口（发布执行器状态），为空时使用内置默认值
+		MessagePort string `json:"messagePort,omitempty"`
+		// StateSubtopic 执行器状态发布的子主题，为空时使用内置默认值
+		StateSubtopic string `json:"stateSubtopic,omitempty"`
+		// DisableStatePublish 为 true 时不发布执行器状态
+		DisableStatePublish bool `json:"disableStatePublish,omitempty"`
+		// StateHeartbeatSec 对账时状态未变化的节点重复发布的周期（秒），0 使用内置默认值
+		StateHeartbeatSec int `json:"stateHeartbeatSec,omitempty"`
 	} `json:"magistrala"`
+	ControlService struct {
+		BaseURL string `json:"baseUrl,omitempty"`
+	} `json:"controlService"`
+	Reconcile struct {
+		IntervalSec int    `json:"intervalSec,omitempty"` // 阀门状态对账周期（秒），0 使用默认值，负数关闭
+		WebhookURL  string `json:"webhookUrl,omitempty"`  // 状态漂移事件推送地址（POST JSON），为空不推送
+	} `json:"reconcile"`
+	Sync struct {
+		OrphanPolicy string `json:"orphanPolicy,omitempty"` // 节点从平台移除后的处理：disable（默认）/ delete / keep
+	} `json:"sync"`
+	Commands struct {
+		Enabled      bool   `json:"enabled,omitempty"`      // 是否经 Magistrala MQTT 适配器接收控制命令
+		BrokerURL    string `json:"brokerUrl,omitempty"`    // MQTT 地址，为空时取 Magistrala baseUrl 的主机 + 1883
+		Subtopic     string `json:"subtopic,omitempty"`     // 命令子主题，默认 commands
+		AckSubtopic  string `json:"ackSubtopic,omitempty"`  // 回执子主题，默认 commands/ack
+		ClientID     string `json:"clientId,omitempty"`     // 连接所用的专用 Magistrala client（必填，不使用节点 client）
+		ClientSecret string `json:"clientSecret,omitempty"` // 与 clientId 配套（可为 enc:v1: 加密值）
+		// Commanders 允许下发命令的发布方及其 HMAC 密钥；未签名或签名不符的命令一律丢弃
+		Commanders []CommanderKey `json:"commanders,omitempty"`
+	} `json:"commands"`
+	Audit struct {
+		MaxSizeMB   int `json:"maxSizeMB,omitempty"`   // 审计日志单文件上限（MB），0 使用默认值，负数不按大小轮转
+		MaxAgeHours int `json:"maxAgeHours,omitempty"` // 单文件最长覆盖时长（小时），0 使用默认值，负数不按时间轮转
+		MaxFiles    int `json:"maxFiles,omitempty"`    // 保留的历史分段数，0 使用默认值，负数全部保留
+		// TrustedProxies 可信反向代理（IP 或 CIDR）：只有来自这些地址的请求才采用 X-Request-Source / X-Forwarded-For
+		TrustedProxies []string `json:"trustedProxies,omitempty"`
+	} `json:"audit"`
+	Batch struct {
+		Concurrency int `json:"concurrency,omitempty"` // 批量控制的全局并发上限，0 使用默认值
+	} `json:"batch"`
+	Safety struct {
+		MaxOpenSeconds int `json:"maxOpenSeconds,omitempty"` // 任一阀门最长开启时长（秒），0 使用默认值，负数不限制
+		// 联锁（见 service/interlock.go）：各项为 0 表示不限制
+		MaxOpenNodes int                        `json:"maxOpenNodes,omitempty"` // 全站同时开启的节点数上限（泵/管压能力）
+		MinToggleSec int                        `json:"minToggleSec,omitempty"` // 同一节点两次切换的最小间隔（秒）
+		Devices      map[string]DeviceInterlock `json:"devices,omitempty"`      // 按 deviceAddr 的设备级联锁
+		Forbidden    [][]string                 `json:"forbidden,omitempty"`    // 不允许同时开启的节点组合（clientId 或 deviceAddr_nodeId）
+	} `json:"safety"`
+	// RelayPlatform 传感器平台（综合环境监控云平台）的继电器，见 relay.go；未配置 username 时不接入继电器
+	RelayPlatform struct {
+		BaseURL     string   `json:"baseUrl,omitempty"`
+		Username    string   `json:"username,omitempty"`
+		Password    string   `json:"password,omitempty"`    // 可为 enc:v1: 加密值
+		DeviceAddrs []string `json:"deviceAddrs,omitempty"` // 只接入这些设备的继电器，为空时接入账号下全部设备
+	} `json:"relayPlatform"`
 }
 
 // CredentialsPath 返回配置文件路径（兼容旧变量名）。
@@ -58,33 +133,8 @@ func loadCredentials() (*AppConfig, error) {
 	return &c, nil
 }
 
-// writeCredentials 将 Credentials 原子化写回 JSON 文件（0600 权限）。
-func writeCredentials(c AppConfig) error {
-	path := CredentialsPath()
-
-	// 确保目录存在
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return fmt.Errorf("创建目录失败: %w", err)
-	}
-
-	// 序列化（缩进便于人工查看）
-	b, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return fmt.Errorf("序列化凭据失败: %w", err)
-	}
-
-	// 原子写入：先写临时文件再替换
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, fs.FileMode(0o600)); err != nil {
-		return fmt.Errorf("写入临时文件失败: %w", err)
-	}
-	if err := os.Rename(tmp, path); err != nil {
-		return fmt.Errorf("替换凭据文件失败: %w", err)
-	}
-	return nil
-}
-
-// GetLoginCredentials 获取农业平台账号与密码。
+// GetLoginCredentials 获取农业平台账号与密码（已解密）。
+// password 可为 enc:v1: 加密值（见 secrets.go）或明文；为明文时只记一次警告，不改写配置文件。
 // 返回：username, password, error
 func GetLoginCredentials() (string, string, error) {
 	c, err := loadCredentials()
@@ -94,34 +144,83 @@ func GetLoginCredentials() (string, string, error) {
 	if strings.TrimSpace(c.AgriPlatform.Username) == "" || strings.TrimSpace(c.AgriPlatform.Password) == "" {
 		return "", "", errors.New("配置缺少 agriPlatform.username 或 password")
 	}
-	return c.AgriPlatform.Username, c.AgriPlatform.Password, nil
+	password, err := DecryptSecret(c.AgriPlatform.Password)
+	if err != nil {
+		return "", "", fmt.Errorf("解密 agriPlatform.password 失败: %w", err)
+	}
+	warnPlaintext("agriPlatform.password", c.AgriPlatform.Password)
+	return c.AgriPlatform.Username, password, nil
 }
 
 // ErrTokenMissing 严格模式下本地未找到已登录 token 时返回该错误。
 var ErrTokenMissing = errors.New("未登录")
 
-// GetUserToken 严格读取 userToken：为空返回 ErrTokenMissing。
+// defaultTokenPath 平台会话 token 文件（运行时数据，勿提交到版本库）。
+const defaultTokenPath = "internal/data/vendor_token"
+
+// TokenPath 返回平台会话 token 文件路径：优先环境变量 EXECUTOR_TOKEN_FILE。
+func TokenPath() string {
+	if env := os.Getenv("EXECUTOR_TOKEN_FILE"); env != "" {
+		return env
+	}
+	return defaultTokenPath
+}
+
+// GetUserToken 严格读取平台 userToken（已解密）：优先会话文件，不存在时回退到 config.json 的旧字段；为空返回 ErrTokenMissing。
 func GetUserToken() (string, error) {
-	c, err := loadCredentials()
-	if err != nil {
-		return "", err
+	raw := ""
+	if b, err := os.ReadFile(TokenPath()); err == nil {
+		raw = strings.TrimSpace(string(b))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("读取会话 token 失败: %w", err)
+	} else {
+		c, err := loadCredentials()
+		if err != nil {
+			return "", err
+		}
+		raw = strings.TrimSpace(c.AgriPlatform.UserToken)
 	}
-	tk := strings.TrimSpace(c.AgriPlatform.UserToken)
-	if tk == "" {
+	if raw == "" {
 		return "", ErrTokenMissing
 	}
+	tk, err := DecryptSecret(raw)
+	if err != nil {
+		return "", fmt.Errorf("解密会话 token 失败: %w", err)
+	}
 	return tk, nil
 }
 
-// SetUserToken 写入/更新 userToken。
-// 成功后落盘到凭据 JSON（权限 0600）。
+// SetUserToken 加密后原子写入会话 token 文件（权限 0600）。
 func SetUserToken(token string) error {
-	c, err := loadCredentials()
+	enc, err := EncryptSecret(token)
 	if err != nil {
-		return err
+		return fmt.Errorf("加密会话 token 失败: %w", err)
+	}
+	path := TokenPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(enc+"\n"), 0o600); err != nil {
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("替换会话 token 文件失败: %w", err)
+	}
+	return nil
+}
+
+// plaintextWarned 已警告过的明文字段，每个字段每进程只警告一次。
+var plaintextWarned sync.Map
+
+// warnPlaintext 配置字段为明文时记一次警告。
+func warnPlaintext(field, value string) {
+	if value == "" || IsEncryptedSecret(value) {
+		return
+	}
+	if _, dup := plaintextWarned.LoadOrStore(field, true); !dup {
+		log.Printf("[config] %s 为明文，建议用 go run ./cmd/encryptsecret 生成 enc:v1: 值替换", field)
 	}
-	c.AgriPlatform.UserToken = token
-	return writeCredentials(*c) // 解引用
 }
 
 // 默认第三方平台 API 基础地址（当 JSON 未设置时回退）。
@@ -159,3 +258,38 @@ func LoadCredentials() (AppConfig, error) {
 	}
 	return *c, nil // 解引用后返回
 }
+
+// 默认阀门回读确认超时：平台下发到设备并回报状态通常需要数秒。
+const defaultConfirmTimeout = 15 * time.Second
+
+// GetConfirmTimeout 读取阀门控制后回读确认的超时；未配置时回退到内置默认值。
+func GetConfirmTimeout() time.Duration {
+	c, err := loadCredentials()
+	if err != nil || c.AgriPlatform.ConfirmTimeoutSec <= 0 {
+		return defaultConfirmTimeout
+	}
+	return time.Duration(c.AgriPlatform.ConfirmTimeoutSec) * time.Second
+}
+
+// 默认阀门状态字段。平台文档（API文档.md 8.1 getDeviceIii、8.3 getDeviceNodeList）的节点对象没有开关状态字段，
+// 该默认值未经真实响应验证：部署前抓取一次阀门节点的 getDeviceNodeList 响应，确认字段名后配置 agriPlatform.nodeStateKey。
+const defaultNodeStateKey = "switchState"
+
+// GetNodeStateKey 读取节点对象中表示阀门开关状态的字段名；未配置时回退到内置默认值。
+func GetNodeStateKey() string {
+	c, err := loadCredentials()
+	if err != nil || strings.TrimSpace(c.AgriPlatform.NodeStateKey) == "" {
+		return defaultNodeStateKey
+	}
+	return strings.TrimSpace(c.AgriPlatform.NodeStateKey)
+}
+
+// GetAuthFailCodes 读取表示 token 失效的业务 code 列表。
+// 平台文档只给出成功码 1000，没有内置默认值：未配置时返回空，只按 HTTP 401/403 判断。
+func GetAuthFailCodes() []int {
+	c, err := loadCredentials()
+	if err != nil {
+		return nil
+	}
+	return c.AgriPlatform.AuthFailCodes
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 // 严格从 config.json 读取基础配置（不带端口）
@@ -19,15 +21,23 @@ func GetMagistralaBaseURL() (string, error) {
 	return "", errors.New("缺少共享配置 data/magistrala.json 的 baseUrl")
 }
 
+// GetMagistralaToken 读取 Magistrala 用户 token（已解密）：优先 config.json 的 magistrala.userToken，
+// 其次共享配置 data/magistrala.json；两处均可为 enc:v1: 加密值。
 func GetMagistralaToken() (string, error) {
-	// 仅从共享配置 data/magistrala.json 读取
-	if shared := tryLoadSharedMagBase(); shared != nil {
-		t := strings.TrimSpace(shared.UserToken)
-		if t != "" {
-			return t, nil
-		}
+	raw, field := "", ""
+	if c, err := loadCredentials(); err == nil && strings.TrimSpace(c.Magistrala.UserToken) != "" {
+		raw, field = strings.TrimSpace(c.Magistrala.UserToken), "magistrala.userToken"
+	} else if shared := tryLoadSharedMagBase(); shared != nil && strings.TrimSpace(shared.UserToken) != "" {
+		raw, field = strings.TrimSpace(shared.UserToken), "data/magistrala.json userToken"
+	}
+	if raw == "" {
+		return "", errors.New("缺少 magistrala.userToken（config.json 或共享配置 data/magistrala.json）")
+	}
+	t, err := DecryptSecret(raw)
+	if err != nil {
+		return "", fmt.Errorf("解密 %s 失败: %w", field, err)
 	}
-	return "", errors.New("缺少共享配置 data/magistrala.json 的 userToken")
+	return t, nil
 }
 
 func GetMagistralaDomainID() (string, error) {
@@ -83,3 +93,43 @@ func tryLoadSharedMagBase() *struct {
 	}
 	return nil
 }
+
+// 执行器状态发布默认值：HTTP 适配器端口与 agriDataIntegration 的 messagePort 一致。
+const (
+	defaultMessagePort    = "9011"
+	defaultStateSubtopic  = "executor"
+	defaultStateHeartbeat = 10 * time.Minute
+)
+
+// GetMagistralaMessagePort 读取 HTTP 适配器端口；未配置时回退到内置默认值。
+func GetMagistralaMessagePort() string {
+	c, err := loadCredentials()
+	if err != nil || strings.TrimSpace(c.Magistrala.MessagePort) == "" {
+		return defaultMessagePort
+	}
+	return strings.TrimSpace(c.Magistrala.MessagePort)
+}
+
+// GetStateSubtopic 读取执行器状态发布的子主题；未配置时回退到内置默认值。
+func GetStateSubtopic() string {
+	c, err := loadCredentials()
+	if err != nil || strings.Trim(c.Magistrala.StateSubtopic, " /") == "" {
+		return defaultStateSubtopic
+	}
+	return strings.Trim(c.Magistrala.StateSubtopic, " /")
+}
+
+// GetStateHeartbeat 读取对账时状态未变化节点的重复发布周期；未配置时回退到内置默认值。
+func GetStateHeartbeat() time.Duration {
+	c, err := loadCredentials()
+	if err != nil || c.Magistrala.StateHeartbeatSec <= 0 {
+		return defaultStateHeartbeat
+	}
+	return time.Duration(c.Magistrala.StateHeartbeatSec) * time.Second
+}
+
+// StatePublishEnabled 是否发布执行器状态（默认开启）。
+func StatePublishEnabled() bool {
+	c, err := loadCredentials()
+	return err != nil || !c.Magistrala.DisableStatePublish
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// 默认阀门状态对账周期。
+const defaultReconcileInterval = time.Minute
+
+// GetReconcileInterval 读取阀门状态对账周期；未配置时回退到默认值，配置为负数时返回 0（关闭对账）。
+func GetReconcileInterval() time.Duration {
+	c, err := loadCredentials()
+	if err != nil || c.Reconcile.IntervalSec == 0 {
+		return defaultReconcileInterval
+	}
+	if c.Reconcile.IntervalSec < 0 {
+		return 0
+	}
+	return time.Duration(c.Reconcile.IntervalSec) * time.Second
+}
+
+// GetDriftWebhookURL 读取状态漂移事件推送地址；未配置时返回空串。
+func GetDriftWebhookURL() string {
+	c, err := loadCredentials()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(c.Reconcile.WebhookURL)
+}
+
+// 孤儿映射（节点已从平台账号移除）的处理策略。
+const (
+	OrphanDisable = "disable" // 停用 Magistrala client，映射保留并标记 orphanedAt（默认）
+	OrphanDelete  = "delete"  // 删除 Magistrala client 与映射
+	OrphanKeep    = "keep"    // 仅报告，不做处理
+)
+
+// GetOrphanPolicy 读取孤儿映射处理策略；未配置或取值非法时回退到 disable。
+func GetOrphanPolicy() string {
+	c, err := loadCredentials()
+	if err != nil {
+		return OrphanDisable
+	}
+	switch p := strings.ToLower(strings.TrimSpace(c.Sync.OrphanPolicy)); p {
+	case OrphanDelete, OrphanKeep:
+		return p
+	}
+	return OrphanDisable
+}
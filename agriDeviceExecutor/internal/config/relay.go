@@ -0,0 +1,49 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 传感器平台默认地址（与 agriDataIntegration 接入的是同一平台）。
+const defaultRelayBaseURL = "http://www.0531yun.com"
+
+// RelayPlatform 是继电器所在传感器平台的接入参数（密码已解密）。
+type RelayPlatform struct {
+	BaseURL     string
+	Username    string
+	Password    string
+	DeviceAddrs []string
+}
+
+// ErrRelayDisabled 未配置 relayPlatform，继电器不接入。
+var ErrRelayDisabled = errors.New("未配置 relayPlatform，继电器不可用")
+
+// RelayPlatformEnabled 是否配置了继电器平台账号。
+func RelayPlatformEnabled() bool {
+	c, err := loadCredentials()
+	return err == nil && strings.TrimSpace(c.RelayPlatform.Username) != ""
+}
+
+// GetRelayPlatform 读取继电器平台参数；password 可为 enc:v1: 加密值或明文（同 agriPlatform.password）。
+func GetRelayPlatform() (RelayPlatform, error) {
+	c, err := loadCredentials()
+	if err != nil {
+		return RelayPlatform{}, err
+	}
+	rp := c.RelayPlatform
+	if strings.TrimSpace(rp.Username) == "" {
+		return RelayPlatform{}, ErrRelayDisabled
+	}
+	password, err := DecryptSecret(rp.Password)
+	if err != nil {
+		return RelayPlatform{}, fmt.Errorf("解密 relayPlatform.password 失败: %w", err)
+	}
+	warnPlaintext("relayPlatform.password", rp.Password)
+	base := strings.TrimRight(strings.TrimSpace(rp.BaseURL), "/")
+	if base == "" {
+		base = defaultRelayBaseURL
+	}
+	return RelayPlatform{BaseURL: base, Username: rp.Username, Password: password, DeviceAddrs: rp.DeviceAddrs}, nil
+}
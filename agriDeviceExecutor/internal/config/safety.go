@@ -0,0 +1,54 @@
+package config
+
+import "time"
+
+// 默认阀门最长开启时长：控制服务未给出 maxOpenSeconds 或异常退出时，阀门最多保持开启这么久。
+const defaultMaxOpenSeconds = 4 * 3600
+
+// GetMaxOpenDuration 读取任一阀门的最长开启时长（全局上限）；未配置时回退到默认值，配置为负数时返回 0（不限制）。
+func GetMaxOpenDuration() time.Duration {
+	c, err := loadCredentials()
+	if err != nil || c.Safety.MaxOpenSeconds == 0 {
+		return defaultMaxOpenSeconds * time.Second
+	}
+	if c.Safety.MaxOpenSeconds < 0 {
+		return 0
+	}
+	return time.Duration(c.Safety.MaxOpenSeconds) * time.Second
+}
+
+// DeviceInterlock 是单台设备的联锁参数；MinToggleSec 非 0 时覆盖全站值。
+type DeviceInterlock struct {
+	MaxOpenNodes int `json:"maxOpenNodes,omitempty"`
+	MinToggleSec int `json:"minToggleSec,omitempty"`
+}
+
+// Interlocks 是液压安全联锁参数；数值为 0 表示不限制。
+type Interlocks struct {
+	MaxOpenNodes int
+	MinToggle    time.Duration
+	Devices      map[string]DeviceInterlock
+	Forbidden    [][]string
+}
+
+// GetInterlocks 读取联锁参数；读取失败时返回零值（不限制）。
+func GetInterlocks() Interlocks {
+	c, err := loadCredentials()
+	if err != nil {
+		return Interlocks{}
+	}
+	return Interlocks{
+		MaxOpenNodes: max(c.Safety.MaxOpenNodes, 0),
+		MinToggle:    time.Duration(max(c.Safety.MinToggleSec, 0)) * time.Second,
+		Devices:      c.Safety.Devices,
+		Forbidden:    c.Safety.Forbidden,
+	}
+}
+
+// DeviceMinToggle 返回设备的最小切换间隔：设备配置优先，否则取全站值。
+func (il Interlocks) DeviceMinToggle(deviceAddr string) time.Duration {
+	if d, ok := il.Devices[deviceAddr]; ok && d.MinToggleSec > 0 {
+		return time.Duration(d.MinToggleSec) * time.Second
+	}
+	return il.MinToggle
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// secrets.go：敏感字段的静态加密（AES-256-GCM）。
+// 加密后的值形如 "enc:v1:<base64(nonce|密文)>"；不带前缀的值视为旧版明文，读取时原样返回，
+// 由调用方在下次写入时加密（config.json 的平台密码、映射库中的 clientSecret）。
+//
+// 密钥来源（按优先级）：
+//   - 环境变量 EXECUTOR_SECRET_KEY：hex 编码的 32 字节密钥；
+//   - 环境变量 EXECUTOR_SECRET_KEY_FILE 指定的文件，默认 internal/data/secret.key；
+//     文件不存在时自动生成（0600），勿提交到版本库，丢失后已加密的数据无法解密。
+
+const (
+	secretPrefix         = "enc:v1:"
+	defaultSecretKeyPath = "internal/data/secret.key"
+	secretKeySize        = 32
+)
+
+var (
+	secretMu   sync.Mutex
+	secretAEAD cipher.AEAD
+)
+
+// ErrSecretKey 密钥与已加密数据不匹配（密钥被替换或数据损坏）。
+var ErrSecretKey = errors.New("解密失败：密钥不匹配或数据已损坏")
+
+// SecretKeyPath 返回密钥文件路径。
+func SecretKeyPath() string {
+	if env := os.Getenv("EXECUTOR_SECRET_KEY_FILE"); env != "" {
+		return env
+	}
+	return defaultSecretKeyPath
+}
+
+// IsEncryptedSecret 判断值是否已加密。
+func IsEncryptedSecret(s string) bool {
+	return strings.HasPrefix(s, secretPrefix)
+}
+
+// EncryptSecret 加密敏感字段；空串与已加密的值原样返回。
+func EncryptSecret(plain string) (string, error) {
+	if plain == "" || IsEncryptedSecret(plain) {
+		return plain, nil
+	}
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret 解密敏感字段；未加密的旧版明文原样返回。
+func DecryptSecret(s string) (string, error) {
+	if !IsEncryptedSecret(s) {
+		return s, nil
+	}
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, secretPrefix))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", ErrSecretKey
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrSecretKey
+	}
+	return string(plain), nil
+}
+
+// RedactSecret 返回用于 API 输出的脱敏值：非空一律显示为 "******"。
+func RedactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "******"
+}
+
+// secretCipher 懒加载密钥并缓存 AEAD。
+func secretCipher() (cipher.AEAD, error) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	if secretAEAD != nil {
+		return secretAEAD, nil
+	}
+	key, err := loadSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("初始化加密失败: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("初始化加密失败: %w", err)
+	}
+	secretAEAD = aead
+	return aead, nil
+}
+
+// loadSecretKey 读取环境变量或密钥文件；文件不存在时生成。
+func loadSecretKey() ([]byte, error) {
+	if env := strings.TrimSpace(os.Getenv("EXECUTOR_SECRET_KEY")); env != "" {
+		key, err := hex.DecodeString(env)
+		if err != nil || len(key) != secretKeySize {
+			return nil, errors.New("EXECUTOR_SECRET_KEY 须为 hex 编码的 32 字节密钥")
+		}
+		return key, nil
+	}
+	path := SecretKeyPath()
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, secretKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("生成加密密钥失败: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("创建目录失败: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("写入加密密钥失败: %w", err)
+		}
+		log.Printf("[secrets] 已生成加密密钥 %s，请妥善备份", path)
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("读取加密密钥失败: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != secretKeySize {
+		return nil, fmt.Errorf("加密密钥格式错误: %s", path)
+	}
+	return key, nil
+}
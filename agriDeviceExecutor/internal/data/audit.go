@@ -1,8 +1,10 @@
 package data
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,42 +13,228 @@ import (
 
 // 审计日志以 JSON Lines 形式追加写入 audit.log
 // 单行结构：AuditRecord
-// 设计：轻量无轮转；后续可根据大小增加简单轮转策略。
+// 轮转：按大小或时间把 audit.log 改名为 audit-<时间>.log（检查点文件一并改名），新文件延续 seq 与哈希链（见 audit_rotate.go）。
+// 防篡改：每条记录带 seq 与上一条记录的哈希（哈希链），并定期写入 ed25519 签名检查点（见 audit_chain.go）。
 
-const auditLogPath = "internal/data/audit.log"
+const (
+	auditLogPath         = "internal/data/audit.log"
+	auditKeyPath         = "internal/data/audit.key" // 检查点签名私钥（首次启动生成，勿提交到版本库）
+	auditCheckpointEvery = 50                        // 每追加多少条记录写一次检查点
+)
+
+// auditNow 审计使用的时钟（记录时间、检查点时间、分段文件名），测试可替换。
+var auditNow = time.Now
+
+var (
+	auditMu       sync.Mutex
+	auditLoaded   bool   // 是否已从文件恢复链头
+	auditSeq      int64  // 链上最后一条记录的序号
+	auditLastHash string // 链上最后一条记录的哈希
+	auditCpSeq    int64  // 最近一次检查点覆盖到的序号
+	auditSigner   *auditKey
+	auditSize     int64 // 当前 audit.log 的字节数
+	auditStartTs  int64 // 当前 audit.log 第一条记录的时间（按时间轮转）
+)
 
-var auditMu sync.Mutex
+// 审计来源（AuditRecord.Source）。HTTP 请求可用 X-Request-Source 头细分（如 llm、scheduler）。
+const (
+	SourceHTTP      = "http"
+	SourceMQTT      = "mqtt"
+	SourceReconcile = "reconcile"
+	SourceSync      = "sync"
+	SourceStartup   = "startup"
+	SourceDeadman   = "deadman"
+)
+
+// Origin 描述一次操作的发起方，写入审计的 source / remote。
+type Origin struct {
+	Source string // http / mqtt / reconcile / sync / startup，或 X-Request-Source 指定的值
+	Remote string // 请求方地址（HTTP 客户端 IP）；非 HTTP 来源为空
+}
 
 // AuditRecord 记录一次执行或映射相关操作。
 type AuditRecord struct {
+	Seq        int64       `json:"seq,omitempty"`
 	Timestamp  int64       `json:"ts"`
 	Action     string      `json:"action"` // valveControl / modeUpdate / syncAdd / syncSkip / syncError 等
 	ClientId   string      `json:"clientId"`
 	DeviceAddr string      `json:"deviceAddr"`
 	NodeId     int         `json:"nodeId"`
 	Success    bool        `json:"success"`
-	Detail     string      `json:"detail"`          // 错误或补充说明
-	Extra      interface{} `json:"extra,omitempty"` // 可选扩展字段
+	Detail     string      `json:"detail"`               // 错误或补充说明
+	Extra      interface{} `json:"extra,omitempty"`      // 可选扩展字段
+	Source     string      `json:"source,omitempty"`     // 发起方，见 Origin
+	Remote     string      `json:"remote,omitempty"`     // 请求方地址
+	DurationMs int64       `json:"durationMs,omitempty"` // 操作耗时（毫秒）
+	PrevHash   string      `json:"prevHash,omitempty"`
+	Hash       string      `json:"hash,omitempty"`
 }
 
-// AppendAudit 追加审计记录。
+// AppendAudit 追加审计记录（填写 seq/prevHash/hash）。
 func AppendAudit(rec AuditRecord) error {
 	auditMu.Lock()
 	defer auditMu.Unlock()
 	if err := os.MkdirAll(filepath.Dir(auditLogPath), 0o755); err != nil {
 		return fmt.Errorf("创建审计目录失败: %w", err)
 	}
+	if err := loadAuditChainLocked(); err != nil {
+		return err
+	}
+	if rec.Timestamp == 0 {
+		rec.Timestamp = auditNow().Unix()
+	}
+	if err := rotateAuditLocked(time.Unix(rec.Timestamp, 0)); err != nil {
+		log.Printf("[audit] 轮转失败，继续写入当前文件: %v", err)
+	}
+	return appendAuditLocked(rec)
+}
+
+// appendAuditLocked 把记录接到链尾并写入 audit.log，按需写检查点；调用方持有 auditMu 且已恢复链头。
+func appendAuditLocked(rec AuditRecord) error {
 	f, err := os.OpenFile(auditLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
 	if err != nil {
 		return fmt.Errorf("打开审计文件失败: %w", err)
 	}
 	defer f.Close()
-	if rec.Timestamp == 0 {
-		rec.Timestamp = time.Now().Unix()
+	rec.Seq = auditSeq + 1
+	rec.PrevHash = auditLastHash
+	hash, err := hashAuditRecord(rec)
+	if err != nil {
+		return fmt.Errorf("计算审计哈希失败: %w", err)
 	}
+	rec.Hash = hash
 	b, _ := json.Marshal(rec)
 	if _, err := f.Write(append(b, '\n')); err != nil {
 		return fmt.Errorf("写入审计失败: %w", err)
 	}
+	auditSeq, auditLastHash = rec.Seq, rec.Hash
+	auditSize += int64(len(b)) + 1
+	if auditStartTs == 0 {
+		auditStartTs = rec.Timestamp
+	}
+	if auditSeq-auditCpSeq >= auditCheckpointEvery {
+		return checkpointAuditLocked()
+	}
+	return nil
+}
+
+// ReloadAudit 丢弃内存中的链头与签名私钥，下次写入时从审计文件重新恢复（审计文件被替换或切换工作目录后调用）。
+func ReloadAudit() {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditLoaded, auditSeq, auditLastHash, auditCpSeq = false, 0, "", 0
+	auditSigner, auditSize, auditStartTs = nil, 0, 0
+}
+
+// CheckpointAudit 为当前链头写一条签名检查点；自上次检查点后无新记录时不做任何事。
+// 建议定期调用，缩短尾部截断无法被发现的窗口。
+func CheckpointAudit() error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if err := loadAuditChainLocked(); err != nil {
+		return err
+	}
+	return checkpointAuditLocked()
+}
+
+// loadAuditChainLocked 首次写入前恢复链头，并加载签名私钥（失败则仅记录日志、不写检查点）。
+// audit.log 刚轮转过（没有链上记录）时从最新的历史分段恢复 seq、哈希与检查点位置。
+func loadAuditChainLocked() error {
+	if auditLoaded {
+		return nil
+	}
+	head, found, err := scanAuditHead(auditLogPath)
+	if err != nil {
+		return err
+	}
+	auditSize, auditStartTs = head.size, head.startTs
+	headPath := auditLogPath
+	if !found {
+		segs, err := auditRotatedSegments(auditLogPath)
+		if err != nil {
+			return err
+		}
+		for i := len(segs) - 1; i >= 0 && !found; i-- {
+			var h auditHead
+			if h, found, err = scanAuditHead(segs[i]); err != nil {
+				return err
+			}
+			if found {
+				head, headPath = h, segs[i]
+			}
+		}
+	}
+	auditSeq, auditLastHash = head.seq, head.hash
+	cps, err := readAuditCheckpoints(AuditCheckpointPath(headPath))
+	if err != nil {
+		return err
+	}
+	if len(cps) > 0 {
+		auditCpSeq = cps[len(cps)-1].Seq
+	}
+	if k, err := loadOrCreateAuditKey(auditKeyPath); err != nil {
+		log.Printf("[audit] 签名私钥不可用，不写检查点: %v", err)
+	} else {
+		auditSigner = k
+	}
+	auditLoaded = true
+	return nil
+}
+
+// auditHead 是单个审计文件的链尾与文件信息。
+type auditHead struct {
+	seq     int64
+	hash    string
+	size    int64
+	startTs int64
+}
+
+// scanAuditHead 读取审计文件的最后一条链上记录；文件不存在时 found=false。
+func scanAuditHead(path string) (head auditHead, found bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return head, false, nil
+	}
+	if err != nil {
+		return head, false, fmt.Errorf("打开审计文件失败: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		head.size += int64(len(scanner.Bytes())) + 1
+		var r AuditRecord
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		if head.startTs == 0 {
+			head.startTs = r.Timestamp
+		}
+		if r.Hash != "" {
+			head.seq, head.hash, found = r.Seq, r.Hash, true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return head, false, fmt.Errorf("读取审计文件失败: %w", err)
+	}
+	return head, found, nil
+}
+
+// checkpointAuditLocked 对当前链头签名并追加到检查点文件。
+func checkpointAuditLocked() error {
+	if auditSigner == nil || auditSeq == 0 || auditSeq == auditCpSeq {
+		return nil
+	}
+	c := auditSigner.sign(auditSeq, auditLastHash)
+	b, _ := json.Marshal(c)
+	f, err := os.OpenFile(AuditCheckpointPath(auditLogPath), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开检查点文件失败: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("写入检查点失败: %w", err)
+	}
+	auditCpSeq = auditSeq
 	return nil
 }
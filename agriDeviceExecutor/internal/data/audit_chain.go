@@ -0,0 +1,328 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 审计日志哈希链与签名检查点：
+// - hash = sha256(prevHash + 规范化记录 JSON（hash 字段置空）)，修改/插入/删除中间记录会使链断开；
+// - 检查点对最新 (seq, hash) 做 ed25519 签名，写入 audit.log.checkpoints，截断尾部或整体重算链会与检查点不符；
+// - 轮转后每个分段有各自的检查点文件，链跨分段连续；
+// - 按保留策略删除最旧的分段前先在链上追加 auditPrune 记录，链的起点须与最近一条删除记录一致（见 audit_rotate.go）；
+// - 校验只需公钥 audit.key.pub（见 cmd/auditverify）。
+
+// AuditCheckpoint 是对某一时刻审计链头的签名。
+type AuditCheckpoint struct {
+	Seq   int64  `json:"seq"`
+	Hash  string `json:"hash"`
+	Ts    int64  `json:"ts"`
+	KeyID string `json:"keyId"`
+	Sig   string `json:"sig"`
+}
+
+func (c AuditCheckpoint) signedPayload() []byte {
+	return []byte(fmt.Sprintf("%d|%s|%d", c.Seq, c.Hash, c.Ts))
+}
+
+// AuditCheckpointPath 返回审计日志对应的检查点文件路径。
+func AuditCheckpointPath(logPath string) string {
+	return logPath + ".checkpoints"
+}
+
+// DefaultAuditPaths 返回默认的审计日志与公钥路径（相对执行目录）。
+func DefaultAuditPaths() (logPath, pubKeyPath string) {
+	return auditLogPath, auditKeyPath + ".pub"
+}
+
+type auditKey struct {
+	priv  ed25519.PrivateKey
+	keyID string
+}
+
+// loadOrCreateAuditKey 加载 hex 编码的 ed25519 seed；不存在时生成（0600），并写出公钥 <path>.pub。
+func loadOrCreateAuditKey(path string) (*auditKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("生成签名私钥失败: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("创建目录失败: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("写入签名私钥失败: %w", err)
+		}
+		b = []byte(hex.EncodeToString(seed))
+	} else if err != nil {
+		return nil, fmt.Errorf("读取签名私钥失败: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("签名私钥格式错误: %s", path)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := priv.Public().(ed25519.PublicKey)
+	if err := os.WriteFile(path+".pub", []byte(hex.EncodeToString(pub)+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("写入公钥失败: %w", err)
+	}
+	return &auditKey{priv: priv, keyID: auditKeyID(pub)}, nil
+}
+
+func (k *auditKey) sign(seq int64, hash string) AuditCheckpoint {
+	c := AuditCheckpoint{Seq: seq, Hash: hash, Ts: auditNow().Unix(), KeyID: k.keyID}
+	c.Sig = hex.EncodeToString(ed25519.Sign(k.priv, c.signedPayload()))
+	return c
+}
+
+func auditKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadAuditPublicKey 读取 hex 编码的 ed25519 公钥。
+func LoadAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取公钥失败: %w", err)
+	}
+	pub, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("公钥格式错误: %s", path)
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// hashAuditRecord 计算记录哈希。Extra 可能是任意结构体，先规范化为有序键的 JSON，
+// 保证写入时与校验时（从文件解码为 map 后）得到相同的字节序列。
+func hashAuditRecord(rec AuditRecord) (string, error) {
+	rec.Hash = ""
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return "", err
+	}
+	canon, err := json.Marshal(generic)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(rec.PrevHash), canon...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditVerifyReport 是审计日志校验结果。
+type AuditVerifyReport struct {
+	Segments    int      `json:"segments"`
+	Records     int      `json:"records"`
+	Legacy      int      `json:"legacy"` // 启用哈希链之前的历史记录，不参与校验
+	Pruned      int64    `json:"pruned"` // 按保留策略删除的最早分段中的记录数（链从 seq Pruned+1 开始，须有对应的 auditPrune 记录）
+	LastSeq     int64    `json:"lastSeq"`
+	Checkpoints int      `json:"checkpoints"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// OK 表示未发现篡改或截断。
+func (r AuditVerifyReport) OK() bool { return len(r.Problems) == 0 }
+
+// VerifyAudit 校验单个审计文件的哈希链与检查点签名；pub 为 nil 时只校验哈希链。
+// 链必须从 seq 1 开始，轮转后的日志请用 VerifyAuditSegments。
+func VerifyAudit(logPath, checkpointPath string, pub ed25519.PublicKey) (AuditVerifyReport, error) {
+	v := auditVerifier{pub: pub}
+	err := v.segment(logPath, checkpointPath, "")
+	return v.rep, err
+}
+
+// VerifyAuditSegments 按顺序校验全部分段（见 AuditSegments），链须跨分段连续；
+// 各分段用各自的检查点文件。最早的分段已按保留策略删除时链可从 seq>1 开始，计入 Pruned，
+// 但起点须与链上最近一条 auditPrune 记录一致：没有删除记录、或删除的比记录的多，都视为分段被删除。
+func VerifyAuditSegments(logPath string, pub ed25519.PublicKey) (AuditVerifyReport, error) {
+	paths, err := AuditSegments(logPath)
+	if err != nil {
+		return AuditVerifyReport{}, err
+	}
+	if len(paths) == 0 {
+		return AuditVerifyReport{}, fmt.Errorf("打开审计文件失败: %s 不存在", logPath)
+	}
+	v := auditVerifier{pub: pub, allowPruned: true}
+	for _, p := range paths {
+		if err := v.segment(p, AuditCheckpointPath(p), filepath.Base(p)+" "); err != nil {
+			return v.rep, err
+		}
+	}
+	v.checkPruned()
+	return v.rep, nil
+}
+
+// checkPruned 核对链起点与最近一条 auditPrune 记录：记录之后仍保留的旧分段（删除失败）不算问题。
+func (v *auditVerifier) checkPruned() {
+	if v.rep.Pruned == 0 {
+		return
+	}
+	p := v.lastPrune
+	switch {
+	case p == nil:
+		v.rep.Problems = append(v.rep.Problems, fmt.Sprintf("链从 seq %d 开始，但没有分段删除记录（最早的分段被删除）", v.rep.Pruned+1))
+	case v.rep.Pruned > p.ThroughSeq:
+		v.rep.Problems = append(v.rep.Problems, fmt.Sprintf("链从 seq %d 开始，但删除记录只覆盖到 seq %d（多删除了分段）", v.rep.Pruned+1, p.ThroughSeq))
+	case v.rep.Pruned == p.ThroughSeq && v.prunedPrev != p.ThroughHash:
+		v.rep.Problems = append(v.rep.Problems, fmt.Sprintf("链起点的 prevHash 与删除记录中 seq %d 的哈希不符", p.ThroughSeq))
+	}
+}
+
+// auditVerifier 在分段之间延续链状态。
+type auditVerifier struct {
+	pub         ed25519.PublicKey
+	allowPruned bool
+	rep         AuditVerifyReport
+	prev        string
+	chained     bool
+	prunedPrev  string      // 链起点（seq Pruned+1）的 prevHash
+	lastPrune   *AuditPrune // 链上最近一条 auditPrune 记录
+}
+
+func (v *auditVerifier) segment(logPath, checkpointPath, label string) error {
+	rep := &v.rep
+	problem := func(format string, args ...interface{}) {
+		rep.Problems = append(rep.Problems, label+fmt.Sprintf(format, args...))
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("打开审计文件失败: %w", err)
+	}
+	defer f.Close()
+	rep.Segments++
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	hashes := map[int64]string{}
+	var segLast int64
+	line := 0
+	for scanner.Scan() {
+		line++
+		rep.Records++
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			problem("第 %d 行: 无法解析: %v", line, err)
+			continue
+		}
+		if r.Seq == 0 && r.Hash == "" {
+			if v.chained {
+				problem("第 %d 行: 哈希链开始后出现无链记录", line)
+			} else {
+				rep.Legacy++
+			}
+			continue
+		}
+		checkPrev := true
+		if !v.chained {
+			v.chained = true
+			if r.Seq != 1 {
+				if v.allowPruned && rep.Segments == 1 && r.PrevHash != "" {
+					rep.Pruned = r.Seq - 1
+					v.prunedPrev = r.PrevHash
+					checkPrev = false
+				} else {
+					problem("第 %d 行: 链从 seq %d 开始，之前的记录缺失", line, r.Seq)
+				}
+			}
+		} else if r.Seq != rep.LastSeq+1 {
+			problem("第 %d 行: seq %d 紧跟 %d（记录被插入或删除）", line, r.Seq, rep.LastSeq)
+		}
+		if checkPrev && r.PrevHash != v.prev {
+			problem("第 %d 行 (seq %d): prevHash 不匹配", line, r.Seq)
+		}
+		if want, err := hashAuditRecord(r); err != nil {
+			problem("第 %d 行 (seq %d): 计算哈希失败: %v", line, r.Seq, err)
+		} else if want != r.Hash {
+			problem("第 %d 行 (seq %d): 记录被修改（哈希不匹配）", line, r.Seq)
+		}
+		if r.Action == AuditActionPrune {
+			var p AuditPrune
+			if b, err := json.Marshal(r.Extra); err == nil && json.Unmarshal(b, &p) == nil && p.ThroughSeq > 0 {
+				v.lastPrune = &p
+			} else {
+				problem("第 %d 行 (seq %d): 分段删除记录格式错误", line, r.Seq)
+			}
+		}
+		hashes[r.Seq] = r.Hash
+		v.prev = r.Hash
+		rep.LastSeq = r.Seq
+		segLast = r.Seq
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取审计文件失败: %w", err)
+	}
+
+	cps, err := readAuditCheckpoints(checkpointPath)
+	if err != nil {
+		return err
+	}
+	rep.Checkpoints += len(cps)
+	if len(cps) == 0 && segLast > 0 {
+		problem("未找到检查点: %s", checkpointPath)
+	}
+	var lastCp int64
+	for i, c := range cps {
+		if v.pub != nil {
+			sig, err := hex.DecodeString(c.Sig)
+			if err != nil || c.KeyID != auditKeyID(v.pub) || !ed25519.Verify(v.pub, c.signedPayload(), sig) {
+				problem("检查点 %d (seq %d): 签名无效", i+1, c.Seq)
+				continue
+			}
+		}
+		if c.Seq < lastCp {
+			problem("检查点 %d: seq %d 倒退", i+1, c.Seq)
+		}
+		lastCp = c.Seq
+		h, ok := hashes[c.Seq]
+		switch {
+		case c.Seq > segLast:
+			problem("检查点 %d: seq %d 超出日志末尾（最后 seq %d），日志被截断", i+1, c.Seq, segLast)
+		case !ok:
+			problem("检查点 %d: 日志中缺少 seq %d", i+1, c.Seq)
+		case h != c.Hash:
+			problem("检查点 %d: seq %d 的哈希与签名检查点不符（链被重算）", i+1, c.Seq)
+		}
+	}
+	return nil
+}
+
+// readAuditCheckpoints 读取检查点文件；不存在视为没有检查点。
+func readAuditCheckpoints(path string) ([]AuditCheckpoint, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取检查点失败: %w", err)
+	}
+	var out []AuditCheckpoint
+	for i, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var c AuditCheckpoint
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("检查点第 %d 行解析失败: %w", i+1, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
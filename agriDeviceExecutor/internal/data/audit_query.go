@@ -0,0 +1,134 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AuditQuery 是审计查询条件；零值字段不过滤。
+type AuditQuery struct {
+	ClientId string
+	Actions  []string // 任一匹配
+	Source   string
+	From     int64 // unix 秒，含
+	To       int64 // unix 秒，含
+	Success  *bool
+	Offset   int
+	Limit    int // <=0 返回全部
+}
+
+func (q AuditQuery) match(r AuditRecord) bool {
+	if q.ClientId != "" && r.ClientId != q.ClientId {
+		return false
+	}
+	if q.Source != "" && r.Source != q.Source {
+		return false
+	}
+	if q.From > 0 && r.Timestamp < q.From {
+		return false
+	}
+	if q.To > 0 && r.Timestamp > q.To {
+		return false
+	}
+	if q.Success != nil && r.Success != *q.Success {
+		return false
+	}
+	if len(q.Actions) == 0 {
+		return true
+	}
+	for _, a := range q.Actions {
+		if r.Action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryAudit 在全部分段（含已轮转的历史分段）中查询审计记录，按时间倒序（最新在前），
+// 返回 Offset/Limit 对应的一页与匹配总数。
+// 分段从旧到新逐行流式读取，只在环形缓冲中保留最近 Offset+Limit 条命中记录（Limit<=0 时保留全部），
+// 内存占用与页大小相关而与日志总量无关。
+// 文件句柄在 auditMu 下打开，之后读取期间的轮转（改名/删除）不影响本次查询。
+func QueryAudit(q AuditQuery) ([]AuditRecord, int, error) {
+	files, err := openAuditSegments()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	keep := 0 // 环形缓冲容量，0 表示不限
+	if q.Limit > 0 {
+		keep = max(q.Offset, 0) + q.Limit
+	}
+	var ring []AuditRecord
+	next, total := 0, 0
+	for _, f := range files {
+		// 分段最后修改时间早于 From 的，其中记录都不会命中
+		if st, err := f.Stat(); err == nil && q.From > 0 && st.ModTime().Unix() < q.From {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			var r AuditRecord
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue
+			}
+			if !q.match(r) {
+				continue
+			}
+			total++
+			if keep == 0 || len(ring) < keep {
+				ring = append(ring, r)
+			} else {
+				ring[next] = r
+				next = (next + 1) % keep
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, 0, fmt.Errorf("读取审计文件失败: %w", err)
+		}
+	}
+
+	// 还原为从旧到新，再倒序为最新在前
+	matched := append(ring[next:len(ring):len(ring)], ring[:next]...)
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	if q.Offset >= total {
+		return []AuditRecord{}, total, nil
+	}
+	page := matched[max(q.Offset, 0):]
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+	}
+	return page, total, nil
+}
+
+// openAuditSegments 在 auditMu 下打开全部分段（从旧到新）。
+func openAuditSegments() ([]*os.File, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	paths, err := AuditSegments(auditLogPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("打开审计文件失败: %w", err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+)
+
+// 审计日志轮转：
+// - 写入前若 audit.log 超过 audit.maxSizeMB，或第一条记录已超过 audit.maxAgeHours，先对链头写检查点，
+//   再把 audit.log 与 audit.log.checkpoints 改名为 audit-<时间>.log 与 audit-<时间>.log.checkpoints；
+// - 新的 audit.log 延续 seq 与 prevHash，整条链跨分段连续（校验见 VerifyAuditSegments）；
+// - 历史分段超过 audit.maxFiles 时删除最旧的分段：删除前先在链上追加一条 auditPrune 记录，写明删除到的 seq 与该记录哈希；
+//   校验时链从保留的第一段开始（计入 pruned），其起点须与最近一条 auditPrune 记录一致，否则视为分段被删除。
+
+// auditSegmentTimeLayout 分段文件名中的时间，字典序即时间顺序。
+const auditSegmentTimeLayout = "20060102-150405.000"
+
+// AuditActionPrune 是删除过期分段的审计动作，Extra 为 AuditPrune。
+const AuditActionPrune = "auditPrune"
+
+// AuditPrune 记录一次按保留策略删除的分段：链上 seq ≤ ThroughSeq 的记录已删除，ThroughHash 为 seq ThroughSeq 的哈希。
+type AuditPrune struct {
+	ThroughSeq  int64    `json:"throughSeq"`
+	ThroughHash string   `json:"throughHash"`
+	Segments    []string `json:"segments"`
+}
+
+// rotateAuditLocked 按配置判断是否需要轮转；调用方持有 auditMu。
+func rotateAuditLocked(now time.Time) error {
+	if auditSize == 0 {
+		return nil
+	}
+	rot := config.GetAuditRotation()
+	bySize := rot.MaxBytes > 0 && auditSize >= rot.MaxBytes
+	byAge := rot.MaxAge > 0 && auditStartTs > 0 && now.Sub(time.Unix(auditStartTs, 0)) >= rot.MaxAge
+	if !bySize && !byAge {
+		return nil
+	}
+	// 分段末尾必须有签名检查点，否则截断分段尾部无法被发现
+	if err := checkpointAuditLocked(); err != nil {
+		return err
+	}
+	seg := auditSegmentPath(auditLogPath, auditNow())
+	if _, err := os.Stat(seg); err == nil {
+		return fmt.Errorf("分段文件已存在: %s", seg)
+	}
+	if err := os.Rename(auditLogPath, seg); err != nil {
+		return fmt.Errorf("轮转审计文件失败: %w", err)
+	}
+	if err := os.Rename(AuditCheckpointPath(auditLogPath), AuditCheckpointPath(seg)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("轮转检查点文件失败: %w", err)
+	}
+	auditSize, auditStartTs = 0, 0
+	log.Printf("[audit] 已轮转到 %s（seq ≤ %d）", filepath.Base(seg), auditSeq)
+	return pruneAuditSegments(auditLogPath, rot.MaxFiles)
+}
+
+// auditSegmentPath 返回轮转后的分段路径：audit.log → audit-20060102-150405.000.log。
+func auditSegmentPath(logPath string, t time.Time) string {
+	ext := filepath.Ext(logPath)
+	return strings.TrimSuffix(logPath, ext) + "-" + t.Format(auditSegmentTimeLayout) + ext
+}
+
+// auditRotatedSegments 返回已轮转的历史分段，按时间从旧到新。
+func auditRotatedSegments(logPath string) ([]string, error) {
+	ext := filepath.Ext(logPath)
+	segs, err := filepath.Glob(strings.TrimSuffix(logPath, ext) + "-*" + ext)
+	if err != nil {
+		return nil, fmt.Errorf("列出审计分段失败: %w", err)
+	}
+	sort.Strings(segs)
+	return segs, nil
+}
+
+// AuditSegments 返回完整审计链的全部文件：历史分段（从旧到新）加当前日志（存在时）。
+func AuditSegments(logPath string) ([]string, error) {
+	segs, err := auditRotatedSegments(logPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(logPath); err == nil {
+		segs = append(segs, logPath)
+	}
+	return segs, nil
+}
+
+// pruneAuditSegments 只保留最近 keep 个历史分段（keep<=0 时全部保留）。调用方持有 auditMu。
+// 删除前先追加 auditPrune 记录（写入失败则不删除）；删除失败只记录日志，校验时多保留的分段不算问题。
+func pruneAuditSegments(logPath string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	segs, err := auditRotatedSegments(logPath)
+	if err != nil || len(segs) <= keep {
+		return err
+	}
+	doomed := segs[:len(segs)-keep]
+	var through auditHead
+	for i := len(doomed) - 1; i >= 0; i-- { // 最后一个含链上记录的待删分段
+		h, found, err := scanAuditHead(doomed[i])
+		if err != nil {
+			return err
+		}
+		if found {
+			through = h
+			break
+		}
+	}
+	if through.seq > 0 {
+		names := make([]string, len(doomed))
+		for i, seg := range doomed {
+			names[i] = filepath.Base(seg)
+		}
+		err := appendAuditLocked(AuditRecord{
+			Timestamp: auditNow().Unix(),
+			Action:    AuditActionPrune,
+			Success:   true,
+			Detail:    fmt.Sprintf("删除 %d 个过期分段（seq ≤ %d）", len(doomed), through.seq),
+			Extra:     AuditPrune{ThroughSeq: through.seq, ThroughHash: through.hash, Segments: names},
+		})
+		if err != nil {
+			return fmt.Errorf("写入分段删除记录失败，暂不删除: %w", err)
+		}
+	}
+	for _, seg := range doomed {
+		if err := os.Remove(seg); err != nil {
+			log.Printf("[audit] 删除过期分段失败 %s: %v", seg, err)
+			continue
+		}
+		_ = os.Remove(AuditCheckpointPath(seg))
+		log.Printf("[audit] 已删除过期分段 %s", filepath.Base(seg))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupAudit 在临时目录中重新初始化审计链：每条记录前时钟前进 2 小时，超过 maxAgeHours=1 即轮转，只保留 2 个历史分段。
+func setupAudit(t *testing.T) (tick func()) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	cfg := filepath.Join(dir, "config.json")
+	b, _ := json.Marshal(map[string]any{"audit": map[string]any{"maxSizeMB": -1, "maxAgeHours": 1, "maxFiles": 2}})
+	if err := os.WriteFile(cfg, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", cfg)
+
+	now := time.Unix(1767884023, 0)
+	auditNow = func() time.Time { return now }
+	ReloadAudit()
+	t.Cleanup(func() {
+		auditNow = time.Now
+		ReloadAudit()
+	})
+	return func() { now = now.Add(2 * time.Hour) }
+}
+
+func appendRecords(t *testing.T, tick func(), n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		tick()
+		if err := AppendAudit(AuditRecord{Action: "valveControl", ClientId: fmt.Sprintf("c%d", i), Success: true}); err != nil {
+			t.Fatalf("写入审计失败: %v", err)
+		}
+	}
+}
+
+func TestAuditPruneIsRecorded(t *testing.T) {
+	tick := setupAudit(t)
+	appendRecords(t, tick, 6)
+	if err := CheckpointAudit(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := LoadAuditPublicKey(auditKeyPath + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := VerifyAuditSegments(auditLogPath, pub)
+	if err != nil || !rep.OK() || rep.Pruned == 0 {
+		t.Fatalf("按保留策略删除后校验应通过且计入 pruned: %+v (err %v)", rep, err)
+	}
+
+	// 绕过保留策略再删除最旧的分段：链起点超出删除记录的范围
+	segs, err := auditRotatedSegments(auditLogPath)
+	if err != nil || len(segs) == 0 {
+		t.Fatalf("没有历史分段: %v", err)
+	}
+	os.Remove(segs[0])
+	os.Remove(AuditCheckpointPath(segs[0]))
+	rep, err = VerifyAuditSegments(auditLogPath, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OK() || !strings.Contains(strings.Join(rep.Problems, "\n"), "删除记录只覆盖到") {
+		t.Fatalf("未记录的分段删除未被发现: %+v", rep)
+	}
+}
+
+func TestQueryAuditPagesNewestFirst(t *testing.T) {
+	tick := setupAudit(t)
+	appendRecords(t, tick, 6)
+
+	page, total, err := QueryAudit(AuditQuery{Actions: []string{"valveControl"}, Offset: 1, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最旧的分段已删除，保留的 valveControl 记录从新到旧为 c5 c4 c3 ...
+	if total < 3 || len(page) != 2 || page[0].ClientId != "c4" || page[1].ClientId != "c3" {
+		t.Fatalf("分页结果 total=%d page=%+v，期望 c4、c3", total, page)
+	}
+	if page, _, _ := QueryAudit(AuditQuery{Offset: total + 5, Limit: 2}); len(page) != 0 {
+		t.Fatalf("超出总数的 offset 应返回空页: %+v", page)
+	}
+}
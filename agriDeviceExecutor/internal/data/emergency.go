@@ -0,0 +1,54 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaEmergencyStop 全局急停状态在 meta bucket 中的键。
+const metaEmergencyStop = "emergency_stop"
+
+// EmergencyStop 是全局急停状态，保存在映射库中，重启后仍生效。
+type EmergencyStop struct {
+	Active   bool   `json:"active"`
+	Reason   string `json:"reason,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Since    int64  `json:"since,omitempty"` // 最近一次切换状态的时间（unix 秒）
+}
+
+// GetEmergencyStop 读取急停状态；从未设置过时返回未激活。
+func GetEmergencyStop() (EmergencyStop, error) {
+	var s EmergencyStop
+	h, err := handle()
+	if err != nil {
+		return s, err
+	}
+	err = h.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(bucketMeta)).Get([]byte(metaEmergencyStop))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &s)
+	})
+	if err != nil {
+		return s, fmt.Errorf("读取急停状态失败: %w", err)
+	}
+	return s, nil
+}
+
+// SetEmergencyStop 保存急停状态。
+func SetEmergencyStop(s EmergencyStop) error {
+	h, err := handle()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return h.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketMeta)).Put([]byte(metaEmergencyStop), b)
+	})
+}
@@ -2,13 +2,14 @@ package data
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -16,185 +17,189 @@ import (
 	"agriDeviceExecutor/internal/config"
 )
 
-// 默认映射文件路径（相对执行目录）。
-const defaultMappingPath = "internal/data/executor_mapping.json"
-
 // ExecutorMappingEntry 表示设备+节点+寄存器 与 Magistrala clientId 的映射关系。
 // LastValue 与 Status 预留给后续采集/执行状态更新；UpdatedAt 标记最近更新时间。
+// Confirm 为最近一次阀门控制的回读确认结果（confirmed / unconfirmed / failed），ConfirmedAt 为确认完成时间。
+// ObservedAt 为对账最近一次读到节点状态的时间；Drift 表示实际状态与最近一次下发的动作不一致（绕过本系统操作）。
+// NodeName 为平台上的节点名称（同步时更新）；OrphanedAt 非 0 表示节点已从平台账号中移除，对应 Magistrala client 已停用。
+// ClientSecret 在映射库中加密保存，API 输出前须经 Redacted 脱敏；SecretRotatedAt 为最近一次轮换时间。
+// SecretRotatePending 表示 secret 来自旧版 JSON 映射（明文入库、可预测），须尽快轮换（见 service.RunSecretRotation）。
+// Mode 为最近一次成功设置的工作模式（"1" 手动 / "2" 自动），未设置过时为空。
+// OpenDeadline 非 0 表示阀门处于开启状态且须在该时间（unix 秒）前关闭，到期由执行层自动关阀（见 service/deadman.go）。
+// Actuator 为执行器类型（valve / relay），旧映射为空，视为阀门节点（见 Kind）。
 type ExecutorMappingEntry struct {
-	DeviceAddr   string      `json:"deviceAddr"`
-	NodeId       int         `json:"nodeId"`
-	ClientId     string      `json:"clientId"`
-	ClientSecret string      `json:"clientSecret"`
-	Status       string      `json:"status"`
-	LastValue    interface{} `json:"lastValue"`
-	UpdatedAt    int64       `json:"updatedAt"`
+	DeviceAddr          string      `json:"deviceAddr"`
+	NodeId              int         `json:"nodeId"`
+	ClientId            string      `json:"clientId"`
+	ClientSecret        string      `json:"clientSecret"`
+	Status              string      `json:"status"`
+	LastValue           interface{} `json:"lastValue"`
+	UpdatedAt           int64       `json:"updatedAt"`
+	Confirm             string      `json:"confirm,omitempty"`
+	ConfirmedAt         int64       `json:"confirmedAt,omitempty"`
+	ObservedAt          int64       `json:"observedAt,omitempty"`
+	Drift               bool        `json:"drift,omitempty"`
+	NodeName            string      `json:"nodeName,omitempty"`
+	OrphanedAt          int64       `json:"orphanedAt,omitempty"`
+	SecretRotatedAt     int64       `json:"secretRotatedAt,omitempty"`
+	SecretRotatePending bool        `json:"secretRotatePending,omitempty"`
+	Mode                string      `json:"mode,omitempty"`
+	OpenDeadline        int64       `json:"openDeadline,omitempty"`
+	Actuator            string      `json:"actuator,omitempty"`
+}
+
+// 执行器类型。
+const (
+	ActuatorValve = "valve" // 灌溉平台的阀门节点（deviceAddr + nodeId）
+	ActuatorRelay = "relay" // 传感器平台设备的继电器（风机、补光灯、水泵等），nodeId 为继电器号
+)
+
+// relayAddrPrefix 继电器映射的 deviceAddr 前缀：两个平台的设备地址相互独立，加前缀避免映射 key 与阀门节点冲突。
+const relayAddrPrefix = "relay-"
+
+// RelayDeviceAddr 返回传感器平台设备的继电器在映射中使用的 deviceAddr。
+func RelayDeviceAddr(platformAddr string) string {
+	return relayAddrPrefix + strings.TrimSpace(platformAddr)
+}
+
+// RelayPlatformAddr 从继电器映射的 deviceAddr 还原传感器平台设备地址；不是继电器映射时返回 false。
+func RelayPlatformAddr(deviceAddr string) (string, bool) {
+	addr, ok := strings.CutPrefix(strings.TrimSpace(deviceAddr), relayAddrPrefix)
+	return addr, ok && addr != ""
+}
+
+// validNodeId 阀门节点号从 1 开始；继电器号以平台为准，可以为 0。
+func validNodeId(deviceAddr string, nodeId int) bool {
+	if _, relay := RelayPlatformAddr(deviceAddr); relay {
+		return nodeId >= 0
+	}
+	return nodeId > 0
+}
+
+// Kind 返回执行器类型，未记录时按 deviceAddr 判断。
+func (e ExecutorMappingEntry) Kind() string {
+	if e.Actuator != "" {
+		return e.Actuator
+	}
+	if _, ok := RelayPlatformAddr(e.DeviceAddr); ok {
+		return ActuatorRelay
+	}
+	return ActuatorValve
 }
 
-// 内存存储结构。
-type mappingStore struct {
-	mu      sync.RWMutex
-	entries map[string]ExecutorMappingEntry // key = deviceAddr|nodeId
-	path    string
+// Redacted 返回 clientSecret 脱敏后的副本，用于 API 输出。
+func (e ExecutorMappingEntry) Redacted() ExecutorMappingEntry {
+	e.ClientSecret = config.RedactSecret(e.ClientSecret)
+	return e
 }
 
-var globalStore = &mappingStore{entries: map[string]ExecutorMappingEntry{}, path: defaultMappingPath}
+// 阀门控制确认结果。
+const (
+	ConfirmConfirmed   = "confirmed"   // 回读状态与期望一致
+	ConfirmUnconfirmed = "unconfirmed" // 超时前未能读到节点状态，阀门是否动作未知
+	ConfirmFailed      = "failed"      // 控制命令失败，或超时时回读状态仍与期望相反
+	ConfirmPending     = "pending"     // 仅出现在 HTTP 响应中：等待时间内尚未确认，确认在后台继续
+)
+
+// ErrEntryNotFound 映射不存在。
+var ErrEntryNotFound = errors.New("映射不存在")
 
 // key 生成。
 func makeKey(deviceAddr string, nodeId int) string {
 	return fmt.Sprintf("%s|%d", deviceAddr, nodeId)
 }
 
-// LoadMapping 从文件加载映射（若文件不存在则创建空文件）。
+// LoadMapping 打开映射数据库（path 为空时使用默认路径），执行 schema 迁移，
+// 首次打开时一次性导入已有的 JSON 映射文件（见 mapping_store.go）。
 func LoadMapping(path string) error {
-	if path == "" {
-		path = defaultMappingPath
-	}
-	globalStore.mu.Lock()
-	defer globalStore.mu.Unlock()
-	globalStore.path = path
-
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		// 若新文件不存在，尝试从旧文件迁移
-		legacy := "internal/data/Executor_mapping.json"
-		if _, lerr := os.Stat(legacy); lerr == nil {
-			if content, rerr := os.ReadFile(legacy); rerr == nil {
-				// 尝试直接写入到新文件
-				if err := os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
-					if len(content) == 0 {
-						content = []byte("[]")
-					}
-					_ = os.WriteFile(path, content, 0o644)
-				}
-			}
-		}
-		// 如果仍不存在，初始化空文件
-		if _, ck := os.Stat(path); errors.Is(ck, os.ErrNotExist) {
-			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-				return fmt.Errorf("创建映射目录失败: %w", err)
-			}
-			empty := []ExecutorMappingEntry{}
-			b, _ := json.MarshalIndent(empty, "", "  ")
-			if err := os.WriteFile(path, b, 0o644); err != nil {
-				return fmt.Errorf("创建映射文件失败: %w", err)
-			}
-			return nil
-		}
-	}
-
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("读取映射文件失败: %w", err)
-	}
-	var list []ExecutorMappingEntry
-	if len(content) > 0 {
-		if err := json.Unmarshal(content, &list); err != nil {
-			return fmt.Errorf("解析映射文件失败: %w", err)
-		}
-	}
-	globalStore.entries = map[string]ExecutorMappingEntry{}
-	// 迁移：旧文件可能包含 registerId；节点级唯一，仅保留首个出现的 client
-	for _, e := range list {
-		k := makeKey(e.DeviceAddr, e.NodeId)
-		if _, exists := globalStore.entries[k]; exists {
-			// 已存在则跳过重复节点
-			continue
-		}
-		// 去掉 RegisterId（旧数据忽略）
-		globalStore.entries[k] = ExecutorMappingEntry{
-			DeviceAddr:   e.DeviceAddr,
-			NodeId:       e.NodeId,
-			ClientId:     e.ClientId,
-			ClientSecret: e.ClientSecret,
-			Status:       e.Status,
-			LastValue:    e.LastValue,
-			UpdatedAt:    e.UpdatedAt,
-		}
-	}
-	return nil
+	return openStore(path)
 }
 
 // UpdateEntryValue 更新某映射的运行状态与最新值。
 func UpdateEntryValue(deviceAddr string, nodeId int, status string, value interface{}) error {
-	key := makeKey(deviceAddr, nodeId)
+	return updateEntry(makeKey(deviceAddr, nodeId), func(e *ExecutorMappingEntry) {
+		e.Status = status
+		e.LastValue = value
+		e.UpdatedAt = time.Now().Unix()
+	})
+}
 
-	// 写锁阶段：更新内存
-	globalStore.mu.Lock()
-	e, ok := globalStore.entries[key]
-	if !ok {
-		globalStore.mu.Unlock()
-		return errors.New("映射不存在")
-	}
-	e.Status = status
-	e.LastValue = value
-	e.UpdatedAt = time.Now().Unix()
-	globalStore.entries[key] = e
-	globalStore.mu.Unlock() // 释放写锁，避免与 SaveMapping 的 RLock 互阻
+// UpdateEntryConfirm 记录阀门控制的确认结果；status 为空时保留原运行状态（回读不到节点状态时不臆测）。
+func UpdateEntryConfirm(deviceAddr string, nodeId int, status string, value interface{}, confirm string) error {
+	return updateEntry(makeKey(deviceAddr, nodeId), func(e *ExecutorMappingEntry) {
+		if status != "" {
+			e.Status = status
+		}
+		e.LastValue = value
+		e.Confirm = confirm
+		e.Drift = false // 新的下发动作重新确立期望状态
+		e.UpdatedAt = time.Now().Unix()
+		e.ConfirmedAt = e.UpdatedAt
+	})
+}
 
-	// 持久化阶段（单独加读锁快照）
-	return SaveMapping()
+// SetObservedState 记录对账读到的节点状态与是否漂移，返回更新前的映射。
+func SetObservedState(deviceAddr string, nodeId int, status string, drift bool) (ExecutorMappingEntry, bool) {
+	var prev ExecutorMappingEntry
+	err := updateEntry(makeKey(deviceAddr, nodeId), func(e *ExecutorMappingEntry) {
+		prev = *e
+		now := time.Now().Unix()
+		if e.Status != status {
+			e.Status = status
+			e.UpdatedAt = now
+		}
+		e.ObservedAt = now
+		e.Drift = drift
+	})
+	return prev, err == nil
 }
 
-// SaveMapping 将内存映射写回文件。
-func SaveMapping() error {
-	globalStore.mu.RLock()
-	list := make([]ExecutorMappingEntry, 0, len(globalStore.entries))
-	for _, e := range globalStore.entries {
-		list = append(list, e)
-	}
-	globalStore.mu.RUnlock() // 尽早释放读锁，减少持有时间
+// SetOpenDeadline 设置阀门自动关闭的期限（unix 秒），0 表示清除。
+func SetOpenDeadline(deviceAddr string, nodeId int, deadline int64) error {
+	return updateEntry(makeKey(deviceAddr, nodeId), func(e *ExecutorMappingEntry) {
+		e.OpenDeadline = deadline
+	})
+}
 
-	b, _ := json.MarshalIndent(list, "", "  ")
-	if err := os.WriteFile(globalStore.path, b, 0o644); err != nil {
-		return fmt.Errorf("保存映射失败: %w", err)
-	}
-	return nil
+// UpdateEntry 在一个事务内修改单条映射（同步更新名称、孤儿标记等）。
+func UpdateEntry(deviceAddr string, nodeId int, fn func(e *ExecutorMappingEntry)) error {
+	return updateEntry(makeKey(deviceAddr, nodeId), fn)
+}
+
+// DeleteEntry 删除映射及其 clientId 索引。
+func DeleteEntry(deviceAddr string, nodeId int) error {
+	return deleteEntry(makeKey(deviceAddr, nodeId))
 }
 
 // GetEntry 查询单条映射。
 func GetEntry(deviceAddr string, nodeId int) (ExecutorMappingEntry, bool) {
-	globalStore.mu.RLock()
-	defer globalStore.mu.RUnlock()
-	e, ok := globalStore.entries[makeKey(deviceAddr, nodeId)]
-	return e, ok
+	return getEntry(makeKey(deviceAddr, nodeId))
 }
 
-// GetAllEntries 返回全部映射列表。
+// GetAllEntries 返回全部映射列表（按 deviceAddr|nodeId 排序）。
 func GetAllEntries() []ExecutorMappingEntry {
-	globalStore.mu.RLock()
-	defer globalStore.mu.RUnlock()
-	list := make([]ExecutorMappingEntry, 0, len(globalStore.entries))
-	for _, e := range globalStore.entries {
-		list = append(list, e)
-	}
-	return list
+	return allEntries()
 }
 
-// GetEntryByClientId 根据 clientId 反向查询映射。常用于执行端点根据 clientId 控制。
+// GetEntryByClientId 根据 clientId 反向查询映射（走 clientId 索引）。常用于执行端点根据 clientId 控制。
 func GetEntryByClientId(clientId string) (ExecutorMappingEntry, bool) {
 	if clientId == "" {
 		return ExecutorMappingEntry{}, false
 	}
-	globalStore.mu.RLock()
-	defer globalStore.mu.RUnlock()
-	for _, e := range globalStore.entries {
-		if e.ClientId == clientId {
-			return e, true
-		}
-	}
-	return ExecutorMappingEntry{}, false
+	return getEntryByClientId(clientId)
 }
 
-// EnsureEntry 确保映射存在；若不存在则向 Magistrala 注册并创建。
+// EnsureEntry 确保映射存在；若不存在则向 Magistrala 注册并创建。继电器以 RelayDeviceAddr 作为 deviceAddr。
 func EnsureEntry(deviceAddr string, nodeId int) (ExecutorMappingEntry, error) {
-	if deviceAddr == "" || nodeId <= 0 {
+	if deviceAddr == "" || !validNodeId(deviceAddr, nodeId) {
 		return ExecutorMappingEntry{}, errors.New("非法参数: deviceAddr/nodeId 必填")
 	}
 	key := makeKey(deviceAddr, nodeId)
-	globalStore.mu.RLock()
-	existing, ok := globalStore.entries[key]
-	globalStore.mu.RUnlock()
-	if ok {
+	// 同一节点的检查、注册与写入串行执行，并发调用不会各自注册一个 Magistrala client
+	mu := ensureLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	if existing, ok := getEntry(key); ok {
 		return existing, nil
 	}
 	clientId, clientSecret, err := RegisterMagistralaClient(deviceAddr, nodeId)
@@ -209,18 +214,31 @@ func EnsureEntry(deviceAddr string, nodeId int) (ExecutorMappingEntry, error) {
 		Status:       "new",
 		UpdatedAt:    time.Now().Unix(),
 	}
-	globalStore.mu.Lock()
-	globalStore.entries[key] = entry
-	globalStore.mu.Unlock()
-	if err := SaveMapping(); err != nil {
-		return entry, fmt.Errorf("保存映射文件失败: %w", err)
+	entry.Actuator = entry.Kind()
+	stored, err := insertEntry(entry)
+	if err != nil {
+		return entry, fmt.Errorf("保存映射失败: %w", err)
+	}
+	if stored.ClientId != clientId {
+		// 另一进程已写入该节点的映射：删除本次多注册的 client
+		if derr := DeleteMagistralaClient(clientId); derr != nil {
+			log.Printf("[warn] 删除多余的 Magistrala client 失败 clientId=%s err=%v", clientId, derr)
+		}
 	}
-	return entry, nil
+	return stored, nil
+}
+
+// ensureLocks 按 deviceAddr|nodeId 串行化 EnsureEntry。
+var ensureLocks sync.Map // key → *sync.Mutex
+
+func ensureLock(key string) *sync.Mutex {
+	mu, _ := ensureLocks.LoadOrStore(key, &sync.Mutex{})
+	return mu.(*sync.Mutex)
 }
 
 // RegisterMagistralaClient 注册设备节点到 Magistrala，并连接到指定频道，返回 clientId 与 clientSecret。
 func RegisterMagistralaClient(deviceAddr string, nodeId int) (string, string, error) {
-	if strings.TrimSpace(deviceAddr) == "" || nodeId <= 0 {
+	if strings.TrimSpace(deviceAddr) == "" || !validNodeId(deviceAddr, nodeId) {
 		return "", "", errors.New("注册参数非法: 需要 deviceAddr、nodeId")
 	}
 
@@ -250,7 +268,10 @@ func RegisterMagistralaClient(deviceAddr string, nodeId int) (string, string, er
 	// 为避免重复信息，name 与 identity 不再包含 registerId
 	name := fmt.Sprintf("executor-%s-%d", deviceAddr, nodeId)
 	identity := fmt.Sprintf("executor-%s-%d", deviceAddr, nodeId)
-	secret := fmt.Sprintf("secret-%s-%d-%d", deviceAddr, nodeId, time.Now().UnixNano())
+	secret, err := newClientSecret()
+	if err != nil {
+		return "", "", err
+	}
 
 	payload := map[string]any{
 		"name":   name,
@@ -263,6 +284,7 @@ func RegisterMagistralaClient(deviceAddr string, nodeId int) (string, string, er
 		"metadata": map[string]any{
 			"device_addr":  deviceAddr,
 			"node_id":      nodeId,
+			"actuator":     ExecutorMappingEntry{DeviceAddr: deviceAddr}.Kind(),
 			"created_from": "executor-auto-sync",
 		},
 	}
@@ -341,3 +363,128 @@ func RegisterMagistralaClient(deviceAddr string, nodeId int) (string, string, er
 
 	return clientId, clientSecret, nil
 }
+
+// SetMagistralaClientEnabled 启用/停用 Magistrala client（节点从平台移除或重新出现时调用）。
+func SetMagistralaClientEnabled(clientId string, enabled bool) error {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return magistralaClientCall(http.MethodPost, clientId, "/"+action, nil)
+}
+
+// DeleteMagistralaClient 删除 Magistrala client；client 已不存在时视为成功。
+func DeleteMagistralaClient(clientId string) error {
+	return magistralaClientCall(http.MethodDelete, clientId, "", nil)
+}
+
+// newClientSecret 生成 32 字节随机 clientSecret（hex 编码）。
+func newClientSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成 clientSecret 失败: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// rotateMu 串行化 clientSecret 轮换，避免并发轮换使 Magistrala 与映射中的 secret 不一致。
+var rotateMu sync.Mutex
+
+// RotateClientSecret 为 clientId 生成新 secret，先更新 Magistrala client 凭据，再写入映射。
+// 映射写入失败时将 Magistrala 回滚为旧 secret；回滚也失败时返回的错误同时包含两者，需人工处理。
+func RotateClientSecret(clientId string) (ExecutorMappingEntry, error) {
+	rotateMu.Lock()
+	defer rotateMu.Unlock()
+	entry, ok := GetEntryByClientId(clientId)
+	if !ok {
+		return ExecutorMappingEntry{}, ErrEntryNotFound
+	}
+	secret, err := newClientSecret()
+	if err != nil {
+		return entry, err
+	}
+	if err := magistralaClientCall(http.MethodPatch, clientId, "/secret", map[string]string{"secret": secret}); err != nil {
+		return entry, err
+	}
+	var updated ExecutorMappingEntry
+	err = updateEntry(makeKey(entry.DeviceAddr, entry.NodeId), func(e *ExecutorMappingEntry) {
+		e.ClientSecret = secret
+		e.SecretRotatedAt = time.Now().Unix()
+		e.SecretRotatePending = false
+		updated = *e
+	})
+	if err != nil {
+		if rbErr := magistralaClientCall(http.MethodPatch, clientId, "/secret", map[string]string{"secret": entry.ClientSecret}); rbErr != nil {
+			return entry, fmt.Errorf("保存映射失败: %v；回滚 Magistrala secret 也失败: %w", err, rbErr)
+		}
+		return entry, fmt.Errorf("保存映射失败，已回滚 Magistrala secret: %w", err)
+	}
+	return updated, nil
+}
+
+// PendingSecretRotations 返回待轮换 secret 的映射（SecretRotatePending 且已注册 client）。
+func PendingSecretRotations() []ExecutorMappingEntry {
+	var list []ExecutorMappingEntry
+	for _, e := range allEntries() {
+		if e.SecretRotatePending && e.ClientId != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// magistralaClientCall 调用 {clientBase}/{domainId}/clients/{clientId}{suffix}；payload 非 nil 时作为 JSON 请求体。
+func magistralaClientCall(method, clientId, suffix string, payload any) error {
+	if strings.TrimSpace(clientId) == "" {
+		return errors.New("clientId 不能为空")
+	}
+	baseURL, err := config.GetMagistralaBaseURL()
+	if err != nil {
+		return err
+	}
+	magToken, err := config.GetMagistralaToken()
+	if err != nil {
+		return err
+	}
+	domainID, err := config.GetMagistralaDomainID()
+	if err != nil {
+		return err
+	}
+	clientBase := strings.TrimRight(baseURL, "/") + ":9006"
+	target := fmt.Sprintf("%s/%s/clients/%s%s", clientBase, domainID, clientId, suffix)
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+magToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return nil
+	}
+	op := strings.TrimPrefix(suffix, "/")
+	if op == "" {
+		op = strings.ToLower(method)
+	}
+	return fmt.Errorf("Magistrala client %s %s 失败 http=%d body=%s", clientId, op, resp.StatusCode, string(b))
+}
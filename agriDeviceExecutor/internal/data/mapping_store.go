@@ -0,0 +1,481 @@
+package data
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"agriDeviceExecutor/internal/config"
+)
+
+// mapping_store.go：执行映射的嵌入式存储（bbolt）。
+// 每次修改在一个事务内完成（读-改-写单条记录并维护索引），不再整文件重写；读取走索引，不再全表扫描。
+//
+// 布局：
+//   - entries：deviceAddr|nodeId → ExecutorMappingEntry（JSON），键有序，GetAllEntries 输出顺序稳定；
+//     clientSecret 加密保存（config.EncryptSecret），读出时解密，内存中的映射始终为明文
+//   - idx_client：clientId → deviceAddr|nodeId
+//   - meta：schema_version（uint64 大端）、imported_from / imported_at（JSON 导入记录）
+//
+// schema 迁移：migrations[i] 将 schema 从 i 升到 i+1，打开时在一个事务内按序执行未完成的迁移。
+// 首次打开（schema 为 0）时在同一事务中导入数据库所在目录下已有的 executor_mapping.json 与旧版 Executor_mapping.json，
+// 事务提交后删除这两个文件（其中的 clientSecret 为明文）；导入的 secret 标记为待轮换（schema 4）。
+// 打开时校验加密密钥：任一已加密的 clientSecret 无法解密（密钥被替换）时拒绝启动，避免写入不一致的数据。
+
+const (
+	defaultMappingDBPath  = "internal/data/executor_mapping.db"
+	mappingJSONName       = "executor_mapping.json" // 与数据库同目录
+	legacyMappingJSONName = "Executor_mapping.json"
+	mappingOpenTimeout    = 2 * time.Second // 另一进程持有数据库时快速失败，而不是一直阻塞
+	bucketEntries         = "entries"
+	bucketClientIndex     = "idx_client"
+	bucketMeta            = "meta"
+	metaSchemaVersion     = "schema_version"
+	metaImportedFrom      = "imported_from"
+	metaImportedAt        = "imported_at"
+)
+
+var (
+	storeMu sync.RWMutex // 保护 db 句柄的替换；数据并发由 bbolt 事务保证
+	db      *bolt.DB
+)
+
+// migrations 按序升级 schema；新增迁移只在末尾追加，已发布的迁移不可修改。dir 为数据库所在目录。
+var migrations = []func(tx *bolt.Tx, dir string) error{
+	// 1：entries 与 meta；首次建库时导入 JSON 映射
+	func(tx *bolt.Tx, dir string) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketEntries)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketMeta)); err != nil {
+			return err
+		}
+		return importJSON(tx, dir)
+	},
+	// 2：clientId 索引，按现有 entries 回填
+	func(tx *bolt.Tx, _ string) error {
+		idx, err := tx.CreateBucketIfNotExists([]byte(bucketClientIndex))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(bucketEntries)).ForEach(func(k, v []byte) error {
+			var e ExecutorMappingEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("解析映射 %s 失败: %w", k, err)
+			}
+			if e.ClientId == "" {
+				return nil
+			}
+			return idx.Put([]byte(e.ClientId), k)
+		})
+	},
+	// 3：加密已有的明文 clientSecret
+	func(tx *bolt.Tx, _ string) error {
+		entries := tx.Bucket([]byte(bucketEntries))
+		updates := map[string][]byte{}
+		err := entries.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(v)
+			if err != nil {
+				return fmt.Errorf("解析映射 %s 失败: %w", k, err)
+			}
+			b, err := encodeEntry(e)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, b := range updates { // ForEach 期间不可修改 bucket
+			if err := entries.Put([]byte(k), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 4：从未轮换过的 secret 来自 JSON 映射（曾以明文入库、按 deviceAddr-nodeId-时间 生成），标记为待轮换
+	func(tx *bolt.Tx, _ string) error {
+		entries := tx.Bucket([]byte(bucketEntries))
+		updates := map[string][]byte{}
+		err := entries.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(v)
+			if err != nil {
+				return fmt.Errorf("解析映射 %s 失败: %w", k, err)
+			}
+			if e.ClientId == "" || e.SecretRotatedAt != 0 {
+				return nil
+			}
+			e.SecretRotatePending = true
+			b, err := encodeEntry(e)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, b := range updates {
+			if err := entries.Put([]byte(k), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// openStore 打开数据库并执行迁移；重复调用会先关闭已打开的数据库。
+func openStore(path string) error {
+	if path == "" {
+		path = defaultMappingDBPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("创建映射目录失败: %w", err)
+	}
+	h, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: mappingOpenTimeout})
+	if err != nil {
+		return fmt.Errorf("打开映射数据库失败: %w", err)
+	}
+	dir := filepath.Dir(path)
+	if err := h.Update(func(tx *bolt.Tx) error { return migrate(tx, dir) }); err != nil {
+		h.Close()
+		return fmt.Errorf("映射数据库迁移失败: %w", err)
+	}
+	if err := h.View(verifySecretKey); err != nil {
+		h.Close()
+		return err
+	}
+
+	storeMu.Lock()
+	old := db
+	db = h
+	storeMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// CloseMapping 关闭映射数据库（进程退出前调用）。
+func CloseMapping() error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if db == nil {
+		return nil
+	}
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// migrate 执行未完成的迁移并记录 schema 版本；dir 为数据库所在目录（JSON 导入从该目录读取）。
+func migrate(tx *bolt.Tx, dir string) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return err
+	}
+	var version uint64
+	if v := meta.Get([]byte(metaSchemaVersion)); len(v) == 8 {
+		version = binary.BigEndian.Uint64(v)
+	}
+	if version > uint64(len(migrations)) {
+		return fmt.Errorf("数据库 schema 版本 %d 高于程序支持的 %d，请升级程序", version, len(migrations))
+	}
+	for i := version; i < uint64(len(migrations)); i++ {
+		if err := migrations[i](tx, dir); err != nil {
+			return fmt.Errorf("迁移到 schema %d 失败: %w", i+1, err)
+		}
+		log.Printf("[mapping] schema 已迁移到 %d", i+1)
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(len(migrations)))
+	return tx.Bucket([]byte(bucketMeta)).Put([]byte(metaSchemaVersion), buf)
+}
+
+// importJSON 从 dir 一次性导入 JSON 映射：先新文件，后旧版文件；同一 deviceAddr|nodeId 仅保留首个出现的记录
+// （旧文件可能包含 registerId 维度的重复节点）。导入的记录标记为待轮换；事务提交后删除已导入的文件。
+func importJSON(tx *bolt.Tx, dir string) error {
+	entries := tx.Bucket([]byte(bucketEntries))
+	var sources []string
+	total := 0
+	for _, name := range []string{mappingJSONName, legacyMappingJSONName} {
+		path := filepath.Join(dir, name)
+		content, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("读取映射文件 %s 失败: %w", path, err)
+		}
+		var list []ExecutorMappingEntry
+		if len(content) > 0 {
+			if err := json.Unmarshal(content, &list); err != nil {
+				return fmt.Errorf("解析映射文件 %s 失败: %w", path, err)
+			}
+		}
+		n := 0
+		for _, e := range list {
+			if e.DeviceAddr == "" || e.NodeId <= 0 {
+				continue
+			}
+			k := []byte(makeKey(e.DeviceAddr, e.NodeId))
+			if entries.Get(k) != nil {
+				continue
+			}
+			e.SecretRotatePending = e.ClientId != ""
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := entries.Put(k, b); err != nil {
+				return err
+			}
+			n++
+		}
+		sources = append(sources, path)
+		total += n
+		log.Printf("[mapping] 已从 %s 导入 %d 条映射", path, n)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	tx.OnCommit(func() {
+		for _, path := range sources {
+			if err := os.Remove(path); err != nil {
+				log.Printf("[mapping] 删除已导入的 %s 失败（含明文 secret，请手动删除）: %v", path, err)
+			} else {
+				log.Printf("[mapping] 已删除已导入的 %s", path)
+			}
+		}
+	})
+	meta := tx.Bucket([]byte(bucketMeta))
+	from, _ := json.Marshal(sources)
+	if err := meta.Put([]byte(metaImportedFrom), from); err != nil {
+		return err
+	}
+	log.Printf("[mapping] JSON 导入完成，共 %d 条", total)
+	return meta.Put([]byte(metaImportedAt), []byte(time.Now().Format(time.RFC3339)))
+}
+
+// verifySecretKey 用全部已加密的 clientSecret 校验当前密钥；任一条无法解密即返回错误（报告首条与总数）。
+func verifySecretKey(tx *bolt.Tx) error {
+	var first string
+	var firstErr error
+	failed := 0
+	err := tx.Bucket([]byte(bucketEntries)).ForEach(func(k, v []byte) error {
+		var raw struct {
+			ClientSecret string `json:"clientSecret"`
+		}
+		if json.Unmarshal(v, &raw) != nil || !config.IsEncryptedSecret(raw.ClientSecret) {
+			return nil
+		}
+		if _, err := config.DecryptSecret(raw.ClientSecret); err != nil {
+			if failed == 0 {
+				first, firstErr = string(k), err
+			}
+			failed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 条映射的 clientSecret 无法解密（首条 %s；加密密钥 %s 或 EXECUTOR_SECRET_KEY 是否被替换？）: %w",
+			failed, first, config.SecretKeyPath(), firstErr)
+	}
+	return nil
+}
+
+// encodeEntry 序列化映射，clientSecret 加密。
+func encodeEntry(e ExecutorMappingEntry) ([]byte, error) {
+	enc, err := config.EncryptSecret(e.ClientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("加密 clientSecret 失败: %w", err)
+	}
+	e.ClientSecret = enc
+	return json.Marshal(e)
+}
+
+// decodeEntry 反序列化映射，clientSecret 解密（兼容未加密的旧数据）。
+func decodeEntry(v []byte) (ExecutorMappingEntry, error) {
+	var e ExecutorMappingEntry
+	if err := json.Unmarshal(v, &e); err != nil {
+		return e, err
+	}
+	plain, err := config.DecryptSecret(e.ClientSecret)
+	if err != nil {
+		return e, fmt.Errorf("解密 clientSecret 失败: %w", err)
+	}
+	e.ClientSecret = plain
+	return e, nil
+}
+
+// handle 返回当前数据库句柄；未打开时返回错误。
+func handle() (*bolt.DB, error) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	if db == nil {
+		return nil, errors.New("映射数据库未打开")
+	}
+	return db, nil
+}
+
+// updateEntry 在一个事务内读取、修改并写回单条映射，clientId 变化时同步维护索引。
+func updateEntry(key string, fn func(e *ExecutorMappingEntry)) error {
+	h, err := handle()
+	if err != nil {
+		return err
+	}
+	return h.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket([]byte(bucketEntries))
+		v := entries.Get([]byte(key))
+		if v == nil {
+			return ErrEntryNotFound
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return fmt.Errorf("解析映射 %s 失败: %w", key, err)
+		}
+		oldClient := e.ClientId
+		fn(&e)
+		return putEntry(tx, key, e, oldClient)
+	})
+}
+
+// insertEntry 新增映射；若已存在（并发同步已插入）则返回已有记录。
+func insertEntry(e ExecutorMappingEntry) (ExecutorMappingEntry, error) {
+	h, err := handle()
+	if err != nil {
+		return e, err
+	}
+	key := makeKey(e.DeviceAddr, e.NodeId)
+	stored := e
+	err = h.Update(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(bucketEntries)).Get([]byte(key)); v != nil {
+			var err error
+			stored, err = decodeEntry(v)
+			return err
+		}
+		return putEntry(tx, key, e, "")
+	})
+	return stored, err
+}
+
+// putEntry 写入映射并维护 clientId 索引。
+func putEntry(tx *bolt.Tx, key string, e ExecutorMappingEntry, oldClient string) error {
+	b, err := encodeEntry(e)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(bucketEntries)).Put([]byte(key), b); err != nil {
+		return err
+	}
+	idx := tx.Bucket([]byte(bucketClientIndex))
+	if oldClient != "" && oldClient != e.ClientId {
+		if err := idx.Delete([]byte(oldClient)); err != nil {
+			return err
+		}
+	}
+	if e.ClientId == "" {
+		return nil
+	}
+	return idx.Put([]byte(e.ClientId), []byte(key))
+}
+
+// deleteEntry 删除映射及其 clientId 索引。
+func deleteEntry(key string) error {
+	h, err := handle()
+	if err != nil {
+		return err
+	}
+	return h.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket([]byte(bucketEntries))
+		v := entries.Get([]byte(key))
+		if v == nil {
+			return ErrEntryNotFound
+		}
+		var e ExecutorMappingEntry
+		if err := json.Unmarshal(v, &e); err == nil && e.ClientId != "" {
+			if err := tx.Bucket([]byte(bucketClientIndex)).Delete([]byte(e.ClientId)); err != nil {
+				return err
+			}
+		}
+		return entries.Delete([]byte(key))
+	})
+}
+
+func getEntry(key string) (ExecutorMappingEntry, bool) {
+	var e ExecutorMappingEntry
+	found := false
+	h, err := handle()
+	if err != nil {
+		return e, false
+	}
+	_ = h.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(bucketEntries)).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		var err error
+		e, err = decodeEntry(v)
+		found = err == nil
+		return nil
+	})
+	return e, found
+}
+
+func getEntryByClientId(clientId string) (ExecutorMappingEntry, bool) {
+	var e ExecutorMappingEntry
+	found := false
+	h, err := handle()
+	if err != nil {
+		return e, false
+	}
+	_ = h.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket([]byte(bucketClientIndex)).Get([]byte(clientId))
+		if key == nil {
+			return nil
+		}
+		v := tx.Bucket([]byte(bucketEntries)).Get(key)
+		if v == nil {
+			return nil
+		}
+		var err error
+		e, err = decodeEntry(v)
+		found = err == nil
+		return nil
+	})
+	return e, found
+}
+
+func allEntries() []ExecutorMappingEntry {
+	list := []ExecutorMappingEntry{}
+	h, err := handle()
+	if err != nil {
+		return list
+	}
+	_ = h.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketEntries)).ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(v)
+			if err != nil {
+				log.Printf("[mapping] 跳过无法解析的映射 %s: %v", k, err)
+				return nil
+			}
+			list = append(list, e)
+			return nil
+		})
+	})
+	return list
+}
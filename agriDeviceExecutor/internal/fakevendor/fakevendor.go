@@ -0,0 +1,778 @@
+// Package fakevendor 是第三方灌溉平台（api.farm.0531yun.cn /api/v2.0）的有状态模拟服务，用于离线集成测试。
+//
+// 行为说明：
+//   - 实现执行层用到的全部接口：登录、用户信息、设备列表、设备详情、节点列表、修改设备/节点、批量使能、
+//     遥调读写、历史记录、手动开关阀、修改工作模式；响应结构与平台文档（API文档.md）一致，code=1000 为成功；
+//   - 阀门有状态：manualControlValve 之后经 Options.ActuationDelay 节点状态字段（Options.StateKey）才变化，可设置卡死（SetStuck）
+//     或模拟绕过系统的现场操作（SetSwitch）；
+//   - 故障注入：ExpireTokens 使已签发 token 全部失效（返回 Options.AuthFailCode），
+//     InjectFault 按接口注入 HTTP 状态码、业务 code 与延迟，可限定生效次数；
+//   - Calls 统计每个接口的调用次数，便于断言重试与重新登录；
+//   - 同时模拟传感器平台的继电器接口（/api/getToken 等，见 relay.go），与灌溉平台共用账号、各自签发 token。
+//
+// 用作独立服务见 cmd/fakevendor；测试中直接 httptest.NewServer(fakevendor.New(...))。
+package fakevendor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认值。
+const (
+	DefaultLoginName    = "demo"
+	DefaultLoginPwd     = "demo123"
+	DefaultAuthFailCode = 1003          // 文档未定义，执行层需在 agriPlatform.authFailCodes 中配置
+	DefaultStateKey     = "switchState" // 文档未定义，与执行层 agriPlatform.nodeStateKey 的默认值一致
+	codeBadParam        = 1010          // 参数错误
+	defaultTokenTTL     = 2 * time.Hour
+	historyInterval     = 10 * time.Minute // 模拟采集间隔
+	apiPrefix           = "/api/v2.0"
+)
+
+// 节点类型（factorType）。
+const (
+	FactorSensor = 1 // 采集器
+	FactorValve  = 2 // 阀门
+)
+
+// Options 是模拟平台的配置；零值字段使用默认值。
+type Options struct {
+	LoginName      string
+	LoginPwd       string
+	TokenTTL       time.Duration // 登录返回的 expDate 距当前的时长
+	AuthFailCode   int           // token 失效时返回的业务 code
+	ActuationDelay time.Duration // 阀门从收到命令到状态变化的延迟
+	// StateKey 阀门节点输出开关状态的字段名（平台文档未定义），为空时为 DefaultStateKey；
+	// 与执行层的 agriPlatform.nodeStateKey 对应
+	StateKey string
+}
+
+// Regulating 是节点遥调项（8.6 / 8.7）。
+type Regulating struct {
+	FactorId     string  `json:"factorId"`
+	RegularValue float64 `json:"regularValue"`
+	RegularText  string  `json:"regularText"`
+	AlarmLevel   int     `json:"alarmLevel"`
+}
+
+// Node 是设备节点的状态。
+type Node struct {
+	NodeId      int
+	NodeName    string
+	FactorType  int    // FactorSensor / FactorValve
+	Enable      int    // 1 开启 0 关闭
+	SwitchState int    // 阀门状态：1 开 0 关
+	Mode        string // 阀门工作模式："1" 手动 / "2" 自动
+	Stuck       bool   // 卡死：接受命令但状态不变
+
+	target   int
+	changeAt time.Time // 非零表示有待生效的命令
+}
+
+// Device 是一台灌溉设备。
+type Device struct {
+	DeviceAddr string
+	DeviceName string
+	DeviceType string
+	Nodes      []*Node
+}
+
+// Fault 是注入的故障；按 Endpoint 匹配接口（路径最后一段，不区分大小写，空串匹配全部）。
+type Fault struct {
+	Endpoint   string
+	Latency    time.Duration // 响应前等待
+	HTTPStatus int           // 非 0 时直接以该 HTTP 状态码返回
+	Code       int           // 非 0 时以 HTTP 200 + 该业务 code 返回
+	Message    string
+	Times      int // 生效次数，0 表示一直生效
+}
+
+// Server 是模拟平台，实现 http.Handler。
+type Server struct {
+	opts Options
+
+	mu          sync.Mutex
+	devices     []*Device
+	regulating  map[string][]Regulating // factorId → 遥调项
+	tokens      map[string]time.Time    // token → 过期时间
+	relays      []*RelayDevice
+	relayTokens map[string]time.Time
+	faults      []*Fault
+	calls       map[string]int
+	logins      int
+}
+
+// New 创建模拟平台（不含设备，用 AddDevice 添加或 SeedDemo 填充示例设备）。
+func New(opts Options) *Server {
+	if opts.LoginName == "" {
+		opts.LoginName = DefaultLoginName
+	}
+	if opts.LoginPwd == "" {
+		opts.LoginPwd = DefaultLoginPwd
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = defaultTokenTTL
+	}
+	if opts.AuthFailCode == 0 {
+		opts.AuthFailCode = DefaultAuthFailCode
+	}
+	if opts.StateKey == "" {
+		opts.StateKey = DefaultStateKey
+	}
+	return &Server{
+		opts:        opts,
+		regulating:  map[string][]Regulating{},
+		tokens:      map[string]time.Time{},
+		relayTokens: map[string]time.Time{},
+		calls:       map[string]int{},
+	}
+}
+
+// AddDevice 添加设备；节点 Enable 为 0 时按开启处理，阀门节点默认手动模式并带两档遥调（断开 / 闭合）。
+func (s *Server) AddDevice(addr, name string, nodes ...Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &Device{DeviceAddr: addr, DeviceName: name, DeviceType: "irrigation2"}
+	for _, n := range nodes {
+		n := n
+		if n.Enable == 0 {
+			n.Enable = 1
+		}
+		if n.FactorType == FactorValve && n.Mode == "" {
+			n.Mode = "1"
+		}
+		n.target = n.SwitchState
+		d.Nodes = append(d.Nodes, &n)
+		if n.FactorType == FactorValve {
+			fid := factorID(addr, n.NodeId)
+			s.regulating[fid] = []Regulating{
+				{FactorId: fid, RegularValue: 0, RegularText: "断开"},
+				{FactorId: fid, RegularValue: 100, RegularText: "闭合"},
+			}
+		}
+	}
+	s.devices = append(s.devices, d)
+}
+
+// SeedDemo 填充示例设备：两台设备各含一个采集节点与若干阀门节点，另有一台带两路继电器的传感器平台设备。
+func (s *Server) SeedDemo() {
+	s.AddDevice("21131734", "一号灌溉控制器",
+		Node{NodeId: 1, NodeName: "土壤温湿度", FactorType: FactorSensor},
+		Node{NodeId: 10001, NodeName: "A区阀门", FactorType: FactorValve},
+		Node{NodeId: 10002, NodeName: "B区阀门", FactorType: FactorValve},
+	)
+	s.AddDevice("21131735", "二号灌溉控制器",
+		Node{NodeId: 1, NodeName: "土壤温湿度", FactorType: FactorSensor},
+		Node{NodeId: 10001, NodeName: "C区阀门", FactorType: FactorValve},
+	)
+	s.AddRelayDevice(40012345, "一号温室环境监控",
+		Relay{RelayNo: 1, RelayName: "风机"},
+		Relay{RelayNo: 2, RelayName: "补光灯"},
+	)
+}
+
+// ExpireTokens 使已签发的 token 全部失效（模拟平台侧会话过期，含传感器平台 token）。
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]time.Time{}
+	s.relayTokens = map[string]time.Time{}
+}
+
+// InjectFault 注入故障；同一接口有多个故障时按注入顺序取第一个仍生效的。
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.Endpoint = strings.ToLower(f.Endpoint)
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults 清除全部故障。
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// SetStuck 设置阀门卡死（接受命令但状态不变）。
+func (s *Server) SetStuck(devAddr string, nodeId int, stuck bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeLocked(devAddr, nodeId)
+	if !ok {
+		return fmt.Errorf("节点不存在: %s_%d", devAddr, nodeId)
+	}
+	n.Stuck = stuck
+	return nil
+}
+
+// SetSwitch 立即设置阀门状态（模拟绕过系统的现场操作）。
+func (s *Server) SetSwitch(devAddr string, nodeId int, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeLocked(devAddr, nodeId)
+	if !ok {
+		return fmt.Errorf("节点不存在: %s_%d", devAddr, nodeId)
+	}
+	n.SwitchState = boolInt(on)
+	n.target, n.changeAt = n.SwitchState, time.Time{}
+	return nil
+}
+
+// RemoveNode 删除节点（模拟节点从平台账号移除）。
+func (s *Server) RemoveNode(devAddr string, nodeId int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.devices {
+		if d.DeviceAddr != devAddr {
+			continue
+		}
+		for i, n := range d.Nodes {
+			if n.NodeId == nodeId {
+				d.Nodes = append(d.Nodes[:i], d.Nodes[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Node 返回节点当前状态的副本（已应用到期的阀门动作）。
+func (s *Server) Node(devAddr string, nodeId int) (Node, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeLocked(devAddr, nodeId)
+	if !ok {
+		return Node{}, false
+	}
+	settle(n, time.Now())
+	return *n, true
+}
+
+// Calls 返回接口（路径最后一段，不区分大小写）被调用的次数，含失败与注入故障的调用。
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToLower(endpoint)]
+}
+
+// Logins 返回成功登录次数。
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// ServeHTTP 分发 /api/v2.0 下的灌溉平台接口与 /api 下的传感器平台继电器接口。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+	endpoint := strings.ToLower(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+
+	s.mu.Lock()
+	s.calls[endpoint]++
+	f := s.takeFaultLocked(endpoint)
+	s.mu.Unlock()
+	if f != nil {
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.HTTPStatus != 0 {
+			writeResult(w, f.HTTPStatus, f.Code, f.Message, nil)
+			return
+		}
+		if f.Code != 0 {
+			writeResult(w, http.StatusOK, f.Code, f.Message, nil)
+			return
+		}
+	}
+
+	if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		s.serveRelay(w, r, endpoint)
+		return
+	}
+	if endpoint == "userlogin" {
+		s.handleLogin(w, r)
+		return
+	}
+	if !s.validToken(r.Header.Get("token")) {
+		writeResult(w, http.StatusOK, s.opts.AuthFailCode, "token 已失效，请重新登录", nil)
+		return
+	}
+	handler, ok := map[string]func(http.ResponseWriter, *http.Request){
+		"getuser":                             s.handleGetUser,
+		"getsysuserdevice":                    s.handleDeviceList,
+		"getdeviceiii":                        s.handleDeviceDetails,
+		"updatedevinfo":                       s.handleUpdateDevice,
+		"getdevicenodelist":                   s.handleNodeList,
+		"updatedevicenode":                    s.handleUpdateNode,
+		"batchnodeenable":                     s.handleBatchEnable,
+		"getirrigationfactorregulating":       s.handleGetRegulating,
+		"replacetbirrigationfactorregulating": s.handleReplaceRegulating,
+		"gethistorydatalist":                  s.handleHistory,
+		"manualcontrolvalve":                  s.handleManualControl,
+		"updatefactormode":                    s.handleUpdateMode,
+	}[endpoint]
+	if !ok {
+		writeResult(w, http.StatusNotFound, 404, "接口不存在", nil)
+		return
+	}
+	handler(w, r)
+}
+
+// takeFaultLocked 取出匹配的故障并扣减次数。
+func (s *Server) takeFaultLocked(endpoint string) *Fault {
+	for i, f := range s.faults {
+		if f.Endpoint != "" && f.Endpoint != endpoint {
+			continue
+		}
+		out := *f
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &out
+	}
+	return nil
+}
+
+func (s *Server) validToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.tokens[token]
+	return ok && time.Now().Before(exp)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LoginName string `json:"loginName"`
+		LoginPwd  string `json:"loginPwd"`
+	}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	if body.LoginName != s.opts.LoginName || body.LoginPwd != s.opts.LoginPwd {
+		writeResult(w, http.StatusOK, 1002, "用户名或密码错误", nil)
+		return
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	exp := now.Add(s.opts.TokenTTL)
+	s.mu.Lock()
+	s.tokens[token] = exp
+	s.logins++
+	s.mu.Unlock()
+	writeResult(w, http.StatusOK, 1000, "success", map[string]any{
+		"token":     token,
+		"loginSign": "fake-" + token[:8],
+		"currDate":  now.UnixMilli(),
+		"expDate":   exp.UnixMilli(),
+	})
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, http.StatusOK, 1000, "success", map[string]any{
+		"loginName": s.opts.LoginName,
+		"userName":  "模拟用户",
+	})
+}
+
+func (s *Server) handleDeviceList(w http.ResponseWriter, r *http.Request) {
+	deviceType := r.URL.Query().Get("deviceType")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []map[string]any{}
+	for _, d := range s.devices {
+		if deviceType != "" && d.DeviceType != deviceType {
+			continue
+		}
+		out = append(out, map[string]any{
+			"deviceAddr":    d.DeviceAddr,
+			"deviceName":    d.DeviceName,
+			"deviceType":    d.DeviceType,
+			"deviceEnabled": "1",
+		})
+	}
+	writeResult(w, http.StatusOK, 1000, "success", out)
+}
+
+func (s *Server) handleDeviceDetails(w http.ResponseWriter, r *http.Request) {
+	addrs := strings.Split(r.URL.Query().Get("devAddr"), ",")
+	if len(addrs) > 5 {
+		writeResult(w, http.StatusOK, codeBadParam, "最多同时查询 5 个设备", nil)
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []map[string]any{}
+	for _, addr := range addrs {
+		d := s.deviceLocked(strings.TrimSpace(addr))
+		if d == nil {
+			continue
+		}
+		nodes := make([]map[string]any, 0, len(d.Nodes))
+		for _, n := range d.Nodes {
+			nodes = append(nodes, nodeJSON(d, n, now, s.opts.StateKey))
+		}
+		out = append(out, map[string]any{
+			"deviceAddr":              d.DeviceAddr,
+			"deviceName":              d.DeviceName,
+			"deviceType":              d.DeviceType,
+			"deviceEnabled":           "1",
+			"irrigationNodeDOList":    nodes,
+			"listTbIrrigationContact": []any{},
+		})
+	}
+	writeResult(w, http.StatusOK, 1000, "success", out)
+}
+
+func (s *Server) handleUpdateDevice(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if json.NewDecoder(r.Body).Decode(&body) != nil {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deviceLocked(fmt.Sprint(body["deviceAddr"]))
+	if d == nil {
+		writeResult(w, http.StatusOK, 1004, "设备不存在", nil)
+		return
+	}
+	if name, ok := body["deviceName"].(string); ok && name != "" {
+		d.DeviceName = name
+	}
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+func (s *Server) handleNodeList(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deviceLocked(strings.TrimSpace(r.URL.Query().Get("devAddr")))
+	if d == nil {
+		writeResult(w, http.StatusOK, 1004, "设备不存在", nil)
+		return
+	}
+	out := make([]map[string]any, 0, len(d.Nodes))
+	for _, n := range d.Nodes {
+		out = append(out, nodeJSON(d, n, now, s.opts.StateKey))
+	}
+	writeResult(w, http.StatusOK, 1000, "成功", out)
+}
+
+func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if json.NewDecoder(r.Body).Decode(&body) != nil {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	nodeId, _ := strconv.Atoi(fmt.Sprint(body["nodeId"]))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeLocked(fmt.Sprint(body["deviceAddr"]), nodeId)
+	if !ok {
+		writeResult(w, http.StatusOK, 1004, "节点不存在", nil)
+		return
+	}
+	if name, ok := body["nodeName"].(string); ok && name != "" {
+		n.NodeName = name
+	}
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+func (s *Server) handleBatchEnable(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		DevAddr    string `json:"devAddr"`
+		Enable     string `json:"enable"`
+		FactorType string `json:"factorType"`
+	}
+	if json.NewDecoder(r.Body).Decode(&body) != nil || (body.Enable != "0" && body.Enable != "1") {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deviceLocked(body.DevAddr)
+	if d == nil {
+		writeResult(w, http.StatusOK, 1004, "设备不存在", nil)
+		return
+	}
+	enable, _ := strconv.Atoi(body.Enable)
+	for _, n := range d.Nodes {
+		if body.FactorType == "" || body.FactorType == strconv.Itoa(n.FactorType) {
+			n.Enable = enable
+		}
+	}
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+func (s *Server) handleGetRegulating(w http.ResponseWriter, r *http.Request) {
+	fid := strings.TrimSpace(r.URL.Query().Get("factorId"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.regulating[fid]
+	if items == nil {
+		items = []Regulating{}
+	}
+	writeResult(w, http.StatusOK, 1000, "success", items)
+}
+
+func (s *Server) handleReplaceRegulating(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		List []Regulating `json:"listTbIrrigationFactorRegulating"`
+	}
+	if json.NewDecoder(r.Body).Decode(&body) != nil || len(body.List) == 0 {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	grouped := map[string][]Regulating{}
+	for _, it := range body.List {
+		grouped[it.FactorId] = append(grouped[it.FactorId], it)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fid, items := range grouped {
+		s.regulating[fid] = items
+	}
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+// handleHistory 按采集间隔生成确定的历史数据（按时间倒序分页）；阀门节点的 temValue 为当前开关状态。
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, err1 := time.ParseInLocation("2006-01-02 15:04:05", q.Get("startTime"), time.Local)
+	end, err2 := time.ParseInLocation("2006-01-02 15:04:05", q.Get("endTime"), time.Local)
+	pages, _ := strconv.Atoi(q.Get("pages"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if err1 != nil || err2 != nil || !start.Before(end) || pages <= 0 || limit <= 0 || limit > 1000 {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	wanted := map[int]bool{}
+	for _, v := range strings.Split(q.Get("nodeId"), ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			wanted[id] = true
+		}
+	}
+	now := time.Now()
+	s.mu.Lock()
+	d := s.deviceLocked(q.Get("deviceAddr"))
+	if d == nil {
+		s.mu.Unlock()
+		writeResult(w, http.StatusOK, 1004, "设备不存在", nil)
+		return
+	}
+	var nodes []Node
+	for _, n := range d.Nodes {
+		if len(wanted) == 0 || wanted[n.NodeId] {
+			settle(n, now)
+			nodes = append(nodes, *n)
+		}
+	}
+	addr := d.DeviceAddr
+	s.mu.Unlock()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeId < nodes[j].NodeId })
+
+	first := start.Truncate(historyInterval)
+	if first.Before(start) {
+		first = first.Add(historyInterval)
+	}
+	slots := 0
+	if !first.After(end) {
+		slots = int(end.Sub(first)/historyInterval) + 1
+	}
+	total := slots * len(nodes)
+	rows := []map[string]any{}
+	for i := (pages - 1) * limit; i < total && len(rows) < limit; i++ {
+		slot, n := slots-1-i/len(nodes), nodes[i%len(nodes)]
+		t := first.Add(time.Duration(slot) * historyInterval)
+		tem, hum := 20+5*math.Sin(float64(t.Unix())/7200), 60+10*math.Cos(float64(t.Unix())/5400)
+		if n.FactorType == FactorValve {
+			tem, hum = float64(n.SwitchState), 0
+		}
+		tem, hum = math.Round(tem*10)/10, math.Round(hum*10)/10
+		rows = append(rows, map[string]any{
+			"historyId":     t.Unix()/60*100 + int64(n.NodeId%100),
+			"nodeId":        n.NodeId,
+			"deviceAddress": addr,
+			"temStr":        strconv.FormatFloat(tem, 'f', 1, 64),
+			"humStr":        strconv.FormatFloat(hum, 'f', 1, 64),
+			"temValue":      tem,
+			"humValue":      hum,
+			"recordTime":    t.UnixMilli(),
+			"alarmStatus":   0,
+		})
+	}
+	writeResult(w, http.StatusOK, 1000, "获取成功", map[string]any{
+		"pages":      pages,
+		"limit":      limit,
+		"totalPages": (total + limit - 1) / limit,
+		"total":      total,
+		"rows":       rows,
+	})
+}
+
+func (s *Server) handleManualControl(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode != "0" && mode != "1" {
+		writeResult(w, http.StatusOK, codeBadParam, "mode 取值 0 或 1", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeByFactorLocked(q.Get("deviceAddr"), q.Get("factorId"))
+	if !ok || n.FactorType != FactorValve {
+		writeResult(w, http.StatusOK, 1004, "阀门节点不存在", nil)
+		return
+	}
+	if n.Enable == 0 {
+		writeResult(w, http.StatusOK, 1005, "节点已禁用", nil)
+		return
+	}
+	if !n.Stuck {
+		n.target = boolInt(mode == "1")
+		n.changeAt = time.Now().Add(s.opts.ActuationDelay)
+		settle(n, time.Now())
+	}
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+func (s *Server) handleUpdateMode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		FactorId string `json:"factorId"`
+		Mode     string `json:"mode"`
+	}
+	if json.NewDecoder(r.Body).Decode(&body) != nil || (body.Mode != "1" && body.Mode != "2") {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodeByFactorLocked("", body.FactorId)
+	if !ok || n.FactorType != FactorValve {
+		writeResult(w, http.StatusOK, 1004, "阀门节点不存在", nil)
+		return
+	}
+	n.Mode = body.Mode
+	writeResult(w, http.StatusOK, 1000, "success", nil)
+}
+
+func (s *Server) deviceLocked(addr string) *Device {
+	for _, d := range s.devices {
+		if d.DeviceAddr == addr {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) nodeLocked(addr string, nodeId int) (*Node, bool) {
+	if d := s.deviceLocked(addr); d != nil {
+		for _, n := range d.Nodes {
+			if n.NodeId == nodeId {
+				return n, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// nodeByFactorLocked 按 factorId 查找节点：标准格式为 {deviceAddr}_{nodeId}；
+// 执行层修改模式时只传 nodeId，此时在 addr 指定的设备（为空则全部设备）中找第一个匹配的阀门。
+func (s *Server) nodeByFactorLocked(addr, factorId string) (*Node, bool) {
+	if i := strings.LastIndex(factorId, "_"); i > 0 {
+		id, err := strconv.Atoi(factorId[i+1:])
+		if err != nil {
+			return nil, false
+		}
+		return s.nodeLocked(factorId[:i], id)
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(factorId))
+	if err != nil {
+		return nil, false
+	}
+	for _, d := range s.devices {
+		if addr != "" && d.DeviceAddr != addr {
+			continue
+		}
+		for _, n := range d.Nodes {
+			if n.NodeId == id && n.FactorType == FactorValve {
+				return n, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// settle 应用到期的阀门动作。
+func settle(n *Node, now time.Time) {
+	if !n.changeAt.IsZero() && !now.Before(n.changeAt) {
+		n.SwitchState, n.changeAt = n.target, time.Time{}
+	}
+}
+
+// nodeJSON 按平台文档输出节点；阀门节点另带开关状态（字段名 stateKey）与 mode。
+func nodeJSON(d *Device, n *Node, now time.Time, stateKey string) map[string]any {
+	settle(n, now)
+	m := map[string]any{
+		"nodeId":     n.NodeId,
+		"deviceAddr": d.DeviceAddr,
+		"deviceName": d.DeviceName,
+		"factorId":   factorID(d.DeviceAddr, n.NodeId),
+		"nodeName":   n.NodeName,
+		"enable":     n.Enable,
+		"factorType": n.FactorType,
+		"nodeType":   1,
+		"digits":     1,
+		"createTime": "2024-01-01 00:00:00",
+	}
+	if n.FactorType == FactorValve {
+		m["nodeType"] = 5
+		m[stateKey] = n.SwitchState
+		m["mode"] = n.Mode
+	}
+	return m
+}
+
+func factorID(addr string, nodeId int) string {
+	return fmt.Sprintf("%s_%d", addr, nodeId)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeResult 输出平台统一响应 {code, message, data}。
+func writeResult(w http.ResponseWriter, status, code int, message string, data any) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"code": code, "message": message, "data": data})
+}
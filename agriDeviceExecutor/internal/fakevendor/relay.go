@@ -0,0 +1,188 @@
+package fakevendor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// relay.go：传感器平台（综合环境监控云平台）继电器接口的模拟。
+// 接口：GET /api/getToken、GET /api/device/getDeviceList、GET /api/device/getRelayList、POST /api/device/setRelay（表单）；
+// 请求头 authorization 携带 token，失效时返回 Options.AuthFailCode。
+// setRelay 的 opt：0 闭合 / 1 断开，立即生效（卡死的继电器接受命令但状态不变）；relayStatus：1 闭合 / 0 断开。
+
+// Relay 是传感器平台设备上的一路继电器。
+type Relay struct {
+	RelayNo   int
+	RelayName string
+	Status    int  // 1 闭合 0 断开
+	Stuck     bool // 卡死：接受命令但状态不变
+}
+
+// RelayDevice 是一台带继电器的传感器平台设备。
+type RelayDevice struct {
+	DeviceAddr int
+	DeviceName string
+	Relays     []*Relay
+}
+
+// AddRelayDevice 添加带继电器的传感器平台设备。
+func (s *Server) AddRelayDevice(addr int, name string, relays ...Relay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &RelayDevice{DeviceAddr: addr, DeviceName: name}
+	for _, r := range relays {
+		r := r
+		d.Relays = append(d.Relays, &r)
+	}
+	s.relays = append(s.relays, d)
+}
+
+// Relay 返回继电器当前状态的副本。
+func (s *Server) Relay(addr, relayNo int) (Relay, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.relayLocked(addr, relayNo)
+	if !ok {
+		return Relay{}, false
+	}
+	return *r, true
+}
+
+// SetRelayStuck 设置继电器卡死（接受命令但状态不变）。
+func (s *Server) SetRelayStuck(addr, relayNo int, stuck bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.relayLocked(addr, relayNo)
+	if !ok {
+		return fmt.Errorf("继电器不存在: %d_%d", addr, relayNo)
+	}
+	r.Stuck = stuck
+	return nil
+}
+
+// serveRelay 分发传感器平台接口（已计数并处理注入的故障）。
+func (s *Server) serveRelay(w http.ResponseWriter, r *http.Request, endpoint string) {
+	if endpoint == "gettoken" {
+		s.handleRelayToken(w, r)
+		return
+	}
+	s.mu.Lock()
+	exp, ok := s.relayTokens[r.Header.Get("authorization")]
+	s.mu.Unlock()
+	if !ok || !time.Now().Before(exp) {
+		writeResult(w, http.StatusOK, s.opts.AuthFailCode, "token 已失效，请重新获取", nil)
+		return
+	}
+	handler, ok := map[string]func(http.ResponseWriter, *http.Request){
+		"getdevicelist": s.handleRelayDevices,
+		"getrelaylist":  s.handleRelayList,
+		"setrelay":      s.handleSetRelay,
+	}[endpoint]
+	if !ok {
+		writeResult(w, http.StatusNotFound, 404, "接口不存在", nil)
+		return
+	}
+	handler(w, r)
+}
+
+func (s *Server) handleRelayToken(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("loginName") != s.opts.LoginName || q.Get("password") != s.opts.LoginPwd {
+		writeResult(w, http.StatusOK, 1002, "用户名或密码错误", nil)
+		return
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	exp := time.Now().Add(s.opts.TokenTTL)
+	s.mu.Lock()
+	s.relayTokens[token] = exp
+	s.mu.Unlock()
+	writeResult(w, http.StatusOK, 1000, "success", map[string]any{"expiration": exp.UnixMilli(), "token": token})
+}
+
+func (s *Server) handleRelayDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []map[string]any{}
+	for _, d := range s.relays {
+		out = append(out, map[string]any{"deviceAddr": d.DeviceAddr, "deviceName": d.DeviceName})
+	}
+	writeResult(w, http.StatusOK, 1000, "success", out)
+}
+
+func (s *Server) handleRelayList(w http.ResponseWriter, r *http.Request) {
+	addr, err := strconv.Atoi(r.URL.Query().Get("deviceAddr"))
+	if err != nil {
+		writeResult(w, http.StatusOK, codeBadParam, "deviceAddr 非法", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.relayDeviceLocked(addr)
+	if d == nil {
+		writeResult(w, http.StatusOK, 1004, "设备不存在", nil)
+		return
+	}
+	out := []map[string]any{}
+	for _, rl := range d.Relays {
+		out = append(out, map[string]any{
+			"deviceAddr":  d.DeviceAddr,
+			"deviceName":  d.DeviceName,
+			"enabled":     true,
+			"relayName":   rl.RelayName,
+			"relayNo":     rl.RelayNo,
+			"relayStatus": rl.Status,
+		})
+	}
+	writeResult(w, http.StatusOK, 1000, "success", out)
+}
+
+func (s *Server) handleSetRelay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	addr, err1 := strconv.Atoi(r.PostForm.Get("deviceAddr"))
+	relayNo, err2 := strconv.Atoi(r.PostForm.Get("relayNo"))
+	opt := r.PostForm.Get("opt")
+	if err1 != nil || err2 != nil || (opt != "0" && opt != "1") {
+		writeResult(w, http.StatusOK, codeBadParam, "请求参数错误", nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl, ok := s.relayLocked(addr, relayNo)
+	if !ok {
+		writeResult(w, http.StatusOK, 1000, "继电器不存在", false)
+		return
+	}
+	if !rl.Stuck {
+		rl.Status = boolInt(opt == "0")
+	}
+	writeResult(w, http.StatusOK, 1000, "success", true)
+}
+
+func (s *Server) relayDeviceLocked(addr int) *RelayDevice {
+	for _, d := range s.relays {
+		if d.DeviceAddr == addr {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) relayLocked(addr, relayNo int) (*Relay, bool) {
+	if d := s.relayDeviceLocked(addr); d != nil {
+		for _, r := range d.Relays {
+			if r.RelayNo == relayNo {
+				return r, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// actuator.go：执行器驱动。
+// 执行层控制的对象统一称为执行器：灌溉平台的阀门节点（valve）与传感器平台设备的继电器（relay，见 relay_driver.go），
+// 状态统一为 on / off。控制、回读确认、联锁、定时关闭、对账与状态发布对两类执行器一致，只有下发与回读经各自的驱动：
+//   - Switch 下发开/关命令；平台接受命令即返回 nil，是否动作由 ReadStates 回读确认（见 valve_confirm.go）；
+//   - ReadStates 按设备批量回读状态（控制确认与对账共用），结果以 nodeId（继电器为继电器号）为键，读不到的不在结果中。
+// 按映射的 actuator 选择驱动（driverFor）；新增执行器类型时实现 ActuatorDriver 并在 driverFor 中登记。
+
+// ActuatorDriver 是一类执行器的平台驱动。
+type ActuatorDriver interface {
+	Kind() string
+	Switch(e data.ExecutorMappingEntry, on bool) error
+	ReadStates(deviceAddr string, entries []data.ExecutorMappingEntry) (map[int]string, error)
+}
+
+// driverFor 按映射选择驱动；token/baseURL 为灌溉平台会话（继电器驱动使用自己的会话）。
+func driverFor(e data.ExecutorMappingEntry, token, baseURL string) ActuatorDriver {
+	if e.Kind() == data.ActuatorRelay {
+		return relayDriver{}
+	}
+	return valveDriver{token: token, baseURL: baseURL}
+}
+
+// 执行器状态与对应的控制动作。
+var (
+	stateOf  = map[bool]string{true: "on", false: "off"}
+	actionOf = map[bool]string{true: "open", false: "close"}
+)
+
+// valveDriver 驱动灌溉平台的阀门节点（manualControlValve + 节点列表回读）。
+type valveDriver struct {
+	token, baseURL string
+}
+
+func (valveDriver) Kind() string { return data.ActuatorValve }
+
+func (d valveDriver) Switch(e data.ExecutorMappingEntry, on bool) error {
+	devAddr := strings.TrimSpace(e.DeviceAddr)
+	mode := "0"
+	if on {
+		mode = "1"
+	}
+	if err := ManualControlValve(d.token, d.baseURL, devAddr, fmt.Sprintf("%s_%d", devAddr, e.NodeId), mode); err != nil {
+		return fmt.Errorf("手动控制失败: %w", err)
+	}
+	return nil
+}
+
+// ReadStates 先查节点列表，仍有节点读不到时再查一次设备详情；两者都失败时返回错误。
+func (d valveDriver) ReadStates(devAddr string, entries []data.ExecutorMappingEntry) (map[int]string, error) {
+	states := map[int]string{}
+	key := config.GetNodeStateKey()
+	lookup := func(items []map[string]any) {
+		for _, e := range entries {
+			if _, ok := states[e.NodeId]; ok {
+				continue
+			}
+			factorId := fmt.Sprintf("%s_%d", devAddr, e.NodeId)
+			for _, it := range items {
+				if st, ok := findNodeState(it, e.NodeId, factorId, key); ok {
+					states[e.NodeId] = st
+					break
+				}
+			}
+		}
+	}
+	nodes, err := GetDeviceNodeList(d.token, d.baseURL, devAddr)
+	if err == nil {
+		lookup(nodes)
+	}
+	if len(states) < len(entries) {
+		devices, derr := GetIrrigationDeviceDetails(d.token, d.baseURL, devAddr)
+		if derr == nil {
+			lookup(devices)
+		} else if err != nil {
+			return states, err
+		}
+	}
+	return states, nil
+}
+
+// ActuatorState 是单个执行器的实时状态（GET /executor/actuatorState）。
+type ActuatorState struct {
+	ClientId     string `json:"clientId"`
+	Actuator     string `json:"actuator"`
+	DeviceAddr   string `json:"deviceAddr"`
+	NodeId       int    `json:"nodeId"`
+	State        string `json:"state"`                  // 回读到的实时状态 on / off
+	Recorded     string `json:"recorded,omitempty"`     // 映射中记录的状态（最近一次确认或对账）
+	OpenDeadline int64  `json:"openDeadline,omitempty"` // 自动关闭期限（unix 秒），0 表示无
+}
+
+// ReadActuatorState 经驱动回读单个执行器的实时状态；只读，不修改映射（状态写回由控制确认与对账负责）。
+func ReadActuatorState(clientId, token, baseURL string) (ActuatorState, error) {
+	e, ok := data.GetEntryByClientId(clientId)
+	if !ok {
+		return ActuatorState{}, fmt.Errorf("clientId 未找到映射: %s", clientId)
+	}
+	drv := driverFor(e, token, baseURL)
+	st := ActuatorState{
+		ClientId:     clientId,
+		Actuator:     drv.Kind(),
+		DeviceAddr:   e.DeviceAddr,
+		NodeId:       e.NodeId,
+		Recorded:     e.Status,
+		OpenDeadline: e.OpenDeadline,
+	}
+	states, err := drv.ReadStates(e.DeviceAddr, []data.ExecutorMappingEntry{e})
+	state, ok := states[e.NodeId]
+	if !ok {
+		if err == nil {
+			err = fmt.Errorf("节点 %d 的状态字段未找到", e.NodeId)
+		}
+		return st, fmt.Errorf("读取执行器状态失败: %w", err)
+	}
+	st.State = state
+	return st, nil
+}
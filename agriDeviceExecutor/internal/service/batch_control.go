@@ -0,0 +1,239 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// batch_control.go：批量阀门控制。
+// - 同一设备的条目按提交顺序串行执行（平台对单台控制器的并发命令会互相覆盖），不同设备并行，
+//   同时执行的条目数受 config.GetBatchConcurrency 限制；
+// - 每个条目走 ExecuteValveControl，回读确认、映射更新与 valveControl 审计与单条控制一致；
+// - 执行前预检：clientId 未映射或 action 非法为 failed，同一 clientId 重复为 skipped（duplicate），
+//   联锁拒绝（见 interlock.go）为 skipped（interlock）；执行时 ExecuteValveControl 再检查一次，拒绝同样为 skipped；
+// - allOrNothing：预检有任一条目不能执行时整批拒绝（rejected），不下发任何命令；执行中出现失败（含 unconfirmed）后不再启动新条目
+//   （skipped，aborted），待进行中的条目结束后关闭本批已打开的阀门。关阀条目不回滚（关闭即安全状态）。
+// 整批结束后追加一条 batchControl 审计，extra 为 BatchReport。
+
+// 批量条目结果。
+const (
+	BatchSuccess = "success"
+	BatchFailed  = "failed"
+	BatchSkipped = "skipped"
+
+	SkipDuplicate = "duplicate" // 同一批中重复的 clientId，只执行第一条
+	SkipInterlock = "interlock" // 联锁拒绝
+	SkipAborted   = "aborted"   // allOrNothing 因其它条目失败而未执行
+)
+
+// maxBatchItems 单批最多条目数。
+const maxBatchItems = 200
+
+// BatchItem 是批量控制的单个条目。
+type BatchItem struct {
+	ClientId       string `json:"clientId"`
+	Action         string `json:"action"`                   // open / close
+	MaxOpenSeconds int    `json:"maxOpenSeconds,omitempty"` // 开阀后自动关闭的时长（见 deadman.go）
+}
+
+// BatchItemResult 是单个条目的执行结果。
+type BatchItemResult struct {
+	ClientId      string `json:"clientId"`
+	Action        string `json:"action"`
+	maxOpen       time.Duration
+	DeviceAddr    string       `json:"deviceAddr,omitempty"`
+	NodeId        int          `json:"nodeId,omitempty"`
+	Status        string       `json:"status"`           // success / failed / skipped
+	Reason        string       `json:"reason,omitempty"` // skipped 的原因：duplicate / interlock / aborted
+	Error         string       `json:"error,omitempty"`
+	Result        *ValveResult `json:"result,omitempty"`
+	RolledBack    bool         `json:"rolledBack,omitempty"`    // allOrNothing 回滚时已关闭
+	RollbackError string       `json:"rollbackError,omitempty"` // 回滚关闭失败的原因（需人工处理）
+}
+
+// BatchReport 是一次批量控制的结果，Items 与请求条目顺序一致。
+type BatchReport struct {
+	AllOrNothing bool              `json:"allOrNothing"`
+	Rejected     bool              `json:"rejected"` // allOrNothing 预检未通过，未下发任何命令
+	Aborted      bool              `json:"aborted"`  // allOrNothing 因执行失败中止并回滚
+	Total        int               `json:"total"`
+	Succeeded    int               `json:"succeeded"`
+	Failed       int               `json:"failed"`
+	Skipped      int               `json:"skipped"`
+	RolledBack   int               `json:"rolledBack"`
+	ElapsedMs    int64             `json:"elapsedMs"`
+	Items        []BatchItemResult `json:"items"`
+}
+
+// ExecuteBatchControl 执行批量阀门控制；条目级的失败记入报告，只有参数整体非法时返回 error。
+func ExecuteBatchControl(items []BatchItem, allOrNothing bool, token, baseURL string, origin data.Origin) (BatchReport, error) {
+	rep := BatchReport{AllOrNothing: allOrNothing, Total: len(items)}
+	if len(items) == 0 {
+		return rep, errors.New("items 不能为空")
+	}
+	if len(items) > maxBatchItems {
+		return rep, fmt.Errorf("items 最多 %d 条", maxBatchItems)
+	}
+	start := time.Now()
+
+	// 预检并按设备分组（组内保持提交顺序）
+	rep.Items = make([]BatchItemResult, len(items))
+	seen := map[string]bool{}
+	groups := map[string][]int{}
+	var order []string
+	runnable := true
+	for i, it := range items {
+		res := &rep.Items[i]
+		res.ClientId, res.Action = strings.TrimSpace(it.ClientId), it.Action
+		res.maxOpen = time.Duration(it.MaxOpenSeconds) * time.Second
+		if res.Action != "open" && res.Action != "close" {
+			res.Status, res.Error = BatchFailed, "action 取值 open 或 close"
+			runnable = false
+			continue
+		}
+		if it.MaxOpenSeconds < 0 {
+			res.Status, res.Error = BatchFailed, "maxOpenSeconds 不能为负数"
+			runnable = false
+			continue
+		}
+		e, ok := data.GetEntryByClientId(res.ClientId)
+		if !ok {
+			res.Status, res.Error = BatchFailed, "clientId 未找到映射: "+res.ClientId
+			runnable = false
+			continue
+		}
+		res.DeviceAddr, res.NodeId = strings.TrimSpace(e.DeviceAddr), e.NodeId
+		if seen[res.ClientId] {
+			res.Status, res.Reason = BatchSkipped, SkipDuplicate
+			runnable = false
+			continue
+		}
+		seen[res.ClientId] = true
+		if err := checkInterlock(e, res.Action == "open"); err != nil {
+			res.Status, res.Reason, res.Error = BatchSkipped, SkipInterlock, err.Error()
+			runnable = false
+			continue
+		}
+		if _, ok := groups[res.DeviceAddr]; !ok {
+			order = append(order, res.DeviceAddr)
+		}
+		groups[res.DeviceAddr] = append(groups[res.DeviceAddr], i)
+	}
+
+	if allOrNothing && !runnable {
+		rep.Rejected = true
+		for i := range rep.Items {
+			if rep.Items[i].Status == "" {
+				rep.Items[i].Status, rep.Items[i].Reason = BatchSkipped, SkipAborted
+			}
+		}
+	} else {
+		runBatchGroups(&rep, order, groups, allOrNothing, token, baseURL, origin)
+	}
+	if allOrNothing && rep.Aborted {
+		rollbackBatch(&rep, token, baseURL, origin)
+	}
+
+	for _, r := range rep.Items {
+		switch r.Status {
+		case BatchSuccess:
+			rep.Succeeded++
+		case BatchFailed:
+			rep.Failed++
+		case BatchSkipped:
+			rep.Skipped++
+		}
+		if r.RolledBack {
+			rep.RolledBack++
+		}
+	}
+	rep.ElapsedMs = time.Since(start).Milliseconds()
+	auditControl(data.AuditRecord{
+		Action:  "batchControl",
+		Success: rep.Failed == 0 && rep.Skipped == 0,
+		Detail: fmt.Sprintf("total=%d succeeded=%d failed=%d skipped=%d rolledBack=%d allOrNothing=%v rejected=%v",
+			rep.Total, rep.Succeeded, rep.Failed, rep.Skipped, rep.RolledBack, allOrNothing, rep.Rejected),
+		Extra: rep,
+	}, origin, rep.ElapsedMs)
+	return rep, nil
+}
+
+// runBatchGroups 每个设备一个 goroutine 串行执行组内条目，信号量限制全局并发。
+func runBatchGroups(rep *BatchReport, order []string, groups map[string][]int, allOrNothing bool,
+	token, baseURL string, origin data.Origin) {
+	sem := make(chan struct{}, config.GetBatchConcurrency())
+	var (
+		mu      sync.Mutex // 保护 rep.Aborted
+		wg      sync.WaitGroup
+		aborted = func() bool { mu.Lock(); defer mu.Unlock(); return rep.Aborted }
+	)
+	for _, dev := range order {
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			for _, i := range idx {
+				res := &rep.Items[i]
+				sem <- struct{}{}
+				if allOrNothing && aborted() {
+					<-sem
+					res.Status, res.Reason = BatchSkipped, SkipAborted
+					continue
+				}
+				runBatchItem(res, allOrNothing, token, baseURL, origin)
+				<-sem
+				if allOrNothing && res.Status != BatchSuccess {
+					mu.Lock()
+					rep.Aborted = true
+					mu.Unlock()
+				}
+			}
+		}(groups[dev])
+	}
+	wg.Wait()
+}
+
+// runBatchItem 执行单个条目；预检之后节点可能已被其它请求占用或开启数已满，联锁拒绝记为 skipped。
+// unconfirmed 与单条控制一致视为成功，strict（allOrNothing）时视为失败。
+func runBatchItem(res *BatchItemResult, strict bool, token, baseURL string, origin data.Origin) {
+	vr, err := ExecuteValveControl(res.ClientId, res.Action == "open", res.maxOpen, token, baseURL, origin)
+	switch {
+	case errors.Is(err, ErrInterlock):
+		res.Status, res.Reason, res.Error = BatchSkipped, SkipInterlock, err.Error()
+		return
+	case err != nil:
+		res.Status, res.Error = BatchFailed, err.Error()
+	case vr.Confirm == data.ConfirmConfirmed:
+		res.Status = BatchSuccess
+	case vr.Confirm == data.ConfirmUnconfirmed && !strict:
+		res.Status = BatchSuccess
+	default:
+		res.Status, res.Error = BatchFailed, "未能确认阀门状态: "+vr.Confirm
+	}
+	res.Result = &vr
+}
+
+// rollbackBatch 关闭本批已打开（或状态未确认）的阀门。
+func rollbackBatch(rep *BatchReport, token, baseURL string, origin data.Origin) {
+	for i := range rep.Items {
+		res := &rep.Items[i]
+		if res.Action != "open" || res.Result == nil ||
+			(res.Result.Confirm != data.ConfirmConfirmed && res.Result.Confirm != data.ConfirmUnconfirmed) {
+			continue
+		}
+		vr, err := closeValveForSafety(res.ClientId, token, baseURL, origin)
+		switch {
+		case err != nil:
+			res.RollbackError = err.Error()
+		case vr.Confirm != data.ConfirmConfirmed:
+			res.RollbackError = "关阀未确认: " + vr.Confirm
+		default:
+			res.RolledBack = true
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clock.go：按时间判断的执行层逻辑（定时关阀期限、联锁的最小切换间隔）统一经 clockNow 取时间，
+// 测试可用 SetClock 推进时间，而不必真实等待。
+
+var clockFn atomic.Pointer[func() time.Time]
+
+// clockNow 返回当前时间；未替换时为 time.Now。
+func clockNow() time.Time {
+	if f := clockFn.Load(); f != nil {
+		return (*f)()
+	}
+	return time.Now()
+}
+
+// SetClock 替换时钟，返回恢复原时钟的函数。
+func SetClock(now func() time.Time) (restore func()) {
+	old := clockFn.Swap(&now)
+	return func() { clockFn.Store(old) }
+}
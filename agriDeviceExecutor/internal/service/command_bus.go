@@ -0,0 +1,430 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// command_bus.go：经 Magistrala MQTT 适配器接收控制命令（Magistrala 作为命令总线）。
+// 执行器主动连出并订阅 m/{domainId}/c/{channelId}/{commands.subtopic}，位于 NAT 之后也无需开放入站 HTTP。
+// 只执行 clientId 属于本执行器映射的命令（同一频道可有多个执行器），其余消息忽略；
+// 每条命令执行完成后向 {commands.ackSubtopic} 发布回执。
+//
+// 来源校验：MQTT 消息不携带发布方身份，频道内任一有发布权限的 client 都能发消息，因此命令须经签名信封下发：
+//   {"commander":"<id>","ts":<unix 秒>,"payload":"<命令消息原文>","sig":"<hex>"}
+// sig = HMAC-SHA256(commands.commanders 中该 id 的密钥, commander + "\n" + ts + "\n" + payload)（见 SignCommand）；
+// commander 未配置、签名不符或 ts 与本机时间相差超过 commandMaxSkew 的消息一律丢弃并记日志。
+//
+// payload 中的命令格式（二选一）：
+//   - JSON：{"id":"...","clientId":"...","action":"open"|"close"|"on"|"off"} 或 {"id":"...","clientId":"...","mode":"1"|"2"}，也可为数组；
+//     开阀命令可带 "maxOpenSeconds":600（非负整数秒，见 deadman.go），负数、小数或字符串的命令回执 rejected；
+//   - SenML：名称（bn+n）为 "{clientId}:valve"（继电器也可用 relay / switch；v 1/0、vb 或 vs open/close）或 "{clientId}:mode"（v 1/2）。
+// 阀门节点与继电器（见 actuator.go）使用相同的命令，on / off 与 open / close 等价。
+//
+// MQTT 至少一次投递可能重复：每条命令在 commandDedupTTL 内只执行一次。去重用命令的 id（SenML 以名称+时间作为 id），
+// 没有 id 的命令由信封签名与序号派生 id（重复投递的信封完全相同，派生出相同的 id）。
+// 连接只使用专用的 commands.clientId/clientSecret（未配置时命令总线不启动），不借用节点 client。
+
+const (
+	commandDedupTTL = 10 * time.Minute
+	commandMaxSkew  = 5 * time.Minute // 信封 ts 允许的时钟偏差，须小于 commandDedupTTL
+	commandQoS      = 1
+)
+
+// ErrCommandAuth 命令信封缺失、commander 未配置、签名不符或已过期。
+var ErrCommandAuth = errors.New("命令来源校验失败")
+
+// CommandEnvelope 是经签名的命令消息（见文件头的来源校验说明）。
+type CommandEnvelope struct {
+	Commander string `json:"commander"`
+	Ts        int64  `json:"ts"`
+	Payload   string `json:"payload"`
+	Sig       string `json:"sig"`
+}
+
+// Command 是一条控制命令。
+type Command struct {
+	ID       string `json:"id,omitempty"`
+	ClientId string `json:"clientId"`
+	Action   string `json:"action,omitempty"` // open / close（on / off 等价）
+	Mode     string `json:"mode,omitempty"`   // 1 手动 / 2 自动
+	// MaxOpenSeconds 开阀后自动关闭的时长（见 deadman.go），0 表示只受全局上限约束
+	MaxOpenSeconds int `json:"maxOpenSeconds,omitempty"`
+
+	invalid string // 解析时发现的非法字段，执行时直接拒绝
+}
+
+// CommandAck 是命令回执。
+type CommandAck struct {
+	ID       string `json:"id,omitempty"`
+	ClientId string `json:"clientId"`
+	Action   string `json:"action,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Status   string `json:"status"`            // ok / unconfirmed / failed / rejected / interlock
+	Confirm  string `json:"confirm,omitempty"` // 阀门命令的回读确认结果
+	Error    string `json:"error,omitempty"`
+	Ts       int64  `json:"ts"`
+}
+
+// 回执状态。
+const (
+	AckOK          = "ok"
+	AckUnconfirmed = "unconfirmed"
+	AckFailed      = "failed"
+	AckRejected    = "rejected"  // 命令格式或取值非法
+	AckInterlock   = "interlock" // 安全联锁拒绝，命令未下发（见 interlock.go）
+)
+
+// commandOrigin 是经命令总线执行的操作在审计中的来源。
+var commandOrigin = data.Origin{Source: data.SourceMQTT}
+
+// commandBus 持有 MQTT 连接与去重表。
+type commandBus struct {
+	cfg      config.CommandBusConfig
+	topic    string
+	ackTopic string
+	client   mqtt.Client
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// RunCommandSubscriber 按配置连接 MQTT 并订阅命令，阻塞运行；未开启时直接返回。
+func RunCommandSubscriber() {
+	if !config.CommandBusEnabled() {
+		log.Printf("[commands] 未开启")
+		return
+	}
+	cfg, err := config.GetCommandBusConfig()
+	if err != nil {
+		log.Printf("[commands] 读取配置失败: %v", err)
+		return
+	}
+	domainID, err := config.GetMagistralaDomainID()
+	if err != nil {
+		log.Printf("[commands] %v", err)
+		return
+	}
+	channelID, err := config.GetMagistralaChannelID()
+	if err != nil {
+		log.Printf("[commands] %v", err)
+		return
+	}
+	b := &commandBus{
+		cfg:      cfg,
+		topic:    fmt.Sprintf("m/%s/c/%s/%s", domainID, channelID, cfg.Subtopic),
+		ackTopic: fmt.Sprintf("m/%s/c/%s/%s", domainID, channelID, cfg.AckSubtopic),
+		seen:     map[string]time.Time{},
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(fmt.Sprintf("agri-executor-%d", time.Now().UnixNano())).
+		SetUsername(cfg.ClientID).
+		SetPassword(cfg.ClientSecret).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOrderMatters(false).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("[commands] 连接断开: %v", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	log.Printf("[commands] 连接 %s，订阅 %s", cfg.BrokerURL, b.topic)
+	b.client.Connect() // SetConnectRetry 下失败会在后台重试
+	select {}
+}
+
+func (b *commandBus) onConnect(c mqtt.Client) {
+	tok := c.Subscribe(b.topic, commandQoS, b.onMessage)
+	if tok.WaitTimeout(10*time.Second) && tok.Error() == nil {
+		log.Printf("[commands] 已订阅 %s", b.topic)
+		return
+	}
+	log.Printf("[commands] 订阅失败: %v", tok.Error())
+}
+
+// onMessage 校验信封、解析命令并在独立 goroutine 中执行（阀门确认需数秒，不阻塞 MQTT 收包）。
+func (b *commandBus) onMessage(_ mqtt.Client, msg mqtt.Message) {
+	env, err := OpenCommand(msg.Payload(), b.cfg.Commanders, time.Now())
+	if err != nil {
+		log.Printf("[commands] 丢弃来源不可信的消息: %v", err)
+		return
+	}
+	cmds, err := ParseCommands([]byte(env.Payload))
+	if err != nil {
+		log.Printf("[commands] 忽略 commander=%s 无法解析的消息: %v", env.Commander, err)
+		return
+	}
+	assignCommandIDs(env, cmds)
+	var mine []Command
+	for _, cmd := range cmds {
+		if _, ok := data.GetEntryByClientId(cmd.ClientId); ok && b.firstSeen(env.Commander+"/"+cmd.ID) {
+			mine = append(mine, cmd)
+		}
+	}
+	if len(mine) == 0 {
+		return
+	}
+	go func() {
+		for _, cmd := range mine {
+			b.publishAck(ExecuteCommand(cmd))
+		}
+	}()
+}
+
+// assignCommandIDs 为没有 id 的命令按信封签名与序号派生 id，重复投递的信封得到相同的 id。
+func assignCommandIDs(env CommandEnvelope, cmds []Command) {
+	for i := range cmds {
+		if cmds[i].ID == "" {
+			cmds[i].ID = fmt.Sprintf("%s#%d", env.Sig, i)
+		}
+	}
+}
+
+// firstSeen 记录命令 id，重复投递时返回 false。
+func (b *commandBus) firstSeen(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for k, t := range b.seen {
+		if now.Sub(t) > commandDedupTTL {
+			delete(b.seen, k)
+		}
+	}
+	if _, dup := b.seen[id]; dup {
+		return false
+	}
+	b.seen[id] = now
+	return true
+}
+
+func (b *commandBus) publishAck(ack CommandAck) {
+	payload, _ := json.Marshal(ack)
+	tok := b.client.Publish(b.ackTopic, commandQoS, false, payload)
+	if !tok.WaitTimeout(10*time.Second) || tok.Error() != nil {
+		log.Printf("[commands] 发布回执失败 clientId=%s id=%s: %v", ack.ClientId, ack.ID, tok.Error())
+	}
+}
+
+// ExecuteCommand 执行一条命令并返回回执。非法命令与取不到会话的命令也写审计（来源 mqtt）。
+func ExecuteCommand(cmd Command) CommandAck {
+	if a, ok := map[string]string{"on": "open", "off": "close"}[cmd.Action]; ok {
+		cmd.Action = a
+	}
+	ack := CommandAck{ID: cmd.ID, ClientId: cmd.ClientId, Action: cmd.Action, Mode: cmd.Mode}
+	switch {
+	case cmd.invalid != "":
+		ack.Error = cmd.invalid
+	case cmd.MaxOpenSeconds < 0:
+		ack.Error = "maxOpenSeconds 不能为负数"
+	case cmd.Action == "open" || cmd.Action == "close":
+	case cmd.Action == "" && (cmd.Mode == "1" || cmd.Mode == "2"):
+	default:
+		ack.Error = "需要 action=open|close 或 mode=1|2"
+	}
+	if ack.Error != "" {
+		ack.Status = AckRejected
+		ack.Ts = time.Now().Unix()
+		auditControl(data.AuditRecord{
+			Action:   "commandRejected",
+			ClientId: cmd.ClientId,
+			Detail:   fmt.Sprintf("id=%s action=%q mode=%q: %s", cmd.ID, cmd.Action, cmd.Mode, ack.Error),
+		}, commandOrigin, 0)
+		return ack
+	}
+	token, err := SessionToken()
+	var baseURL string
+	if err == nil {
+		baseURL, err = config.GetNormalizedAPIBaseURL()
+	}
+	switch {
+	case err != nil:
+		action := "valveControl"
+		if cmd.Action == "" {
+			action = "modeUpdate"
+		}
+		auditControl(data.AuditRecord{Action: action, ClientId: cmd.ClientId, Detail: "id=" + cmd.ID + " " + err.Error()}, commandOrigin, 0)
+	case cmd.Action != "":
+		var res ValveResult
+		res, err = ExecuteValveControl(cmd.ClientId, cmd.Action == "open",
+			time.Duration(cmd.MaxOpenSeconds)*time.Second, token, baseURL, commandOrigin)
+		ack.Confirm = res.Confirm
+		switch {
+		case err != nil:
+		case res.Confirm == data.ConfirmConfirmed:
+			ack.Status = AckOK
+		case res.Confirm == data.ConfirmUnconfirmed:
+			ack.Status = AckUnconfirmed
+		default:
+			err = errors.New(res.Error)
+		}
+	default:
+		if err = ExecuteModeUpdate(cmd.ClientId, cmd.Mode, token, baseURL, commandOrigin); err == nil {
+			ack.Status = AckOK
+		}
+	}
+	if err != nil {
+		ack.Status = AckFailed
+		if errors.Is(err, ErrInterlock) {
+			ack.Status = AckInterlock
+		}
+		ack.Error = err.Error()
+	}
+	ack.Ts = time.Now().Unix()
+	return ack
+}
+
+// SignCommand 返回 commander 对命令消息的签名（hex），供命令发布方构造信封。
+func SignCommand(key []byte, commander string, ts int64, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s", commander, ts, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// OpenCommand 解析并校验签名信封：commander 须在 commanders 中，签名一致，ts 与 now 相差不超过 commandMaxSkew。
+func OpenCommand(raw []byte, commanders map[string][]byte, now time.Time) (CommandEnvelope, error) {
+	var env CommandEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Commander == "" || env.Sig == "" {
+		return env, fmt.Errorf("%w: 不是签名信封", ErrCommandAuth)
+	}
+	key, ok := commanders[env.Commander]
+	if !ok {
+		return env, fmt.Errorf("%w: commander %q 未配置", ErrCommandAuth, env.Commander)
+	}
+	want := SignCommand(key, env.Commander, env.Ts, env.Payload)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(env.Sig))) {
+		return env, fmt.Errorf("%w: commander %s 签名不符", ErrCommandAuth, env.Commander)
+	}
+	if skew := now.Sub(time.Unix(env.Ts, 0)); skew > commandMaxSkew || skew < -commandMaxSkew {
+		return env, fmt.Errorf("%w: commander %s 的命令时间 %d 超出允许范围", ErrCommandAuth, env.Commander, env.Ts)
+	}
+	env.Sig = strings.ToLower(env.Sig)
+	return env, nil
+}
+
+// ParseCommands 解析 JSON 或 SenML 命令消息。
+func ParseCommands(payload []byte) ([]Command, error) {
+	trimmed := strings.TrimSpace(string(payload))
+	if trimmed == "" {
+		return nil, errors.New("空消息")
+	}
+	var raws []map[string]any
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &raws); err != nil {
+			return nil, err
+		}
+	} else {
+		var one map[string]any
+		if err := json.Unmarshal([]byte(trimmed), &one); err != nil {
+			return nil, err
+		}
+		raws = []map[string]any{one}
+	}
+	if len(raws) == 0 {
+		return nil, errors.New("空命令列表")
+	}
+	if _, ok := raws[0]["clientId"]; ok {
+		return parseJSONCommands(raws), nil
+	}
+	return parseSenMLCommands(raws)
+}
+
+func parseJSONCommands(raws []map[string]any) []Command {
+	cmds := make([]Command, 0, len(raws))
+	for _, r := range raws {
+		cmd := Command{
+			ID:       strings.TrimSpace(anyString(r["id"])),
+			ClientId: strings.TrimSpace(anyString(r["clientId"])),
+			Action:   strings.ToLower(strings.TrimSpace(anyString(r["action"]))),
+			Mode:     strings.TrimSpace(anyString(r["mode"])),
+		}
+		if v, ok := r["maxOpenSeconds"]; ok && v != nil {
+			// JSON 数字解码为 float64：只接受非负整数秒，其余（负数、小数、字符串）执行时拒绝
+			if n, ok := v.(float64); ok && n >= 0 && n == math.Trunc(n) && n <= math.MaxInt32 {
+				cmd.MaxOpenSeconds = int(n)
+			} else {
+				cmd.invalid = fmt.Sprintf("maxOpenSeconds 须为非负整数: %v", v)
+			}
+		}
+		if cmd.ClientId != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// parseSenMLCommands 按 SenML 语义解析：bn / bt 对后续记录持续生效，名称 = bn + n。
+func parseSenMLCommands(raws []map[string]any) ([]Command, error) {
+	var cmds []Command
+	bn, bt := "", 0.0
+	for _, r := range raws {
+		if v, ok := r["bn"].(string); ok {
+			bn = v
+		}
+		if v, ok := r["bt"].(float64); ok {
+			bt = v
+		}
+		name := bn + anyString(r["n"])
+		i := strings.LastIndex(name, ":")
+		if i <= 0 {
+			continue
+		}
+		clientId, field := name[:i], strings.ToLower(name[i+1:])
+		t, _ := r["t"].(float64)
+		cmd := Command{ClientId: clientId}
+		if bt+t != 0 {
+			cmd.ID = fmt.Sprintf("%s@%.3f", name, bt+t)
+		}
+		switch field {
+		case "valve", "valve_state", "relay", "relay_state", "switch":
+			st, ok := parseSwitch(r["vs"])
+			if !ok {
+				st, ok = parseSwitch(r["vb"])
+			}
+			if !ok {
+				st, ok = parseSwitch(r["v"])
+			}
+			if !ok {
+				continue
+			}
+			cmd.Action = map[string]string{"on": "open", "off": "close"}[st]
+		case "mode":
+			v, ok := r["v"].(float64)
+			if !ok {
+				continue
+			}
+			cmd.Mode = fmt.Sprint(v)
+		default:
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+	if len(cmds) == 0 {
+		return nil, errors.New("SenML 中没有可识别的命令记录")
+	}
+	return cmds, nil
+}
+
+// anyString 将 JSON 值转为字符串（整数值的数字输出为 "2" 而非 "2.0"）；nil 返回空串。
+func anyString(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOpenCommand(t *testing.T) {
+	key := []byte("0123456789abcdef0123")
+	commanders := map[string][]byte{"planner": key}
+	now := time.Unix(1767884023, 0)
+	payload := `{"clientId":"c1","action":"open"}`
+	envelope := func(commander string, ts int64, body, sig string) []byte {
+		return []byte(fmt.Sprintf(`{"commander":%q,"ts":%d,"payload":%q,"sig":%q}`, commander, ts, body, sig))
+	}
+	good := SignCommand(key, "planner", now.Unix(), payload)
+
+	env, err := OpenCommand(envelope("planner", now.Unix(), payload, good), commanders, now)
+	if err != nil || env.Payload != payload {
+		t.Fatalf("合法信封被拒绝: %+v err=%v", env, err)
+	}
+
+	cases := map[string][]byte{
+		"未签名的命令":         []byte(payload),
+		"未配置的 commander": envelope("intruder", now.Unix(), payload, SignCommand(key, "intruder", now.Unix(), payload)),
+		"篡改命令内容":         envelope("planner", now.Unix(), `{"clientId":"c2","action":"open"}`, good),
+		"过期的信封":          envelope("planner", now.Add(-time.Hour).Unix(), payload, SignCommand(key, "planner", now.Add(-time.Hour).Unix(), payload)),
+	}
+	for name, raw := range cases {
+		if _, err := OpenCommand(raw, commanders, now); !errors.Is(err, ErrCommandAuth) {
+			t.Errorf("%s: err=%v，期望 ErrCommandAuth", name, err)
+		}
+	}
+}
+
+func TestCommandDedupWithoutID(t *testing.T) {
+	env := CommandEnvelope{Commander: "planner", Sig: "abc"}
+	b := &commandBus{seen: map[string]time.Time{}}
+	var ids []string
+	for delivery := 0; delivery < 2; delivery++ { // QoS1 重复投递同一信封
+		cmds, err := ParseCommands([]byte(`[{"clientId":"c1","action":"open"},{"clientId":"c2","action":"open"}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignCommandIDs(env, cmds)
+		for _, cmd := range cmds {
+			if b.firstSeen(env.Commander + "/" + cmd.ID) {
+				ids = append(ids, cmd.ID)
+			}
+		}
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("无 id 命令的去重结果 = %v，期望两条命令各执行一次", ids)
+	}
+}
+
+func TestParseCommandMaxOpenSeconds(t *testing.T) {
+	cmds, err := ParseCommands([]byte(`[{"clientId":"c1","action":"open","maxOpenSeconds":600},{"clientId":"c2","action":"open"}]`))
+	if err != nil || len(cmds) != 2 {
+		t.Fatalf("解析结果=%+v err=%v", cmds, err)
+	}
+	if cmds[0].MaxOpenSeconds != 600 || cmds[0].invalid != "" || cmds[1].MaxOpenSeconds != 0 {
+		t.Fatalf("maxOpenSeconds 解析错误: %+v", cmds)
+	}
+	for _, v := range []string{`-1`, `1.5`, `"600"`} {
+		cmds, err := ParseCommands([]byte(`{"clientId":"c1","action":"open","maxOpenSeconds":` + v + `}`))
+		if err != nil || len(cmds) != 1 || cmds[0].invalid == "" {
+			t.Errorf("maxOpenSeconds=%s 应标记为非法: %+v err=%v", v, cmds, err)
+		}
+	}
+}
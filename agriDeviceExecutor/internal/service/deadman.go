@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// deadman.go：阀门定时关闭（dead-man timer）。
+// 控制服务的定时关阀只存在于其进程内（time.AfterFunc），控制服务崩溃后阀门会一直开着。
+// 执行层因此在开阀时把关阀期限写入映射（openDeadline，持久化在映射库，重启后仍生效），到期自动关阀：
+//   - 期限 = now + min(maxOpenSeconds, safety.maxOpenSeconds)；未给 maxOpenSeconds 时取全局上限（默认 4 小时）；
+//   - 关阀确认、或开阀后回读为关时清除期限；再次开阀或调用 ExtendOpenDeadline 时重新计算；
+//   - 对账读到开启而没有期限的阀门（如在厂商 App 中打开）时按全局上限补上期限，读到关闭时清除；
+//   - 到期关阀以 source=deadman 写 valveControl 审计；关阀失败的节点 deadmanRetry 后重试，期限保留；
+//   - 全局上限只约束阀门节点：继电器（补光灯、风机等常开负载）只有显式给出 maxOpenSeconds 时才有期限。
+
+const (
+	deadmanTick  = 2 * time.Second  // 到期检查周期
+	deadmanRetry = 30 * time.Second // 关阀失败后的重试间隔
+)
+
+// ErrNotOpen 阀门不处于开启状态，无法延长关阀期限。
+var ErrNotOpen = errors.New("阀门未开启")
+
+var (
+	deadmanOrigin  = data.Origin{Source: data.SourceDeadman}
+	deadmanRetryAt sync.Map // busyKey → time.Time，关阀失败后下一次尝试的时间
+)
+
+// openDeadline 计算开启后的关闭期限（unix 秒）；kind 为执行器类型，全局上限只适用于阀门。
+// maxOpen 与全局上限都不限制时返回 0。
+func openDeadline(now time.Time, maxOpen time.Duration, kind string) int64 {
+	var limit time.Duration
+	if kind == data.ActuatorValve {
+		limit = config.GetMaxOpenDuration()
+	}
+	if maxOpen > 0 && (limit == 0 || maxOpen < limit) {
+		limit = maxOpen
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return now.Add(limit).Unix()
+}
+
+// applyOpenDeadline 按控制结果维护关阀期限，返回控制后的期限：
+// 开阀 confirmed / unconfirmed 时重新计算；关阀确认或开阀回读为关时清除；其余情况（命令失败、关阀未确认）保持不变。
+func applyOpenDeadline(devAddr string, nodeId int, kind string, open bool, maxOpen time.Duration, res ValveResult) int64 {
+	var deadline int64
+	switch {
+	case open && (res.Confirm == data.ConfirmConfirmed || res.Confirm == data.ConfirmUnconfirmed):
+		deadline = openDeadline(clockNow(), maxOpen, kind)
+	case open && res.Observed == "off", !open && res.Confirm == data.ConfirmConfirmed:
+		deadline = 0
+	default:
+		e, _ := data.GetEntry(devAddr, nodeId)
+		return e.OpenDeadline
+	}
+	if err := data.SetOpenDeadline(devAddr, nodeId, deadline); err != nil {
+		log.Printf("[deadman] 记录关阀期限失败 deviceAddr=%s nodeId=%d err=%v", devAddr, nodeId, err)
+	}
+	deadmanRetryAt.Delete(busyKey(devAddr, nodeId))
+	return deadline
+}
+
+// observeOpenDeadline 按对账读到的状态维护关阀期限；开启而无期限的继电器不补期限（不受全局上限约束）。
+func observeOpenDeadline(e data.ExecutorMappingEntry, actual string) {
+	switch {
+	case actual == "off" && e.OpenDeadline != 0:
+		_ = data.SetOpenDeadline(e.DeviceAddr, e.NodeId, 0)
+	case actual == "on" && e.OpenDeadline == 0:
+		if d := openDeadline(clockNow(), 0, e.Kind()); d != 0 {
+			log.Printf("[deadman] 发现无期限的开启阀门 clientId=%s deviceAddr=%s nodeId=%d，将于 %s 自动关闭",
+				e.ClientId, e.DeviceAddr, e.NodeId, time.Unix(d, 0).Format(time.RFC3339))
+			_ = data.SetOpenDeadline(e.DeviceAddr, e.NodeId, d)
+		}
+	}
+}
+
+// RunDeadman 周期检查到期的阀门并关闭，阻塞运行；启动时先处理停机期间已到期的阀门。
+func RunDeadman() {
+	ticker := time.NewTicker(deadmanTick)
+	defer ticker.Stop()
+	for {
+		if n, err := DeadmanOnce(); err != nil {
+			log.Printf("[deadman] 到期检查失败（%d 个待关闭）: %v", n, err)
+		}
+		<-ticker.C
+	}
+}
+
+// DeadmanOnce 关闭已到期的阀门，返回本轮到期的节点数。
+func DeadmanOnce() (int, error) {
+	now := clockNow()
+	var due []data.ExecutorMappingEntry
+	for _, e := range data.GetAllEntries() {
+		if e.OpenDeadline == 0 || e.OpenDeadline > now.Unix() {
+			continue
+		}
+		key := busyKey(e.DeviceAddr, e.NodeId)
+		if _, busy := valveBusy.Load(key); busy {
+			continue // 控制中：由该次控制重新确定期限
+		}
+		if at, ok := deadmanRetryAt.Load(key); ok && now.Before(at.(time.Time)) {
+			continue
+		}
+		due = append(due, e)
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+	token, err := SessionToken()
+	if err != nil {
+		return len(due), err
+	}
+	baseURL, err := config.GetNormalizedAPIBaseURL()
+	if err != nil {
+		return len(due), err
+	}
+	for _, e := range due {
+		log.Printf("[deadman] 阀门开启已到期（%s），自动关闭 clientId=%s deviceAddr=%s nodeId=%d",
+			time.Unix(e.OpenDeadline, 0).Format(time.RFC3339), e.ClientId, e.DeviceAddr, e.NodeId)
+		res, err := closeValveForSafety(e.ClientId, token, baseURL, deadmanOrigin)
+		if (err != nil || res.Confirm != data.ConfirmConfirmed) && e.OrphanedAt != 0 {
+			// 节点已从平台移除，重试不会成功：清除期限避免无限重试
+			log.Printf("[deadman] 孤儿节点自动关阀未成功 clientId=%s confirm=%s err=%v，已清除期限，须人工确认阀门状态",
+				e.ClientId, res.Confirm, err)
+			_ = data.SetOpenDeadline(e.DeviceAddr, e.NodeId, 0)
+			continue
+		}
+		if err != nil || res.Confirm != data.ConfirmConfirmed {
+			deadmanRetryAt.Store(busyKey(e.DeviceAddr, e.NodeId), now.Add(deadmanRetry))
+			log.Printf("[deadman] 自动关阀未成功 clientId=%s confirm=%s err=%v，%s 后重试",
+				e.ClientId, res.Confirm, err, deadmanRetry)
+		}
+	}
+	return len(due), nil
+}
+
+// ExtendOpenDeadline 把正在开启的阀门的关阀期限改为 now+maxOpen（阀门受全局上限约束），不重新下发命令；
+// 有期限的阀门视为开启（关阀确认或对账读到关闭时期限即被清除），没有期限时回读实时状态，不依据映射中记录的状态。
+// 成功与失败均追加一条 extendOpen 审计。返回脱敏后的映射。
+func ExtendOpenDeadline(clientId string, maxOpen time.Duration, token, baseURL string, origin data.Origin) (data.ExecutorMappingEntry, error) {
+	rec := data.AuditRecord{Action: "extendOpen", ClientId: clientId}
+	e, ok := data.GetEntryByClientId(clientId)
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("clientId 未找到映射: %s", clientId)
+	case maxOpen <= 0:
+		err = errors.New("maxOpenSeconds 必须大于 0")
+	case e.OpenDeadline == 0:
+		var st ActuatorState
+		if st, err = ReadActuatorState(clientId, token, baseURL); err == nil && st.State != "on" {
+			err = ErrNotOpen
+		}
+	}
+	rec.DeviceAddr, rec.NodeId = e.DeviceAddr, e.NodeId
+	if err == nil {
+		deadline := openDeadline(clockNow(), maxOpen, e.Kind())
+		if err = data.SetOpenDeadline(e.DeviceAddr, e.NodeId, deadline); err == nil {
+			e.OpenDeadline = deadline
+			rec.Detail = fmt.Sprintf("maxOpenSeconds=%d openDeadline=%d", int(maxOpen/time.Second), deadline)
+		}
+	}
+	rec.Success = err == nil
+	if err != nil {
+		rec.Detail = err.Error()
+	}
+	auditControl(rec, origin, 0)
+	return e.Redacted(), err
+}
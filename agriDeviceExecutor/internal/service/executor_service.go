@@ -1,72 +1,228 @@
 package service
 
 import (
+	"errors"
 	"fmt"
-	"strconv"
+	"log"
 	"strings"
+	"time"
 
+	"agriDeviceExecutor/internal/config"
 	"agriDeviceExecutor/internal/data"
 )
 
-// ExecuteValveControl 通过 clientId 控制阀门开关（open=true 开；false 关）
-func ExecuteValveControl(clientId string, open bool, token, baseURL string) error {
-	// start := time.Now()
-	// log.Printf("[debug][exec] start ExecuteValveControl clientId=%s open=%v", clientId, open)
+// ExecuteValveControl 通过 clientId 控制执行器开关（open=true 开 / on；false 关 / off），执行器为阀门节点或继电器，
+// 经对应驱动下发后回读状态确认（见 actuator.go、valve_confirm.go）。
+// 返回的 ValveResult 记录确认结果：命令失败时 Confirm=failed 且 error 非空；
+// 命令成功但回读不一致或读不到时分别为 failed / unconfirmed，error 为 nil，由调用方按 Confirm 决定响应。
+// 映射的 status 只写入回读到的状态；lastValue 记录本次动作。每次控制（含 clientId 未映射）追加一条 valveControl
+// （继电器为 relayControl）审计，记录发起方 origin 与耗时；确认成功后异步发布状态到 Magistrala（见 state_publish.go）。
+// 开启时按 maxOpen（0 表示只受全局上限约束）记录关闭期限，到期由执行层自动关闭（见 deadman.go）。
+// 下发前检查安全联锁（见 interlock.go），拒绝时不下发命令，返回 *InterlockError 并写 interlock 审计。
+func ExecuteValveControl(clientId string, open bool, maxOpen time.Duration, token, baseURL string, origin data.Origin) (ValveResult, error) {
+	return executeValveControl(clientId, open, maxOpen, false, -1, token, baseURL, origin)
+}
+
+// StartValveControl 与 ExecuteValveControl 相同，但回读确认最多等待 wait：到时仍未确认则返回 Confirm=pending，
+// 确认在后台继续，结束后照常更新映射（confirm 字段）、关阀期限与审计，期间节点保持占用。
+// 供 HTTP 接口使用，请求不随确认超时（agriPlatform.confirmTimeoutSec）阻塞。
+func StartValveControl(clientId string, open bool, maxOpen, wait time.Duration, token, baseURL string, origin data.Origin) (ValveResult, error) {
+	return executeValveControl(clientId, open, maxOpen, false, wait, token, baseURL, origin)
+}
+
+// closeValveForSafety 安全类关阀（到期关阀、急停、批量回滚），不受切换间隔限制。
+func closeValveForSafety(clientId, token, baseURL string, origin data.Origin) (ValveResult, error) {
+	return executeValveControl(clientId, false, 0, true, -1, token, baseURL, origin)
+}
+
+// controlAuditAction 控制审计的 action：阀门节点沿用 valveControl。
+func controlAuditAction(e data.ExecutorMappingEntry) string {
+	if e.Kind() == data.ActuatorRelay {
+		return "relayControl"
+	}
+	return "valveControl"
+}
 
+// executeValveControl 下发并确认；wait<0 时等待确认结束，否则最多等待 wait（见 StartValveControl）。
+func executeValveControl(clientId string, open bool, maxOpen time.Duration, safetyClose bool, wait time.Duration, token, baseURL string, origin data.Origin) (ValveResult, error) {
+	action := actionOf[open]
 	e, ok := data.GetEntryByClientId(clientId)
 	if !ok {
-		// log.Printf("[debug][exec] mapping miss clientId=%s cost=%s", clientId, time.Since(start))
-		return fmt.Errorf("clientId 未找到映射: %s", clientId)
+		err := fmt.Errorf("clientId 未找到映射: %s", clientId)
+		auditControl(data.AuditRecord{Action: "valveControl", ClientId: clientId, Detail: "action=" + action + " " + err.Error()}, origin, 0)
+		return ValveResult{}, err
 	}
 	devAddr := strings.TrimSpace(e.DeviceAddr)
-	factorId := fmt.Sprintf("%s_%d", devAddr, e.NodeId)
-	mode := 0
-	if open {
-		mode = 1
+	drv := driverFor(e, token, baseURL)
+
+	res := ValveResult{
+		ClientId:   clientId,
+		Actuator:   drv.Kind(),
+		DeviceAddr: devAddr,
+		NodeId:     e.NodeId,
+		Action:     action,
+		Expected:   stateOf[open],
 	}
-	modeStr := strconv.Itoa(mode)
 
-	// log.Printf("[debug][exec] resolved devAddr=%s nodeId=%d factorId=%s modeStr=%s", devAddr, e.NodeId, factorId, modeStr)
+	// 检查联锁并占用节点，控制与确认期间对账跳过该节点（见 reconcile_service.go）
+	release, err := acquireControl(e, open, safetyClose)
+	if err != nil {
+		res.Error = err.Error()
+		auditControl(data.AuditRecord{
+			Action:     "interlock",
+			ClientId:   clientId,
+			DeviceAddr: devAddr,
+			NodeId:     e.NodeId,
+			Detail:     "action=" + action + " " + err.Error(),
+			Extra:      err,
+		}, origin, 0)
+		return res, err
+	}
+	start := time.Now()
+	if err = drv.Switch(e, open); err != nil {
+		res.Confirm = data.ConfirmFailed
+		res.Error = err.Error()
+		finishControl(e, open, maxOpen, start, &res, origin)
+		release()
+		return res, err
+	}
 
-	// callStart := time.Now()
-	if err := ManualControlValve(token, baseURL, devAddr, factorId, modeStr); err != nil {
-		// log.Printf("[debug][exec] ManualControlValve error=%v cost=%s callCost=%s",
-		// 	err, time.Since(start), time.Since(callStart))
-		return fmt.Errorf("手动控制失败: %w", err)
+	done := make(chan ValveResult, 1)
+	go func() {
+		defer release()
+		r := res
+		confirmValve(drv, e, &r, config.GetConfirmTimeout())
+		finishControl(e, open, maxOpen, start, &r, origin)
+		done <- r
+	}()
+	if wait < 0 {
+		return <-done, nil
 	}
-	// log.Printf("[debug][exec] ManualControlValve ok callCost=%s totalCost=%s",
-	// 	time.Since(callStart), time.Since(start))
-
-	// 执行成功后更新映射状态与最近动作
-	status := map[bool]string{true: "on", false: "off"}[open]
-	last := map[bool]string{true: "open", false: "close"}[open]
-	if err := data.UpdateEntryValue(devAddr, e.NodeId, status, last); err != nil {
-		// 可选：warn 日志
-		// log.Printf("[warn] UpdateEntryValue failed deviceAddr=%s nodeId=%d err=%v", devAddr, e.NodeId, err)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r, nil
+	case <-timer.C:
+		res.Confirm = data.ConfirmPending
+		res.ElapsedMs = time.Since(start).Milliseconds()
+		return res, nil
 	}
+}
 
-	// log.Printf("[debug][exec] mapping updated clientId=%s status=%s finalCost=%s",
-	// 	clientId, map[bool]string{true: "on", false: "off"}[open], time.Since(start))
-	return nil
+// finishControl 记录控制结果：更新映射中的状态与确认结果、维护关阀期限、写控制审计。
+func finishControl(e data.ExecutorMappingEntry, open bool, maxOpen time.Duration, start time.Time, res *ValveResult, origin data.Origin) {
+	devAddr := res.DeviceAddr
+	res.ElapsedMs = time.Since(start).Milliseconds()
+	if uerr := data.UpdateEntryConfirm(devAddr, e.NodeId, res.Observed, res.Action, res.Confirm); uerr != nil {
+		log.Printf("[warn] UpdateEntryConfirm failed deviceAddr=%s nodeId=%d err=%v", devAddr, e.NodeId, uerr)
+	} else if res.Confirm == data.ConfirmConfirmed {
+		go publishEntryState(devAddr, e.NodeId)
+	}
+	res.OpenDeadline = applyOpenDeadline(devAddr, e.NodeId, e.Kind(), open, maxOpen, *res)
+	auditControl(data.AuditRecord{
+		Action:     controlAuditAction(e),
+		ClientId:   res.ClientId,
+		DeviceAddr: devAddr,
+		NodeId:     e.NodeId,
+		Success:    res.Confirm == data.ConfirmConfirmed,
+		Detail:     fmt.Sprintf("action=%s confirm=%s observed=%s %s", res.Action, res.Confirm, res.Observed, res.Error),
+		Extra:      *res,
+	}, origin, res.ElapsedMs)
 }
 
-// ExecuteModeUpdate 通过 clientId 修改阀门工作模式（"1" 手动 / "2" 自动）。
-func ExecuteModeUpdate(clientId string, mode string, token, baseURL string) error {
+// ErrModeUnsupported 执行器没有工作模式（继电器）。
+var ErrModeUnsupported = errors.New("该执行器不支持工作模式")
+
+// ExecuteModeUpdate 通过 clientId 修改阀门工作模式（"1" 手动 / "2" 自动，继电器不支持）；成功后记入映射并异步发布节点状态。
+// 每次调用（含参数非法、clientId 未映射）追加一条 modeUpdate 审计。
+func ExecuteModeUpdate(clientId string, mode string, token, baseURL string, origin data.Origin) error {
+	rec := data.AuditRecord{Action: "modeUpdate", ClientId: clientId}
 	if mode != "1" && mode != "2" {
-		return fmt.Errorf("非法模式: %s", mode)
+		err := fmt.Errorf("非法模式: %s", mode)
+		rec.Detail = err.Error()
+		auditControl(rec, origin, 0)
+		return err
 	}
 	entry, ok := data.GetEntryByClientId(clientId)
 	if !ok {
-		return fmt.Errorf("clientId 未找到映射: %s", clientId)
+		err := fmt.Errorf("clientId 未找到映射: %s", clientId)
+		rec.Detail = fmt.Sprintf("mode=%s %v", mode, err)
+		auditControl(rec, origin, 0)
+		return err
 	}
+	if entry.Kind() != data.ActuatorValve {
+		err := ErrModeUnsupported
+		rec.DeviceAddr, rec.NodeId, rec.Detail = entry.DeviceAddr, entry.NodeId, fmt.Sprintf("mode=%s %v", mode, err)
+		auditControl(rec, origin, 0)
+		return err
+	}
+	start := time.Now()
 	err := UpdateFactorMode(token, baseURL, fmt.Sprint(entry.NodeId), mode)
-	_ = data.AppendAudit(data.AuditRecord{
-		Action:     "modeUpdate",
+	if err == nil {
+		if uerr := data.UpdateEntry(entry.DeviceAddr, entry.NodeId, func(e *data.ExecutorMappingEntry) { e.Mode = mode }); uerr != nil {
+			log.Printf("[warn] 记录模式失败 deviceAddr=%s nodeId=%d err=%v", entry.DeviceAddr, entry.NodeId, uerr)
+		} else {
+			go publishEntryState(entry.DeviceAddr, entry.NodeId)
+		}
+	}
+	rec.DeviceAddr, rec.NodeId = entry.DeviceAddr, entry.NodeId
+	rec.Success = err == nil
+	rec.Detail = fmt.Sprintf("mode=%s err=%v", mode, err)
+	auditControl(rec, origin, time.Since(start).Milliseconds())
+	return err
+}
+
+// RotateClientSecret 轮换 clientId 的 Magistrala secret（见 data.RotateClientSecret），返回脱敏后的映射。
+// 成功与失败（含 clientId 未映射）均追加一条 rotateSecret 审计（不含 secret）。
+func RotateClientSecret(clientId string, origin data.Origin) (data.ExecutorMappingEntry, error) {
+	start := time.Now()
+	entry, err := data.RotateClientSecret(clientId)
+	if errors.Is(err, data.ErrEntryNotFound) {
+		err = fmt.Errorf("clientId 未找到映射: %s", clientId)
+	}
+	auditControl(data.AuditRecord{
+		Action:     "rotateSecret",
 		ClientId:   clientId,
 		DeviceAddr: entry.DeviceAddr,
 		NodeId:     entry.NodeId,
 		Success:    err == nil,
-		Detail:     fmt.Sprintf("mode=%s err=%v", mode, err),
-	})
-	return err
+		Detail:     fmt.Sprintf("err=%v", err),
+	}, origin, time.Since(start).Milliseconds())
+	return entry.Redacted(), err
+}
+
+// secretRotationRetry 待轮换 secret 轮换失败后的重试间隔。
+const secretRotationRetry = 5 * time.Minute
+
+// RunSecretRotation 轮换从旧版 JSON 映射导入的 secret（见 data.PendingSecretRotations）：
+// 失败的在下一轮重试，全部完成后退出。每次轮换写 rotateSecret 审计（来源 startup）。
+func RunSecretRotation() {
+	for {
+		pending := data.PendingSecretRotations()
+		if len(pending) == 0 {
+			return
+		}
+		failed := 0
+		for _, e := range pending {
+			if _, err := RotateClientSecret(e.ClientId, data.Origin{Source: data.SourceStartup}); err != nil {
+				failed++
+				log.Printf("[secrets] 轮换导入的 secret 失败 clientId=%s: %v", e.ClientId, err)
+			}
+		}
+		if failed == 0 {
+			log.Printf("[secrets] 已轮换 %d 个导入的 secret", len(pending))
+			return
+		}
+		log.Printf("[secrets] %d/%d 个导入的 secret 轮换失败，%s 后重试", failed, len(pending), secretRotationRetry)
+		time.Sleep(secretRotationRetry)
+	}
+}
+
+// auditControl 为控制类审计补上发起方与耗时后写入；写入失败只记录日志。
+func auditControl(rec data.AuditRecord, origin data.Origin, durationMs int64) {
+	rec.Source, rec.Remote, rec.DurationMs = origin.Source, origin.Remote, durationMs
+	if err := data.AppendAudit(rec); err != nil {
+		log.Printf("[audit] 写入 %s 审计失败 clientId=%s: %v", rec.Action, rec.ClientId, err)
+	}
 }
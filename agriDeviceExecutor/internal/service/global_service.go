@@ -9,7 +9,6 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"time"
 )
 
 // UserLogin 用户登录，返回平台 token 及登录元信息。
@@ -24,23 +23,38 @@ import (
 //     POST {apiBaseURL}/api/v2.0/entrance/user/userLogin 调用第三方平台登录接口；
 //     请求体：{"loginName":"...","loginPwd":"..."}
 //   - 响应结构参照文档：code=1000 表示成功，data 中包含 token 等字段；
-//   - 成功后会调用 config.SetUserToken() 将 token 写入 credentials.json，供后续接口复用；
-//   - HTTP 超时 10s，错误信息尽量保留平台返回便于排查。
+//   - 成功后会调用 config.SetUserToken() 将 token 加密写入会话文件（config.TokenPath），并更新进程内会话（见 vendor_session.go）；
+//   - 与会话自动续期共用登录锁，并发调用只会串行登录；网络错误按退避重试，错误信息尽量保留平台返回便于排查。
 //
 // 安全提示：避免将明文口令写入日志；生产环境建议使用 HTTPS。
 func UserLogin(_ string, _ string) (interface{}, error) {
+	data, err := session.login()
+	if err != nil {
+		return nil, err
+	}
+
+	// 返回给上层
+	return map[string]any{
+		"token":     data.Token,
+		"loginSign": data.LoginSign,
+		"currDate":  data.CurrDate,
+		"expDate":   data.ExpDate,
+		"loginName": data.LoginName,
+	}, nil
+}
+
+// vendorLogin 调用第三方平台登录接口并解析 data（不落盘、不更新会话）。
+func vendorLogin() (loginResult, error) {
 	// 始终从本地 JSON 读取账号与密码（不依赖客户端传参）
-	fileLoginName, fileLoginPwd, err := config.GetLoginCredentials()
+	loginName, loginPwd, err := config.GetLoginCredentials()
 	if err != nil {
-		return nil, fmt.Errorf("读取本地凭据失败: %w", err)
+		return loginResult{}, fmt.Errorf("读取本地凭据失败: %w", err)
 	}
-	loginName := fileLoginName
-	loginPwd := fileLoginPwd
 
 	// 读取第三方平台基础地址（公共方法处理去尾部斜杠与错误包装）
 	baseURL, err := config.GetNormalizedAPIBaseURL()
 	if err != nil {
-		return nil, err
+		return loginResult{}, err
 	}
 
 	// 构造请求
@@ -51,16 +65,15 @@ func UserLogin(_ string, _ string) (interface{}, error) {
 	}
 	bodyBytes, _ := json.Marshal(reqBody)
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
 	req, err := http.NewRequest(http.MethodPost, loginURL, bytes.NewReader(bodyBytes))
 	if err != nil {
-		return nil, err
+		return loginResult{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err := loginClient.Do(req)
 	if err != nil {
-		return nil, err
+		return loginResult{}, err
 	}
 	defer resp.Body.Close()
 	respBytes, _ := io.ReadAll(resp.Body)
@@ -72,9 +85,9 @@ func UserLogin(_ string, _ string) (interface{}, error) {
 		}
 		_ = json.Unmarshal(respBytes, &w)
 		if w.Code != 0 || w.Message != "" {
-			return nil, fmt.Errorf("登录失败 HTTP %d, 业务code=%d, message=%s", resp.StatusCode, w.Code, w.Message)
+			return loginResult{}, fmt.Errorf("登录失败 HTTP %d, 业务code=%d, message=%s", resp.StatusCode, w.Code, w.Message)
 		}
-		return nil, fmt.Errorf("登录失败 HTTP %d, body=%s", resp.StatusCode, string(respBytes))
+		return loginResult{}, fmt.Errorf("登录失败 HTTP %d, body=%s", resp.StatusCode, string(respBytes))
 	}
 
 	// 解析通用响应
@@ -84,50 +97,33 @@ func UserLogin(_ string, _ string) (interface{}, error) {
 		Data    json.RawMessage `json:"data"`
 	}
 	if err := json.Unmarshal(respBytes, &wrapper); err != nil {
-		return nil, fmt.Errorf("解析登录响应失败: %w", err)
+		return loginResult{}, fmt.Errorf("解析登录响应失败: %w", err)
 	}
 	if wrapper.Code != 1000 {
 		if wrapper.Message == "" {
 			wrapper.Message = "登录失败"
 		}
-		return nil, errors.New(wrapper.Message)
+		return loginResult{}, errors.New(wrapper.Message)
 	}
 
 	// 解析 data 字段，按文档包含 token、loginSign、currDate、expDate
-	var data struct {
-		LoginSign string `json:"loginSign"`
-		CurrDate  int64  `json:"currDate"`
-		ExpDate   int64  `json:"expDate"`
-		Token     string `json:"token"`
-	}
+	var data loginResult
 	if len(wrapper.Data) > 0 {
 		if err := json.Unmarshal(wrapper.Data, &data); err != nil {
-			return nil, fmt.Errorf("解析登录data失败: %w", err)
+			return loginResult{}, fmt.Errorf("解析登录data失败: %w", err)
 		}
 	}
 	if data.Token == "" {
-		return nil, errors.New("登录成功但未返回 token")
+		return loginResult{}, errors.New("登录成功但未返回 token")
 	}
-
-	// 持久化 token 到本地凭据文件
-	if err := config.SetUserToken(data.Token); err != nil {
-		return nil, fmt.Errorf("保存用户令牌失败: %w", err)
-	}
-
-	// 返回给上层
-	return map[string]any{
-		"token":     data.Token,
-		"loginSign": data.LoginSign,
-		"currDate":  data.CurrDate,
-		"expDate":   data.ExpDate,
-		"loginName": loginName,
-	}, nil
+	data.LoginName = loginName
+	return data, nil
 }
 
 // GetUserInfo 调用第三方接口根据 token 获取登录用户信息（支持自动回退读取本地 token）。
 func GetUserInfo(token string, baseURL string) (interface{}, error) {
 	url := baseURL + "/api/v2.0/entrance/user/getUser"
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+	httpClient := vendorClient
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
@@ -208,7 +204,7 @@ func GetSysUserDevice(token, baseURL, groupID, deviceType string) ([]map[string]
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+	httpClient := vendorClient
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// interlock.go：液压安全联锁。每次阀门控制（HTTP、MQTT、批量）下发前检查，拒绝时不下发命令，
+// 返回 *InterlockError（errors.Is(err, ErrInterlock)）并写 interlock 审计：
+//   - orphaned：节点已从平台账号移除；
+//   - busy：节点正在被其它请求控制或确认中；
+//   - emergencyStop：全局急停激活期间拒绝一切开阀（关阀不受限）；只约束阀门节点；
+//   - minToggleInterval：关阀后再次开阀的间隔小于 safety.minToggleSec（设备配置优先）；只限制开阀，从不阻止关阀；
+//   - maxOpenSite / maxOpenDevice：开阀后同时开启的节点数将超过 safety.maxOpenNodes / 设备 maxOpenNodes；
+//   - forbiddenCombination：开阀后将与 safety.forbidden 中同组的节点同时开启。
+// 节点“开启”指回读状态为 on 或存在自动关阀期限（见 deadman.go），正在开阀的请求同样计入。
+// 继电器（见 actuator.go）同样受切换间隔、设备上限与禁止组合约束；急停与全站上限是液压安全措施，只针对阀门节点。
+// 安全类关阀（到期关阀、急停、批量回滚）不受 orphaned、busy 限制：节点在开启期间成为孤儿或控制迟迟未结束时仍尝试关闭。
+
+// 联锁规则。
+const (
+	RuleOrphaned      = "orphaned"
+	RuleBusy          = "busy"
+	RuleEmergencyStop = "emergencyStop"
+	RuleMinToggle     = "minToggleInterval"
+	RuleMaxOpenSite   = "maxOpenSite"
+	RuleMaxOpenDevice = "maxOpenDevice"
+	RuleForbidden     = "forbiddenCombination"
+)
+
+// ErrInterlock 联锁拒绝：节点当前不允许执行该动作。
+var ErrInterlock = errors.New("联锁拒绝")
+
+// InterlockError 是联锁拒绝的具体原因。
+type InterlockError struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+func (e *InterlockError) Error() string {
+	return fmt.Sprintf("联锁拒绝（%s）: %s", e.Rule, e.Detail)
+}
+
+func (e *InterlockError) Unwrap() error { return ErrInterlock }
+
+var (
+	interlockMu  sync.Mutex                               // 检查与占用节点须原子完成
+	pendingOpens = map[string]data.ExecutorMappingEntry{} // busyKey → 正在开阀（尚未确认）的节点
+)
+
+// isOpen 节点是否视为开启。
+func isOpen(e data.ExecutorMappingEntry) bool {
+	return e.Status == "on" || e.OpenDeadline != 0
+}
+
+// checkInterlock 只检查不占用节点（批量预检用）。
+func checkInterlock(e data.ExecutorMappingEntry, open bool) error {
+	interlockMu.Lock()
+	defer interlockMu.Unlock()
+	return checkInterlockLocked(e, open, false)
+}
+
+// acquireControl 检查联锁并占用节点，返回释放函数；开阀请求在释放前计入其它请求的开启数。
+// safetyClose 为 true 时（安全类关阀）不检查 orphaned、busy；节点已被占用时不接管占用，释放时也不解除。
+func acquireControl(e data.ExecutorMappingEntry, open, safetyClose bool) (func(), error) {
+	interlockMu.Lock()
+	defer interlockMu.Unlock()
+	if err := checkInterlockLocked(e, open, safetyClose); err != nil {
+		return nil, err
+	}
+	key := busyKey(strings.TrimSpace(e.DeviceAddr), e.NodeId)
+	_, held := valveBusy.LoadOrStore(key, struct{}{})
+	if open {
+		pendingOpens[key] = e
+	}
+	return func() {
+		interlockMu.Lock()
+		delete(pendingOpens, key)
+		interlockMu.Unlock()
+		if !held {
+			valveBusy.Delete(key)
+		}
+	}, nil
+}
+
+func checkInterlockLocked(e data.ExecutorMappingEntry, open, safetyClose bool) error {
+	devAddr := strings.TrimSpace(e.DeviceAddr)
+	key := busyKey(devAddr, e.NodeId)
+	if e.OrphanedAt != 0 && !safetyClose {
+		return &InterlockError{Rule: RuleOrphaned, Detail: "节点已从平台移除"}
+	}
+	if _, busy := valveBusy.Load(key); busy && !safetyClose {
+		return &InterlockError{Rule: RuleBusy, Detail: "节点正在执行其它控制"}
+	}
+	if open && e.Kind() == data.ActuatorValve {
+		st, err := data.GetEmergencyStop()
+		if err != nil {
+			// 读不到急停状态时按激活处理
+			return &InterlockError{Rule: RuleEmergencyStop, Detail: err.Error()}
+		}
+		if st.Active {
+			return &InterlockError{Rule: RuleEmergencyStop, Detail: "急停已激活: " + st.Reason}
+		}
+	}
+
+	il := config.GetInterlocks()
+	if minToggle := il.DeviceMinToggle(devAddr); open && minToggle > 0 && e.ConfirmedAt != 0 && fmt.Sprint(e.LastValue) != "open" {
+		if since := clockNow().Sub(time.Unix(e.ConfirmedAt, 0)); since < minToggle {
+			return &InterlockError{Rule: RuleMinToggle, Detail: fmt.Sprintf("距上次切换 %s，最小间隔 %s", since.Truncate(time.Second), minToggle)}
+		}
+	}
+	if !open || isOpen(e) {
+		return nil // 关阀、或重复开启已开启的节点不增加开启数
+	}
+
+	// 当前开启的其它节点（含正在开阀的请求）
+	opened := map[string]data.ExecutorMappingEntry{}
+	for _, o := range data.GetAllEntries() {
+		if isOpen(o) && o.OrphanedAt == 0 {
+			opened[busyKey(strings.TrimSpace(o.DeviceAddr), o.NodeId)] = o
+		}
+	}
+	for k, o := range pendingOpens {
+		opened[k] = o
+	}
+	delete(opened, key)
+
+	if il.MaxOpenNodes > 0 && e.Kind() == data.ActuatorValve {
+		n := 0
+		for _, o := range opened {
+			if o.Kind() == data.ActuatorValve {
+				n++
+			}
+		}
+		if n+1 > il.MaxOpenNodes {
+			return &InterlockError{Rule: RuleMaxOpenSite, Detail: fmt.Sprintf("全站已开启 %d 个阀门节点，上限 %d", n, il.MaxOpenNodes)}
+		}
+	}
+	if d, ok := il.Devices[devAddr]; ok && d.MaxOpenNodes > 0 {
+		n := 0
+		for _, o := range opened {
+			if strings.TrimSpace(o.DeviceAddr) == devAddr {
+				n++
+			}
+		}
+		if n+1 > d.MaxOpenNodes {
+			return &InterlockError{Rule: RuleMaxOpenDevice, Detail: fmt.Sprintf("设备 %s 已开启 %d 个节点，上限 %d", devAddr, n, d.MaxOpenNodes)}
+		}
+	}
+	for _, group := range il.Forbidden {
+		if !refersTo(group, e) {
+			continue
+		}
+		for _, o := range opened {
+			if refersTo(group, o) {
+				return &InterlockError{Rule: RuleForbidden, Detail: fmt.Sprintf("不能与 %s_%d 同时开启（组合 %v）", strings.TrimSpace(o.DeviceAddr), o.NodeId, group)}
+			}
+		}
+	}
+	return nil
+}
+
+// refersTo 组合中是否包含该节点（按 clientId 或 deviceAddr_nodeId 匹配）。
+func refersTo(group []string, e data.ExecutorMappingEntry) bool {
+	factorId := fmt.Sprintf("%s_%d", strings.TrimSpace(e.DeviceAddr), e.NodeId)
+	for _, ref := range group {
+		if ref = strings.TrimSpace(ref); ref == e.ClientId || ref == factorId {
+			return true
+		}
+	}
+	return false
+}
+
+// EmergencyStopReport 是急停切换的结果；激活时 Items 为逐个关阀的结果。
+type EmergencyStopReport struct {
+	State  data.EmergencyStop `json:"state"`
+	Closed int                `json:"closed"`
+	Failed int                `json:"failed"`
+	Items  []BatchItemResult  `json:"items,omitempty"`
+	Error  string             `json:"error,omitempty"` // 取不到平台会话等整体错误（急停状态已生效）
+}
+
+// SetEmergencyStop 切换全局急停。激活时先保存状态（立即拒绝新的开阀），再关闭全部开启或可能开启的阀门节点（继电器不受急停影响）：
+// 同一设备串行、不同设备并行（并发同批量控制）；正在控制中的节点等其结束后再关闭。
+// 已激活时再次激活会重新执行一轮关阀。写 emergencyStop / emergencyRelease 审计。
+func SetEmergencyStop(active bool, reason, operator string, origin data.Origin) (EmergencyStopReport, error) {
+	start := time.Now()
+	st := data.EmergencyStop{Active: active, Reason: reason, Operator: operator, Since: start.Unix()}
+	if err := data.SetEmergencyStop(st); err != nil {
+		return EmergencyStopReport{}, fmt.Errorf("保存急停状态失败: %w", err)
+	}
+	rep := EmergencyStopReport{State: st, Items: []BatchItemResult{}}
+	if !active {
+		log.Printf("[estop] 急停已解除 operator=%s", operator)
+		auditControl(data.AuditRecord{Action: "emergencyRelease", Success: true, Detail: fmt.Sprintf("operator=%s reason=%s", operator, reason)}, origin, 0)
+		return rep, nil
+	}
+	log.Printf("[estop] 急停已激活 operator=%s reason=%s", operator, reason)
+
+	// 开启、最近一次动作为开阀、或正在开阀的节点
+	sweep := map[string]data.ExecutorMappingEntry{}
+	for _, e := range data.GetAllEntries() {
+		if e.Kind() == data.ActuatorValve && e.OrphanedAt == 0 && (isOpen(e) || e.LastValue == "open") {
+			sweep[busyKey(strings.TrimSpace(e.DeviceAddr), e.NodeId)] = e
+		}
+	}
+	interlockMu.Lock()
+	for k, e := range pendingOpens {
+		if e.Kind() == data.ActuatorValve {
+			sweep[k] = e
+		}
+	}
+	interlockMu.Unlock()
+	byDevice := map[string][]data.ExecutorMappingEntry{}
+	for _, e := range sweep {
+		byDevice[strings.TrimSpace(e.DeviceAddr)] = append(byDevice[strings.TrimSpace(e.DeviceAddr)], e)
+	}
+	devices := make([]string, 0, len(byDevice))
+	for dev := range byDevice {
+		devices = append(devices, dev)
+	}
+	sort.Strings(devices)
+	for _, dev := range devices {
+		sort.Slice(byDevice[dev], func(i, j int) bool { return byDevice[dev][i].NodeId < byDevice[dev][j].NodeId })
+	}
+
+	token, err := SessionToken()
+	var baseURL string
+	if err == nil {
+		baseURL, err = config.GetNormalizedAPIBaseURL()
+	}
+	if err != nil {
+		rep.Error = err.Error()
+	} else {
+		var (
+			mu  sync.Mutex
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, config.GetBatchConcurrency())
+		)
+		for _, dev := range devices {
+			wg.Add(1)
+			go func(entries []data.ExecutorMappingEntry) {
+				defer wg.Done()
+				for _, e := range entries {
+					waitIdle(busyKey(strings.TrimSpace(e.DeviceAddr), e.NodeId), config.GetConfirmTimeout()+5*time.Second)
+					sem <- struct{}{}
+					it := BatchItemResult{ClientId: e.ClientId, Action: "close", DeviceAddr: e.DeviceAddr, NodeId: e.NodeId}
+					vr, err := closeValveForSafety(e.ClientId, token, baseURL, origin)
+					<-sem
+					it.Result = &vr
+					if err == nil && vr.Confirm == data.ConfirmConfirmed {
+						it.Status = BatchSuccess
+					} else {
+						it.Status, it.Error = BatchFailed, fmt.Sprintf("confirm=%s err=%v", vr.Confirm, err)
+					}
+					mu.Lock()
+					rep.Items = append(rep.Items, it)
+					mu.Unlock()
+				}
+			}(byDevice[dev])
+		}
+		wg.Wait()
+	}
+	for _, it := range rep.Items {
+		if it.Status == BatchSuccess {
+			rep.Closed++
+		} else {
+			rep.Failed++
+		}
+	}
+	auditControl(data.AuditRecord{
+		Action:  "emergencyStop",
+		Success: rep.Failed == 0 && rep.Error == "",
+		Detail:  fmt.Sprintf("operator=%s reason=%s closed=%d failed=%d %s", operator, reason, rep.Closed, rep.Failed, rep.Error),
+		Extra:   rep,
+	}, origin, time.Since(start).Milliseconds())
+	return rep, nil
+}
+
+// waitIdle 等待节点结束正在进行的控制，最多 timeout。
+func waitIdle(key string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, busy := valveBusy.Load(key); !busy {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
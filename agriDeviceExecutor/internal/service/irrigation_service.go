@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 )
 
 // ControlIrrigationNode 控制指定设备的某个节点。
@@ -54,7 +53,7 @@ func ManualControlValve(token, baseURL, deviceAddr, factorId, mode string) error
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -118,7 +117,7 @@ func GetIrrigationDeviceDetails(token, baseURL, devAddr string) ([]map[string]an
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -181,7 +180,7 @@ func UpdateIrrigationDeviceInfo(token, baseURL string, payload map[string]any) e
 	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(bodyBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -230,7 +229,7 @@ func GetDeviceNodeList(token, baseURL, devAddr string) ([]map[string]any, error)
 	u.RawQuery = q.Encode()
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -289,7 +288,7 @@ func UpdateDeviceNode(token, baseURL string, payload map[string]any) error {
 	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(bodyBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -341,7 +340,7 @@ func BatchNodeEnable(token, baseURL, devAddr, enable, factorType string) error {
 	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(bodyBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -390,7 +389,7 @@ func GetIrrigationFactorRegulating(token, baseURL, factorId string) ([]map[strin
 	u.RawQuery = q.Encode()
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -457,7 +456,7 @@ func ReplaceTbIrrigationFactorRegulating(token, baseURL string, items []Regulati
 	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(bodyBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -512,7 +511,7 @@ func GetHistoryDataList(token, baseURL, deviceAddr, startTime, endTime string, p
 	u.RawQuery = q.Encode()
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -572,7 +571,7 @@ func UpdateFactorMode(token, baseURL, factorId, mode string) error {
 	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(bodyBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("token", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := vendorClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ProxyOverride 将人工接管请求转发到控制服务 /control/override。
+// 人工接管由控制服务统一维护（按 domain/channel/分区 生效），执行层只做透传，避免两处状态不一致。
+//
+// 返回：控制服务的 HTTP 状态码与 JSON 响应体；网络错误或响应非 JSON 时返回 error。
+// 设置与解除接管（POST / DELETE）追加一条 override 审计，extra 为请求体。
+func ProxyOverride(method, rawQuery string, body []byte, origin data.Origin) (int, json.RawMessage, error) {
+	start := time.Now()
+	status, resp, err := proxyOverride(method, rawQuery, body)
+	if method == http.MethodGet {
+		return status, resp, err
+	}
+	rec := data.AuditRecord{
+		Action:  "override",
+		Success: err == nil && status >= 200 && status < 300,
+		Detail:  fmt.Sprintf("%s %s status=%d err=%v", method, rawQuery, status, err),
+	}
+	if len(body) > 0 {
+		rec.Extra = json.RawMessage(body)
+	}
+	auditControl(rec, origin, time.Since(start).Milliseconds())
+	return status, resp, err
+}
+
+func proxyOverride(method, rawQuery string, body []byte) (int, json.RawMessage, error) {
+	base, err := config.GetControlServiceBaseURL()
+	if err != nil {
+		return 0, nil, fmt.Errorf("读取控制服务地址失败: %w", err)
+	}
+	target := base + "/control/override"
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("请求控制服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("读取控制服务响应失败: %w", err)
+	}
+	if len(bytes.TrimSpace(respBody)) == 0 {
+		return resp.StatusCode, nil, nil
+	}
+	if !json.Valid(respBody) {
+		return resp.StatusCode, nil, fmt.Errorf("控制服务响应非 JSON: HTTP %d %s", resp.StatusCode, string(respBody))
+	}
+	return resp.StatusCode, json.RawMessage(respBody), nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// reconcile_service.go：阀门状态周期对账与漂移检测。
+// 阀门可能在厂商 App 中被手动切换，或由设备自动模式动作，映射中的 status 会因此过期。
+// 对账按设备经执行器驱动批量回读全部已映射节点（含继电器，见 actuator.go）的实际状态，写回 status/observedAt；
+// 实际状态与最近一次下发的动作（lastValue=open/close）不一致时标记 drift，并在首次发现时
+// 写 valveDrift 审计、推送 webhook（config.GetDriftWebhookURL）；恢复一致时写 driftCleared 审计。
+// 正在下发/确认中的节点跳过，避免把本系统自己的动作误判为漂移。
+// 每轮结束后把读到状态的节点发布到 Magistrala（见 state_publish.go）；读到的状态同时用于维护自动关阀期限（见 deadman.go）。
+
+// valveBusy 记录正在执行控制的节点（key = deviceAddr|nodeId）。
+var valveBusy sync.Map
+
+func busyKey(deviceAddr string, nodeId int) string {
+	return fmt.Sprintf("%s|%d", deviceAddr, nodeId)
+}
+
+// DriftEvent 是状态漂移事件，写入审计 extra 并作为 webhook 请求体。
+type DriftEvent struct {
+	Event       string `json:"event"` // valveDrift
+	ClientId    string `json:"clientId"`
+	DeviceAddr  string `json:"deviceAddr"`
+	NodeId      int    `json:"nodeId"`
+	Commanded   string `json:"commanded"`   // 最近一次下发的动作 open / close
+	Expected    string `json:"expected"`    // 期望状态 on / off
+	Actual      string `json:"actual"`      // 回读到的实际状态
+	CommandedAt int64  `json:"commandedAt"` // 最近一次下发确认时间（unix 秒）
+	DetectedAt  int64  `json:"detectedAt"`
+}
+
+// ReconcileReport 是一轮对账的统计。
+type ReconcileReport struct {
+	Devices   int `json:"devices"`
+	Nodes     int `json:"nodes"`
+	Observed  int `json:"observed"`  // 读到状态的节点数
+	Changed   int `json:"changed"`   // status 发生变化的节点数
+	Drifted   int `json:"drifted"`   // 新发现漂移的节点数
+	Skipped   int `json:"skipped"`   // 控制中跳过的节点数
+	Unread    int `json:"unread"`    // 读不到状态的节点数
+	Published int `json:"published"` // 成功发布状态的节点数
+}
+
+// RunReconciler 按配置周期执行对账，阻塞运行；周期配置为关闭时直接返回。
+func RunReconciler() {
+	interval := config.GetReconcileInterval()
+	if interval <= 0 {
+		log.Printf("[reconcile] 已关闭")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rep, err := ReconcileOnce()
+		if err != nil {
+			log.Printf("[reconcile] 对账失败: %v", err)
+			continue
+		}
+		if rep.Changed > 0 || rep.Drifted > 0 || rep.Unread > 0 {
+			log.Printf("[reconcile] 节点=%d 读到=%d 变化=%d 漂移=%d 跳过=%d 未读到=%d",
+				rep.Nodes, rep.Observed, rep.Changed, rep.Drifted, rep.Skipped, rep.Unread)
+		}
+	}
+}
+
+// ReconcileOnce 回读全部已映射节点的实际状态并更新映射。
+func ReconcileOnce() (ReconcileReport, error) {
+	var rep ReconcileReport
+	token, err := SessionToken()
+	if err != nil {
+		return rep, err
+	}
+	baseURL, err := config.GetNormalizedAPIBaseURL()
+	if err != nil {
+		return rep, err
+	}
+
+	byDevice := map[string][]data.ExecutorMappingEntry{}
+	for _, e := range data.GetAllEntries() {
+		byDevice[e.DeviceAddr] = append(byDevice[e.DeviceAddr], e)
+	}
+	rep.Devices = len(byDevice)
+
+	var drifts []DriftEvent
+	var observed []data.ExecutorMappingEntry
+	for devAddr, entries := range byDevice {
+		rep.Nodes += len(entries)
+		states, err := driverFor(entries[0], token, baseURL).ReadStates(devAddr, entries)
+		if err != nil {
+			log.Printf("[reconcile] 读取状态失败 deviceAddr=%s: %v", devAddr, err)
+		}
+		for _, e := range entries {
+			actual, ok := states[e.NodeId]
+			if !ok {
+				rep.Unread++
+				continue
+			}
+			if _, busy := valveBusy.Load(busyKey(devAddr, e.NodeId)); busy {
+				rep.Skipped++
+				continue
+			}
+			// 读取期间完成了新的控制（与读取前的快照不同）：以新的期望为准，本轮不判定
+			if cur, ok := data.GetEntry(devAddr, e.NodeId); !ok || cur.ConfirmedAt != e.ConfirmedAt || fmt.Sprint(cur.LastValue) != fmt.Sprint(e.LastValue) {
+				rep.Skipped++
+				continue
+			}
+			rep.Observed++
+
+			commanded, _ := e.LastValue.(string)
+			expected := map[string]string{"open": "on", "close": "off"}[commanded]
+			drift := expected != "" && actual != expected
+			prev, ok := data.SetObservedState(devAddr, e.NodeId, actual, drift)
+			if !ok {
+				continue
+			}
+			observeOpenDeadline(prev, actual)
+			cur := prev
+			cur.Status = actual
+			observed = append(observed, cur)
+			if prev.Status != actual {
+				rep.Changed++
+			}
+			switch {
+			case drift && !prev.Drift:
+				rep.Drifted++
+				drifts = append(drifts, DriftEvent{
+					Event:       "valveDrift",
+					ClientId:    e.ClientId,
+					DeviceAddr:  devAddr,
+					NodeId:      e.NodeId,
+					Commanded:   commanded,
+					Expected:    expected,
+					Actual:      actual,
+					CommandedAt: prev.ConfirmedAt,
+					DetectedAt:  time.Now().Unix(),
+				})
+			case !drift && prev.Drift:
+				_ = data.AppendAudit(data.AuditRecord{
+					Action:     "driftCleared",
+					ClientId:   e.ClientId,
+					DeviceAddr: devAddr,
+					NodeId:     e.NodeId,
+					Success:    true,
+					Detail:     fmt.Sprintf("actual=%s", actual),
+					Source:     data.SourceReconcile,
+				})
+			}
+		}
+	}
+	for _, ev := range drifts {
+		log.Printf("[reconcile] 状态漂移 clientId=%s deviceAddr=%s nodeId=%d 下发=%s 实际=%s",
+			ev.ClientId, ev.DeviceAddr, ev.NodeId, ev.Commanded, ev.Actual)
+		_ = data.AppendAudit(data.AuditRecord{
+			Action:     "valveDrift",
+			ClientId:   ev.ClientId,
+			DeviceAddr: ev.DeviceAddr,
+			NodeId:     ev.NodeId,
+			Success:    false,
+			Detail:     fmt.Sprintf("commanded=%s expected=%s actual=%s", ev.Commanded, ev.Expected, ev.Actual),
+			Extra:      ev,
+			Source:     data.SourceReconcile,
+		})
+		if err := postDriftWebhook(ev); err != nil {
+			log.Printf("[reconcile] 推送漂移事件失败: %v", err)
+		}
+	}
+	if !config.StatePublishEnabled() {
+		return rep, nil
+	}
+	now := time.Now()
+	for _, e := range observed {
+		if !statePublishDue(e, now) {
+			continue
+		}
+		if err := PublishNodeState(e); err != nil {
+			log.Printf("[reconcile] 发布节点状态失败 deviceAddr=%s nodeId=%d: %v", e.DeviceAddr, e.NodeId, err)
+			continue
+		}
+		rep.Published++
+	}
+	return rep, nil
+}
+
+// postDriftWebhook 推送漂移事件；未配置地址时不做任何事。
+func postDriftWebhook(ev DriftEvent) error {
+	url := config.GetDriftWebhookURL()
+	if url == "" {
+		return nil
+	}
+	b, _ := json.Marshal(ev)
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
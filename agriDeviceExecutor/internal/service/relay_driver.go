@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// relay_driver.go：传感器平台（综合环境监控云平台，agriDataIntegration 接入的同一平台）的继电器驱动。
+// 继电器驱动风机、补光灯、水泵等，映射方式与阀门节点相同（每个继电器一个 Magistrala client，见 sync_service.go），
+// 映射的 deviceAddr 为 data.RelayDeviceAddr(设备地址)，nodeId 为继电器号。
+//   - 接口：/api/getToken 登录，/api/device/getDeviceList、/api/device/getRelayList 读取，/api/device/setRelay 操作；
+//     请求头 authorization 携带 token，与灌溉平台的会话相互独立；
+//   - setRelay 的 opt：0 闭合（on）/ 1 断开（off）；getRelayList 的 relayStatus：1 闭合（on）/ 0 断开（off）；
+//   - token 按登录响应的 expiration 提前 refreshMargin 续期，被平台拒绝时重新登录并重试一次；
+//     网络错误与 429/5xx 的退避重试同灌溉平台（loginClient）。
+
+// setRelay 的操作值。
+const (
+	relayOptOn  = 0 // 闭合
+	relayOptOff = 1 // 断开
+)
+
+// RelayInfo 是传感器平台上的一个继电器。
+type RelayInfo struct {
+	DeviceAddr  int    `json:"deviceAddr"`
+	DeviceName  string `json:"deviceName"`
+	Enabled     bool   `json:"enabled"`
+	RelayName   string `json:"relayName"`
+	RelayNo     int    `json:"relayNo"`
+	RelayStatus int    `json:"relayStatus"`
+}
+
+// State 返回继电器状态 on / off。
+func (r RelayInfo) State() string {
+	return stateOf[r.RelayStatus == 1]
+}
+
+// relayDriver 驱动传感器平台设备的继电器。
+type relayDriver struct{}
+
+func (relayDriver) Kind() string { return data.ActuatorRelay }
+
+func (relayDriver) Switch(e data.ExecutorMappingEntry, on bool) error {
+	addr, ok := data.RelayPlatformAddr(e.DeviceAddr)
+	if !ok {
+		return fmt.Errorf("不是继电器映射: %s", e.DeviceAddr)
+	}
+	opt := relayOptOff
+	if on {
+		opt = relayOptOn
+	}
+	form := url.Values{}
+	form.Set("deviceAddr", addr)
+	form.Set("relayNo", strconv.Itoa(e.NodeId))
+	form.Set("opt", strconv.Itoa(opt))
+	var done bool
+	if err := relaySess.call(http.MethodPost, "/api/device/setRelay", form, &done); err != nil {
+		return fmt.Errorf("继电器操作失败: %w", err)
+	}
+	if !done {
+		return errors.New("继电器操作失败: 平台未执行")
+	}
+	return nil
+}
+
+func (relayDriver) ReadStates(deviceAddr string, _ []data.ExecutorMappingEntry) (map[int]string, error) {
+	addr, ok := data.RelayPlatformAddr(deviceAddr)
+	if !ok {
+		return nil, fmt.Errorf("不是继电器映射: %s", deviceAddr)
+	}
+	relays, err := GetRelayList(addr)
+	if err != nil {
+		return nil, err
+	}
+	states := map[int]string{}
+	for _, r := range relays {
+		states[r.RelayNo] = r.State()
+	}
+	return states, nil
+}
+
+// GetRelayList 读取传感器平台设备的继电器列表。
+func GetRelayList(platformAddr string) ([]RelayInfo, error) {
+	var relays []RelayInfo
+	q := url.Values{}
+	q.Set("deviceAddr", strings.TrimSpace(platformAddr))
+	if err := relaySess.call(http.MethodGet, "/api/device/getRelayList", q, &relays); err != nil {
+		return nil, fmt.Errorf("读取继电器列表失败: %w", err)
+	}
+	return relays, nil
+}
+
+// ListRelayDevices 返回接入继电器的传感器平台设备地址：配置了 relayPlatform.deviceAddrs 时取配置，否则取账号下全部设备。
+func ListRelayDevices() ([]string, error) {
+	rp, err := config.GetRelayPlatform()
+	if err != nil {
+		return nil, err
+	}
+	if len(rp.DeviceAddrs) > 0 {
+		return rp.DeviceAddrs, nil
+	}
+	var devices []struct {
+		DeviceAddr json.Number `json:"deviceAddr"`
+	}
+	if err := relaySess.call(http.MethodGet, "/api/device/getDeviceList", nil, &devices); err != nil {
+		return nil, fmt.Errorf("读取传感器平台设备失败: %w", err)
+	}
+	addrs := make([]string, 0, len(devices))
+	for _, d := range devices {
+		if s := d.DeviceAddr.String(); s != "" {
+			addrs = append(addrs, s)
+		}
+	}
+	return addrs, nil
+}
+
+// relaySession 是传感器平台会话；mu 同时用于串行化登录。
+type relaySession struct {
+	mu    sync.Mutex
+	token string
+	exp   time.Time
+}
+
+var relaySess = &relaySession{}
+
+// current 返回当前 token，尚未登录或即将到期时先登录。
+func (s *relaySession) current(rp config.RelayPlatform) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.exp.IsZero() || time.Until(s.exp) > refreshMargin) {
+		return s.token, nil
+	}
+	return s.loginLocked(rp)
+}
+
+// relogin 在 stale 被平台拒绝后调用：若其它请求已换过 token 则直接返回新 token。
+func (s *relaySession) relogin(rp config.RelayPlatform, stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.token != stale {
+		return s.token, nil
+	}
+	return s.loginLocked(rp)
+}
+
+func (s *relaySession) loginLocked(rp config.RelayPlatform) (string, error) {
+	q := url.Values{}
+	q.Set("loginName", rp.Username)
+	q.Set("password", rp.Password)
+	req, err := http.NewRequest(http.MethodGet, rp.BaseURL+"/api/getToken?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Expiration int64  `json:"expiration"`
+		Token      string `json:"token"`
+	}
+	if err := relayDo(req, &res); err != nil {
+		return "", fmt.Errorf("传感器平台登录失败: %w", err)
+	}
+	if res.Token == "" {
+		return "", errors.New("传感器平台登录失败: 未返回 token")
+	}
+	s.token, s.exp = res.Token, expTime(res.Expiration)
+	return s.token, nil
+}
+
+// call 调用传感器平台接口并把 data 解析到 out；GET 时 params 为查询参数，POST 时为表单。
+// token 失效时重新登录并重试一次。
+func (s *relaySession) call(method, path string, params url.Values, out any) error {
+	rp, err := config.GetRelayPlatform()
+	if err != nil {
+		return err
+	}
+	build := func(token string) (*http.Request, error) {
+		var req *http.Request
+		var err error
+		if method == http.MethodGet {
+			u := rp.BaseURL + path
+			if len(params) > 0 {
+				u += "?" + params.Encode()
+			}
+			req, err = http.NewRequest(method, u, nil)
+		} else {
+			req, err = http.NewRequest(method, rp.BaseURL+path, strings.NewReader(params.Encode()))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		}
+		if err == nil {
+			req.Header.Set("authorization", token)
+		}
+		return req, err
+	}
+	token, err := s.current(rp)
+	if err != nil {
+		return err
+	}
+	req, err := build(token)
+	if err != nil {
+		return err
+	}
+	if err = relayDo(req, out); !errors.Is(err, errRelayAuth) {
+		return err
+	}
+	if token, err = s.relogin(rp, token); err != nil {
+		return fmt.Errorf("token 失效且重新登录失败: %w", err)
+	}
+	if req, err = build(token); err != nil {
+		return err
+	}
+	return relayDo(req, out)
+}
+
+// errRelayAuth 传感器平台拒绝了 token。
+var errRelayAuth = errors.New("传感器平台 token 失效")
+
+// relayDo 发送请求并解析 {code, message, data}；code≠1000 时返回错误，token 失效时包装 errRelayAuth。
+func relayDo(req *http.Request, out any) error {
+	resp, err := loginClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if isAuthFailure(resp) {
+		return errRelayAuth
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var w struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &w); err != nil {
+		return fmt.Errorf("解析响应失败 http=%d body=%s", resp.StatusCode, string(body))
+	}
+	if w.Code != 1000 {
+		return fmt.Errorf("code=%d message=%s", w.Code, w.Message)
+	}
+	if out == nil || len(w.Data) == 0 || string(w.Data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(w.Data, out); err != nil {
+		return fmt.Errorf("解析 data 失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// state_publish.go：把执行器节点状态以 SenML 发布到 Magistrala。
+// 每个节点使用自己的 client secret，经 HTTP 适配器发布到
+// {baseUrl}:{messagePort}/http/m/{domainId}/c/{channelId}/{stateSubtopic}，
+// 状态因此与传感器数据出现在同一频道，LLM 规划器读取频道消息即可看到当前执行器状态。
+//
+// 每条消息为一个 SenML pack（bn = executor-{deviceAddr}-{nodeId}:，bt = 发布时间）：
+//   - valve_state（继电器为 relay_state）：1 开 / 0 关，仅在已读到节点状态时发布；
+//   - mode：1 手动 / 2 自动，仅在设置过模式时发布；
+//   - last_action：最近一次下发的动作 open / close（字符串 vs）。
+// 发布时机：阀门控制确认成功后、模式修改成功后；每轮对账只发布读到状态且状态有变化，
+// 或距上次发布超过心跳周期（magistrala.stateHeartbeatSec）的节点。已成为孤儿（client 已停用）的节点不发布。
+// 发布失败只记录日志，不影响控制结果。
+
+var statePublishClient = &http.Client{Timeout: 10 * time.Second}
+
+// publishedState 最近一次成功发布的节点状态签名与时间。
+type publishedState struct {
+	sig string
+	at  time.Time
+}
+
+// lastPublished 按 deviceAddr|nodeId 记录最近一次成功发布，对账据此跳过未变化的节点。
+var lastPublished sync.Map
+
+// stateSignature 返回参与发布的状态字段签名。
+func stateSignature(e data.ExecutorMappingEntry) string {
+	return fmt.Sprintf("%s|%s|%v", e.Status, e.Mode, e.LastValue)
+}
+
+// statePublishDue 判断对账时是否需要发布节点状态：状态有变化，或距上次发布已超过心跳周期。
+func statePublishDue(e data.ExecutorMappingEntry, now time.Time) bool {
+	if e.OrphanedAt != 0 {
+		return false
+	}
+	v, ok := lastPublished.Load(busyKey(e.DeviceAddr, e.NodeId))
+	if !ok {
+		return true
+	}
+	p := v.(publishedState)
+	return p.sig != stateSignature(e) || now.Sub(p.at) >= config.GetStateHeartbeat()
+}
+
+// senmlRecord 是 SenML JSON 记录（RFC 8428）。
+type senmlRecord struct {
+	BaseName    string   `json:"bn,omitempty"`
+	BaseTime    float64  `json:"bt,omitempty"`
+	Name        string   `json:"n"`
+	Value       *float64 `json:"v,omitempty"`
+	StringValue string   `json:"vs,omitempty"`
+}
+
+// nodeStatePack 由映射构造 SenML pack；没有可发布的状态时返回空。
+func nodeStatePack(e data.ExecutorMappingEntry, now time.Time) []senmlRecord {
+	var pack []senmlRecord
+	num := func(name string, v float64) {
+		pack = append(pack, senmlRecord{Name: name, Value: &v})
+	}
+	stateName := e.Kind() + "_state"
+	switch e.Status {
+	case "on":
+		num(stateName, 1)
+	case "off":
+		num(stateName, 0)
+	}
+	if m, err := strconv.Atoi(e.Mode); err == nil {
+		num("mode", float64(m))
+	}
+	if action, ok := e.LastValue.(string); ok && (action == "open" || action == "close") {
+		pack = append(pack, senmlRecord{Name: "last_action", StringValue: action})
+	}
+	if len(pack) == 0 {
+		return nil
+	}
+	pack[0].BaseName = fmt.Sprintf("executor-%s-%d:", e.DeviceAddr, e.NodeId)
+	pack[0].BaseTime = float64(now.UnixMilli()) / 1000
+	return pack
+}
+
+// PublishNodeState 发布单个节点的当前状态；未开启发布、节点已成为孤儿或没有可发布的状态时不做任何事。
+func PublishNodeState(e data.ExecutorMappingEntry) error {
+	if !config.StatePublishEnabled() || e.OrphanedAt != 0 {
+		return nil
+	}
+	if e.ClientSecret == "" {
+		return fmt.Errorf("节点 %s|%d 缺少 clientSecret", e.DeviceAddr, e.NodeId)
+	}
+	now := time.Now()
+	pack := nodeStatePack(e, now)
+	if pack == nil {
+		return nil
+	}
+	baseURL, err := config.GetMagistralaBaseURL()
+	if err != nil {
+		return err
+	}
+	domainID, err := config.GetMagistralaDomainID()
+	if err != nil {
+		return err
+	}
+	channelID, err := config.GetMagistralaChannelID()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(pack)
+	if err != nil {
+		return fmt.Errorf("序列化 SenML 失败: %w", err)
+	}
+	target := fmt.Sprintf("%s:%s/http/m/%s/c/%s/%s", strings.TrimRight(baseURL, "/"),
+		config.GetMagistralaMessagePort(), domainID, channelID, config.GetStateSubtopic())
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/senml+json")
+	req.Header.Set("Authorization", "Client "+e.ClientSecret)
+	resp, err := statePublishClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("发布状态失败 http=%d body=%s", resp.StatusCode, string(b))
+	}
+	lastPublished.Store(busyKey(e.DeviceAddr, e.NodeId), publishedState{sig: stateSignature(e), at: now})
+	return nil
+}
+
+// publishEntryState 读取最新映射并发布（控制路径调用，失败只记录日志）。
+func publishEntryState(deviceAddr string, nodeId int) {
+	e, ok := data.GetEntry(deviceAddr, nodeId)
+	if !ok {
+		return
+	}
+	if err := PublishNodeState(e); err != nil {
+		log.Printf("[publish] 发布节点状态失败 deviceAddr=%s nodeId=%d: %v", deviceAddr, nodeId, err)
+	}
+}
@@ -1,58 +1,298 @@
 package service
 
 import (
+	"agriDeviceExecutor/internal/config"
 	"agriDeviceExecutor/internal/data"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
-// SyncAll 遍历用户所有设备与其节点，确保映射存在。
+// 节点同步分类。
+const (
+	SyncAdded     = "added"     // 平台上新出现的节点：注册 Magistrala client 并建立映射
+	SyncUnchanged = "unchanged" // 已有映射且无变化
+	SyncChanged   = "changed"   // 已有映射但节点名称变化，或孤儿节点重新出现（恢复 client）
+	SyncOrphaned  = "orphaned"  // 映射存在但节点已不在平台账号中，按孤儿策略处理
+
+	// OrphanActionSkipped 平台返回空设备列表（或某设备返回空节点列表）时孤儿不做处理。
+	OrphanActionSkipped = "skipped"
+)
+
+// SyncItem 是单个节点的同步结果。
+type SyncItem struct {
+	DeviceAddr string   `json:"deviceAddr"`
+	NodeId     int      `json:"nodeId"`
+	NodeName   string   `json:"nodeName,omitempty"`
+	ClientId   string   `json:"clientId,omitempty"`
+	Class      string   `json:"class"`
+	Changes    []string `json:"changes,omitempty"` // changed 的具体变化
+	Action     string   `json:"action,omitempty"`  // orphaned 的处理：disable / delete / keep / none（此前已停用）
+	Error      string   `json:"error,omitempty"`
+}
+
+// SyncReport 是一次同步的差异报告；DryRun 时只计算差异，不注册、不修改映射与 Magistrala。
+type SyncReport struct {
+	DryRun       bool       `json:"dryRun"`
+	OrphanPolicy string     `json:"orphanPolicy"`
+	Added        int        `json:"added"`
+	Unchanged    int        `json:"unchanged"`
+	Changed      int        `json:"changed"`
+	Orphaned     int        `json:"orphaned"`
+	Errors       []string   `json:"errors,omitempty"` // 设备级错误（读取节点失败等）
+	Items        []SyncItem `json:"items"`
+}
+
+// SyncAll 将平台账号下的全部设备节点与映射对账，逐节点分类为 added / unchanged / changed / orphaned。
 // 规则：
-// - device 列表来源：GetSysUserDevice
-// - node 列表来源：GetDeviceNodeList
-// - 节点级唯一，不再区分寄存器（registerId 移除）
-// - 调用 data.EnsureEntry(deviceAddr,nodeId) 保障映射与注册
-// 审计：新增映射 syncAdd；已有映射 syncSkip；错误 syncError。
-func SyncAll(token, baseURL string) error {
+//   - device 列表来源：GetSysUserDevice；node 列表来源：GetDeviceNodeList
+//   - 节点级唯一，不再区分寄存器（registerId 移除）
+//   - added 调用 data.EnsureEntry 注册 Magistrala client 并建立映射；changed 更新映射（重新出现的孤儿先恢复 client）
+//   - orphaned 按 config.GetOrphanPolicy() 停用或删除 Magistrala client 与映射；读取节点失败的设备不判定孤儿，
+//     平台返回空设备列表、或某设备返回空节点列表时也不处理（该设备的）孤儿，避免平台异常时误停用 client
+//   - 配置了 relayPlatform 时同样同步传感器平台的继电器（见 syncRelays）；未配置或读取设备失败时继电器映射不判定孤儿
+//
+// 审计：syncAdd / syncChange / syncOrphan / syncError，source 为 origin（startup / sync / http）；unchanged 不写审计。
+func SyncAll(token, baseURL string, dryRun bool, origin data.Origin) (SyncReport, error) {
+	rep := SyncReport{DryRun: dryRun, OrphanPolicy: config.GetOrphanPolicy(), Items: []SyncItem{}}
 	devices, err := GetSysUserDevice(token, baseURL, "", "")
 	if err != nil {
-		return fmt.Errorf("获取设备失败: %w", err)
+		return rep, fmt.Errorf("获取设备失败: %w", err)
 	}
+
+	seen := map[string]bool{}
+	unreadable := map[string]bool{}
+	emptied := map[string]bool{} // 返回空节点列表的设备，其映射不判定孤儿
 	for _, d := range devices {
-		devAddr := fmt.Sprint(d["deviceAddr"])
-		if devAddr == "" {
+		devAddr := strings.TrimSpace(fmt.Sprint(d["deviceAddr"]))
+		if devAddr == "" || devAddr == "<nil>" {
 			continue
 		}
 		nodes, err := GetDeviceNodeList(token, baseURL, devAddr)
 		if err != nil {
-			_ = data.AppendAudit(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, Detail: err.Error(), Success: false})
+			unreadable[devAddr] = true
+			rep.Errors = append(rep.Errors, fmt.Sprintf("设备 %s 读取节点失败: %v", devAddr, err))
+			if !dryRun {
+				auditSync(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, Detail: err.Error(), Success: false}, origin)
+			}
+			continue
+		}
+		if len(nodes) == 0 {
+			emptied[devAddr] = true
+			rep.Errors = append(rep.Errors, fmt.Sprintf("设备 %s 返回空节点列表，跳过该设备的孤儿处理", devAddr))
 			continue
 		}
 		for _, n := range nodes {
-			nodeIdAny := n["nodeId"]
-			if nodeIdAny == nil {
+			nodeId := parseNodeId(n["nodeId"])
+			if nodeId <= 0 {
 				continue
 			}
-			// nodeId 可能是数值型
-			nodeIdInt := 0
-			switch v := nodeIdAny.(type) {
-			case float64:
-				nodeIdInt = int(v)
-			case int:
-				nodeIdInt = v
-			case string:
-				// 若是字符串尝试转换
-				fmt.Sscanf(v, "%d", &nodeIdInt)
+			seen[fmt.Sprintf("%s|%d", devAddr, nodeId)] = true
+			name := ""
+			if v, ok := n["nodeName"].(string); ok {
+				name = strings.TrimSpace(v)
 			}
-			if nodeIdInt <= 0 {
-				continue
+			rep.Items = append(rep.Items, syncNode(devAddr, nodeId, name, dryRun, origin))
+		}
+	}
+
+	relaysListed := config.RelayPlatformEnabled() && syncRelays(&rep, seen, unreadable, emptied, dryRun, origin)
+
+	orphanGuard := len(devices) == 0
+	if orphanGuard {
+		rep.Errors = append(rep.Errors, "平台返回空设备列表，跳过孤儿处理")
+	}
+	for _, e := range data.GetAllEntries() {
+		if seen[fmt.Sprintf("%s|%d", e.DeviceAddr, e.NodeId)] || unreadable[e.DeviceAddr] {
+			continue
+		}
+		if e.Kind() == data.ActuatorRelay && !relaysListed {
+			continue
+		}
+		if emptied[e.DeviceAddr] || (e.Kind() != data.ActuatorRelay && orphanGuard) {
+			rep.Items = append(rep.Items, SyncItem{DeviceAddr: e.DeviceAddr, NodeId: e.NodeId, NodeName: e.NodeName,
+				ClientId: e.ClientId, Class: SyncOrphaned, Action: OrphanActionSkipped})
+			continue
+		}
+		it := SyncItem{DeviceAddr: e.DeviceAddr, NodeId: e.NodeId, NodeName: e.NodeName, ClientId: e.ClientId, Class: SyncOrphaned}
+		switch {
+		case e.OrphanedAt != 0 && rep.OrphanPolicy != config.OrphanDelete:
+			it.Action = "none" // 此前已停用
+		default:
+			it.Action = rep.OrphanPolicy
+			if !dryRun {
+				applyOrphan(&it, rep.OrphanPolicy, origin)
 			}
-			entry, err := data.EnsureEntry(devAddr, nodeIdInt)
-			if err != nil {
-				_ = data.AppendAudit(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, NodeId: nodeIdInt, Detail: err.Error(), Success: false})
+		}
+		rep.Items = append(rep.Items, it)
+	}
+
+	sort.Slice(rep.Items, func(i, j int) bool {
+		a, b := rep.Items[i], rep.Items[j]
+		if a.DeviceAddr != b.DeviceAddr {
+			return a.DeviceAddr < b.DeviceAddr
+		}
+		return a.NodeId < b.NodeId
+	})
+	for _, it := range rep.Items {
+		switch it.Class {
+		case SyncAdded:
+			rep.Added++
+		case SyncUnchanged:
+			rep.Unchanged++
+		case SyncChanged:
+			rep.Changed++
+		case SyncOrphaned:
+			rep.Orphaned++
+		}
+	}
+	return rep, nil
+}
+
+// syncRelays 同步传感器平台设备的继电器：每个继电器按节点同样处理（deviceAddr 为 data.RelayDeviceAddr，nodeId 为继电器号）。
+// 读取继电器列表失败的设备记入 unreadable，返回空继电器列表的设备记入 emptied；
+// 返回是否读到了设备列表（否则继电器映射不判定孤儿）。
+func syncRelays(rep *SyncReport, seen, unreadable, emptied map[string]bool, dryRun bool, origin data.Origin) bool {
+	addrs, err := ListRelayDevices()
+	if err != nil {
+		rep.Errors = append(rep.Errors, fmt.Sprintf("读取继电器设备失败: %v", err))
+		if !dryRun {
+			auditSync(data.AuditRecord{Action: "syncError", Detail: err.Error(), Success: false}, origin)
+		}
+		return false
+	}
+	for _, addr := range addrs {
+		devAddr := data.RelayDeviceAddr(addr)
+		relays, err := GetRelayList(addr)
+		if err != nil {
+			unreadable[devAddr] = true
+			rep.Errors = append(rep.Errors, fmt.Sprintf("设备 %s 读取继电器失败: %v", devAddr, err))
+			if !dryRun {
+				auditSync(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, Detail: err.Error(), Success: false}, origin)
+			}
+			continue
+		}
+		if len(relays) == 0 {
+			emptied[devAddr] = true
+			rep.Errors = append(rep.Errors, fmt.Sprintf("设备 %s 返回空继电器列表，跳过该设备的孤儿处理", devAddr))
+			continue
+		}
+		for _, r := range relays {
+			if r.RelayNo < 0 {
 				continue
 			}
-			_ = data.AppendAudit(data.AuditRecord{Action: "syncAdd", DeviceAddr: devAddr, NodeId: nodeIdInt, ClientId: entry.ClientId, Success: true})
+			seen[fmt.Sprintf("%s|%d", devAddr, r.RelayNo)] = true
+			rep.Items = append(rep.Items, syncNode(devAddr, r.RelayNo, strings.TrimSpace(r.RelayName), dryRun, origin))
+		}
+	}
+	return len(addrs) > 0
+}
+
+// syncNode 分类并（非 dryRun 时）应用平台上存在的单个节点。
+func syncNode(devAddr string, nodeId int, name string, dryRun bool, origin data.Origin) SyncItem {
+	it := SyncItem{DeviceAddr: devAddr, NodeId: nodeId, NodeName: name}
+	existing, ok := data.GetEntry(devAddr, nodeId)
+	if !ok {
+		it.Class = SyncAdded
+		if dryRun {
+			return it
+		}
+		entry, err := data.EnsureEntry(devAddr, nodeId)
+		if err != nil {
+			it.Error = err.Error()
+			auditSync(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, NodeId: nodeId, Detail: err.Error(), Success: false}, origin)
+			return it
+		}
+		it.ClientId = entry.ClientId
+		if name != "" {
+			_ = data.UpdateEntry(devAddr, nodeId, func(e *data.ExecutorMappingEntry) { e.NodeName = name })
+		}
+		auditSync(data.AuditRecord{Action: "syncAdd", DeviceAddr: devAddr, NodeId: nodeId, ClientId: entry.ClientId, Success: true}, origin)
+		return it
+	}
+
+	it.ClientId = existing.ClientId
+	if existing.OrphanedAt != 0 {
+		it.Changes = append(it.Changes, "restored")
+	}
+	if name != "" && name != existing.NodeName {
+		it.Changes = append(it.Changes, fmt.Sprintf("nodeName: %q → %q", existing.NodeName, name))
+	}
+	if len(it.Changes) == 0 {
+		it.Class = SyncUnchanged
+		return it
+	}
+	it.Class = SyncChanged
+	if dryRun {
+		return it
+	}
+	if existing.OrphanedAt != 0 {
+		if err := data.SetMagistralaClientEnabled(existing.ClientId, true); err != nil {
+			it.Error = err.Error()
+			auditSync(data.AuditRecord{Action: "syncError", DeviceAddr: devAddr, NodeId: nodeId, ClientId: existing.ClientId, Detail: err.Error(), Success: false}, origin)
+			return it
+		}
+	}
+	err := data.UpdateEntry(devAddr, nodeId, func(e *data.ExecutorMappingEntry) {
+		if name != "" {
+			e.NodeName = name
+		}
+		e.OrphanedAt = 0
+		e.UpdatedAt = time.Now().Unix()
+	})
+	if err != nil {
+		it.Error = err.Error()
+	}
+	auditSync(data.AuditRecord{
+		Action: "syncChange", DeviceAddr: devAddr, NodeId: nodeId, ClientId: existing.ClientId,
+		Success: err == nil, Detail: strings.Join(it.Changes, "; "),
+	}, origin)
+	return it
+}
+
+// applyOrphan 按策略处理孤儿映射：Magistrala 调用失败时映射保持不变，下次同步重试。
+func applyOrphan(it *SyncItem, policy string, origin data.Origin) {
+	var err error
+	switch policy {
+	case config.OrphanDisable:
+		if err = data.SetMagistralaClientEnabled(it.ClientId, false); err == nil {
+			err = data.UpdateEntry(it.DeviceAddr, it.NodeId, func(e *data.ExecutorMappingEntry) {
+				e.OrphanedAt = time.Now().Unix()
+			})
 		}
+	case config.OrphanDelete:
+		if err = data.DeleteMagistralaClient(it.ClientId); err == nil {
+			err = data.DeleteEntry(it.DeviceAddr, it.NodeId)
+		}
+	case config.OrphanKeep:
+		return
+	}
+	if err != nil {
+		it.Error = err.Error()
+	}
+	auditSync(data.AuditRecord{
+		Action: "syncOrphan", DeviceAddr: it.DeviceAddr, NodeId: it.NodeId, ClientId: it.ClientId,
+		Success: err == nil, Detail: "policy=" + policy + " " + it.Error,
+	}, origin)
+}
+
+// auditSync 写入同步审计（带发起方）。
+func auditSync(rec data.AuditRecord, origin data.Origin) {
+	rec.Source, rec.Remote = origin.Source, origin.Remote
+	_ = data.AppendAudit(rec)
+}
+
+// parseNodeId 解析平台返回的 nodeId（可能是数值或字符串）。
+func parseNodeId(v any) int {
+	id := 0
+	switch x := v.(type) {
+	case float64:
+		id = int(x)
+	case int:
+		id = x
+	case string:
+		fmt.Sscanf(x, "%d", &id)
 	}
-	return nil
+	return id
 }
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"agriDeviceExecutor/internal/config"
+	"agriDeviceExecutor/internal/data"
+)
+
+// valve_confirm.go：执行器控制的回读确认。
+// 平台接受命令（如 manualControlValve 返回 code=1000）只表示命令已下发，执行器是否动作需回读状态：
+// 每 confirmInterval 经驱动回读一次（阀门节点先查 getDeviceNodeList，读不到时改查 getDeviceIii；继电器查 getRelayList），
+// 直到状态与期望一致或超时（config.GetConfirmTimeout）。
+//
+// 只认配置的阀门开关字段（config.GetNodeStateKey，平台文档未定义该字段），不在其他字段中猜测
+// （value/data 等可能是传感器读数，会误判开关状态）；
+// 取值 1/true/"on"/"open"/"开" 视为开，0/false/"off"/"close"/"关" 视为关。
+
+const confirmInterval = time.Second
+
+// ValveResult 是一次执行器控制的结果，随 API 响应与审计记录返回。
+type ValveResult struct {
+	ClientId   string `json:"clientId"`
+	Actuator   string `json:"actuator,omitempty"` // valve / relay
+	DeviceAddr string `json:"deviceAddr"`
+	NodeId     int    `json:"nodeId"`
+	Action     string `json:"action"`             // open / close
+	Expected   string `json:"expected"`           // on / off
+	Observed   string `json:"observed,omitempty"` // 最后一次回读到的状态（on / off），读不到时为空
+	Confirm    string `json:"confirm"`            // confirmed / unconfirmed / failed
+	Polls      int    `json:"polls"`              // 回读次数
+	ElapsedMs  int64  `json:"elapsedMs"`          // 从下发命令到确认结束的耗时
+	// OpenDeadline 控制后的自动关阀期限（unix 秒），0 表示无
+	OpenDeadline int64  `json:"openDeadline,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// confirmValve 经驱动轮询执行器状态直到与 expected 一致或超时，填写 res 的 Observed/Confirm/Polls。
+func confirmValve(drv ActuatorDriver, e data.ExecutorMappingEntry, res *ValveResult, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for {
+		res.Polls++
+		states, err := drv.ReadStates(res.DeviceAddr, []data.ExecutorMappingEntry{e})
+		if observed, ok := states[res.NodeId]; ok {
+			res.Observed = observed
+			if observed == res.Expected {
+				res.Confirm = data.ConfirmConfirmed
+				return
+			}
+		} else if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("节点 %d 的状态字段未找到（阀门按 agriPlatform.nodeStateKey=%q 读取）", res.NodeId, config.GetNodeStateKey())
+		}
+		if time.Now().Add(confirmInterval).After(deadline) {
+			break
+		}
+		time.Sleep(confirmInterval)
+	}
+	switch {
+	case res.Observed != "":
+		res.Confirm = data.ConfirmFailed
+		res.Error = fmt.Sprintf("超时 %s 后节点状态仍为 %s", timeout, res.Observed)
+	default:
+		res.Confirm = data.ConfirmUnconfirmed
+		if lastErr != nil {
+			res.Error = fmt.Sprintf("无法回读节点状态: %v", lastErr)
+		}
+	}
+}
+
+// findNodeState 在任意嵌套的 JSON 值中查找 nodeId 或 factorId 匹配的节点对象，并解析其 key 字段的开关状态。
+func findNodeState(v any, nodeId int, factorId, key string) (string, bool) {
+	switch x := v.(type) {
+	case map[string]any:
+		if matchesNode(x, nodeId, factorId) {
+			if st, ok := parseSwitch(x[key]); ok {
+				return st, true
+			}
+		}
+		for _, child := range x {
+			if st, ok := findNodeState(child, nodeId, factorId, key); ok {
+				return st, true
+			}
+		}
+	case []any:
+		for _, child := range x {
+			if st, ok := findNodeState(child, nodeId, factorId, key); ok {
+				return st, true
+			}
+		}
+	}
+	return "", false
+}
+
+func matchesNode(m map[string]any, nodeId int, factorId string) bool {
+	if f, ok := m["factorId"]; ok && factorId != "" && fmt.Sprint(f) == factorId {
+		return true
+	}
+	id, ok := m["nodeId"]
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(id)))
+	return err == nil && n == nodeId
+}
+
+// parseSwitch 将平台返回的状态值归一化为 on / off。
+func parseSwitch(v any) (string, bool) {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return "on", true
+		}
+		return "off", true
+	case float64:
+		switch x {
+		case 1:
+			return "on", true
+		case 0:
+			return "off", true
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(x)) {
+		case "1", "on", "open", "true", "开", "开启":
+			return "on", true
+		case "0", "off", "close", "closed", "false", "关", "关闭":
+			return "off", true
+		}
+	}
+	return "", false
+}
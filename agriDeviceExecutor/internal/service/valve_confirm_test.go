@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"agriDeviceExecutor/internal/config"
+)
+
+// loadNodeListFixture 读取 testdata/getDeviceNodeList.json：平台文档（API文档.md 8.3.4）给出的 getDeviceNodeList 返回示例原文。
+func loadNodeListFixture(t *testing.T) []map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "getDeviceNodeList.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Code int              `json:"code"`
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil || resp.Code != 1000 || len(resp.Data) != 1 {
+		t.Fatalf("解析文档示例失败: code=%d n=%d err=%v", resp.Code, len(resp.Data), err)
+	}
+	return resp.Data
+}
+
+func TestFindNodeStateOnDocumentedResponse(t *testing.T) {
+	nodes := loadNodeListFixture(t)
+	// 文档示例的节点对象没有开关状态字段：不从 enable、temLowerLimit 等其他字段猜测
+	if st, ok := findNodeState(nodes[0], 1, "21104619_1", config.GetNodeStateKey()); ok {
+		t.Fatalf("文档示例不含状态字段，却解析出 %q", st)
+	}
+
+	// 阀门节点按配置的字段名读取，其他字段名一律不认
+	valve := nodes[0]
+	valve["factorType"], valve["nodeType"] = 2.0, 5.0
+	valve["valveState"] = 1.0
+	if st, ok := findNodeState(nodes[0], 1, "21104619_1", "valveState"); !ok || st != "on" {
+		t.Fatalf("按配置字段读取 = %q/%v，期望 on", st, ok)
+	}
+	if _, ok := findNodeState(nodes[0], 1, "21104619_1", "switchState"); ok {
+		t.Fatal("未配置的字段名不应被读取")
+	}
+	if _, ok := findNodeState(nodes[0], 2, "21104619_2", "valveState"); ok {
+		t.Fatal("节点编号不匹配时不应返回状态")
+	}
+}
+
+func TestNodeStateKeyConfigurable(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("CONFIG_PATH", cfg)
+	if k := config.GetNodeStateKey(); k != "switchState" {
+		t.Fatalf("配置文件缺失时字段名 = %q，期望默认 switchState", k)
+	}
+	if err := os.WriteFile(cfg, []byte(`{"agriPlatform":{"nodeStateKey":" valveState "}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if k := config.GetNodeStateKey(); k != "valveState" {
+		t.Fatalf("配置的字段名 = %q，期望 valveState", k)
+	}
+}
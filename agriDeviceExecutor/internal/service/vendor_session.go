@@ -0,0 +1,288 @@
+package service
+
+import (
+	"agriDeviceExecutor/internal/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// vendor_session.go：第三方平台会话（自动续期）。
+//
+// 行为说明：
+//   - 会话记录 token 及登录响应中的 expDate；距到期不足 refreshMargin 时，下一次取 token 即主动重新登录；
+//   - 进程启动后首次取 token 时沿用会话文件中已保存的 userToken（见 config.GetUserToken）（到期时间未知，直到被平台拒绝）；
+//   - 所有带 token 的第三方请求经 vendorClient 发出：请求头 token 统一替换为会话当前 token，
+//     遇到 token 失效（HTTP 401/403，或业务 code ∈ agriPlatform.authFailCodes）时重新登录并重试一次；
+//     平台文档只给出成功码 1000，未定义 token 失效的业务 code，因此默认只认 HTTP 状态码，不按 message 文案猜测；
+//   - 重新登录串行执行：并发请求同时发现 token 失效时只登录一次，其余请求直接使用新 token；
+//   - 网络错误与 429/502/503/504 按指数退避重试（最多 vendorMaxAttempts 次，单次等待不超过 vendorMaxBackoff）。
+//     平台接口均为“设置为某状态”（开/关阀、改模式、改名称），重复下发结果一致，可安全重试。
+
+const (
+	refreshMargin     = 5 * time.Minute
+	vendorMaxAttempts = 3
+	vendorBaseBackoff = 200 * time.Millisecond
+	vendorMaxBackoff  = 2 * time.Second
+)
+
+// vendorSession 是进程内唯一的第三方平台会话；mu 同时用于串行化登录。
+type vendorSession struct {
+	mu     sync.Mutex
+	token  string
+	exp    time.Time // 零值表示到期时间未知
+	loaded bool      // 是否已尝试读取会话文件中保存的 token
+}
+
+var session = &vendorSession{}
+
+// vendorClient 发送带 token 的第三方请求（自动续期 + 退避重试）。
+// 总超时覆盖重试与重新登录；单次请求等待响应头不超过 10s。
+var vendorClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &vendorTransport{auth: true},
+}
+
+// loginClient 发送登录请求（仅退避重试，不做 token 处理）。
+var loginClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &vendorTransport{},
+}
+
+// ResetSessions 丢弃进程内的平台会话（含继电器平台），下次请求时重新读取会话文件或登录；更换平台账号或地址后调用。
+func ResetSessions() {
+	session.mu.Lock()
+	session.token, session.exp, session.loaded = "", time.Time{}, false
+	session.mu.Unlock()
+	relaySess.mu.Lock()
+	relaySess.token, relaySess.exp = "", time.Time{}
+	relaySess.mu.Unlock()
+}
+
+// SessionToken 返回当前有效的平台 token；尚未登录或即将到期时先登录。
+func SessionToken() (string, error) {
+	return session.current()
+}
+
+// current 返回当前 token，必要时登录。
+func (s *vendorSession) current() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		s.loaded = true
+		if tk, err := config.GetUserToken(); err == nil {
+			s.token = tk
+		}
+	}
+	if s.token != "" && (s.exp.IsZero() || time.Until(s.exp) > refreshMargin) {
+		return s.token, nil
+	}
+	if s.token != "" {
+		log.Printf("[session] token 将于 %s 到期，主动重新登录", s.exp.Format(time.RFC3339))
+	}
+	if _, err := s.loginLocked(); err != nil {
+		return "", err
+	}
+	return s.token, nil
+}
+
+// relogin 在 stale 被平台拒绝后调用：若其它请求已换过 token 则直接返回新 token，否则重新登录。
+func (s *vendorSession) relogin(stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.token != stale {
+		return s.token, nil
+	}
+	log.Printf("[session] token 已失效，重新登录")
+	if _, err := s.loginLocked(); err != nil {
+		return "", err
+	}
+	return s.token, nil
+}
+
+// login 强制重新登录（/entrance/user/userLogin 与启动时调用）。
+func (s *vendorSession) login() (loginResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loginLocked()
+}
+
+// loginLocked 调用平台登录并更新会话与会话文件；调用方须持有 mu。
+func (s *vendorSession) loginLocked() (loginResult, error) {
+	res, err := vendorLogin()
+	if err != nil {
+		return res, err
+	}
+	if err := config.SetUserToken(res.Token); err != nil {
+		return res, fmt.Errorf("保存用户令牌失败: %w", err)
+	}
+	s.token = res.Token
+	s.exp = expTime(res.ExpDate)
+	s.loaded = true
+	return res, nil
+}
+
+// expTime 将 expDate 转为时间：兼容毫秒与秒时间戳；无效或已过去时返回零值（到期时间未知）。
+func expTime(v int64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	var t time.Time
+	if v > 1e12 {
+		t = time.UnixMilli(v)
+	} else {
+		t = time.Unix(v, 0)
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}
+	}
+	return t
+}
+
+// vendorTransport 为第三方请求提供退避重试；auth 为 true 时负责注入 token 与失效后重新登录。
+type vendorTransport struct {
+	auth bool
+	base http.RoundTripper
+}
+
+func (t *vendorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.auth {
+		return t.send(req)
+	}
+	token, err := session.current()
+	if err != nil {
+		return nil, fmt.Errorf("获取平台 token 失败: %w", err)
+	}
+	resp, err := t.send(withToken(req, token))
+	if err != nil || !isAuthFailure(resp) {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil // 请求体无法重放，交由调用方按失败处理
+	}
+	fresh, err := session.relogin(token)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("token 失效且重新登录失败: %w", err)
+	}
+	resp.Body.Close()
+	retry, err := rewind(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(withToken(retry, fresh))
+}
+
+// send 发送请求，网络错误与可重试状态码按指数退避重试。
+func (t *vendorTransport) send(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = defaultVendorTransport
+	}
+	backoff := vendorBaseBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if attempt >= vendorMaxAttempts || (req.Body != nil && req.GetBody == nil) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err != nil {
+			log.Printf("[session] %s %s 失败（第 %d 次）: %v", req.Method, req.URL.Path, attempt, err)
+		} else {
+			log.Printf("[session] %s %s 返回 HTTP %d（第 %d 次）", req.Method, req.URL.Path, resp.StatusCode, attempt)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if backoff *= 2; backoff > vendorMaxBackoff {
+			backoff = vendorMaxBackoff
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// defaultVendorTransport 限制单次请求等待响应头的时间，避免一次慢请求耗尽整体超时。
+var defaultVendorTransport http.RoundTripper = func() http.RoundTripper {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ResponseHeaderTimeout = 10 * time.Second
+	return tr
+}()
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewind 复制请求并重置请求体以便重发。
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body == nil || req.GetBody == nil {
+		return r, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("重放请求体失败: %w", err)
+	}
+	r.Body = body
+	return r, nil
+}
+
+// withToken 返回替换了 token 请求头的请求副本（RoundTripper 不得修改原请求）。
+func withToken(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("token", token)
+	return r
+}
+
+// authFailCodes 首次使用时读取一次 agriPlatform.authFailCodes，修改后重启生效。
+var authFailCodes = sync.OnceValue(config.GetAuthFailCodes)
+
+// isAuthFailure 判断响应是否表示 token 失效；配置了业务 code 时会读取响应体并放回，调用方仍可正常读取。
+func isAuthFailure(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+	codes := authFailCodes()
+	if len(codes) == 0 {
+		return false
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return false
+	}
+	var w struct {
+		Code int `json:"code"`
+	}
+	if json.Unmarshal(b, &w) != nil || w.Code == 1000 {
+		return false
+	}
+	return slices.Contains(codes, w.Code)
+}
+
+// loginResult 是平台登录响应 data 字段。
+type loginResult struct {
+	LoginSign string `json:"loginSign"`
+	CurrDate  int64  `json:"currDate"`
+	ExpDate   int64  `json:"expDate"`
+	Token     string `json:"token"`
+	LoginName string `json:"-"`
+}
@@ -134,7 +134,13 @@ func (o *Orchestrator) SendToExecutor(cmd ExecCommand) error {
 	b, _ := json.Marshal(payload)
 	url := fmt.Sprintf("%s/executor/valveControl", o.ExecutorBase)
 	log.Printf("[Executor] POST %s payload=%s", url, string(b))
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(b))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Source", "llm") // 执行层审计记录的来源
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Printf("[Executor] request error: %v", err)
 		return err
@@ -147,6 +153,57 @@ func (o *Orchestrator) SendToExecutor(cmd ExecCommand) error {
 	return nil
 }
 
+// ExecResult 是执行层批量控制中单条指令的结果。
+type ExecResult struct {
+	ClientId string `json:"clientId"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`           // success / failed / skipped
+	Reason   string `json:"reason,omitempty"` // skipped 的原因：duplicate / interlock / aborted
+	Error    string `json:"error,omitempty"`
+}
+
+// SendBatchToExecutor 一次下发多条指令到执行模块 /executor/batchControl（执行层按设备串行、限制并发），
+// 返回各指令的执行结果；部分失败不视为 error。执行层整批拒绝或中止（非 200）时返回 error，
+// 响应中带有逐条结果时一并返回。
+func (o *Orchestrator) SendBatchToExecutor(cmds []ExecCommand) ([]ExecResult, error) {
+	if o.ExecutorBase == "" {
+		return nil, fmt.Errorf("参数不完整")
+	}
+	items := make([]map[string]string, 0, len(cmds))
+	for _, c := range cmds {
+		items = append(items, map[string]string{"clientId": c.ClientId, "action": c.Action})
+	}
+	b, _ := json.Marshal(map[string]any{"items": items})
+	url := fmt.Sprintf("%s/executor/batchControl", o.ExecutorBase)
+	log.Printf("[Executor] POST %s items=%d", url, len(items))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Source", "llm") // 执行层审计记录的来源
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("[Executor] request error: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Message string `json:"message"`
+		Data    struct {
+			Items []ExecResult `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("解析执行层响应失败 http=%d: %w", resp.StatusCode, err)
+	}
+	log.Printf("[Executor] response status=%d message=%s", resp.StatusCode, out.Message)
+	if resp.StatusCode != http.StatusOK {
+		return out.Data.Items, fmt.Errorf("执行失败 http=%d: %s", resp.StatusCode, out.Message)
+	}
+	return out.Data.Items, nil
+}
+
 // ResolveRegionCommands 读取映射文件 executors，将区域命令映射成具体 clientId 指令
 func (o *Orchestrator) ResolveRegionCommands(rcs []RegionCommand) ([]ExecCommand, error) {
 	if o.MappingPath == "" {
@@ -11,10 +11,12 @@ import (
 
 // TaskPayload: 控制服务消费的任务载荷，符合 /control/task 接口。
 type TaskPayload struct {
-	TaskType string                 `json:"task_type"`
-	Target   string                 `json:"target"`
-	Params   map[string]interface{} `json:"params,omitempty"`
-	Source   string                 `json:"source,omitempty"`
+	TaskType  string                 `json:"task_type"`
+	DomainID  string                 `json:"domain_id,omitempty"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	Target    string                 `json:"target"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Source    string                 `json:"source,omitempty"`
 }
 
 // ControlAdapter: 不改动原 orchestrator 的前提下，负责
@@ -74,7 +76,7 @@ func (a *ControlAdapter) RunTasks(limit int) ([]TaskPayload, error) {
 		return nil, nil
 	}
 
-	tasks := regionCommandsToTasks(regionCmds)
+	tasks := regionCommandsToTasks(regionCmds, a.DomainID, a.ChannelID)
 	log.Printf("[Adapter] tasks=%d", len(tasks))
 	return tasks, nil
 }
@@ -88,7 +90,13 @@ func (a *ControlAdapter) PostTask(task TaskPayload) error {
 	url := fmt.Sprintf("%s/control/task", strings.TrimRight(a.ControlBase, "/"))
 	log.Printf("[Adapter] POST %s payload=%s", url, string(body))
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Principal", a.principal())
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -99,6 +107,40 @@ func (a *ControlAdapter) PostTask(task TaskPayload) error {
 	return nil
 }
 
+// principal: 提交任务的主体标识，写入控制服务的防篡改执行日志，便于追溯是哪个频道的 LLM 规划下发的命令。
+func (a *ControlAdapter) principal() string {
+	return fmt.Sprintf("llm-adapter:%s/%s", a.DomainID, a.ChannelID)
+}
+
+// PreviewTask: 调用控制服务 /control/plan 预览任务（策略结论、设备命令、时间线），不会真正下发。
+func (a *ControlAdapter) PreviewTask(task TaskPayload) (map[string]interface{}, error) {
+	if a.ControlBase == "" || task.TaskType == "" || task.Target == "" {
+		return nil, fmt.Errorf("control base/task_type/target 缺失")
+	}
+	body, _ := json.Marshal(task)
+	url := fmt.Sprintf("%s/control/plan", strings.TrimRight(a.ControlBase, "/"))
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Principal", a.principal())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("控制服务返回 http=%d", resp.StatusCode)
+	}
+	var preview map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		return nil, fmt.Errorf("解析计划预览失败: %w", err)
+	}
+	return preview, nil
+}
+
 // parseRegionCommands: 兼容数组、{commands:[...]}、单对象三种格式。
 func parseRegionCommands(raw string) ([]RegionCommand, error) {
 	var regionCmds []RegionCommand
@@ -124,8 +166,9 @@ func parseRegionCommands(raw string) ([]RegionCommand, error) {
 // 规则：
 // - task_type 使用 action；
 // - target 优先 partition_name，否则使用 partition_id；
+// - domain_id/channel_id 取消息来源频道，分区名称只在频道内唯一；
 // - params 附带 reason（若存在）；source 固定为 "llm"。
-func regionCommandsToTasks(rcs []RegionCommand) []TaskPayload {
+func regionCommandsToTasks(rcs []RegionCommand, domainID, channelID string) []TaskPayload {
 	tasks := make([]TaskPayload, 0, len(rcs))
 	for _, rc := range rcs {
 		target := rc.PartitionName
@@ -137,10 +180,12 @@ func regionCommandsToTasks(rcs []RegionCommand) []TaskPayload {
 			params["reason"] = rc.Reason
 		}
 		tasks = append(tasks, TaskPayload{
-			TaskType: rc.Action,
-			Target:   target,
-			Params:   params,
-			Source:   "llm",
+			TaskType:  rc.Action,
+			DomainID:  domainID,
+			ChannelID: channelID,
+			Target:    target,
+			Params:    params,
+			Source:    "llm",
 		})
 	}
 	return tasks
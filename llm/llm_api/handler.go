@@ -16,6 +16,8 @@ type result struct {
 	Code    int             `json:"code"`
 	Message string          `json:"message"`
 	Data    json.RawMessage `json:"data,omitempty"`
+	// Results 下发后执行层返回的逐条结果（plan-and-execute），data 保持为指令列表
+	Results json.RawMessage `json:"results,omitempty"`
 }
 
 // 兼容旧调用，不覆盖 prompt。
@@ -168,12 +170,25 @@ func PlanAndExecuteHandler(w http.ResponseWriter, r *http.Request, orch *core.Or
 		_ = json.NewEncoder(w).Encode(result{Code: 500, Message: err.Error()})
 		return
 	}
-	// 下发执行
-	for _, c := range cmds {
-		_ = ov.SendToExecutor(c)
+	// 下发执行（批量接口，执行层按设备串行并限制并发）；data 仍为指令列表，逐条执行结果放在 results
+	results := []core.ExecResult{}
+	if len(cmds) > 0 {
+		results, err = ov.SendBatchToExecutor(cmds)
 	}
-	b, _ := json.Marshal(cmds)
-	_ = json.NewEncoder(w).Encode(result{Code: 1000, Message: "ok", Data: b})
+	b := mustJSON(cmds)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		_ = json.NewEncoder(w).Encode(result{Code: 502, Message: err.Error(), Data: b, Results: mustJSON(results)})
+		return
+	}
+	msg := "ok"
+	for _, res := range results {
+		if res.Status != "success" {
+			msg = "partial"
+			break
+		}
+	}
+	_ = json.NewEncoder(w).Encode(result{Code: 1000, Message: msg, Data: b, Results: mustJSON(results)})
 }
 
 // 使用真实推理：基于 llm.AnalyzeRegionCommands 和配置创建的 LLMClient。
@@ -327,13 +342,12 @@ func NewControlAdapterFromConfig() (*core.ControlAdapter, error) {
 	return adapter, nil
 }
 
-// PlanAndSendToControlHandler：拉取→推理→转任务→下发控制服务
-// POST /llm/plan-and-send {"limit":10,"domainId":"...","channelId":"..."}
-func PlanAndSendToControlHandler(w http.ResponseWriter, r *http.Request, baseAdapter *core.ControlAdapter) {
+// planTasks：解析 {"limit","domainId","channelId"}，按请求频道拉取消息并推理出任务；失败时已写出响应，返回 ok=false。
+func planTasks(w http.ResponseWriter, r *http.Request, baseAdapter *core.ControlAdapter) (*core.ControlAdapter, []core.TaskPayload, bool) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		_ = json.NewEncoder(w).Encode(result{Code: 405, Message: "method not allowed"})
-		return
+		return nil, nil, false
 	}
 	var body struct {
 		Limit     int    `json:"limit"`
@@ -347,7 +361,7 @@ func PlanAndSendToControlHandler(w http.ResponseWriter, r *http.Request, baseAda
 	if body.DomainID == "" || body.ChannelID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		_ = json.NewEncoder(w).Encode(result{Code: 400, Message: "domainId/channelId 不能为空"})
-		return
+		return nil, nil, false
 	}
 
 	adapter := *baseAdapter
@@ -358,6 +372,16 @@ func PlanAndSendToControlHandler(w http.ResponseWriter, r *http.Request, baseAda
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(result{Code: 500, Message: err.Error()})
+		return nil, nil, false
+	}
+	return &adapter, tasks, true
+}
+
+// PlanAndSendToControlHandler：拉取→推理→转任务→下发控制服务
+// POST /llm/plan-and-send {"limit":10,"domainId":"...","channelId":"..."}
+func PlanAndSendToControlHandler(w http.ResponseWriter, r *http.Request, baseAdapter *core.ControlAdapter) {
+	adapter, tasks, ok := planTasks(w, r, baseAdapter)
+	if !ok {
 		return
 	}
 	for _, t := range tasks {
@@ -370,6 +394,31 @@ func PlanAndSendToControlHandler(w http.ResponseWriter, r *http.Request, baseAda
 	_ = json.NewEncoder(w).Encode(result{Code: 1000, Message: "ok", Data: mustJSON(tasks)})
 }
 
+// PlanPreviewHandler：拉取→推理→转任务→逐个调用控制服务 /control/plan 预览，不下发任何任务
+// POST /llm/plan-preview {"limit":10,"domainId":"...","channelId":"..."}
+// data 为 [{task, preview}]，preview 即控制服务返回的计划预览（策略结论、设备命令、时间线）。
+func PlanPreviewHandler(w http.ResponseWriter, r *http.Request, baseAdapter *core.ControlAdapter) {
+	adapter, tasks, ok := planTasks(w, r, baseAdapter)
+	if !ok {
+		return
+	}
+	type taskPreview struct {
+		Task    core.TaskPayload       `json:"task"`
+		Preview map[string]interface{} `json:"preview"`
+	}
+	previews := make([]taskPreview, 0, len(tasks))
+	for _, t := range tasks {
+		p, err := adapter.PreviewTask(t)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(result{Code: 502, Message: err.Error()})
+			return
+		}
+		previews = append(previews, taskPreview{Task: t, Preview: p})
+	}
+	_ = json.NewEncoder(w).Encode(result{Code: 1000, Message: "ok", Data: mustJSON(previews)})
+}
+
 // 辅助：序列化任务列表
 func mustJSON(v any) json.RawMessage {
 	b, _ := json.Marshal(v)
@@ -21,6 +21,9 @@ func main() {
 	mux.HandleFunc("/llm/plan-and-send", func(w http.ResponseWriter, r *http.Request) { // 新增路由
 		PlanAndSendToControlHandler(w, r, ctrlAdapter)
 	})
+	mux.HandleFunc("/llm/plan-preview", func(w http.ResponseWriter, r *http.Request) { // 预览，不下发
+		PlanPreviewHandler(w, r, ctrlAdapter)
+	})
 	log.Println("llm_api listening on :9000")
 	if err := http.ListenAndServe(":9000", mux); err != nil {
 		log.Fatalf("server error: %v", err)